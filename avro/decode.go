@@ -0,0 +1,303 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxDepth limits nesting of decoded values, it protects from stack overflow on recursive schemas.
+const maxDepth = 256
+
+var errShortBuffer = errors.New("unexpected end of data")
+
+// Decode converts Avro binary encoded datum to JSON. Unions are rendered as value of selected branch,
+// bytes and fixed values as strings of code points 0-255 according to Avro JSON encoding.
+func (s *Schema) Decode(data []byte) (json.RawMessage, error) {
+	d := &decoder{b: data}
+
+	if err := d.value(s.root, 0); err != nil {
+		return nil, err
+	}
+
+	if len(d.b) != 0 {
+		return nil, fmt.Errorf("%d bytes left after datum, schema does not match data", len(d.b))
+	}
+
+	return d.out.Bytes(), nil
+}
+
+type decoder struct {
+	b   []byte
+	out bytes.Buffer
+}
+
+func (d *decoder) value(n *node, depth int) error {
+	if depth > maxDepth {
+		return errors.New("too deep nesting")
+	}
+
+	switch n.kind {
+	case kindNull:
+		d.out.WriteString("null")
+	case kindBoolean:
+		b, err := d.take(1)
+		if err != nil {
+			return err
+		}
+
+		d.out.WriteString(strconv.FormatBool(b[0] != 0))
+	case kindInt, kindLong:
+		v, err := d.long()
+		if err != nil {
+			return err
+		}
+
+		d.out.WriteString(strconv.FormatInt(v, 10))
+	case kindFloat:
+		b, err := d.take(4)
+		if err != nil {
+			return err
+		}
+
+		d.float(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 32)
+	case kindDouble:
+		b, err := d.take(8)
+		if err != nil {
+			return err
+		}
+
+		d.float(math.Float64frombits(binary.LittleEndian.Uint64(b)), 64)
+	case kindString:
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+
+		d.string(string(b))
+	case kindBytes:
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+
+		d.string(codePoints(b))
+	case kindFixed:
+		b, err := d.take(n.size)
+		if err != nil {
+			return err
+		}
+
+		d.string(codePoints(b))
+	case kindEnum:
+		i, err := d.index(len(n.symbols))
+		if err != nil {
+			return fmt.Errorf("enum [%s]: %w", n.name, err)
+		}
+
+		d.string(n.symbols[i])
+	case kindUnion:
+		i, err := d.index(len(n.branches))
+		if err != nil {
+			return fmt.Errorf("union: %w", err)
+		}
+
+		return d.value(n.branches[i], depth+1)
+	case kindRecord:
+		return d.record(n, depth)
+	case kindArray:
+		return d.blocks('[', ']', sized(n.items), func(first bool) error {
+			if !first {
+				d.out.WriteByte(',')
+			}
+
+			return d.value(n.items, depth+1)
+		})
+	case kindMap:
+		return d.blocks('{', '}', true, func(first bool) error {
+			if !first {
+				d.out.WriteByte(',')
+			}
+
+			key, err := d.bytes()
+			if err != nil {
+				return err
+			}
+
+			d.string(string(key))
+			d.out.WriteByte(':')
+
+			return d.value(n.values, depth+1)
+		})
+	}
+
+	return nil
+}
+
+func (d *decoder) record(n *node, depth int) error {
+	d.out.WriteByte('{')
+
+	for i, f := range n.fields {
+		if i != 0 {
+			d.out.WriteByte(',')
+		}
+
+		d.string(f.name)
+		d.out.WriteByte(':')
+
+		if err := d.value(f.typ, depth+1); err != nil {
+			return fmt.Errorf("field [%s] of record [%s]: %w", f.name, n.name, err)
+		}
+	}
+
+	d.out.WriteByte('}')
+
+	return nil
+}
+
+// blocks decodes array or map items written in blocks, each block starts with items count.
+// Negative count is followed by block size in bytes. When items take at least one byte (sized),
+// count of corrupted data is checked against size of data left, so huge counts fail before items are read.
+func (d *decoder) blocks(open, closing byte, sized bool, item func(first bool) error) error {
+	d.out.WriteByte(open)
+
+	first := true
+
+	for {
+		count, err := d.long()
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			break
+		}
+
+		if count < 0 {
+			count = -count
+
+			if _, err = d.long(); err != nil {
+				return err
+			}
+		}
+
+		if sized && count > int64(len(d.b)) {
+			return fmt.Errorf("invalid block count %d", count)
+		}
+
+		for i := int64(0); i < count; i++ {
+			if err = item(first); err != nil {
+				return err
+			}
+
+			first = false
+		}
+	}
+
+	d.out.WriteByte(closing)
+
+	return nil
+}
+
+// sized reports whether each value of type takes at least one byte. Nested records are not inspected,
+// so it could report false for records that are sized.
+func sized(n *node) bool {
+	switch n.kind {
+	case kindNull:
+		return false
+	case kindFixed:
+		return n.size > 0
+	case kindRecord:
+		for _, f := range n.fields {
+			if f.typ.kind != kindRecord && sized(f.typ) {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return true
+	}
+}
+
+func (d *decoder) long() (int64, error) {
+	// Avro uses zig-zag varints as encoding/binary does.
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		return 0, errShortBuffer
+	}
+
+	d.b = d.b[n:]
+
+	return v, nil
+}
+
+func (d *decoder) index(size int) (int, error) {
+	i, err := d.long()
+	if err != nil {
+		return 0, err
+	}
+
+	if i < 0 || i >= int64(size) {
+		return 0, fmt.Errorf("index %d out of range", i)
+	}
+
+	return int(i), nil
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n > len(d.b) {
+		return nil, errShortBuffer
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+
+	return b, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n > int64(len(d.b)) {
+		return nil, errShortBuffer
+	}
+
+	return d.take(int(n))
+}
+
+func (d *decoder) string(s string) {
+	// marshaling of string never fails.
+	js, _ := json.Marshal(s)
+
+	d.out.Write(js)
+}
+
+// float writes number, NaN and infinities that have no JSON representation are written as strings.
+func (d *decoder) float(f float64, bitSize int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		d.string(strconv.FormatFloat(f, 'g', -1, bitSize))
+
+		return
+	}
+
+	d.out.WriteString(strconv.FormatFloat(f, 'g', -1, bitSize))
+}
+
+// codePoints converts bytes to string of code points 0-255 as Avro JSON encoding does.
+func codePoints(b []byte) string {
+	r := make([]rune, len(b))
+
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+
+	return string(r)
+}
@@ -0,0 +1,48 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Encoding is a name of encoding of values rendered by Decoder.
+const Encoding = "avro"
+
+// magicByte starts values of Confluent wire format.
+const magicByte = 0
+
+// headerSize is a size of magic byte and schema ID.
+const headerSize = 5
+
+// Decoder decodes values of Confluent wire format: magic byte, big endian 4 bytes schema ID and Avro binary datum.
+type Decoder struct {
+	registry *Registry
+}
+
+// NewDecoder creates decoder that fetches schemas from registry.
+func NewDecoder(registry *Registry) *Decoder {
+	return &Decoder{registry: registry}
+}
+
+// Decode returns JSON of Avro datum.
+func (d *Decoder) Decode(b []byte) (json.RawMessage, string, error) {
+	if len(b) < headerSize || b[0] != magicByte {
+		return nil, "", errors.New("value is not in Confluent wire format")
+	}
+
+	id := int32(binary.BigEndian.Uint32(b[1:headerSize]))
+
+	schema, err := d.registry.Schema(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	js, err := schema.Decode(b[headerSize:])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode datum of schema %d: %w", id, err)
+	}
+
+	return js, Encoding, nil
+}
@@ -0,0 +1,177 @@
+package avro
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of RegistryOptions.
+const (
+	defaultRegistryTimeout = 10 * time.Second
+	defaultRetryInterval   = 30 * time.Second
+)
+
+// maxSchemaSize limits size of Schema Registry responses.
+const maxSchemaSize = 10 << 20
+
+// RegistryOptions configures Schema Registry client.
+type RegistryOptions struct {
+	// URL is a base URL of Schema Registry, e.g. https://registry:8081.
+	URL string
+	// User and Password are credentials of HTTP basic authentication, it is not used when User is empty.
+	User     string
+	Password string
+	// TLS configures HTTPS connections, default config is used when nil.
+	TLS *tls.Config
+	// Timeout of schema requests, 10 seconds when zero.
+	Timeout time.Duration
+	// RetryInterval is a time during which failed schema is not requested again, 30 seconds when zero.
+	RetryInterval time.Duration
+}
+
+// Registry fetches schemas from Confluent Schema Registry by ID and caches them. It is safe for concurrent use.
+type Registry struct {
+	url           string
+	user          string
+	password      string
+	client        *http.Client
+	retryInterval time.Duration
+	now           func() time.Time
+
+	mu      sync.Mutex
+	schemas map[int32]*Schema
+	// failures holds errors of schemas that could not be fetched or parsed and time when they were requested.
+	failures map[int32]failure
+}
+
+type failure struct {
+	err error
+	at  time.Time
+}
+
+// NewRegistry creates Schema Registry client.
+func NewRegistry(opts RegistryOptions) (*Registry, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema registry URL: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("schema registry URL should be http or https, got [%s]", opts.URL)
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRegistryTimeout
+	}
+
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultRetryInterval
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.TLS != nil {
+		transport.TLSClientConfig = opts.TLS
+	}
+
+	return &Registry{
+		url:           strings.TrimRight(opts.URL, "/"),
+		user:          opts.User,
+		password:      opts.Password,
+		client:        &http.Client{Timeout: opts.Timeout, Transport: transport},
+		retryInterval: opts.RetryInterval,
+		now:           time.Now,
+		schemas:       make(map[int32]*Schema),
+		failures:      make(map[int32]failure),
+	}, nil
+}
+
+// Schema returns schema with ID. Fetched schemas are cached forever as schema IDs are immutable,
+// failures are cached for RetryInterval, so unreachable registry does not slow down each decoded message.
+func (r *Registry) Schema(id int32) (*Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.schemas[id]; ok {
+		return s, nil
+	}
+
+	if f, ok := r.failures[id]; ok && r.now().Sub(f.at) < r.retryInterval {
+		return nil, f.err
+	}
+
+	s, err := r.fetch(id)
+	if err != nil {
+		err = fmt.Errorf("schema %d: %w", id, err)
+		r.failures[id] = failure{err: err, at: r.now()}
+
+		return nil, err
+	}
+
+	delete(r.failures, id)
+	r.schemas[id] = s
+
+	return s, nil
+}
+
+// schemaResponse is a body of GET /schemas/ids/{id} response.
+type schemaResponse struct {
+	Schema string `json:"schema"`
+	// SchemaType is empty for Avro schemas.
+	SchemaType string `json:"schemaType"`
+}
+
+func (r *Registry) fetch(id int32) (*Schema, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url+"/schemas/ids/"+strconv.Itoa(int(id)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json, application/json")
+
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("schema registry request failed: %w", err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSchemaSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema registry response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schema registry responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var sr schemaResponse
+	if err = json.Unmarshal(body, &sr); err != nil {
+		return nil, fmt.Errorf("invalid schema registry response: %w", err)
+	}
+
+	if sr.SchemaType != "" && sr.SchemaType != "AVRO" {
+		return nil, fmt.Errorf("unsupported schema type [%s]", sr.SchemaType)
+	}
+
+	if sr.Schema == "" {
+		return nil, errors.New("empty schema")
+	}
+
+	return ParseSchema(sr.Schema)
+}
@@ -0,0 +1,280 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const orderSchema = `{"type":"record","name":"Order","namespace":"shop","fields":[` +
+	`{"name":"id","type":"long"},{"name":"note","type":["null","string"]}]}`
+
+// testRegistry is a Schema Registry handler that serves schemas by ID and counts requests of each path.
+type testRegistry struct {
+	user     string
+	password string
+
+	mu sync.Mutex
+	// responses are bodies of responses by request path, other paths are not found.
+	responses map[string]string
+	requests  map[string]int
+}
+
+func (tr *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	tr.mu.Lock()
+	tr.requests[req.URL.Path]++
+	body, ok := tr.responses[req.URL.Path]
+	tr.mu.Unlock()
+
+	if user, password, _ := req.BasicAuth(); user != tr.user || password != tr.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error_code":401,"message":"Unauthorized"}`))
+
+		return
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	_, _ = w.Write([]byte(body))
+}
+
+func (tr *testRegistry) set(path, body string) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.responses[path] = body
+}
+
+func (tr *testRegistry) count(path string) int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return tr.requests[path]
+}
+
+func schemaBody(t *testing.T, schema string) string {
+	t.Helper()
+
+	b, err := json.Marshal(schemaResponse{Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// newRegistryHandler returns registry handler that requires credentials user and password when user is not empty.
+func newRegistryHandler(t *testing.T, user, password string) *testRegistry {
+	t.Helper()
+
+	return &testRegistry{
+		responses: map[string]string{
+			"/schemas/ids/1": schemaBody(t, orderSchema),
+			"/schemas/ids/2": `{"schema":"syntax = \"proto3\";","schemaType":"PROTOBUF"}`,
+			"/schemas/ids/3": `{"schema":""}`,
+			"/schemas/ids/4": `not json`,
+			"/schemas/ids/5": schemaBody(t, `{"type":"record","name":"R"}`),
+		},
+		user:     user,
+		password: password,
+		requests: make(map[string]int),
+	}
+}
+
+// newTestRegistry starts server of handler tr and returns registry client of it.
+func newTestRegistry(t *testing.T, tr *testRegistry, opts RegistryOptions) *Registry {
+	t.Helper()
+
+	srv := httptest.NewServer(tr)
+	t.Cleanup(srv.Close)
+
+	opts.URL = srv.URL + "/"
+
+	r, err := NewRegistry(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestNewRegistry(t *testing.T) {
+	for _, u := range []string{"registry:8081", "ftp://registry", "http://[::1"} {
+		if _, err := NewRegistry(RegistryOptions{URL: u}); err == nil {
+			t.Errorf("NewRegistry(%q) error = nil, want invalid URL", u)
+		}
+	}
+}
+
+func TestRegistryCache(t *testing.T) {
+	tr := newRegistryHandler(t, "", "")
+	r := newTestRegistry(t, tr, RegistryOptions{})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := r.Schema(1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	s1, err := r.Schema(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := r.Schema(1)
+	if err != nil || s1 != s2 {
+		t.Errorf("Schema(1) = %p, %v, want cached %p", s2, err, s1)
+	}
+
+	if n := tr.count("/schemas/ids/1"); n != 1 {
+		t.Errorf("schema is requested %d times, want 1", n)
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		wantErr  string
+	}{
+		{name: "valid credentials", user: "reader", password: "secret"},
+		{name: "invalid password", user: "reader", password: "wrong", wantErr: "responded 401 Unauthorized"},
+		{name: "no credentials", wantErr: "responded 401 Unauthorized"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newRegistryHandler(t, "reader", "secret")
+			r := newTestRegistry(t, tr, RegistryOptions{User: tc.user, Password: tc.password})
+
+			_, err := r.Schema(1)
+
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("Schema(1) = %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("Schema(1) error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistryInvalidSchemas(t *testing.T) {
+	tests := []struct {
+		id      int32
+		wantErr string
+	}{
+		{id: 99, wantErr: `schema 99: schema registry responded 404 Not Found: {"error_code":40403`},
+		{id: 2, wantErr: "schema 2: unsupported schema type [PROTOBUF]"},
+		{id: 3, wantErr: "schema 3: empty schema"},
+		{id: 4, wantErr: "schema 4: invalid schema registry response"},
+		{id: 5, wantErr: "schema 5: record [R] without fields"},
+	}
+
+	tr := newRegistryHandler(t, "", "")
+	r := newTestRegistry(t, tr, RegistryOptions{})
+
+	for _, tc := range tests {
+		if s, err := r.Schema(tc.id); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("Schema(%d) = %v, %v, want %q", tc.id, s, err, tc.wantErr)
+		}
+	}
+}
+
+// TestRegistryRetry checks that unknown schema is not requested again until retry interval passes.
+func TestRegistryRetry(t *testing.T) {
+	tr := newRegistryHandler(t, "", "")
+	r := newTestRegistry(t, tr, RegistryOptions{RetryInterval: time.Minute})
+
+	now := time.Date(2021, time.February, 3, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	const path = "/schemas/ids/7"
+
+	for _, step := range []struct {
+		elapsed  time.Duration
+		register bool
+		requests int
+		wantErr  bool
+	}{
+		{requests: 1, wantErr: true},
+		{elapsed: 30 * time.Second, requests: 1, wantErr: true},
+		{elapsed: 30 * time.Second, requests: 2, wantErr: true},
+		{elapsed: 30 * time.Second, register: true, requests: 2, wantErr: true},
+		{elapsed: 30 * time.Second, requests: 3},
+		{elapsed: time.Hour, requests: 3},
+	} {
+		now = now.Add(step.elapsed)
+
+		if step.register {
+			tr.set(path, schemaBody(t, orderSchema))
+		}
+
+		_, err := r.Schema(7)
+		if (err != nil) != step.wantErr || tr.count(path) != step.requests {
+			t.Errorf("after %s: Schema(7) = %v with %d requests, want error %v with %d requests",
+				step.elapsed, err, tr.count(path), step.wantErr, step.requests)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tr := newRegistryHandler(t, "", "")
+	d := NewDecoder(newTestRegistry(t, tr, RegistryOptions{}))
+
+	header := func(id uint32) []byte {
+		b := make([]byte, headerSize)
+		binary.BigEndian.PutUint32(b[1:], id)
+
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		value   []byte
+		want    string
+		wantErr string
+	}{
+		// id 21 and null note, zig-zag encoded.
+		{name: "datum", value: append(header(1), 42, 0), want: `{"id":21,"note":null}`},
+		{name: "short value", value: []byte{0, 0, 1}, wantErr: "not in Confluent wire format"},
+		{name: "magic byte", value: append([]byte{1, 0, 0, 0, 1}, 42, 0), wantErr: "not in Confluent wire format"},
+		{name: "unknown schema", value: append(header(99), 42, 0), wantErr: "schema 99: schema registry responded 404"},
+		{name: "truncated datum", value: append(header(1), 42), wantErr: "failed to decode datum of schema 1"},
+	}
+
+	for _, tc := range tests {
+		js, enc, err := d.Decode(tc.value)
+
+		switch {
+		case tc.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: Decode() error = %v, want %q", tc.name, err, tc.wantErr)
+			}
+		case err != nil || string(js) != tc.want || enc != Encoding:
+			t.Errorf("%s: Decode() = %s, %s, %v, want %s", tc.name, js, enc, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package avro decodes Avro records of Confluent wire format to JSON using schemas of Confluent Schema Registry.
+package avro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBoolean
+	kindInt
+	kindLong
+	kindFloat
+	kindDouble
+	kindBytes
+	kindString
+	kindRecord
+	kindEnum
+	kindArray
+	kindMap
+	kindUnion
+	kindFixed
+)
+
+var primitives = map[string]kind{
+	"null":    kindNull,
+	"boolean": kindBoolean,
+	"int":     kindInt,
+	"long":    kindLong,
+	"float":   kindFloat,
+	"double":  kindDouble,
+	"bytes":   kindBytes,
+	"string":  kindString,
+}
+
+// Schema is a parsed Avro schema.
+type Schema struct {
+	root *node
+}
+
+// node is a type of Avro schema.
+type node struct {
+	kind kind
+	// name is a full name of record, enum and fixed types.
+	name     string
+	fields   []field
+	symbols  []string
+	items    *node
+	values   *node
+	branches []*node
+	size     int
+}
+
+type field struct {
+	name string
+	typ  *node
+}
+
+// ParseSchema parses Avro schema in JSON form. Named types should be defined before they are referenced.
+func ParseSchema(schema string) (*Schema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	}
+
+	p := parser{named: make(map[string]*node)}
+
+	root, err := p.parse(v, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{root: root}, nil
+}
+
+type parser struct {
+	named map[string]*node
+}
+
+func (p parser) parse(v interface{}, namespace string) (*node, error) {
+	switch t := v.(type) {
+	case string:
+		return p.reference(t, namespace)
+	case []interface{}:
+		return p.union(t, namespace)
+	case map[string]interface{}:
+		return p.complex(t, namespace)
+	default:
+		return nil, fmt.Errorf("invalid type definition %v", v)
+	}
+}
+
+// reference returns primitive type or type defined before with name.
+func (p parser) reference(name, namespace string) (*node, error) {
+	if k, ok := primitives[name]; ok {
+		return &node{kind: k}, nil
+	}
+
+	if n, ok := p.named[fullName(name, namespace)]; ok {
+		return n, nil
+	}
+
+	if n, ok := p.named[name]; ok {
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("unknown type [%s]", name)
+}
+
+func (p parser) union(branches []interface{}, namespace string) (*node, error) {
+	n := &node{kind: kindUnion}
+
+	for _, b := range branches {
+		bn, err := p.parse(b, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid union branch: %w", err)
+		}
+
+		n.branches = append(n.branches, bn)
+	}
+
+	if len(n.branches) == 0 {
+		return nil, errors.New("empty union")
+	}
+
+	return n, nil
+}
+
+func (p parser) complex(def map[string]interface{}, namespace string) (*node, error) {
+	typ, ok := def["type"]
+	if !ok {
+		return nil, errors.New("type definition without type")
+	}
+
+	name, ok := typ.(string)
+	if !ok {
+		// {"type": {"type": "array", ...}} and {"type": [...]} wrap other definitions.
+		return p.parse(typ, namespace)
+	}
+
+	switch name {
+	case "record", "error":
+		return p.record(def, namespace)
+	case "enum":
+		n, err := p.define(def, namespace, kindEnum)
+		if err != nil {
+			return nil, err
+		}
+
+		symbols, _ := def["symbols"].([]interface{})
+		for _, s := range symbols {
+			symbol, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid symbol of enum [%s]", n.name)
+			}
+
+			n.symbols = append(n.symbols, symbol)
+		}
+
+		return n, nil
+	case "fixed":
+		n, err := p.define(def, namespace, kindFixed)
+		if err != nil {
+			return nil, err
+		}
+
+		size, ok := def["size"].(float64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("invalid size of fixed [%s]", n.name)
+		}
+
+		n.size = int(size)
+
+		return n, nil
+	case "array":
+		items, err := p.parse(def["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array items: %w", err)
+		}
+
+		return &node{kind: kindArray, items: items}, nil
+	case "map":
+		values, err := p.parse(def["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid map values: %w", err)
+		}
+
+		return &node{kind: kindMap, values: values}, nil
+	default:
+		// primitive type with attributes, e.g. logical type, or reference.
+		return p.reference(name, namespace)
+	}
+}
+
+func (p parser) record(def map[string]interface{}, namespace string) (*node, error) {
+	// record is defined before its fields are parsed, so fields could reference it recursively.
+	n, err := p.define(def, namespace, kindRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := def["fields"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("record [%s] without fields", n.name)
+	}
+
+	for _, f := range fields {
+		fd, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid field of record [%s]", n.name)
+		}
+
+		name, _ := fd["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("field without name in record [%s]", n.name)
+		}
+
+		typ, err := p.parse(fd["type"], namespaceOf(n.name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid field [%s] of record [%s]: %w", name, n.name, err)
+		}
+
+		n.fields = append(n.fields, field{name: name, typ: typ})
+	}
+
+	return n, nil
+}
+
+// define registers named type.
+func (p parser) define(def map[string]interface{}, namespace string, k kind) (*node, error) {
+	name, _ := def["name"].(string)
+	if name == "" {
+		return nil, errors.New("named type without name")
+	}
+
+	if ns, ok := def["namespace"].(string); ok {
+		namespace = ns
+	}
+
+	n := &node{kind: k, name: fullName(name, namespace)}
+
+	if _, ok := p.named[n.name]; ok {
+		return nil, fmt.Errorf("type [%s] is defined twice", n.name)
+	}
+
+	p.named[n.name] = n
+
+	return n, nil
+}
+
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+
+	return namespace + "." + name
+}
+
+func namespaceOf(fullName string) string {
+	if i := strings.LastIndexByte(fullName, '.'); i >= 0 {
+		return fullName[:i]
+	}
+
+	return ""
+}
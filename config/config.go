@@ -2,18 +2,26 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/koding/multiconfig"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/dumper"
+	"github.com/obalunenko/kafka-dump/filter"
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/security"
 )
 
 const (
@@ -24,13 +32,44 @@
 // Config stores service config parameters.
 type Config struct {
 	kafkaVersion       sarama.KafkaVersion
+	location           *time.Location
+	outputFormat       format.Format
+	compression        format.Compression
+	decoders           *format.Decoders
+	filter             *filter.Filter
+	redactKey          []byte
+	timestampSource    dumper.TimestampSource
+	bucketing          dumper.Bucketing
+	pathTemplate       *dumper.PathTemplate
+	commitPolicy       dumper.CommitPolicy
+	balanceStrategy    sarama.BalanceStrategy
+	topicsInclude      *regexp.Regexp
+	topicsExclude      *regexp.Regexp
+	security           *security.Options
+	overrides          []dumper.TopicOverride
+	from               time.Time
+	to                 time.Time
+	partitionOffsets   []dumper.PartitionOffsets
+	snapshotAt         time.Time
 	KafkaBrokers       []string `required:"true"`
-	Topics             []string `required:"true"` // (example: '{"Topic1", "Topic2"}'
+	Topics             []string // (example: '{"Topic1", "Topic2"}', required when TopicsInclude is empty
 	OutputDir          string   `default:"OUTPUT_DATA"`
+	OutputFormat       string   `default:"raw"`
+	RecordSeparator    string   `default:"\\n"` // used only by raw OutputFormat, supports escape sequences
+	Compression        string   `default:"none"`
+	KeyDecoder         string   `default:"raw"`
+	ValueDecoder       string   `default:"raw"`
+	Filter             string   // expression selecting dumped messages, all messages are dumped when empty
+	OutputPathTemplate string   `default:"{topic}/partition-{partition}/{bucket}_Partition_{partition}{ext}"`
+	ClusterName        string   `default:"default"` // value of {cluster} placeholder in OutputPathTemplate
+	KeyHashBuckets     int      `default:"16"`      // number of {key_hash_bucket} placeholder buckets
 	KafkaClientID      string   `default:"kafka-dumper"`
 	KafkaGroupID       string   `default:"kafka-dumper"`
 	KafkaVersionString string   `default:"0.10.2.0"`
+	BalanceStrategy    string   `default:"range"` // range, roundrobin or sticky
 	Timezone           string   `default:"GMT"`
+	TimestampSource    string   `default:"create"` // create, logappend or receive
+	Bucketing          string   `default:"daily"`  // daily or hourly
 	Log                string   `default:"Info"`
 	LocalLog           bool     `required:"false"` // if true  - will write log to stdout and to
 	// file kafka-dump.log at OutputDir
@@ -40,6 +79,92 @@ type Config struct {
 	// kafka after start of tool
 
 	Init bool `required:"false"`
+
+	// dump files writer settings
+	MaxOpenFiles    int       `default:"256"`
+	WriteBufferSize int       `default:"65536"`
+	FlushInterval   *Duration `default:"1s"`
+	IdleTimeout     *Duration `default:"1m"`
+
+	// dump files rotation settings, zero disables limit
+	RotateMaxBytes   int64     `required:"false"`
+	RotateMaxRecords int64     `required:"false"`
+	RotateMaxAge     *Duration `required:"false"`
+
+	// dump files older than Retention are removed, zero keeps them
+	Retention *Duration `required:"false"`
+
+	// per topic settings, set only in config file as [[topic]] tables
+	Topic []TopicConfig `toml:"topic" structs:"-"`
+
+	// offsets commit settings
+	Durable         bool      `required:"false"`   // if true - offsets are marked only after data is fsynced
+	CommitPolicy    string    `default:"interval"` // batch or interval
+	CommitBatchSize int       `default:"1000"`
+	CommitInterval  *Duration `default:"5s"`
+
+	// bounded job mode settings, zero disables condition
+	StopAtHighWatermark bool      `required:"false"` // if true - exits after partitions are dumped up to high watermarks at start
+	StopMaxMessages     int64     `required:"false"`
+	StopMaxBytes        int64     `required:"false"`
+	StopMaxDuration     *Duration `required:"false"`
+	StopIdle            *Duration `required:"false"`
+
+	// range mode settings, consumer group is not used when any of them is set
+	From             string   // RFC3339
+	To               string   // RFC3339
+	PartitionOffsets []string // (example: 'orders:0:1500-2000,orders:1:1200-')
+
+	// group-less mode settings
+	NoGroup        bool   `required:"false"`          // if true - partitions are consumed without consumer group
+	CheckpointFile string `default:"checkpoint.json"` // relative to OutputDir
+	Partitions     []int  // partitions of Topics to dump in NoGroup, range or snapshot mode, all when empty
+
+	// snapshot mode settings
+	Snapshot            bool   `required:"false"` // if true - the latest value of each key is written to one file per topic
+	SnapshotAt          string // RFC3339
+	SnapshotMemoryBytes int64  `default:"67108864"`
+	SnapshotSpillDir    string // OutputDir when empty
+
+	// topics discovery settings
+	TopicsInclude         string    // regular expression of topic names
+	TopicsExclude         string    // regular expression of topic names
+	IncludeInternalTopics bool      `required:"false"`
+	TopicsRefreshInterval *Duration `default:"1m"`
+
+	// connection security settings
+	TLSEnabled            bool   `required:"false"`
+	TLSCAFile             string // PEM
+	TLSCertFile           string // PEM
+	TLSKeyFile            string // PEM
+	TLSServerName         string
+	TLSInsecureSkipVerify bool   `required:"false"`
+	SASLMechanism         string // PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER
+	SASLUser              string
+	SASLPassword          string `json:"-"` // never logged
+	SASLPasswordFile      string
+	SASLTokenFile         string
+
+	// Confluent Schema Registry settings of avro decoder
+	SchemaRegistryURL                string
+	SchemaRegistryUser               string
+	SchemaRegistryPassword           string    `json:"-"` // never logged
+	SchemaRegistryCAFile             string    // PEM
+	SchemaRegistryCertFile           string    // PEM
+	SchemaRegistryKeyFile            string    // PEM
+	SchemaRegistryInsecureSkipVerify bool      `required:"false"`
+	SchemaRegistryTimeout            *Duration `default:"10s"`
+
+	// protobuf decoder settings
+	ProtoDescriptorSet string // FileDescriptorSet file, e.g. built by protoc --include_imports --descriptor_set_out
+
+	// redaction settings of [[topic.redact]] rules
+	RedactHMACKey     string `json:"-"` // never logged
+	RedactHMACKeyFile string
+
+	// HTTP listener settings
+	HTTPAddr        string    // host:port of HTTP listener with metrics and health endpoints, disabled when empty
+	LivenessTimeout *Duration `default:"1m"`
 }
 
 // Help output for flags when program run with -h flag.
@@ -53,11 +178,81 @@ func setFlagsHelp() map[string]string {
 	usageMsg["KafkaBrokers"] = "Kafka brokers address"
 	usageMsg["Log"] = `Log level: All, Debug, Info, Error, Fatal, Panic, Warn`
 	usageMsg["KafkaVersionString"] = `Kafka version`
+	usageMsg["BalanceStrategy"] = `Consumer group partitions balance strategy: range, roundrobin or sticky`
 	usageMsg["OutputDir"] = "Location of directory where kafka dump will be stored locally"
 	usageMsg["Overwrite"] = `When select as true - 
 	all previous dump in specified OutputDir will be overwritten. All kafka messages would be read again`
+	usageMsg["OutputFormat"] = `Format of dumped records: raw (message value followed by RecordSeparator),
+	jsonl (one JSON object per line with topic, partition, offset, key, headers, timestamp and value)
+	or binary (length-prefixed records with checksum and sidecar offset index)`
+	usageMsg["RecordSeparator"] = `Separator written after each record in raw OutputFormat. Escape sequences (\n, \r\n, \x00) are supported`
+	usageMsg["Compression"] = `Compression of dump files: none, gzip (.gz), zstd (.zst), snappy (.sz) or lz4 (.lz4).
+	Extension of compression is appended to extension of OutputFormat, each flush of write buffer writes independent frame`
+	usageMsg["KeyDecoder"] = `Decoder of message keys: raw (kept as is), string (UTF-8), json (json:indent re-indents),
+	hex, base64, int32, int64 (big endian), uuid, avro (Confluent wire format, requires SchemaRegistryURL),
+	protobuf (schema-less) or protobuf:<message type> (requires ProtoDescriptorSet). Keys that could not be decoded are dumped as is with decode error flag`
+	usageMsg["Filter"] = `Expression selecting dumped messages, e.g. value.event_type == "refund" and key =~ "^cust-".
+	Fields: key, value, value.<json path>, header.<name>, topic, partition, offset, timestamp, size, key_size, value_size;
+	operators: == != < <= > >= =~ !~, exists(field), and, or, not. Offsets of filtered out messages are committed too`
+	usageMsg["RedactHMACKey"] = `Key of hmac action of [[topic.redact]] rules, prefer RedactHMACKeyFile to keep it out of config and process list`
+	usageMsg["RedactHMACKeyFile"] = `File with key of hmac action of [[topic.redact]] rules, trailing new line is ignored`
+	usageMsg["ProtoDescriptorSet"] = `Compiled FileDescriptorSet with message types of protobuf:<message type> decoders,
+	e.g. built by protoc --include_imports --descriptor_set_out`
+	usageMsg["ValueDecoder"] = `Decoder of message values, the same decoders as for KeyDecoder are supported`
+	usageMsg["OutputPathTemplate"] = `Template of dump files path relative to OutputDir. Placeholders: {topic}, {partition}
+	({partition:4} - zero padded), {bucket}, {date}, {yyyy}, {mm}, {dd}, {hh}, {key_hash_bucket}, {header:<name>}, {cluster}, {ext}`
+	usageMsg["ClusterName"] = `Cluster name used for {cluster} placeholder of OutputPathTemplate`
+	usageMsg["KeyHashBuckets"] = `Number of buckets for {key_hash_bucket} placeholder of OutputPathTemplate`
+	usageMsg["MaxOpenFiles"] = `Maximum number of simultaneously open dump files, least recently used file is closed when limit is reached`
+	usageMsg["WriteBufferSize"] = `Size in bytes of write buffer of each open dump file, buffer is flushed when it is full`
+	usageMsg["FlushInterval"] = `Maximum time that written data could stay in write buffer before flush to disk`
+	usageMsg["IdleTimeout"] = `Dump files without writes for this time are closed`
+	usageMsg["RotateMaxBytes"] = `Maximum size in bytes of dump segment, 0 - unlimited`
+	usageMsg["RotateMaxRecords"] = `Maximum number of records in dump segment, 0 - unlimited`
+	usageMsg["RotateMaxAge"] = `Maximum difference between times of the first and the last messages of dump segment, 0 - unlimited`
+	usageMsg["Durable"] = `When true - offsets are marked only after dumped data is flushed and fsynced (at-least-once),
+	partial records left at the end of files by crash are truncated on start`
+	usageMsg["CommitPolicy"] = `When offsets are marked in Durable mode: batch (each CommitBatchSize messages) or interval (each CommitInterval)`
+	usageMsg["CommitBatchSize"] = `Number of messages between fsync and offsets commit for batch CommitPolicy`
+	usageMsg["CommitInterval"] = `Time between fsync and offsets commit for interval CommitPolicy`
+	usageMsg["StopAtHighWatermark"] = `When true - high watermarks of all partitions are recorded at start,
+	dumper exits after claimed partitions are dumped up to them`
+	usageMsg["StopMaxMessages"] = `Exit after this number of dumped messages, 0 - unlimited`
+	usageMsg["StopMaxBytes"] = `Exit after this number of dumped bytes, 0 - unlimited`
+	usageMsg["StopMaxDuration"] = `Exit after running for this time, 0 - unlimited`
+	usageMsg["StopIdle"] = `Exit when no messages were received for this time, 0 - unlimited`
+	usageMsg["From"] = `Dump messages with timestamp at or after this time (RFC3339), partitions are consumed
+	without consumer group and dumper exits when they are dumped up to To or high watermarks at start`
+	usageMsg["To"] = `Dump messages with timestamp before this time (RFC3339), each partition stops at the first message past it`
+	usageMsg["PartitionOffsets"] = `Comma separated topic:partition:start-end explicit offsets ranges (end is exclusive, could be omitted),
+	they override From and To for these partitions`
+	usageMsg["NoGroup"] = `When true - partitions are consumed without joining KafkaGroupID consumer group,
+	offsets of dumped messages are kept in CheckpointFile and consuming is resumed from them on restart`
+	usageMsg["CheckpointFile"] = `Path of checkpoint file relative to OutputDir used in NoGroup mode`
+	usageMsg["Partitions"] = `Partitions of Topics to dump in NoGroup, range or snapshot mode, all partitions when empty`
+	usageMsg["Snapshot"] = `When true - partitions are consumed without consumer group up to high watermarks at start
+	and the latest value of each key is written to one snapshot file per topic sorted by key, keys with tombstones are removed`
+	usageMsg["SnapshotAt"] = `Snapshot state at this time (RFC3339): partitions are consumed up to the first message with timestamp at or after it`
+	usageMsg["SnapshotMemoryBytes"] = `Size of latest records kept in memory in Snapshot mode, they are spilled to sorted files on disk when it is exceeded`
+	usageMsg["SnapshotSpillDir"] = `Directory of spilled files in Snapshot mode, OutputDir when empty`
+	usageMsg["TopicsInclude"] = `Regular expression of topic names to dump in addition to Topics,
+	topics created while dumper runs are picked up each TopicsRefreshInterval`
+	usageMsg["TopicsExclude"] = `Regular expression of topic names matched by TopicsInclude that should not be dumped`
+	usageMsg["IncludeInternalTopics"] = `When true - TopicsInclude could match internal topics with names starting with __`
+	usageMsg["TopicsRefreshInterval"] = `How often topics metadata is refreshed to discover topics matched by TopicsInclude`
+	setSecurityFlagsHelp(usageMsg)
+	setSchemaRegistryFlagsHelp(usageMsg)
+	usageMsg["HTTPAddr"] = `Address (host:port) of HTTP listener that serves Prometheus metrics at /metrics,
+	liveness check at /healthz and readiness check at /readyz, disabled when empty`
+	usageMsg["LivenessTimeout"] = `Liveness check fails when consumer loop has no iterations for this time`
 	usageMsg["Timezone"] = "Timezone that will be used for timestamps in messages"
+	usageMsg["TimestampSource"] = `Timestamp used to bucket messages into files: create (message CreateTime),
+	logappend (LogAppendTime/block timestamp) or receive (wall-clock time of receiving).
+	Messages without timestamps fall back to the next available source`
+	usageMsg["Bucketing"] = `Time buckets of dump files computed in Timezone: daily or hourly`
 	usageMsg["Topics"] = `List of all topics with specified message type which will be dumped`
+	usageMsg["Retention"] = `Dump files not modified for this time are removed, files are kept forever when zero.
+	Path template should have {topic} as whole directory or file name`
 	usageMsg["Log"] = `Log level that will be displayed (DEBUG, INFO, ERROR, WARN, FATAL"`
 	usageMsg["LocalLog"] = `When true will write log to stdout and to file kafka-dump.log at OutputDir`
 	usageMsg["Newest"] = `when set true - will start dump all messages that appears in kafka after start of tool`
@@ -65,16 +260,23 @@ func setFlagsHelp() map[string]string {
 	return usageMsg
 }
 
-// GetTimeZone - parses timezone string and return time.Location representation.
-func (c *Config) GetTimeZone() *time.Location {
+// Timezone setter.
+func (c *Config) setTimeZone() error {
 	timezone, err := time.LoadLocation(c.Timezone)
 	if err != nil {
-		log.Fatalf("Failed to set Timezone")
+		return fmt.Errorf("failed to set Timezone: %w", err)
 	}
 
 	log.Infof("Timezone: %s", timezone)
 
-	return timezone
+	c.location = timezone
+
+	return nil
+}
+
+// GetTimeZone - returns time.Location representation of Timezone.
+func (c *Config) GetTimeZone() *time.Location {
+	return c.location
 }
 
 // Make each tool run unique - add hostname of runner to KafkaClientID for Kafka Consumer.
@@ -88,79 +290,154 @@ func (c *Config) addHostnameToClientID() {
 	c.KafkaClientID = c.KafkaClientID + "-" + hn
 }
 
-// Start reading kafka messages from the beginning and overwrite already received data.
-func (c *Config) overwriteMessages() {
+// Start reading kafka messages from the beginning, already received data is removed by dumper when it starts.
+func (c *Config) overwriteMessages() error {
 	if c.Overwrite {
 		log.Infof("All received Messages will be overwritten")
 
-		c.KafkaGroupID += "-" + time.Now().Format(timeFormat)
-		c.KafkaClientID += "-" + time.Now().Format(timeFormat)
-
-		if err := os.RemoveAll(path.Join(c.OutputDir)); err != nil {
-			log.Fatalf("Failed to remove all dirs: %v", err)
+		// checkpoint of NoGroup mode is removed with OutputDir, so new group is not needed.
+		if !c.NoGroup {
+			c.KafkaGroupID += "-" + time.Now().Format(timeFormat)
 		}
+
+		c.KafkaClientID += "-" + time.Now().Format(timeFormat)
 	}
+
+	return nil
 }
 
+// ErrConfigInitialized is returned by LoadConfig when Init is set and initial config file was created.
+var ErrConfigInitialized = errors.New("initial config file created")
+
 // LoadConfig loads configuration for service to struct Config and store topics to Topics map.
-func LoadConfig() *Config {
-	svcConfig := &Config{}
+// Returned errors except ErrConfigInitialized are *dumper.ConfigError.
+func LoadConfig() (*Config, error) {
+	svcConfig, err := loadConfig()
+	if err != nil && !errors.Is(err, ErrConfigInitialized) {
+		return nil, &dumper.ConfigError{Err: err}
+	}
 
+	return svcConfig, err
+}
+
+func loadConfig() (*Config, error) {
 	log.Infof("Loading configuration\n")
 
 	usr, errUser := user.Current()
 	if errUser != nil {
-		log.Fatal(errUser)
+		return nil, errUser
 	}
 
 	log.Infof("Current Username: %s. Home dir: %s", usr.Username, usr.HomeDir)
 	configPath := path.Join(usr.HomeDir, ".config/", toolName)
 
-	m := newConfig(path.Join(configPath, "config.toml"), "KafkaDump", true)
+	return loadConfigFile(path.Join(configPath, "config.toml"), nil)
+}
+
+// loadConfigFile loads configuration from TOML file, environment variables and args,
+// os.Args[1:] are used when args is nil.
+func loadConfigFile(file string, args []string) (*Config, error) {
+	svcConfig := &Config{}
+
+	m := newConfig(file, "KafkaDump", true, args, setFlagsHelp())
 
 	if err := m.Load(svcConfig); err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
 	}
 
 	if svcConfig.Init {
-		initServiceConfigFile()
+		if err := initServiceConfigFile(); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrConfigInitialized
 	}
 
 	// set logger
-	setLogger(svcConfig)
+	var logDir string
+	if svcConfig.LocalLog {
+		logDir = svcConfig.OutputDir
+	}
+
+	if err := setLogger(svcConfig.Log, logDir); err != nil {
+		return nil, err
+	}
 
 	// Parse topics
 
 	svcConfig.addHostnameToClientID()
 
-	svcConfig.overwriteMessages()
+	setters := []func() error{
+		svcConfig.overwriteMessages,
+		svcConfig.setTimeZone,
+		svcConfig.setKafkaVersion,
+		svcConfig.setBalanceStrategy,
+		svcConfig.setOutputFormat,
+		svcConfig.setCompression,
+		svcConfig.setSchemaRegistry,
+		svcConfig.setProtobuf,
+		svcConfig.setDecoders,
+		svcConfig.setFilter,
+		svcConfig.setBucketing,
+		svcConfig.setPathTemplate,
+		svcConfig.setCommitPolicy,
+		svcConfig.setRange,
+		svcConfig.setRedactKey,
+		svcConfig.setTopicOverrides,
+		svcConfig.setTopicPatterns,
+		svcConfig.setSecurity,
+	}
 
-	svcConfig.setKafkaVersion()
+	for _, set := range setters {
+		if err := set(); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := m.Validate(svcConfig); err != nil {
-		log.Fatalf("Config struct is invalid: %v\n", err)
+		return nil, fmt.Errorf("config struct is invalid: %w", err)
 	}
 
 	log.Infof("Configuration loaded\n")
 
 	prettyConfig, err := json.MarshalIndent(svcConfig, "", "")
 	if err != nil {
-		log.Fatalf("Failed to marshal indent config: %v", err)
+		return nil, fmt.Errorf("failed to marshal indent config: %w", err)
 	}
 
 	log.Infof("Current config:\n %s", string(prettyConfig))
 
-	return svcConfig
+	return svcConfig, nil
 }
 
 // KafkaVersion setter.
-func (c *Config) setKafkaVersion() {
+func (c *Config) setKafkaVersion() error {
 	// Parse kafkaVersion
-	if v, err := sarama.ParseKafkaVersion(c.KafkaVersionString); err == nil {
-		c.kafkaVersion = v
-	} else {
-		log.Fatalf("Failed to parse kafkaVersion: %v", err)
+	v, err := sarama.ParseKafkaVersion(c.KafkaVersionString)
+	if err != nil {
+		return fmt.Errorf("failed to parse kafkaVersion: %w", err)
 	}
+
+	c.kafkaVersion = v
+
+	return nil
+}
+
+// BalanceStrategy setter.
+func (c *Config) setBalanceStrategy() error {
+	s, err := dumper.ParseBalanceStrategy(c.BalanceStrategy)
+	if err != nil {
+		return fmt.Errorf("failed to parse BalanceStrategy: %w", err)
+	}
+
+	c.balanceStrategy = s
+
+	return nil
+}
+
+// GroupBalanceStrategy getter.
+func (c *Config) GroupBalanceStrategy() sarama.BalanceStrategy {
+	return c.balanceStrategy
 }
 
 // KafkaVersion getter.
@@ -168,8 +445,456 @@ func (c *Config) KafkaVersion() sarama.KafkaVersion {
 	return c.kafkaVersion
 }
 
+// OutputFormat setter.
+func (c *Config) setOutputFormat() error {
+	f, err := format.Parse(c.OutputFormat)
+	if err != nil {
+		return fmt.Errorf("failed to parse OutputFormat: %w", err)
+	}
+
+	c.outputFormat = f
+
+	return nil
+}
+
+// Format getter.
+func (c *Config) Format() format.Format {
+	return c.outputFormat
+}
+
+// Compression setter.
+func (c *Config) setCompression() error {
+	compression, err := format.ParseCompression(c.Compression)
+	if err != nil {
+		return fmt.Errorf("failed to parse Compression: %w", err)
+	}
+
+	c.compression = compression
+
+	return nil
+}
+
+// Filter setter.
+func (c *Config) setFilter() error {
+	c.filter = nil
+
+	if c.Filter == "" {
+		return nil
+	}
+
+	f, err := filter.Parse(c.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to parse Filter: %w", err)
+	}
+
+	c.filter = f
+
+	return nil
+}
+
+// RedactHMACKey and RedactHMACKeyFile setter.
+func (c *Config) setRedactKey() error {
+	c.redactKey = nil
+
+	switch {
+	case c.RedactHMACKey != "" && c.RedactHMACKeyFile != "":
+		return errors.New("either RedactHMACKey or RedactHMACKeyFile should be set")
+	case c.RedactHMACKey != "":
+		c.redactKey = []byte(c.RedactHMACKey)
+	case c.RedactHMACKeyFile != "":
+		data, err := ioutil.ReadFile(c.RedactHMACKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read RedactHMACKeyFile: %w", err)
+		}
+
+		c.redactKey = []byte(strings.TrimRight(string(data), "\r\n"))
+	}
+
+	return nil
+}
+
+// MessageFilter getter.
+func (c *Config) MessageFilter() *filter.Filter {
+	return c.filter
+}
+
+// FileCompression getter.
+func (c *Config) FileCompression() format.Compression {
+	return c.compression
+}
+
+// KeyDecoder and ValueDecoder setter.
+func (c *Config) setDecoders() error {
+	var err error
+
+	c.decoders = &format.Decoders{}
+
+	if c.decoders.Key, err = format.NewDecoder(c.KeyDecoder); err != nil {
+		return fmt.Errorf("failed to parse KeyDecoder: %w", err)
+	}
+
+	if c.decoders.Value, err = format.NewDecoder(c.ValueDecoder); err != nil {
+		return fmt.Errorf("failed to parse ValueDecoder: %w", err)
+	}
+
+	return nil
+}
+
+// Decoders getter.
+func (c *Config) Decoders() format.Decoders {
+	if c.decoders == nil {
+		return format.Decoders{}
+	}
+
+	return *c.decoders
+}
+
+// TimestampSource and Bucketing setter.
+func (c *Config) setBucketing() error {
+	src, err := dumper.ParseTimestampSource(c.TimestampSource)
+	if err != nil {
+		return fmt.Errorf("failed to parse TimestampSource: %w", err)
+	}
+
+	b, err := dumper.ParseBucketing(c.Bucketing)
+	if err != nil {
+		return fmt.Errorf("failed to parse Bucketing: %w", err)
+	}
+
+	c.timestampSource = src
+	c.bucketing = b
+
+	return nil
+}
+
+// OutputPathTemplate setter.
+func (c *Config) setPathTemplate() error {
+	if c.Rotation().Enabled() && c.OutputPathTemplate == dumper.DefaultPathTemplate {
+		log.Infof("Rotation is enabled, segments will be named by first offset: %s", dumper.DefaultSegmentPathTemplate)
+
+		c.OutputPathTemplate = dumper.DefaultSegmentPathTemplate
+	}
+
+	t, err := dumper.ParsePathTemplate(c.OutputPathTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse OutputPathTemplate: %w", err)
+	}
+
+	if t.Uses("key_hash_bucket") && c.KeyHashBuckets <= 0 {
+		return fmt.Errorf("KeyHashBuckets should be positive when {key_hash_bucket} is used, got %d", c.KeyHashBuckets)
+	}
+
+	if c.Rotation().Enabled() && !t.Uses("offset") {
+		return errors.New("OutputPathTemplate should contain {offset} placeholder when rotation is enabled")
+	}
+
+	c.pathTemplate = t
+
+	return nil
+}
+
+// Layout returns layout of dump files built by configured path template, timestamp source, bucketing and timezone.
+func (c *Config) Layout() dumper.Layout {
+	return dumper.Layout{
+		OutputDir: c.OutputDir,
+		Template:  c.pathTemplate,
+		Bucketer: dumper.Bucketer{
+			Location:  c.GetTimeZone(),
+			Source:    c.timestampSource,
+			Bucketing: c.bucketing,
+		},
+		Cluster:        c.ClusterName,
+		KeyHashBuckets: c.KeyHashBuckets,
+	}
+}
+
+// WriterOptions returns options of dump files writer.
+func (c *Config) WriterOptions() dumper.WriterOptions {
+	return dumper.WriterOptions{
+		MaxOpenFiles:  c.MaxOpenFiles,
+		BufferSize:    c.WriteBufferSize,
+		FlushInterval: c.FlushInterval.Duration(),
+		IdleTimeout:   c.IdleTimeout.Duration(),
+	}
+}
+
+// Rotation returns rotation policy of dump segments.
+func (c *Config) Rotation() dumper.RotationPolicy {
+	return dumper.RotationPolicy{
+		MaxBytes:   c.RotateMaxBytes,
+		MaxRecords: c.RotateMaxRecords,
+		MaxAge:     c.RotateMaxAge.Duration(),
+	}
+}
+
+// CommitPolicy setter.
+func (c *Config) setCommitPolicy() error {
+	p, err := dumper.ParseCommitPolicy(c.CommitPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to parse CommitPolicy: %w", err)
+	}
+
+	if p == dumper.CommitPerBatch && c.CommitBatchSize <= 0 {
+		return fmt.Errorf("CommitBatchSize should be positive for batch CommitPolicy, got %d", c.CommitBatchSize)
+	}
+
+	if p == dumper.CommitPerInterval && c.CommitInterval.Duration() <= 0 {
+		return fmt.Errorf("CommitInterval should be positive for interval CommitPolicy, got %s", c.CommitInterval.Duration())
+	}
+
+	c.commitPolicy = p
+
+	return nil
+}
+
+// CommitOptions returns options of offsets commits.
+func (c *Config) CommitOptions() dumper.CommitOptions {
+	return dumper.CommitOptions{
+		Durable:   c.Durable,
+		Policy:    c.commitPolicy,
+		BatchSize: c.CommitBatchSize,
+		Interval:  c.CommitInterval.Duration(),
+	}
+}
+
+// DumperOptions returns options of dumper built from configuration.
+func (c *Config) DumperOptions() (dumper.Options, error) {
+	encoder, err := format.NewEncoder(c.Format(), c.RecordSeparator)
+	if err != nil {
+		return dumper.Options{}, &dumper.ConfigError{Err: fmt.Errorf("failed to create encoder: %w", err)}
+	}
+
+	return dumper.Options{
+		Brokers:         c.KafkaBrokers,
+		GroupID:         c.KafkaGroupID,
+		ClientID:        c.KafkaClientID,
+		Version:         c.KafkaVersion(),
+		Security:        c.SecurityOptions(),
+		Newest:          c.Newest,
+		Topics:          c.Topics,
+		TopicsInclude:   c.topicsInclude,
+		TopicsExclude:   c.topicsExclude,
+		IncludeInternal: c.IncludeInternalTopics,
+		TopicsRefresh:   c.TopicsRefreshInterval.Duration(),
+		BalanceStrategy: c.GroupBalanceStrategy(),
+		Layout:          c.Layout(),
+		Encoder:         encoder,
+		Decoders:        c.Decoders(),
+		Compression:     c.FileCompression(),
+		Filter:          c.MessageFilter(),
+		Writer:          c.WriterOptions(),
+		Rotation:        c.Rotation(),
+		Retention:       c.Retention.Duration(),
+		Overrides:       c.TopicOverrides(),
+		Commit:          c.CommitOptions(),
+		Stop:            c.StopOptions(),
+		Range:           c.RangeOptions(),
+		Checkpoint:      c.Checkpoint(),
+		Snapshot:        c.SnapshotOptions(),
+		Partitions:      c.SelectedPartitions(),
+		Overwrite:       c.Overwrite,
+		LivenessTimeout: c.LivenessTimeout.Duration(),
+		Logger:          log.StandardLogger(),
+	}, nil
+}
+
+// StopOptions returns conditions on which dumper exits by itself.
+func (c *Config) StopOptions() dumper.StopOptions {
+	return dumper.StopOptions{
+		AtHighWatermark: c.StopAtHighWatermark,
+		MaxMessages:     c.StopMaxMessages,
+		MaxBytes:        c.StopMaxBytes,
+		MaxDuration:     c.StopMaxDuration.Duration(),
+		MaxIdle:         c.StopIdle.Duration(),
+	}
+}
+
+// From, To, PartitionOffsets and SnapshotAt setter.
+func (c *Config) setRange() error {
+	var err error
+
+	if c.From != "" {
+		if c.from, err = time.Parse(time.RFC3339, c.From); err != nil {
+			return fmt.Errorf("failed to parse From: %w", err)
+		}
+	}
+
+	if c.To != "" {
+		if c.to, err = time.Parse(time.RFC3339, c.To); err != nil {
+			return fmt.Errorf("failed to parse To: %w", err)
+		}
+	}
+
+	c.partitionOffsets = nil
+
+	for _, s := range c.PartitionOffsets {
+		po, err := dumper.ParsePartitionOffsets(s)
+		if err != nil {
+			return fmt.Errorf("failed to parse PartitionOffsets: %w", err)
+		}
+
+		c.partitionOffsets = append(c.partitionOffsets, po)
+	}
+
+	c.snapshotAt = time.Time{}
+
+	if c.SnapshotAt != "" {
+		if c.snapshotAt, err = time.Parse(time.RFC3339, c.SnapshotAt); err != nil {
+			return fmt.Errorf("failed to parse SnapshotAt: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Topic tables setter, named topics are added to Topics.
+func (c *Config) setTopicOverrides() error {
+	c.overrides = nil
+
+	for _, t := range c.Topic {
+		switch {
+		case t.Name == "" && t.Pattern == "":
+			return errors.New("either Name or Pattern of [[topic]] should be set")
+		case t.Name != "" && t.Pattern != "":
+			return fmt.Errorf("both Name and Pattern of [[topic]] are set: %s and %s", t.Name, t.Pattern)
+		}
+
+		o, err := t.override(c.Format(), c.RecordSeparator, c.redactKey)
+		if err != nil {
+			return fmt.Errorf("invalid [[topic]] settings of %s: %w", t.name(), err)
+		}
+
+		if o.Template != nil && o.Template.Uses("key_hash_bucket") && c.KeyHashBuckets <= 0 {
+			return fmt.Errorf("KeyHashBuckets should be positive when {key_hash_bucket} is used by %s", t.name())
+		}
+
+		if t.Name != "" && !contains(c.Topics, t.Name) {
+			c.Topics = append(c.Topics, t.Name)
+		}
+
+		c.overrides = append(c.overrides, o)
+	}
+
+	return nil
+}
+
+// TopicOverrides returns settings of [[topic]] tables.
+func (c *Config) TopicOverrides() []dumper.TopicOverride {
+	return c.overrides
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TopicsInclude and TopicsExclude setter.
+func (c *Config) setTopicPatterns() error {
+	var err error
+
+	if c.TopicsInclude != "" {
+		if c.topicsInclude, err = regexp.Compile(c.TopicsInclude); err != nil {
+			return fmt.Errorf("failed to parse TopicsInclude: %w", err)
+		}
+	}
+
+	if c.TopicsExclude != "" {
+		if c.topicsExclude, err = regexp.Compile(c.TopicsExclude); err != nil {
+			return fmt.Errorf("failed to parse TopicsExclude: %w", err)
+		}
+	}
+
+	if len(c.Topics) == 0 && c.topicsInclude == nil {
+		return errors.New("either Topics, TopicsInclude or Name of [[topic]] should be set")
+	}
+
+	return nil
+}
+
+// TLS and SASL setter.
+func (c *Config) setSecurity() error {
+	opts, err := securitySettings{
+		TLSEnabled:            c.TLSEnabled,
+		TLSCAFile:             c.TLSCAFile,
+		TLSCertFile:           c.TLSCertFile,
+		TLSKeyFile:            c.TLSKeyFile,
+		TLSServerName:         c.TLSServerName,
+		TLSInsecureSkipVerify: c.TLSInsecureSkipVerify,
+		SASLMechanism:         c.SASLMechanism,
+		SASLUser:              c.SASLUser,
+		SASLPassword:          c.SASLPassword,
+		SASLPasswordFile:      c.SASLPasswordFile,
+		SASLTokenFile:         c.SASLTokenFile,
+	}.options()
+	if err != nil {
+		return err
+	}
+
+	c.security = &opts
+
+	return nil
+}
+
+// SecurityOptions returns TLS and SASL options of connections to brokers.
+func (c *Config) SecurityOptions() security.Options {
+	if c.security == nil {
+		return security.Options{}
+	}
+
+	return *c.security
+}
+
+// RangeOptions returns range of messages to dump without consumer group.
+func (c *Config) RangeOptions() dumper.RangeOptions {
+	return dumper.RangeOptions{
+		From:    c.from,
+		To:      c.to,
+		Offsets: c.partitionOffsets,
+	}
+}
+
+// SnapshotOptions returns settings of snapshot mode.
+func (c *Config) SnapshotOptions() dumper.SnapshotOptions {
+	return dumper.SnapshotOptions{
+		Enabled:     c.Snapshot,
+		At:          c.snapshotAt,
+		MemoryBytes: c.SnapshotMemoryBytes,
+		SpillDir:    c.SnapshotSpillDir,
+	}
+}
+
+// Checkpoint returns path of checkpoint file in NoGroup mode, empty when consumer group is used.
+func (c *Config) Checkpoint() string {
+	if !c.NoGroup {
+		return ""
+	}
+
+	return filepath.Join(c.OutputDir, c.CheckpointFile)
+}
+
+// SelectedPartitions returns partitions of topics to dump, nil when all partitions are dumped.
+func (c *Config) SelectedPartitions() []int32 {
+	if len(c.Partitions) == 0 {
+		return nil
+	}
+
+	partitions := make([]int32, 0, len(c.Partitions))
+
+	for _, p := range c.Partitions {
+		partitions = append(partitions, int32(p))
+	}
+
+	return partitions
+}
+
 // Implementation of default loader for multiconfig.
-func newConfig(path string, prefix string, camelCase bool) *multiconfig.DefaultLoader {
+// Flags are parsed from args, os.Args[1:] is used when args is nil.
+func newConfig(path string, prefix string, camelCase bool, args []string, usageMsg map[string]string) *multiconfig.DefaultLoader {
 	var loaders []multiconfig.Loader
 
 	// Read default values defined via tag fields "default"
@@ -196,14 +921,13 @@ func newConfig(path string, prefix string, camelCase bool) *multiconfig.DefaultL
 		CamelCase: camelCase,
 	}
 
-	usageMsg := setFlagsHelp()
 	f := &multiconfig.FlagLoader{
 		Prefix:        "",
 		Flatten:       false,
 		CamelCase:     false,
 		EnvPrefix:     prefix,
 		ErrorHandling: 0,
-		Args:          nil,
+		Args:          args,
 		FlagUsageFunc: func(s string) string { return usageMsg[s] },
 	}
 
@@ -218,52 +942,59 @@ func newConfig(path string, prefix string, camelCase bool) *multiconfig.DefaultL
 }
 
 // creates initial config file.
-func initServiceConfigFile() {
+func initServiceConfigFile() error {
 	log.Infof("Creating initial config file...")
 
 	usr, errUser := user.Current()
 	if errUser != nil {
-		log.Fatal(errUser)
+		return errUser
 	}
 
 	log.Infof("Current Username: %s. Home dir: %s", usr.Username, usr.HomeDir)
 
 	configPath := path.Join(usr.HomeDir, ".config", toolName, "config.toml")
-	if err := os.MkdirAll(filepath.Dir(configPath), os.ModePerm); err != nil {
-		log.Fatalf("failed creating all dirs for config file [%s]: %v", filepath.Dir(configPath), err)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o700); err != nil {
+		return fmt.Errorf("failed creating all dirs for config file [%s]: %w", filepath.Dir(configPath), err)
 	}
 
-	configFile, err := os.OpenFile(configPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o777)
+	configFile, err := os.OpenFile(configPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
 	if err != nil {
-		log.Fatalf("error opening config file: %v", err)
+		return fmt.Errorf("error opening config file: %w", err)
 	}
 
 	OutputDir := path.Join(usr.HomeDir, "Desktop", "KAFKA-DUMP", "OUTPUT")
 
 	_, writeErr := configFile.WriteString(fmt.Sprintf(`OutputDir="%s"
+OutputFormat="jsonl"
 Topics=["Topic1", "Topic2"]
 KafkaClientID="kafka-dumper"
 Consumer_Group="test-kafka-dump"
 KafkaVersion="0.10.2.0"
 KafkaBrokers=["localhost:9092"]
 Timezone="Europe/Brussels"
+TimestampSource="create"
+Bucketing="daily"
 Overwrite=true
 Log="Debug"
 LocalLog=true
 Newest=false`, OutputDir))
 	if writeErr != nil {
-		log.Fatalf("Failed to write config file: %v", writeErr)
+		_ = configFile.Close()
+
+		return fmt.Errorf("failed to write config file: %w", writeErr)
 	}
 
 	if err := configFile.Close(); err != nil {
-		log.Fatalf("Failed to close config file: %v", err)
+		return fmt.Errorf("failed to close config file: %w", err)
 	}
 
 	log.Infof("Local initial config file was created at [%s]", configPath)
-	os.Exit(1)
+
+	return nil
 }
 
-func setLogger(cfg *Config) {
+// setLogger sets level and format of logs, when logDir is not empty logs are written to kafka-dump.log in it as well.
+func setLogger(level string, logDir string) error {
 	formatter := &log.TextFormatter{
 		ForceColors:               true,
 		DisableColors:             false,
@@ -284,23 +1015,23 @@ func setLogger(cfg *Config) {
 
 	log.SetFormatter(formatter)
 
-	lvl, err := log.ParseLevel(cfg.Log)
+	lvl, err := log.ParseLevel(level)
 	if err != nil {
 		lvl = log.InfoLevel
 	}
 
 	log.SetLevel(lvl)
 
-	if cfg.LocalLog {
+	if logDir != "" {
 		// Open logfile
-		logFileLoc := path.Join(cfg.OutputDir, "kafka-dump.log")
+		logFileLoc := path.Join(logDir, "kafka-dump.log")
 		if err := os.MkdirAll(filepath.Dir(logFileLoc), 0o700); err != nil {
-			log.Fatalf("failed creating all dirs for logfile [%s]:  %v", filepath.Dir(logFileLoc), err)
+			return fmt.Errorf("failed creating all dirs for logfile [%s]: %w", filepath.Dir(logFileLoc), err)
 		}
 
 		logFile, err := os.OpenFile(logFileLoc, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.ModePerm)
 		if err != nil {
-			log.Fatalf("error opening file: %v", err)
+			return fmt.Errorf("error opening log file: %w", err)
 		}
 
 		// create multiwriter for logs
@@ -308,4 +1039,6 @@ func setLogger(cfg *Config) {
 
 		log.SetOutput(mw)
 	}
+
+	return nil
 }
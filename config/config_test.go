@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes config.toml with settings to dir and returns its path.
+func writeConfigFile(t *testing.T, dir, settings string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(file, []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// TestOverwriteInvalidConfig checks that previous dump is kept when configuration with Overwrite is invalid.
+func TestOverwriteInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := filepath.Join(dir, "output")
+	dumped := filepath.Join(outputDir, "orders", "0.jsonl")
+
+	if err := os.MkdirAll(filepath.Dir(dumped), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(dumped, []byte("{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	file := writeConfigFile(t, dir, fmt.Sprintf(`OutputDir=%q
+KafkaBrokers=["localhost:9092"]
+Topics=["orders"]
+OutputFormat="jsonl"
+Overwrite=true
+Timezone="Nowhere/Invalid"
+`, outputDir))
+
+	if _, err := loadConfigFile(file, []string{}); err == nil {
+		t.Fatal("loadConfigFile() error = nil, want invalid Timezone")
+	}
+
+	if _, err := os.Stat(dumped); err != nil {
+		t.Errorf("dump file of previous run is removed by invalid config: %v", err)
+	}
+}
+
+func TestLoadDurations(t *testing.T) {
+	file := writeConfigFile(t, t.TempDir(), `KafkaBrokers=["localhost:9092"]
+Topics=["orders"]
+FlushInterval="5s"
+IdleTimeout="2m"
+RotateMaxAge="1h"
+Retention="720h"
+CommitInterval="10s"
+StopMaxDuration="30m"
+StopIdle="1m30s"
+TopicsRefreshInterval="45s"
+SchemaRegistryTimeout="3s"
+LivenessTimeout="90s"
+`)
+
+	c, err := loadConfigFile(file, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		got  *Duration
+		want time.Duration
+	}{
+		{name: "FlushInterval", got: c.FlushInterval, want: 5 * time.Second},
+		{name: "IdleTimeout", got: c.IdleTimeout, want: 2 * time.Minute},
+		{name: "RotateMaxAge", got: c.RotateMaxAge, want: time.Hour},
+		{name: "Retention", got: c.Retention, want: 720 * time.Hour},
+		{name: "CommitInterval", got: c.CommitInterval, want: 10 * time.Second},
+		{name: "StopMaxDuration", got: c.StopMaxDuration, want: 30 * time.Minute},
+		{name: "StopIdle", got: c.StopIdle, want: 90 * time.Second},
+		{name: "TopicsRefreshInterval", got: c.TopicsRefreshInterval, want: 45 * time.Second},
+		{name: "SchemaRegistryTimeout", got: c.SchemaRegistryTimeout, want: 3 * time.Second},
+		{name: "LivenessTimeout", got: c.LivenessTimeout, want: 90 * time.Second},
+	} {
+		if tc.got.Duration() != tc.want {
+			t.Errorf("%s = %s, want %s", tc.name, tc.got.Duration(), tc.want)
+		}
+	}
+
+	opts, err := c.DumperOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Writer.FlushInterval != 5*time.Second || opts.Retention != 720*time.Hour || opts.Stop.MaxIdle != 90*time.Second {
+		t.Errorf("DumperOptions() = %+v, want durations of config file", opts)
+	}
+}
+
+func TestLoadDurationDefaults(t *testing.T) {
+	file := writeConfigFile(t, t.TempDir(), `KafkaBrokers=["localhost:9092"]
+Topics=["orders"]
+`)
+
+	c, err := loadConfigFile(file, []string{"-flushinterval=250ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		got  *Duration
+		want time.Duration
+	}{
+		{name: "FlushInterval", got: c.FlushInterval, want: 250 * time.Millisecond},
+		{name: "IdleTimeout", got: c.IdleTimeout, want: time.Minute},
+		{name: "RotateMaxAge", got: c.RotateMaxAge},
+		{name: "Retention", got: c.Retention},
+		{name: "CommitInterval", got: c.CommitInterval, want: 5 * time.Second},
+		{name: "SchemaRegistryTimeout", got: c.SchemaRegistryTimeout, want: 10 * time.Second},
+	} {
+		if tc.got.Duration() != tc.want {
+			t.Errorf("%s = %s, want %s", tc.name, tc.got.Duration(), tc.want)
+		}
+	}
+}
+
+func TestLoadInvalidDuration(t *testing.T) {
+	for _, settings := range []string{`FlushInterval="5 seconds"`, `Retention=5`} {
+		file := writeConfigFile(t, t.TempDir(), "KafkaBrokers=[\"localhost:9092\"]\nTopics=[\"orders\"]\n"+settings+"\n")
+
+		if _, err := loadConfigFile(file, []string{}); err == nil {
+			t.Errorf("loadConfigFile() of %s error = nil", settings)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration set by duration string (e.g. 1m30s) in config file, environment variables and flags.
+// Config fields are pointers to it, so loaders set them through flag.Value and encoding.TextUnmarshaler.
+type Duration time.Duration
+
+// Set parses duration string, it implements flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration [%s]: %w", s, err)
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// UnmarshalText parses duration string of config file.
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+// MarshalText returns duration string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// String returns duration string.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Duration returns d as time.Duration, zero when d is nil.
+func (d *Duration) Duration() time.Duration {
+	if d == nil {
+		return 0
+	}
+
+	return time.Duration(*d)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/protobuf"
+)
+
+// ProtoDescriptorSet setter, it registers protobuf decoder.
+func (c *Config) setProtobuf() error {
+	var set *protobuf.DescriptorSet
+
+	if c.ProtoDescriptorSet != "" {
+		var err error
+
+		if set, err = protobuf.LoadDescriptorSet(c.ProtoDescriptorSet); err != nil {
+			return fmt.Errorf("failed to load ProtoDescriptorSet: %w", err)
+		}
+	}
+
+	raw := protobuf.NewRawDecoder()
+
+	format.RegisterDecoder(protobuf.Encoding, func(typeName string) (format.Decoder, error) {
+		switch {
+		case typeName == "":
+			return raw, nil
+		case set == nil:
+			return nil, errors.New("ProtoDescriptorSet should be set to decode messages by type")
+		default:
+			return set.Decoder(typeName)
+		}
+	})
+
+	return nil
+}
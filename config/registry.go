@@ -0,0 +1,66 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/obalunenko/kafka-dump/avro"
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/security"
+)
+
+// setSchemaRegistryFlagsHelp adds help output of Schema Registry flags.
+func setSchemaRegistryFlagsHelp(usageMsg map[string]string) {
+	usageMsg["SchemaRegistryURL"] = `URL of Confluent Schema Registry used by avro decoder, e.g. https://registry:8081`
+	usageMsg["SchemaRegistryUser"] = `Schema Registry user for HTTP basic authentication, it is disabled when empty`
+	usageMsg["SchemaRegistryPassword"] = `Schema Registry password for HTTP basic authentication`
+	usageMsg["SchemaRegistryCAFile"] = `PEM bundle of certificate authorities to verify Schema Registry, system roots are used when empty`
+	usageMsg["SchemaRegistryCertFile"] = `PEM client certificate for mutual TLS with Schema Registry, requires SchemaRegistryKeyFile`
+	usageMsg["SchemaRegistryKeyFile"] = `PEM private key of SchemaRegistryCertFile`
+	usageMsg["SchemaRegistryInsecureSkipVerify"] = `When true - Schema Registry certificate is not verified (testing only)`
+	usageMsg["SchemaRegistryTimeout"] = `Timeout of Schema Registry requests`
+}
+
+// SchemaRegistry setter, it registers avro decoder.
+func (c *Config) setSchemaRegistry() error {
+	if c.SchemaRegistryURL == "" {
+		format.RegisterDecoder(avro.Encoding, func(string) (format.Decoder, error) {
+			return nil, errors.New("SchemaRegistryURL should be set for avro decoder")
+		})
+
+		return nil
+	}
+
+	tlsConfig, err := security.TLSOptions{
+		CAFile:             c.SchemaRegistryCAFile,
+		CertFile:           c.SchemaRegistryCertFile,
+		KeyFile:            c.SchemaRegistryKeyFile,
+		InsecureSkipVerify: c.SchemaRegistryInsecureSkipVerify,
+	}.Config()
+	if err != nil {
+		return fmt.Errorf("invalid Schema Registry TLS settings: %w", err)
+	}
+
+	registry, err := avro.NewRegistry(avro.RegistryOptions{
+		URL:      c.SchemaRegistryURL,
+		User:     c.SchemaRegistryUser,
+		Password: c.SchemaRegistryPassword,
+		TLS:      tlsConfig,
+		Timeout:  c.SchemaRegistryTimeout.Duration(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse SchemaRegistryURL: %w", err)
+	}
+
+	decoder := avro.NewDecoder(registry)
+
+	format.RegisterDecoder(avro.Encoding, func(arg string) (format.Decoder, error) {
+		if arg != "" {
+			return nil, errors.New("decoder has no arguments")
+		}
+
+		return decoder, nil
+	})
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/dumper"
+	"github.com/obalunenko/kafka-dump/restore"
+	"github.com/obalunenko/kafka-dump/security"
+)
+
+// RestoreConfig stores parameters of restore command.
+type RestoreConfig struct {
+	kafkaVersion       sarama.KafkaVersion
+	from               time.Time
+	to                 time.Time
+	topicMapping       map[string]string
+	security           *security.Options
+	KafkaBrokers       []string
+	InputDir           string `required:"true"`
+	KafkaClientID      string `default:"kafka-restore"`
+	KafkaVersionString string `default:"0.11.0.0"`
+	Topics             []string
+	Partitions         []int
+	TopicMapping       []string // (example: 'orders:orders-restored,payments:payments-restored')
+	KeepPartition      bool     `required:"false"`
+	From               string   // RFC3339
+	To                 string   // RFC3339
+	RateLimit          float64  `required:"false"`
+	DryRun             bool     `required:"false"`
+	Log                string   `default:"Info"`
+
+	// connection security settings
+	TLSEnabled            bool   `required:"false"`
+	TLSCAFile             string // PEM
+	TLSCertFile           string // PEM
+	TLSKeyFile            string // PEM
+	TLSServerName         string
+	TLSInsecureSkipVerify bool   `required:"false"`
+	SASLMechanism         string // PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER
+	SASLUser              string
+	SASLPassword          string `json:"-"` // never logged
+	SASLPasswordFile      string
+	SASLTokenFile         string
+}
+
+// Help output for restore flags when program run with restore -h.
+func setRestoreFlagsHelp() map[string]string {
+	usageMsg := make(map[string]string)
+
+	usageMsg["KafkaBrokers"] = "Kafka brokers address, not required for DryRun"
+	usageMsg["InputDir"] = "OutputDir of dumper with jsonl or binary dump files to restore"
+	usageMsg["KafkaClientID"] = "Kafka producer clientID"
+	usageMsg["KafkaVersionString"] = `Kafka version, at least 0.11.0.0 is required to restore headers`
+	usageMsg["Topics"] = `Source topics to restore, all topics when empty`
+	usageMsg["Partitions"] = `Source partitions to restore, all partitions when empty`
+	usageMsg["TopicMapping"] = `Comma separated source:target topic pairs to restore topics under new names`
+	usageMsg["KeepPartition"] = `When true - messages are produced to their original partitions, otherwise partitioned by key`
+	usageMsg["From"] = `Restore messages with timestamp at or after this time (RFC3339)`
+	usageMsg["To"] = `Restore messages with timestamp before this time (RFC3339)`
+	usageMsg["RateLimit"] = `Maximum number of produced messages per second, 0 - unlimited`
+	usageMsg["DryRun"] = `When true - dump files are read and messages selected, but nothing is produced`
+	usageMsg["Log"] = `Log level that will be displayed (DEBUG, INFO, ERROR, WARN, FATAL"`
+
+	setSecurityFlagsHelp(usageMsg)
+
+	return usageMsg
+}
+
+// LoadRestoreConfig loads configuration of restore command from args, environment variables with KafkaRestore prefix.
+// Returned errors are *dumper.ConfigError.
+func LoadRestoreConfig(args []string) (*RestoreConfig, error) {
+	cfg, err := loadRestoreConfig(args)
+	if err != nil {
+		return nil, &dumper.ConfigError{Err: err}
+	}
+
+	return cfg, nil
+}
+
+func loadRestoreConfig(args []string) (*RestoreConfig, error) {
+	cfg := &RestoreConfig{}
+
+	m := newConfig("", "KafkaRestore", false, args, setRestoreFlagsHelp())
+
+	if err := m.Load(cfg); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if err := setLogger(cfg.Log, ""); err != nil {
+		return nil, err
+	}
+
+	setters := []func() error{
+		cfg.setKafkaVersion,
+		cfg.setTimeRange,
+		cfg.setTopicMapping,
+		cfg.setSecurity,
+	}
+
+	for _, set := range setters {
+		if err := set(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.Validate(cfg); err != nil {
+		return nil, fmt.Errorf("config struct is invalid: %w", err)
+	}
+
+	prettyConfig, err := json.MarshalIndent(cfg, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal indent config: %w", err)
+	}
+
+	log.Infof("Current config:\n %s", string(prettyConfig))
+
+	return cfg, nil
+}
+
+// KafkaVersion setter.
+func (c *RestoreConfig) setKafkaVersion() error {
+	v, err := sarama.ParseKafkaVersion(c.KafkaVersionString)
+	if err != nil {
+		return fmt.Errorf("failed to parse kafkaVersion: %w", err)
+	}
+
+	c.kafkaVersion = v
+
+	return nil
+}
+
+// From and To setter.
+func (c *RestoreConfig) setTimeRange() error {
+	var err error
+
+	if c.From != "" {
+		if c.from, err = time.Parse(time.RFC3339, c.From); err != nil {
+			return fmt.Errorf("failed to parse From: %w", err)
+		}
+	}
+
+	if c.To != "" {
+		if c.to, err = time.Parse(time.RFC3339, c.To); err != nil {
+			return fmt.Errorf("failed to parse To: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// TopicMapping setter.
+func (c *RestoreConfig) setTopicMapping() error {
+	if len(c.TopicMapping) == 0 {
+		return nil
+	}
+
+	c.topicMapping = make(map[string]string, len(c.TopicMapping))
+
+	for _, pair := range c.TopicMapping {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("TopicMapping should contain source:target pairs, got [%s]", pair)
+		}
+
+		c.topicMapping[parts[0]] = parts[1]
+	}
+
+	return nil
+}
+
+// TLS and SASL setter.
+func (c *RestoreConfig) setSecurity() error {
+	opts, err := securitySettings{
+		TLSEnabled:            c.TLSEnabled,
+		TLSCAFile:             c.TLSCAFile,
+		TLSCertFile:           c.TLSCertFile,
+		TLSKeyFile:            c.TLSKeyFile,
+		TLSServerName:         c.TLSServerName,
+		TLSInsecureSkipVerify: c.TLSInsecureSkipVerify,
+		SASLMechanism:         c.SASLMechanism,
+		SASLUser:              c.SASLUser,
+		SASLPassword:          c.SASLPassword,
+		SASLPasswordFile:      c.SASLPasswordFile,
+		SASLTokenFile:         c.SASLTokenFile,
+	}.options()
+	if err != nil {
+		return err
+	}
+
+	c.security = &opts
+
+	return nil
+}
+
+// SecurityOptions returns TLS and SASL options of connections to brokers.
+func (c *RestoreConfig) SecurityOptions() security.Options {
+	if c.security == nil {
+		return security.Options{}
+	}
+
+	return *c.security
+}
+
+// RestoreOptions returns options of restorer built from configuration.
+func (c *RestoreConfig) RestoreOptions() restore.Options {
+	partitions := make([]int32, 0, len(c.Partitions))
+
+	for _, p := range c.Partitions {
+		partitions = append(partitions, int32(p))
+	}
+
+	return restore.Options{
+		Brokers:       c.KafkaBrokers,
+		ClientID:      c.KafkaClientID,
+		Version:       c.kafkaVersion,
+		Security:      c.SecurityOptions(),
+		InputDir:      c.InputDir,
+		Topics:        c.Topics,
+		Partitions:    partitions,
+		From:          c.from,
+		To:            c.to,
+		TopicMapping:  c.topicMapping,
+		KeepPartition: c.KeepPartition,
+		RateLimit:     c.RateLimit,
+		DryRun:        c.DryRun,
+		Logger:        log.StandardLogger(),
+	}
+}
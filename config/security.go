@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/obalunenko/kafka-dump/security"
+)
+
+// securitySettings are TLS and SASL fields shared by dumper and restore configs.
+type securitySettings struct {
+	TLSEnabled            bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+	SASLMechanism         string
+	SASLUser              string
+	SASLPassword          string
+	SASLPasswordFile      string
+	SASLTokenFile         string
+}
+
+// setSecurityFlagsHelp adds help output of TLS and SASL flags.
+func setSecurityFlagsHelp(usageMsg map[string]string) {
+	usageMsg["TLSEnabled"] = `When true - connections to brokers are encrypted with TLS`
+	usageMsg["TLSCAFile"] = `PEM bundle of certificate authorities to verify brokers, system roots are used when empty`
+	usageMsg["TLSCertFile"] = `PEM client certificate for mutual TLS, requires TLSKeyFile`
+	usageMsg["TLSKeyFile"] = `PEM private key of TLSCertFile`
+	usageMsg["TLSServerName"] = `Server name used to verify broker certificates instead of broker host`
+	usageMsg["TLSInsecureSkipVerify"] = `When true - broker certificates are not verified (testing only)`
+	usageMsg["SASLMechanism"] = `SASL mechanism: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER, SASL is disabled when empty`
+	usageMsg["SASLUser"] = `SASL user for PLAIN and SCRAM mechanisms`
+	usageMsg["SASLPassword"] = `SASL password, prefer SASLPasswordFile to keep it out of config and process list`
+	usageMsg["SASLPasswordFile"] = `File with SASL password, trailing new line is ignored`
+	usageMsg["SASLTokenFile"] = `File with OAUTHBEARER access token, it is read on each connection so it could be refreshed`
+}
+
+// options validates SASL mechanism and returns security options.
+func (s securitySettings) options() (security.Options, error) {
+	mechanism, err := security.ParseMechanism(s.SASLMechanism)
+	if err != nil {
+		return security.Options{}, fmt.Errorf("failed to parse SASLMechanism: %w", err)
+	}
+
+	return security.Options{
+		TLS: security.TLSOptions{
+			Enabled:            s.TLSEnabled,
+			CAFile:             s.TLSCAFile,
+			CertFile:           s.TLSCertFile,
+			KeyFile:            s.TLSKeyFile,
+			ServerName:         s.TLSServerName,
+			InsecureSkipVerify: s.TLSInsecureSkipVerify,
+		},
+		SASL: security.SASLOptions{
+			Mechanism:    mechanism,
+			User:         s.SASLUser,
+			Password:     s.SASLPassword,
+			PasswordFile: s.SASLPasswordFile,
+			TokenFile:    s.SASLTokenFile,
+		},
+	}, nil
+}
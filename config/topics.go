@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/obalunenko/kafka-dump/dumper"
+	"github.com/obalunenko/kafka-dump/filter"
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/redact"
+)
+
+// TopicConfig overrides dump settings of topic with Name or of topics matched by Pattern.
+// It is set in config file as [[topic]] table, empty fields inherit global settings.
+type TopicConfig struct {
+	Name               string // topic is dumped even when it is not listed in Topics
+	Pattern            string // regular expression of topic names, matched topics should be subscribed by Topics or TopicsInclude
+	OutputFormat       string
+	RecordSeparator    string // used only by raw OutputFormat, supports escape sequences
+	Compression        string
+	KeyDecoder         string
+	ValueDecoder       string
+	Filter             string        // replaces global Filter
+	Redact             []redact.Rule `toml:"redact"` // [[topic.redact]] tables applied to decoded keys and values
+	OutputPathTemplate string
+	Retention          string // duration (e.g. 168h), "0" disables global Retention
+}
+
+// override validates topic settings and returns dumper override built from them.
+func (t TopicConfig) override(globalFormat format.Format, globalSeparator string, redactKey []byte) (dumper.TopicOverride, error) {
+	o := dumper.TopicOverride{Name: t.Name}
+
+	var err error
+
+	if t.Pattern != "" {
+		if o.Pattern, err = regexp.Compile(t.Pattern); err != nil {
+			return o, fmt.Errorf("failed to parse Pattern: %w", err)
+		}
+	}
+
+	if t.OutputFormat != "" || t.RecordSeparator != "" {
+		f, separator := globalFormat, globalSeparator
+
+		if t.OutputFormat != "" {
+			if f, err = format.Parse(t.OutputFormat); err != nil {
+				return o, fmt.Errorf("failed to parse OutputFormat: %w", err)
+			}
+		}
+
+		if t.RecordSeparator != "" {
+			if f != format.Raw {
+				return o, fmt.Errorf("RecordSeparator could not be set for %s OutputFormat", f)
+			}
+
+			separator = t.RecordSeparator
+		}
+
+		if o.Encoder, err = format.NewEncoder(f, separator); err != nil {
+			return o, fmt.Errorf("failed to create encoder: %w", err)
+		}
+	}
+
+	if t.Compression != "" {
+		if o.Compression, err = format.ParseCompression(t.Compression); err != nil {
+			return o, fmt.Errorf("failed to parse Compression: %w", err)
+		}
+	}
+
+	if t.KeyDecoder != "" {
+		if o.Decoders.Key, err = format.NewDecoder(t.KeyDecoder); err != nil {
+			return o, fmt.Errorf("failed to parse KeyDecoder: %w", err)
+		}
+	}
+
+	if t.ValueDecoder != "" {
+		if o.Decoders.Value, err = format.NewDecoder(t.ValueDecoder); err != nil {
+			return o, fmt.Errorf("failed to parse ValueDecoder: %w", err)
+		}
+	}
+
+	if t.Filter != "" {
+		if o.Filter, err = filter.Parse(t.Filter); err != nil {
+			return o, fmt.Errorf("failed to parse Filter: %w", err)
+		}
+	}
+
+	if len(t.Redact) != 0 {
+		if o.Redactor, err = redact.New(t.Redact, redactKey); err != nil {
+			return o, fmt.Errorf("failed to parse [[topic.redact]]: %w", err)
+		}
+	}
+
+	if t.OutputPathTemplate != "" {
+		if o.Template, err = dumper.ParsePathTemplate(t.OutputPathTemplate); err != nil {
+			return o, fmt.Errorf("failed to parse OutputPathTemplate: %w", err)
+		}
+	}
+
+	if t.Retention != "" {
+		if o.Retention, err = time.ParseDuration(t.Retention); err != nil {
+			return o, fmt.Errorf("failed to parse Retention: %w", err)
+		}
+
+		if o.Retention < 0 {
+			return o, errors.New("Retention should not be negative")
+		}
+
+		if o.Retention == 0 {
+			o.Retention = -1
+		}
+	}
+
+	return o, nil
+}
+
+// name returns name or pattern of topic settings for error messages.
+func (t TopicConfig) name() string {
+	if t.Pattern != "" {
+		return "pattern [" + t.Pattern + "]"
+	}
+
+	return "topic [" + t.Name + "]"
+}
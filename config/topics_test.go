@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+func TestTopicRetention(t *testing.T) {
+	tests := []struct {
+		retention string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{retention: "", want: 0},
+		{retention: "168h", want: 168 * time.Hour},
+		// zero disables global retention of topic.
+		{retention: "0", want: -1},
+		{retention: "0s", want: -1},
+		{retention: "-1h", wantErr: true},
+		{retention: "week", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		o, err := TopicConfig{Name: "orders", Retention: tc.retention}.override(format.JSONL, "", nil)
+
+		switch {
+		case tc.wantErr:
+			if err == nil {
+				t.Errorf("override() of Retention %q error = nil", tc.retention)
+			}
+		case err != nil || o.Retention != tc.want:
+			t.Errorf("override() of Retention %q = %s, %v, want %s", tc.retention, o.Retention, err, tc.want)
+		}
+	}
+}
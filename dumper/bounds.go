@@ -0,0 +1,352 @@
+package dumper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// StopOptions configures conditions on which dumper stops by itself, zero values are disabled.
+type StopOptions struct {
+	// AtHighWatermark records high watermarks of all partitions of topics at start and stops dumper
+	// when all claimed partitions are consumed up to them.
+	AtHighWatermark bool
+	// MaxMessages stops dumper after this number of dumped messages.
+	MaxMessages int64
+	// MaxBytes stops dumper after this number of dumped record bytes.
+	MaxBytes int64
+	// MaxDuration stops dumper after this time since start.
+	MaxDuration time.Duration
+	// MaxIdle stops dumper when no messages were received for this time.
+	MaxIdle time.Duration
+}
+
+// drainIdle is how long consumer of partition should receive nothing before partition
+// is considered drained, it is longer than fetch wait time and retry backoff of sarama consumer.
+var drainIdle = 5 * time.Second
+
+// partitionBounds are offsets of partition recorded at start.
+type partitionBounds struct {
+	oldest int64
+	end    int64
+	// to is a time of the first message that is beyond the end, zero when partition is not bounded by time.
+	to time.Time
+}
+
+// progress counts dumped messages and stops dumper when stop condition is met.
+// It is safe for concurrent use by consumers of different partitions.
+type progress struct {
+	opts   StopOptions
+	cancel context.CancelFunc
+	log    Logger
+
+	mu sync.Mutex
+	// bounds holds offsets of all partitions of topics or of selected ranges, nil when dumper is not bounded.
+	bounds map[topicPartition]partitionBounds
+	// pending holds claimed or selected partitions that are not consumed up to their end yet.
+	pending     map[topicPartition]bool
+	messages    int64
+	bytes       int64
+	filtered    int64
+	lastMessage time.Time
+	reason      string
+	complete    bool
+}
+
+func newProgress(opts StopOptions, cancel context.CancelFunc, logger Logger) *progress {
+	return &progress{
+		opts:        opts,
+		cancel:      cancel,
+		log:         logger,
+		lastMessage: time.Now(),
+	}
+}
+
+// recordBounds records oldest and high watermark offsets of all partitions of topics.
+func (p *progress) recordBounds(client sarama.Client, topics []string) error {
+	bounds := make(map[topicPartition]partitionBounds)
+
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return fmt.Errorf("failed to get partitions of topic [%s]: %w", topic, err)
+		}
+
+		for _, partition := range partitions {
+			oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return fmt.Errorf("failed to get oldest offset of [%s:%d]: %w", topic, partition, err)
+			}
+
+			end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return fmt.Errorf("failed to get high watermark of [%s:%d]: %w", topic, partition, err)
+			}
+
+			p.log.Infof("Partition [%s:%d] will be dumped up to offset %d", topic, partition, end)
+
+			bounds[topicPartition{topic: topic, partition: partition}] = partitionBounds{oldest: oldest, end: end}
+		}
+	}
+
+	p.mu.Lock()
+	p.bounds = bounds
+	p.mu.Unlock()
+
+	return nil
+}
+
+// setRanges bounds dumper by resolved ranges of partitions, empty ranges are done at once.
+func (p *progress) setRanges(ranges []partitionRange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bounds = make(map[topicPartition]partitionBounds, len(ranges))
+	p.pending = make(map[topicPartition]bool, len(ranges))
+
+	for _, r := range ranges {
+		p.bounds[r.topicPartition] = partitionBounds{oldest: r.start, end: r.end, to: r.to}
+
+		if r.start < r.end {
+			p.pending[r.topicPartition] = true
+		}
+	}
+
+	if len(p.pending) == 0 {
+		p.finish("no messages in selected range")
+	}
+}
+
+// setClaims starts tracking of partitions claimed by new session.
+func (p *progress) setClaims(claims map[string][]int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bounds == nil {
+		return
+	}
+
+	p.pending = make(map[topicPartition]bool)
+
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			p.pending[topicPartition{topic: topic, partition: partition}] = true
+		}
+	}
+
+	if len(p.pending) == 0 {
+		p.finish("no partitions claimed")
+	}
+}
+
+// reached reports whether partition consumed from offset has nothing to consume up to its end.
+// Offset could be sarama.OffsetOldest or sarama.OffsetNewest.
+func (p *progress) reached(topic string, partition int32, offset int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bounds == nil {
+		return false
+	}
+
+	tp := topicPartition{topic: topic, partition: partition}
+
+	b, ok := p.bounds[tp]
+	if !ok {
+		// partition was created after start.
+		p.done(tp)
+
+		return true
+	}
+
+	switch offset {
+	case sarama.OffsetOldest:
+		offset = b.oldest
+	case sarama.OffsetNewest:
+		offset = b.end
+	}
+
+	if offset < b.oldest {
+		offset = b.oldest
+	}
+
+	if offset >= b.end {
+		p.done(tp)
+
+		return true
+	}
+
+	return false
+}
+
+// add counts consumed message, dumped one of size bytes or filtered out, and reports whether
+// its partition is consumed up to its end.
+func (p *progress) add(msg *sarama.ConsumerMessage, size int, dumped bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if dumped {
+		p.messages++
+		p.bytes += int64(size)
+	} else {
+		p.filtered++
+	}
+
+	p.lastMessage = time.Now()
+
+	var reached bool
+
+	if p.bounds != nil {
+		tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+
+		if b, ok := p.bounds[tp]; !ok || msg.Offset+1 >= b.end {
+			reached = true
+
+			p.done(tp)
+		}
+	}
+
+	switch {
+	case p.opts.MaxMessages > 0 && p.messages >= p.opts.MaxMessages:
+		p.stop(fmt.Sprintf("%d messages dumped", p.messages))
+	case p.opts.MaxBytes > 0 && p.bytes >= p.opts.MaxBytes:
+		p.stop(fmt.Sprintf("%d bytes dumped", p.bytes))
+	}
+
+	return reached
+}
+
+// beyond reports whether message is at or after the end of its partition or its timestamp is at or after
+// the end time. Such message follows offsets before the end that are not data records or ends time range,
+// it is not dumped and its partition is consumed up to its end.
+func (p *progress) beyond(msg *sarama.ConsumerMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bounds == nil {
+		return false
+	}
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+
+	b, ok := p.bounds[tp]
+	if !ok || msg.Offset < b.end && (b.to.IsZero() || msg.Timestamp.Before(b.to)) {
+		return false
+	}
+
+	p.done(tp)
+
+	return true
+}
+
+// drainInterval returns how often consumers should check whether their partition is drained.
+func drainInterval() time.Duration {
+	return drainIdle / 4
+}
+
+// drained reports whether partition is consumed up to its end although no message at end-1 was received,
+// as the last offsets before end are transaction markers or were removed by compaction. It is the case
+// when consumer has no buffered messages, high watermark of its fetches reached end and nothing
+// was received for drainIdle, as fetches return available messages at once.
+func (p *progress) drained(tp topicPartition, hwm int64, buffered int, idle time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bounds == nil || buffered > 0 || idle < drainIdle {
+		return false
+	}
+
+	// partition bounded by future time is consumed until the time passes.
+	if b, ok := p.bounds[tp]; ok && hwm < b.end && (b.to.IsZero() || time.Now().Before(b.to)) {
+		return false
+	}
+
+	p.log.Infof("Partition [%s:%d] has no messages up to its end, the last offsets are not data records",
+		tp.topic, tp.partition)
+	p.done(tp)
+
+	return true
+}
+
+// finishPartition marks partition as consumed up to its end before reaching its end offset.
+func (p *progress) finishPartition(tp topicPartition) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done(tp)
+}
+
+// check stops dumper when it runs longer than MaxDuration or idles longer than MaxIdle.
+func (p *progress) check(started, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.opts.MaxDuration > 0 && now.Sub(started) >= p.opts.MaxDuration:
+		p.stop(fmt.Sprintf("running for %s", p.opts.MaxDuration))
+	case p.opts.MaxIdle > 0 && now.Sub(p.lastMessage) >= p.opts.MaxIdle:
+		p.stop(fmt.Sprintf("no messages for %s", p.opts.MaxIdle))
+	}
+}
+
+// done marks partition as consumed up to its end and finishes dumper when all claimed partitions are done.
+func (p *progress) done(tp topicPartition) {
+	if !p.pending[tp] {
+		return
+	}
+
+	delete(p.pending, tp)
+
+	p.log.Infof("Partition [%s:%d] is dumped up to its end", tp.topic, tp.partition)
+
+	if len(p.pending) == 0 {
+		p.finish("all partitions are dumped up to their end")
+	}
+}
+
+func (p *progress) finish(reason string) {
+	if p.reason == "" {
+		p.complete = true
+	}
+
+	p.stop(reason)
+}
+
+func (p *progress) stop(reason string) {
+	if p.reason != "" {
+		return
+	}
+
+	p.log.Infof("Stopping: %s", reason)
+
+	p.reason = reason
+	p.cancel()
+}
+
+// logSummary logs numbers of dumped and filtered out messages.
+func (p *progress) logSummary() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.log.Infof("Dumped %d messages (%d bytes), %d messages filtered out", p.messages, p.bytes, p.filtered)
+}
+
+// result returns error when bounded dumper was stopped before reaching end of partitions.
+func (p *progress) result() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bounds == nil || p.complete {
+		return nil
+	}
+
+	reason := p.reason
+	if reason == "" {
+		reason = "interrupted"
+	}
+
+	return &IncompleteError{Reason: reason, Remaining: len(p.pending)}
+}
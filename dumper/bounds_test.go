@@ -0,0 +1,147 @@
+package dumper
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+func TestProgressDrained(t *testing.T) {
+	tp := topicPartition{topic: "t"}
+
+	tests := []struct {
+		name     string
+		hwm      int64
+		buffered int
+		idle     time.Duration
+		want     bool
+	}{
+		{name: "drained", hwm: 5, idle: drainIdle, want: true},
+		{name: "high watermark grew", hwm: 7, idle: drainIdle, want: true},
+		{name: "high watermark before end", hwm: 4, idle: drainIdle},
+		{name: "messages are buffered", hwm: 5, buffered: 1, idle: drainIdle},
+		{name: "message received recently", hwm: 5, idle: drainIdle - time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			canceled := false
+
+			p := newProgress(StopOptions{AtHighWatermark: true}, func() { canceled = true }, discardLogger())
+			p.bounds = map[topicPartition]partitionBounds{tp: {oldest: 0, end: 5}}
+			p.setClaims(map[string][]int32{"t": {0}})
+
+			if got := p.drained(tp, tc.hwm, tc.buffered, tc.idle); got != tc.want {
+				t.Errorf("drained = %v, want %v", got, tc.want)
+			}
+
+			if canceled != tc.want || (p.result() == nil) != tc.want {
+				t.Errorf("dumper is stopped: %v, result: %v", canceled, p.result())
+			}
+		})
+	}
+
+	p := newProgress(StopOptions{}, func() {}, discardLogger())
+	if p.drained(tp, 5, 0, drainIdle) {
+		t.Error("unbounded partition is drained")
+	}
+}
+
+// TestAtHighWatermarkAfterControlRecords checks that dumper stopping at high watermark finishes
+// partition which last offsets before high watermark are not data records, e.g. transaction markers.
+func TestAtHighWatermarkAfterControlRecords(t *testing.T) {
+	defer func(idle time.Duration) {
+		drainIdle = idle
+	}(drainIdle)
+
+	drainIdle = 200 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// end is a high watermark at start, hwm is a high watermark returned by fetches.
+		end, hwm int64
+		data     []int64
+		want     []int64
+	}{
+		{name: "nothing after marker", end: 3, hwm: 3, data: []int64{0, 1}, want: []int64{0, 1}},
+		{name: "message produced after start follows marker", end: 2, hwm: 3, data: []int64{0, 2}, want: []int64{0}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := dumpGroupAtHighWatermark(t, tc.end, tc.hwm, tc.data)
+			if !equalOffsets(got, tc.want) {
+				t.Errorf("dumped offsets = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// dumpGroupAtHighWatermark dumps partition with data records at offsets as consumer group member
+// stopping at high watermark end, and returns dumped offsets. Offsets without data are transaction markers
+// that are not returned to consumer.
+func dumpGroupAtHighWatermark(t *testing.T, end, hwm int64, offsets []int64) []int64 {
+	const topic = "orders"
+
+	b := sarama.NewMockBroker(t, 1)
+	defer b.Close()
+
+	fetch := sarama.NewMockFetchResponse(t, 1).SetVersion(7).SetHighWaterMark(topic, 0, hwm)
+
+	for _, offset := range offsets {
+		fetch.SetMessage(topic, 0, offset, sarama.StringEncoder("v"))
+	}
+
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(topic, 0, b.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, end),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group", b),
+		"JoinGroupRequest":  joinGroupResponse(t, 1, topic),
+		"SyncGroupRequest":  syncGroupResponse(t, topic, 0),
+		"HeartbeatRequest":  sarama.NewMockWrapper(&sarama.HeartbeatResponse{}),
+		"LeaveGroupRequest": sarama.NewMockLeaveGroupResponse(t),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group", topic, 0, -1, "", sarama.ErrNoError).
+			SetError(sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"FetchRequest":        fetch,
+	})
+
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}")
+
+	d, err := New(Options{
+		Brokers:  []string{b.Addr()},
+		GroupID:  "group",
+		ClientID: "test",
+		Version:  sarama.V2_0_0_0,
+		Topics:   []string{topic},
+		Layout:   settings.layout,
+		Encoder:  settings.encoder,
+		Stop:     StopOptions{AtHighWatermark: true},
+		Logger:   discardLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = d.Run(ctx); err != nil {
+		t.Fatalf("Run() = %v, want partition dumped up to high watermark", err)
+	}
+
+	return readOffsets(t, filepath.Join(dir, topic, "0.jsonl"))
+}
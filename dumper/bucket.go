@@ -0,0 +1,130 @@
+package dumper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// TimestampSource is a source of message time used for bucketing messages into files.
+type TimestampSource string
+
+const (
+	// TimestampCreate - message timestamp (CreateTime set by producer, or LogAppendTime when topic configured so).
+	TimestampCreate TimestampSource = "create"
+	// TimestampLogAppend - outer block timestamp (LogAppendTime of compressed message set).
+	TimestampLogAppend TimestampSource = "logappend"
+	// TimestampReceive - wall-clock time when message was received by dumper.
+	TimestampReceive TimestampSource = "receive"
+)
+
+// ParseTimestampSource parses timestamp source name (case insensitive).
+func ParseTimestampSource(s string) (TimestampSource, error) {
+	src := TimestampSource(strings.ToLower(strings.TrimSpace(s)))
+
+	switch src {
+	case TimestampCreate, TimestampLogAppend, TimestampReceive:
+		return src, nil
+	default:
+		return "", fmt.Errorf("unknown timestamp source [%s]", s)
+	}
+}
+
+// Bucketing is a granularity of time buckets.
+type Bucketing string
+
+const (
+	// BucketDaily - one bucket per day.
+	BucketDaily Bucketing = "daily"
+	// BucketHourly - one bucket per hour.
+	BucketHourly Bucketing = "hourly"
+)
+
+// ParseBucketing parses bucketing name (case insensitive).
+func ParseBucketing(s string) (Bucketing, error) {
+	b := Bucketing(strings.ToLower(strings.TrimSpace(s)))
+
+	switch b {
+	case BucketDaily, BucketHourly:
+		return b, nil
+	default:
+		return "", fmt.Errorf("unknown bucketing [%s]", s)
+	}
+}
+
+// Bucketer computes time bucket of message in configured location.
+type Bucketer struct {
+	Location  *time.Location
+	Source    TimestampSource
+	Bucketing Bucketing
+	// Now returns wall-clock time, time.Now is used when nil.
+	Now func() time.Time
+
+	log Logger
+}
+
+// Time returns message time from configured source converted to bucketer location.
+// Pre-0.10 message formats have no timestamps, so when selected source is empty
+// the next available one is used: create -> logappend -> receive.
+func (b Bucketer) Time(msg *sarama.ConsumerMessage) time.Time {
+	ts := b.sourceTime(msg)
+	if ts.IsZero() {
+		ts = b.fallbackTime(msg)
+	}
+
+	loc := b.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	return ts.In(loc)
+}
+
+// Bucket returns name of time bucket of message.
+func (b Bucketer) Bucket(msg *sarama.ConsumerMessage) string {
+	layout := "2006-01-02"
+	if b.Bucketing == BucketHourly {
+		layout = "2006-01-02_15"
+	}
+
+	return b.Time(msg).Format(layout)
+}
+
+func (b Bucketer) sourceTime(msg *sarama.ConsumerMessage) time.Time {
+	switch b.Source {
+	case TimestampLogAppend:
+		return msg.BlockTimestamp
+	case TimestampReceive:
+		return b.now()
+	default:
+		return msg.Timestamp
+	}
+}
+
+func (b Bucketer) fallbackTime(msg *sarama.ConsumerMessage) time.Time {
+	for _, ts := range []time.Time{msg.Timestamp, msg.BlockTimestamp} {
+		if !ts.IsZero() {
+			b.logger().Debugf("Message [%s:%d:%d] has no %s timestamp, using %s", msg.Topic, msg.Partition, msg.Offset, b.Source, ts)
+
+			return ts
+		}
+	}
+
+	b.logger().Debugf("Message [%s:%d:%d] has no timestamps, using receive time", msg.Topic, msg.Partition, msg.Offset)
+
+	return b.now()
+}
+
+func (b Bucketer) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+
+	return time.Now()
+}
+
+func (b Bucketer) logger() Logger {
+	return loggerOrDefault(b.log)
+}
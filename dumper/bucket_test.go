@@ -0,0 +1,100 @@
+package dumper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestParseTimestampSource(t *testing.T) {
+	for in, want := range map[string]TimestampSource{
+		"create":      TimestampCreate,
+		" LogAppend ": TimestampLogAppend,
+		"RECEIVE":     TimestampReceive,
+	} {
+		if got, err := ParseTimestampSource(in); err != nil || got != want {
+			t.Errorf("ParseTimestampSource(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "produce"} {
+		if _, err := ParseTimestampSource(in); err == nil {
+			t.Errorf("ParseTimestampSource(%q) error = nil", in)
+		}
+	}
+}
+
+func TestParseBucketing(t *testing.T) {
+	for in, want := range map[string]Bucketing{"daily": BucketDaily, " Hourly": BucketHourly} {
+		if got, err := ParseBucketing(in); err != nil || got != want {
+			t.Errorf("ParseBucketing(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "weekly"} {
+		if _, err := ParseBucketing(in); err == nil {
+			t.Errorf("ParseBucketing(%q) error = nil", in)
+		}
+	}
+}
+
+func TestBucketerTime(t *testing.T) {
+	var (
+		create    = time.Date(2021, time.February, 3, 23, 30, 0, 0, time.UTC)
+		logAppend = time.Date(2021, time.February, 3, 23, 45, 0, 0, time.UTC)
+		receive   = time.Date(2021, time.February, 4, 0, 15, 0, 0, time.UTC)
+	)
+
+	tests := []struct {
+		name      string
+		source    TimestampSource
+		timestamp time.Time
+		block     time.Time
+		want      time.Time
+	}{
+		{name: "create", source: TimestampCreate, timestamp: create, block: logAppend, want: create},
+		{name: "create falls back to logappend", source: TimestampCreate, block: logAppend, want: logAppend},
+		{name: "create falls back to receive", source: TimestampCreate, want: receive},
+		{name: "logappend", source: TimestampLogAppend, timestamp: create, block: logAppend, want: logAppend},
+		{name: "logappend falls back to create", source: TimestampLogAppend, timestamp: create, want: create},
+		{name: "logappend falls back to receive", source: TimestampLogAppend, want: receive},
+		{name: "receive", source: TimestampReceive, timestamp: create, block: logAppend, want: receive},
+		{name: "empty source is create", timestamp: create, block: logAppend, want: create},
+	}
+
+	for _, tc := range tests {
+		b := Bucketer{Source: tc.source, Now: func() time.Time { return receive }, log: discardLogger()}
+		msg := &sarama.ConsumerMessage{Timestamp: tc.timestamp, BlockTimestamp: tc.block}
+
+		if got := b.Time(msg); !got.Equal(tc.want) || got.Location() != time.UTC {
+			t.Errorf("%s: Time() = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Timestamp: time.Date(2021, time.February, 3, 23, 30, 0, 0, time.UTC)}
+	brussels := time.FixedZone("CET", 3600)
+
+	tests := []struct {
+		name      string
+		location  *time.Location
+		bucketing Bucketing
+		want      string
+	}{
+		{name: "daily utc", bucketing: BucketDaily, want: "2021-02-03"},
+		{name: "hourly utc", bucketing: BucketHourly, want: "2021-02-03_23"},
+		{name: "daily in location", location: brussels, bucketing: BucketDaily, want: "2021-02-04"},
+		{name: "hourly in location", location: brussels, bucketing: BucketHourly, want: "2021-02-04_00"},
+		{name: "empty bucketing is daily", location: brussels, want: "2021-02-04"},
+	}
+
+	for _, tc := range tests {
+		b := Bucketer{Location: tc.location, Source: TimestampCreate, Bucketing: tc.bucketing}
+
+		if got := b.Bucket(msg); got != tc.want {
+			t.Errorf("%s: Bucket() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
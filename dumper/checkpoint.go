@@ -0,0 +1,199 @@
+package dumper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// checkpoint keeps offsets of the next messages to dump of each partition in local file,
+// it is used instead of consumer group committed offsets. It is safe for concurrent use.
+type checkpoint struct {
+	path string
+	sync bool
+
+	mu sync.Mutex
+	// offsets holds next offset to consume by topic and partition.
+	offsets map[string]map[int32]int64
+	dirty   bool
+}
+
+// loadCheckpoint reads checkpoint file, missing file is an empty checkpoint.
+// When sync is set saved file is fsynced.
+func loadCheckpoint(path string, sync bool) (*checkpoint, error) {
+	cp := &checkpoint{
+		path:    path,
+		sync:    sync,
+		offsets: make(map[string]map[int32]int64),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cp, nil
+		}
+
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &cp.offsets); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint [%s]: %w", path, err)
+	}
+
+	return cp, nil
+}
+
+// next returns offset of the next message of partition to consume and whether it is known.
+func (cp *checkpoint) next(topic string, partition int32) (int64, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	offset, ok := cp.offsets[topic][partition]
+
+	return offset, ok
+}
+
+// mark records message as dumped, it has the same signature as marker.
+func (cp *checkpoint) mark(msg *sarama.ConsumerMessage) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	partitions, ok := cp.offsets[msg.Topic]
+	if !ok {
+		partitions = make(map[int32]int64)
+		cp.offsets[msg.Topic] = partitions
+	}
+
+	partitions[msg.Partition] = msg.Offset + 1
+	cp.dirty = true
+}
+
+// save writes checkpoint file when offsets were changed since the last save.
+// File is replaced by rename, so crash never leaves partially written checkpoint.
+// When sync is set the rename is made durable by fsync of checkpoint directory.
+func (cp *checkpoint) save() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if !cp.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(cp.offsets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(cp.path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create checkpoint dir: %w", err)
+	}
+
+	tmp := cp.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+
+	if _, err = f.Write(data); err == nil && cp.sync {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	if err = os.Rename(tmp, cp.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+
+	if cp.sync {
+		if err = syncDir(filepath.Dir(cp.path)); err != nil {
+			return err
+		}
+	}
+
+	cp.dirty = false
+
+	return nil
+}
+
+// resolveCheckpoint returns ranges of selected partitions of topics that start from checkpoint offsets,
+// or from the oldest or the newest offsets for partitions without them. Ranges end at high watermarks at start
+// when bounded is set, otherwise they are not bounded.
+func resolveCheckpoint(client sarama.Client, topics []string, partitions []int32, cp *checkpoint, newest, bounded bool,
+	logger Logger) ([]partitionRange, error) {
+	var ranges []partitionRange
+
+	for _, topic := range topics {
+		all, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get partitions of topic [%s]: %w", topic, err)
+		}
+
+		for _, partition := range all {
+			if len(partitions) != 0 && !containsPartition(partitions, partition) {
+				continue
+			}
+
+			oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get oldest offset of [%s:%d]: %w", topic, partition, err)
+			}
+
+			hwm, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get high watermark of [%s:%d]: %w", topic, partition, err)
+			}
+
+			r := partitionRange{topicPartition: topicPartition{topic: topic, partition: partition}, start: oldest, end: unbounded}
+
+			next, ok := cp.next(topic, partition)
+
+			switch {
+			case ok && next < oldest:
+				logger.Warnf("Checkpoint offset %d of [%s:%d] is not available anymore, oldest offset %d is used",
+					next, topic, partition, oldest)
+			case ok && next > hwm:
+				logger.Warnf("Checkpoint offset %d of [%s:%d] is after high watermark, high watermark %d is used",
+					next, topic, partition, hwm)
+
+				r.start = hwm
+			case ok:
+				r.start = next
+			case newest:
+				r.start = hwm
+			}
+
+			if bounded {
+				r.end = hwm
+			}
+
+			logger.Infof("Partition [%s:%d] will be dumped from offset %d", topic, partition, r.start)
+
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges, nil
+}
+
+func containsPartition(list []int32, p int32) bool {
+	for _, v := range list {
+		if v == p {
+			return true
+		}
+	}
+
+	return false
+}
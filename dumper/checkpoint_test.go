@@ -0,0 +1,226 @@
+package dumper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+func TestCheckpointSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "checkpoint.json")
+
+	cp, err := loadCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cp.next("orders", 0); ok {
+		t.Error("next() of missing checkpoint is known")
+	}
+
+	cp.mark(&sarama.ConsumerMessage{Topic: "orders", Partition: 0, Offset: 4})
+	cp.mark(&sarama.ConsumerMessage{Topic: "orders", Partition: 0, Offset: 7})
+	cp.mark(&sarama.ConsumerMessage{Topic: "payments", Partition: 2, Offset: 0})
+
+	if err = cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("checkpoint permissions = %o, want 600", perm)
+	}
+
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary checkpoint is left: %v", err)
+	}
+
+	loaded, err := loadCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		topic     string
+		partition int32
+		want      int64
+		wantOK    bool
+	}{
+		{topic: "orders", partition: 0, want: 8, wantOK: true},
+		{topic: "payments", partition: 2, want: 1, wantOK: true},
+		{topic: "orders", partition: 1},
+		{topic: "unknown", partition: 0},
+	} {
+		if got, ok := loaded.next(tc.topic, tc.partition); got != tc.want || ok != tc.wantOK {
+			t.Errorf("next(%s, %d) = %d, %t, want %d, %t", tc.topic, tc.partition, got, ok, tc.want, tc.wantOK)
+		}
+	}
+
+	// unchanged checkpoint is not written again.
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = loaded.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged checkpoint is saved: %v", err)
+	}
+}
+
+func TestLoadCorruptCheckpoint(t *testing.T) {
+	for name, data := range map[string]string{
+		"truncated":    `{"orders": {"0": 1`,
+		"not json":     "offsets",
+		"wrong types":  `{"orders": {"0": "1"}}`,
+		"wrong nested": `{"orders": [1, 2]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+		if err := ioutil.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadCheckpoint(path, false); err == nil {
+			t.Errorf("%s: loadCheckpoint() error = nil", name)
+		}
+	}
+}
+
+func TestResolveCheckpoint(t *testing.T) {
+	b := rangeBroker(t, nil)
+	defer b.Close()
+
+	client := rangeClient(t, b)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	tests := []struct {
+		name    string
+		offsets map[int32]int64
+		newest  bool
+		bounded bool
+		want    []partitionRange
+	}{
+		{
+			name:    "checkpoint offsets",
+			offsets: map[int32]int64{0: 5},
+			want:    []partitionRange{checkpointRange(0, 5, unbounded), checkpointRange(1, 0, unbounded)},
+		},
+		{
+			name:    "bounded by high watermarks",
+			offsets: map[int32]int64{0: 5},
+			bounded: true,
+			want:    []partitionRange{checkpointRange(0, 5, 10), checkpointRange(1, 0, 0)},
+		},
+		{
+			name:    "removed offset starts from oldest",
+			offsets: map[int32]int64{0: 1},
+			want:    []partitionRange{checkpointRange(0, 2, unbounded), checkpointRange(1, 0, unbounded)},
+		},
+		{
+			name:    "offset after high watermark starts from high watermark",
+			offsets: map[int32]int64{0: 20},
+			want:    []partitionRange{checkpointRange(0, 10, unbounded), checkpointRange(1, 0, unbounded)},
+		},
+		{
+			name:   "newest without checkpoint",
+			newest: true,
+			want:   []partitionRange{checkpointRange(0, 10, unbounded), checkpointRange(1, 0, unbounded)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cp := &checkpoint{offsets: map[string]map[int32]int64{"orders": tc.offsets}}
+
+			got, err := resolveCheckpoint(client, []string{"orders"}, nil, cp, tc.newest, tc.bounded, discardLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("resolveCheckpoint() = %+v, want %+v", got, tc.want)
+			}
+
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("resolveCheckpoint()[%d] = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func checkpointRange(partition int32, start, end int64) partitionRange {
+	return partitionRange{topicPartition: topicPartition{topic: "orders", partition: partition}, start: start, end: end}
+}
+
+// TestCheckpointResume checks that run with checkpoint dumps messages after the saved offsets and saves new ones.
+func TestCheckpointResume(t *testing.T) {
+	fetch := sarama.NewMockFetchResponse(t, 1).SetVersion(3).SetHighWaterMark("orders", 0, 10)
+
+	for offset := int64(2); offset < 10; offset++ {
+		fetch.SetMessage("orders", 0, offset, sarama.StringEncoder("v"))
+	}
+
+	b := rangeBroker(t, fetch)
+	defer b.Close()
+
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}")
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	if err := ioutil.WriteFile(path, []byte(`{"orders": {"0": 6}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New(Options{
+		Brokers:    []string{b.Addr()},
+		ClientID:   "test",
+		Version:    sarama.V0_10_2_0,
+		Topics:     []string{"orders"},
+		Layout:     settings.layout,
+		Encoder:    settings.encoder,
+		Checkpoint: path,
+		Stop:       StopOptions{AtHighWatermark: true},
+		Logger:     discardLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = d.Run(ctx); err != nil {
+		t.Fatalf("Run() = %v, want checkpoint resumed", err)
+	}
+
+	if got, want := readOffsets(t, filepath.Join(dir, "orders", "0.jsonl")), []int64{6, 7, 8, 9}; !equalOffsets(got, want) {
+		t.Errorf("dumped offsets = %v, want %v", got, want)
+	}
+
+	cp, err := loadCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next, ok := cp.next("orders", 0); next != 10 || !ok {
+		t.Errorf("checkpoint offset = %d, %t, want 10", next, ok)
+	}
+}
@@ -0,0 +1,164 @@
+package dumper
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// CommitPolicy defines when offsets of dumped messages are marked in durable mode.
+type CommitPolicy string
+
+const (
+	// CommitPerBatch - files are fsynced and offsets marked after each BatchSize messages.
+	CommitPerBatch CommitPolicy = "batch"
+	// CommitPerInterval - files are fsynced and offsets marked each Interval.
+	CommitPerInterval CommitPolicy = "interval"
+)
+
+// ParseCommitPolicy parses commit policy name (case insensitive).
+func ParseCommitPolicy(s string) (CommitPolicy, error) {
+	p := CommitPolicy(strings.ToLower(strings.TrimSpace(s)))
+
+	switch p {
+	case CommitPerBatch, CommitPerInterval:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown commit policy [%s]", s)
+	}
+}
+
+// CommitOptions configures coordination of offsets commits with writes.
+type CommitOptions struct {
+	// Durable enables at-least-once mode: offsets are marked only after data is flushed and fsynced,
+	// and partial trailing records left by crash are truncated when files are opened.
+	// When disabled offsets are marked right after message is written to buffer.
+	Durable   bool
+	Policy    CommitPolicy
+	BatchSize int
+	Interval  time.Duration
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// marker marks message offset as processed in consumer group session that received it.
+type marker func(msg *sarama.ConsumerMessage)
+
+type pendingMark struct {
+	msg  *sarama.ConsumerMessage
+	mark marker
+	// count is a number of dumped messages of partition since its last commit.
+	count int
+}
+
+// committer marks offsets of dumped messages according to commit options.
+// It is safe for concurrent use by consumers of different partitions.
+type committer struct {
+	opts CommitOptions
+	sync func() error
+	log  Logger
+
+	mu sync.Mutex
+	// pending holds the last dumped message of each partition that is not marked yet.
+	pending map[topicPartition]pendingMark
+	// count is a number of dumped messages of all pending partitions.
+	count int
+}
+
+func newCommitter(opts CommitOptions, sync func() error, logger Logger) *committer {
+	return &committer{
+		opts:    opts,
+		sync:    sync,
+		log:     loggerOrDefault(logger),
+		pending: make(map[topicPartition]pendingMark),
+	}
+}
+
+// Done registers dumped message.
+func (c *committer) Done(msg *sarama.ConsumerMessage, mark marker) error {
+	if !c.opts.Durable {
+		mark(msg)
+
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	c.pending[tp] = pendingMark{msg: msg, mark: mark, count: c.pending[tp].count + 1}
+	c.count++
+
+	if c.opts.Policy == CommitPerBatch && c.count >= c.opts.BatchSize {
+		return c.commit(nil)
+	}
+
+	return nil
+}
+
+// Commit fsyncs dumped data and marks offsets of all pending messages.
+func (c *committer) Commit() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.commit(nil)
+}
+
+// CommitPartition fsyncs dumped data and marks offset of pending message of partition.
+func (c *committer) CommitPartition(topic string, partition int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.commit(&topicPartition{topic: topic, partition: partition})
+}
+
+// commit marks pending messages of passed partition or of all partitions when it is nil.
+func (c *committer) commit(only *topicPartition) error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	if only != nil {
+		if _, ok := c.pending[*only]; !ok {
+			return nil
+		}
+	}
+
+	if err := c.sync(); err != nil {
+		return &WriteError{Err: fmt.Errorf("failed to sync dump files: %w", err)}
+	}
+
+	committed := 0
+
+	for tp, p := range c.pending {
+		if only != nil && tp != *only {
+			continue
+		}
+
+		p.mark(p.msg)
+		delete(c.pending, tp)
+
+		committed += p.count
+	}
+
+	c.count -= committed
+
+	c.log.Debugf("Committed %d dumped messages", committed)
+
+	return nil
+}
+
+// Interval returns how often Commit should be called, zero when commits are driven by batches only.
+func (c *committer) Interval() time.Duration {
+	if !c.opts.Durable || c.opts.Policy != CommitPerInterval {
+		return 0
+	}
+
+	return c.opts.Interval
+}
@@ -0,0 +1,47 @@
+package dumper
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestCommitterCountsPendingMessages(t *testing.T) {
+	syncs := 0
+	c := newCommitter(CommitOptions{Durable: true, Policy: CommitPerBatch, BatchSize: 3},
+		func() error { syncs++; return nil }, nil)
+
+	marked := make(map[int32]int64)
+	mark := func(msg *sarama.ConsumerMessage) { marked[msg.Partition] = msg.Offset }
+
+	for _, msg := range []*sarama.ConsumerMessage{testMessage("t", 0, 0), testMessage("t", 1, 0)} {
+		if err := c.Done(msg, mark); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// released partition takes its messages out of the batch.
+	if err := c.CommitPartition("t", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if syncs != 1 || len(marked) != 1 || c.count != 1 {
+		t.Fatalf("after partition commit: %d syncs, marked %v, count %d", syncs, marked, c.count)
+	}
+
+	if err := c.Done(testMessage("t", 1, 1), mark); err != nil {
+		t.Fatal(err)
+	}
+
+	if syncs != 1 {
+		t.Fatalf("batch of 2 messages is committed")
+	}
+
+	if err := c.Done(testMessage("t", 1, 2), mark); err != nil {
+		t.Fatal(err)
+	}
+
+	if syncs != 2 || marked[1] != 2 || c.count != 0 {
+		t.Fatalf("after batch: %d syncs, marked %v, count %d", syncs, marked, c.count)
+	}
+}
@@ -1,92 +1,822 @@
 package dumper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
-	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"sync/atomic"
-	"syscall"
+	"time"
 
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
-	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/filter"
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/metrics"
+	"github.com/obalunenko/kafka-dump/security"
 )
 
-// Start - starts the dumper consumer loop and processing messages.
-func Start(kafkaBrokers []string, kafkaGroupID string, kafkaClientID string,
-	kafkaVersion sarama.KafkaVersion, kafkaNewestOffset bool, kafkaTopics []string, outputDir string) {
-	// Create Kafka consumers
-	kafkaConfig := cluster.NewConfig()
+// ParseBalanceStrategy parses name of consumer group partitions balance strategy: range, roundrobin or sticky.
+func ParseBalanceStrategy(s string) (sarama.BalanceStrategy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case sarama.RangeBalanceStrategyName:
+		return sarama.BalanceStrategyRange, nil
+	case sarama.RoundRobinBalanceStrategyName:
+		return sarama.BalanceStrategyRoundRobin, nil
+	case sarama.StickyBalanceStrategyName:
+		return sarama.BalanceStrategySticky, nil
+	default:
+		return nil, fmt.Errorf("unknown balance strategy [%s]", s)
+	}
+}
+
+// Options configures Dumper.
+type Options struct {
+	Brokers  []string
+	GroupID  string
+	ClientID string
+	Version  sarama.KafkaVersion
+	// Security configures TLS and SASL authentication, connections are plaintext when zero.
+	Security security.Options
+	// Newest starts consuming of partitions without committed offsets from the newest offset instead of the oldest.
+	Newest bool
+	Topics []string
+	// TopicsInclude subscribes to all topics with matching names in addition to Topics.
+	// Topics created while dumper runs are picked up each TopicsRefresh.
+	TopicsInclude *regexp.Regexp
+	// TopicsExclude skips topics matched by TopicsInclude.
+	TopicsExclude *regexp.Regexp
+	// IncludeInternal allows TopicsInclude to match internal topics with names starting with "__".
+	IncludeInternal bool
+	// TopicsRefresh is how often topics matched by TopicsInclude are discovered, one minute when zero.
+	TopicsRefresh time.Duration
+	// BalanceStrategy is a consumer group partitions balance strategy, range is used when nil.
+	BalanceStrategy sarama.BalanceStrategy
+
+	Layout  Layout
+	Encoder format.Encoder
+	// Decoders convert message keys and values before encoding, nil decoders keep bytes as is.
+	Decoders format.Decoders
+	// Compression compresses dump files, its extension is appended to extension of Encoder.
+	Compression format.Compression
+	// Filter selects dumped messages, offsets of filtered out messages are committed as well.
+	// All messages are dumped when it is nil.
+	Filter   *filter.Filter
+	Writer   WriterOptions
+	Rotation RotationPolicy
+	// Retention is a time after the last modification when dump files are removed, zero keeps files forever.
+	// Files are removed only for topics that are dumped.
+	Retention time.Duration
+	// Overrides change Encoder, Decoders, Compression, Filter, Layout template and Retention of matching topics,
+	// the first matching override is applied.
+	Overrides []TopicOverride
+	Commit    CommitOptions
+	Stop      StopOptions
+	// Range dumps selected messages without consumer group instead of consuming as group member.
+	Range RangeOptions
+	// Checkpoint is a path of file where offsets of dumped messages are kept when partitions are consumed
+	// without consumer group, empty path disables it.
+	Checkpoint string
+	// Snapshot writes the latest value of each key of topics instead of all messages, consumer group is not used.
+	Snapshot SnapshotOptions
+	// Partitions limits consumed partitions of topics in range, checkpoint or snapshot mode, all partitions when empty.
+	Partitions []int32
+	// Overwrite removes Layout.OutputDir with dump files and checkpoint of previous runs when Run connects to brokers.
+	Overwrite bool
+
+	// Logger receives dumper logs, standard logrus logger is used when nil.
+	Logger Logger
+	// Metrics receives dumper and kafka client metrics, they are not collected when nil.
+	Metrics *metrics.Registry
+	// LivenessTimeout is a time without consumer loop iterations after which liveness check fails,
+	// one minute when zero.
+	LivenessTimeout time.Duration
+}
+
+func (o Options) validate() error {
+	switch {
+	case len(o.Brokers) == 0:
+		return errors.New("no kafka brokers")
+	case len(o.Topics) == 0 && o.TopicsInclude == nil:
+		return errors.New("no topics")
+	case o.GroupID == "" && !o.Range.Enabled() && o.Checkpoint == "" && !o.Snapshot.Enabled:
+		return errors.New("empty consumer group id")
+	case o.Range.Enabled() && o.Checkpoint != "":
+		return errors.New("range and checkpoint could not be used together")
+	case o.Snapshot.Enabled && (o.Range.Enabled() || o.Checkpoint != ""):
+		return errors.New("snapshot could not be used together with range or checkpoint")
+	case !o.Snapshot.At.IsZero() && !o.Snapshot.Enabled:
+		return errors.New("snapshot time is set but snapshot is not enabled")
+	case !o.Snapshot.At.IsZero() && !o.Version.IsAtLeast(sarama.V0_10_1_0):
+		return fmt.Errorf("kafka version at least 0.10.1.0 is required to lookup offsets by time, got %s", o.Version)
+	case len(o.Partitions) != 0 && !o.Range.Enabled() && o.Checkpoint == "" && !o.Snapshot.Enabled:
+		return errors.New("partitions could be selected only in range, checkpoint or snapshot mode")
+	case o.Overwrite && (filepath.Clean(o.Layout.OutputDir) == "." || filepath.Clean(o.Layout.OutputDir) == "/"):
+		return fmt.Errorf("output directory [%s] could not be overwritten", o.Layout.OutputDir)
+	case o.Commit.Durable && o.Commit.Policy == CommitPerBatch && o.Commit.BatchSize <= 0:
+		return fmt.Errorf("commit batch size should be positive, got %d", o.Commit.BatchSize)
+	case o.Commit.Durable && o.Commit.Policy == CommitPerInterval && o.Commit.Interval <= 0:
+		return fmt.Errorf("commit interval should be positive, got %s", o.Commit.Interval)
+	}
+
+	if err := o.topicSettings().validate(o.Rotation); err != nil {
+		return err
+	}
+
+	if err := validateOverrides(o.topicSettings(), o.Overrides, o.Rotation); err != nil {
+		return err
+	}
+
+	return o.Range.validate(o.Version)
+}
+
+// topicSettings returns dump settings of topics without overrides.
+func (o Options) topicSettings() topicSettings {
+	return topicSettings{
+		encoder:     o.Encoder,
+		decoders:    o.Decoders,
+		compression: o.Compression,
+		filter:      o.Filter,
+		layout:      o.Layout,
+		retention:   o.Retention,
+	}
+}
+
+// Dumper consumes topics as member of consumer group or selected ranges of partitions and writes messages to dump files.
+type Dumper struct {
+	opts    Options
+	log     Logger
+	config  *sarama.Config
+	metrics *dumperMetrics
+	health  *health
+	// overwritten is true when output directory of previous runs is removed.
+	overwritten bool
+}
+
+// New validates options and creates Dumper. Returned error is *ConfigError.
+func New(opts Options) (*Dumper, error) {
+	if err := opts.validate(); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
 
-	kafkaConfig.Group.Return.Notifications = true
+	if opts.BalanceStrategy == nil {
+		opts.BalanceStrategy = sarama.BalanceStrategyRange
+	}
+
+	m, err := newDumperMetrics(opts.Metrics)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	d := &Dumper{
+		opts:    opts,
+		log:     loggerOrDefault(opts.Logger),
+		metrics: m,
+		health:  newHealth(opts.Layout.OutputDir, opts.LivenessTimeout),
+	}
+
+	if d.config, err = d.kafkaConfig(); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	if opts.Metrics != nil {
+		opts.Metrics.Bridge("kafka_dump_sarama", d.config.MetricRegistry)
+	}
+
+	return d, nil
+}
 
-	kafkaConfig.ClientID = kafkaClientID
+func (d *Dumper) kafkaConfig() (*sarama.Config, error) {
+	kafkaConfig := sarama.NewConfig()
+
+	kafkaConfig.ClientID = d.opts.ClientID
 
 	kafkaConfig.Consumer.Return.Errors = true
-	kafkaConfig.Version = kafkaVersion
+	kafkaConfig.Version = d.opts.Version
 	kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	kafkaConfig.Consumer.Group.Rebalance.Strategy = d.opts.BalanceStrategy
 
-	if kafkaNewestOffset {
-		log.Infof("Will use OffsetNewest")
+	if d.opts.Newest {
+		d.log.Infof("Will use OffsetNewest")
 
 		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
 	}
 
-	consumer, err := cluster.NewConsumer(kafkaBrokers, kafkaGroupID, kafkaTopics, kafkaConfig)
+	if err := d.opts.Security.Apply(kafkaConfig); err != nil {
+		return nil, fmt.Errorf("failed to configure security: %w", err)
+	}
+
+	return kafkaConfig, nil
+}
+
+// LivenessHandler returns HTTP handler of liveness check that fails when consumer loop is stuck
+// or output directory is not writable. It responds with JSON of HealthStatus and 503 status code on failure.
+func (d *Dumper) LivenessHandler() http.Handler {
+	return healthHandler(d.health.liveness)
+}
+
+// ReadinessHandler returns HTTP handler of readiness check that passes when consumer group is joined
+// and partitions are assigned, or when partitions are consumed without consumer group.
+// It responds with JSON of HealthStatus and 503 status code on failure.
+func (d *Dumper) ReadinessHandler() http.Handler {
+	return healthHandler(d.health.readiness)
+}
+
+// Run consumes messages until ctx is canceled, stop condition is met or unrecoverable error happens.
+// Dumped data is committed and all files are closed before Run returns.
+// Returned error is one of *ConnectionError, *WriteError, *DecodeError or *IncompleteError,
+// nil when ctx was canceled or stop condition is met.
+func (d *Dumper) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d.health.reset()
+
+	client, err := sarama.NewClient(d.opts.Brokers, d.config)
+	if err != nil {
+		return &ConnectionError{Err: err}
+	}
+
+	defer func() {
+		if cerr := client.Close(); cerr != nil {
+			d.log.Errorf("Failed to close client: %v", cerr)
+		}
+	}()
+
+	matcher := d.topicMatcher()
+
+	topics, err := matcher.resolve(client)
+	if err != nil {
+		return &ConnectionError{Err: err}
+	}
+
+	d.log.Infof("Topics to dump: %v", topics)
+
+	if d.opts.Overwrite {
+		if err = d.removeOutput(); err != nil {
+			return &WriteError{Err: err}
+		}
+	}
+
+	w := newTopicWatcher(client, matcher, d.opts.TopicsRefresh, topics, d.log)
+
+	prog := newProgress(d.opts.Stop, cancel, d.log)
+
+	var (
+		ranges []partitionRange
+		cp     *checkpoint
+	)
+
+	switch {
+	case d.opts.Snapshot.Enabled:
+		snapshotRange := RangeOptions{To: d.opts.Snapshot.At}
+
+		if ranges, err = resolveRanges(client, topics, d.opts.Partitions, snapshotRange, true, d.log); err != nil {
+			return &ConnectionError{Err: err}
+		}
+
+		prog.setRanges(ranges)
+	case d.opts.Range.Enabled():
+		if ranges, err = resolveRanges(client, topics, d.opts.Partitions, d.opts.Range, false, d.log); err != nil {
+			return &ConnectionError{Err: err}
+		}
+
+		prog.setRanges(ranges)
+	case d.opts.Checkpoint != "":
+		if cp, err = loadCheckpoint(d.opts.Checkpoint, d.opts.Commit.Durable); err != nil {
+			return &WriteError{Err: err}
+		}
+
+		ranges, err = resolveCheckpoint(client, topics, d.opts.Partitions, cp, d.opts.Newest, d.opts.Stop.AtHighWatermark, d.log)
+		if err != nil {
+			return &ConnectionError{Err: err}
+		}
+
+		if d.opts.Stop.AtHighWatermark {
+			prog.setRanges(ranges)
+		}
+	case d.opts.Stop.AtHighWatermark:
+		if err = prog.recordBounds(client, topics); err != nil {
+			return &ConnectionError{Err: err}
+		}
+	}
+
+	writerOpts := d.opts.Writer
+
+	if d.opts.Commit.Durable {
+		d.log.Infof("Durable mode: offsets will be marked after data is fsynced (%s commit policy)", d.opts.Commit.Policy)
+
+		writerOpts.Sync = true
+	}
+
+	pool := newWriterPool(writerOpts, d.log)
+	s := newSink(pool, d.opts.topicSettings(), d.opts.Overrides, d.opts.Rotation, d.metrics, d.log)
+
+	if d.opts.Commit.Durable {
+		pool.opts.Recover = s.recoverFile
+	} else {
+		pool.opts.Recover = s.recoverCompressedFile
+	}
+
+	if d.opts.Snapshot.Enabled {
+		s.snapshot = newSnapshotter(d.opts.Snapshot, d.opts.Layout.OutputDir, topics, d.log)
+	}
+
+	f := &failure{cancel: cancel}
+
+	d.metrics.openFiles(s.openFiles)
+
+	if ranges != nil || cp != nil || d.opts.Snapshot.Enabled {
+		err = d.runRanges(ctx, client, w, ranges, s, f, prog, cp)
+	} else {
+		err = d.runGroup(ctx, client, w, s, f, prog)
+	}
+
 	if err != nil {
-		log.Fatalf("Kafka connection failed. Err: %v", err)
+		return err
 	}
 
-	log.Infof("consumer started\n")
+	prog.logSummary()
+	s.logRedactions()
+
+	if s.snapshot != nil {
+		d.writeSnapshot(s, f, prog)
+	}
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
-	signal.Notify(signals, syscall.SIGTERM)
+	if err = s.Close(); err != nil {
+		d.log.Errorf("Failed to close dump files: %v", err)
+		f.set(&WriteError{Err: err})
+	}
+
+	if cp != nil {
+		if err = cp.save(); err != nil {
+			d.log.Errorf("Failed to save checkpoint: %v", err)
+			f.set(&WriteError{Err: err})
+		}
+	}
 
-	n := consumerLoop(consumer, outputDir, signals)
-	log.Infof("Total messages processed: %d", n)
+	if err = f.get(); err != nil {
+		return err
+	}
+
+	return prog.result()
 }
 
-func consumerLoop(consumer *cluster.Consumer, outputDir string, signals chan os.Signal) (msgCount uint32) {
-	// Get signal for finish
+// removeOutput removes output directory of previous runs. It is done once, so next Run of Dumper
+// continues dump of the first one.
+func (d *Dumper) removeOutput() error {
+	if d.overwritten {
+		return nil
+	}
+
+	d.log.Infof("Removing previous dump files in %s", d.opts.Layout.OutputDir)
+
+	if err := os.RemoveAll(d.opts.Layout.OutputDir); err != nil {
+		return fmt.Errorf("failed to remove output directory: %w", err)
+	}
+
+	d.overwritten = true
+
+	return nil
+}
+
+// writeSnapshot writes snapshot files of topics when all partitions are consumed up to their end,
+// incomplete snapshot is discarded.
+func (d *Dumper) writeSnapshot(s *sink, f *failure, prog *progress) {
+	if f.get() != nil || prog.result() != nil {
+		d.log.Warnf("Snapshot is incomplete, snapshot files are not written")
+		s.snapshot.discard()
+
+		return
+	}
+
+	if err := s.snapshot.write(s.settings); err != nil {
+		d.log.Errorf("Failed to write snapshot: %v", err)
+		f.set(&WriteError{Err: err})
+	}
+}
+
+// runGroup dumps partitions claimed by consumer group member and commits dumped messages.
+// Session is restarted with new topics when topics discovered by w are changed.
+func (d *Dumper) runGroup(ctx context.Context, client sarama.Client, w *topicWatcher, s *sink, f *failure, prog *progress) error {
+	group, err := sarama.NewConsumerGroupFromClient(d.opts.GroupID, client)
+	if err != nil {
+		return &ConnectionError{Err: err}
+	}
+
+	d.log.Infof("consumer started\n")
+
+	c := newCommitter(d.opts.Commit, s.Sync, d.log)
+	h := &groupHandler{sink: s, committer: c, failure: f, progress: prog, metrics: d.metrics, health: d.health, log: d.log}
+
+	consumed := make(chan error, 1)
+	watched := make(chan struct{})
+
+	go func() {
+		consumed <- consume(ctx, group, w, h)
+	}()
+
+	go func() {
+		if !d.opts.Stop.AtHighWatermark {
+			w.run(ctx)
+		}
+
+		close(watched)
+	}()
+
+	d.consumerLoop(ctx, group.Errors(), s, c, nil, f, prog)
+
+	f.cancel()
+
+	if err = <-consumed; err != nil {
+		f.set(&ConnectionError{Err: err})
+	}
+
+	<-watched
+
+	d.log.Infof("Total messages processed: %d", atomic.LoadUint64(&h.msgCount))
+
+	if err = c.Commit(); err != nil {
+		d.log.Errorf("Failed to commit dumped messages: %v", err)
+		f.set(err)
+	}
+
+	if err = group.Close(); err != nil {
+		d.log.Errorf("Failed to close consumer: %v", err)
+	}
+
+	return nil
+}
+
+// runRanges dumps ranges of partitions without consumer group. Offsets of dumped messages are kept in cp,
+// they are not tracked when cp is nil. Partitions of topics discovered by w are consumed in not bounded checkpoint mode.
+func (d *Dumper) runRanges(ctx context.Context, client sarama.Client, w *topicWatcher, ranges []partitionRange, s *sink,
+	f *failure, prog *progress, cp *checkpoint) error {
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return &ConnectionError{Err: err}
+	}
+
+	rc := &rangeConsumer{
+		sink:     s,
+		failure:  f,
+		progress: prog,
+		metrics:  d.metrics,
+		health:   d.health,
+		log:      d.log,
+	}
+
+	switch {
+	case cp != nil:
+		d.log.Infof("Checkpoint consumer started, offsets are kept in [%s]", d.opts.Checkpoint)
+
+		rc.committer = newCommitter(d.opts.Commit, s.Sync, d.log)
+		rc.mark = cp.mark
+	case d.opts.Snapshot.Enabled:
+		d.log.Infof("Snapshot consumer started, consumer group offsets are not used")
+
+		rc.committer = newCommitter(CommitOptions{}, s.Sync, d.log)
+	default:
+		d.log.Infof("Range consumer started, consumer group offsets are not used")
+
+		rc.committer = newCommitter(CommitOptions{}, s.Sync, d.log)
+	}
+
+	rc.start(ctx, consumer, ranges)
+
+	watched := make(chan struct{})
+
+	go func() {
+		if cp != nil && !d.opts.Stop.AtHighWatermark {
+			w.added = func(topics []string) error {
+				added, err := resolveCheckpoint(client, topics, d.opts.Partitions, cp, false, false, d.log)
+				if err != nil {
+					return err
+				}
+
+				rc.start(ctx, consumer, added)
+
+				return nil
+			}
+
+			w.run(ctx)
+		}
+
+		close(watched)
+	}()
+
+	d.consumerLoop(ctx, nil, s, rc.committer, cp, f, prog)
+
+	f.cancel()
+
+	<-watched
+
+	rc.wait()
+
+	d.log.Infof("Total messages processed: %d", atomic.LoadUint64(&rc.msgCount))
+
+	if err = rc.committer.Commit(); err != nil {
+		d.log.Errorf("Failed to commit dumped messages: %v", err)
+		f.set(err)
+	}
+
+	if err = consumer.Close(); err != nil {
+		d.log.Errorf("Failed to close consumer: %v", err)
+	}
+
+	return nil
+}
+
+// consume joins consumer group and consumes claimed partitions of topics discovered by w until context is canceled.
+// Consume is called in loop to rejoin group after each rebalance, session is ended when topics are changed.
+func consume(ctx context.Context, group sarama.ConsumerGroup, w *topicWatcher, h sarama.ConsumerGroupHandler) error {
+	for {
+		topics := w.current()
+
+		if len(topics) == 0 {
+			select {
+			case <-w.changed:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		sessCtx, cancelSess := context.WithCancel(ctx)
+
+		go func() {
+			select {
+			case <-w.changed:
+				cancelSess()
+			case <-sessCtx.Done():
+			}
+		}()
+
+		err := group.Consume(sessCtx, topics, h)
+
+		cancelSess()
+
+		if err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// topicMatcher returns matcher of topics to dump.
+func (d *Dumper) topicMatcher() topicMatcher {
+	return topicMatcher{
+		topics:   d.opts.Topics,
+		include:  d.opts.TopicsInclude,
+		exclude:  d.opts.TopicsExclude,
+		internal: d.opts.IncludeInternal,
+	}
+}
+
+// consumerLoop flushes and commits dumped data, saves checkpoint and checks time stop conditions until ctx is canceled.
+// errs is nil when consumer errors are handled elsewhere, cp is nil when offsets are not kept in checkpoint.
+func (d *Dumper) consumerLoop(ctx context.Context, errs <-chan error, s *sink, c *committer, cp *checkpoint, f *failure,
+	prog *progress) {
+	started := time.Now()
+
+	ticker := time.NewTicker(tickInterval(s.pool.opts))
+	defer ticker.Stop()
+
+	var commitTick <-chan time.Time
+
+	if interval := c.Interval(); interval > 0 {
+		commitTicker := time.NewTicker(interval)
+		defer commitTicker.Stop()
+
+		commitTick = commitTicker.C
+	}
+
+	d.log.Infof("Consumer loop started\n")
+
+	d.health.tick()
+
 	for {
-		log.Infof("Consumer loop started\n")
 		select {
-		case errConsumer := <-consumer.Errors():
-			atomic.AddUint32(&msgCount, 1)
-			log.Errorf("consumer error: %v", errConsumer)
-		case msg := <-consumer.Messages():
-			atomic.AddUint32(&msgCount, 1)
-
-			if msg != nil {
-				log.Infof("received message from topic [%s]:[part[%d];offset[%d];key[%s]]",
-					msg.Topic, msg.Partition, msg.Offset, msg.Key)
-				log.Debugf("Total amount of received messages: %d", atomic.LoadUint32(&msgCount))
-
-				if err := dumpMessage(outputDir, msg); err != nil {
-					log.Fatalf("Failed to dump message: %v", err)
+		case consumerError := <-errs:
+			d.log.Errorf("Received consumerError: %v ", consumerError)
+
+		case now := <-ticker.C:
+			if err := s.Tick(); err != nil {
+				d.log.Errorf("Failed to flush dump files: %v", err)
+			}
+
+			if cp != nil {
+				if err := cp.save(); err != nil {
+					d.log.Errorf("Failed to save checkpoint: %v", err)
 				}
 			}
 
-			// tell kafka we are done with this message
-			consumer.MarkOffset(msg, "")
-		case rbe := <-consumer.Notifications():
-			// Rebalancing
-			js, err := json.Marshal(rbe)
-			if err != nil {
-				log.Errorf("Error when Marshal json from  Notifications channel: %v", err)
+			prog.check(started, now)
+
+			d.health.tick()
+
+		case <-commitTick:
+			if err := c.Commit(); err != nil {
+				d.log.Errorf("Failed to commit dumped messages: %v", err)
+				f.set(err)
 			}
 
-			log.Infof("Rebalancing: %s", string(js))
+		case <-ctx.Done():
+			d.log.Infof("Shutting down consumer")
+
+			return
+		}
+	}
+}
 
-		case consumerError := <-consumer.Errors():
-			atomic.AddUint32(&msgCount, 1)
-			log.Errorf("Received consumerError: %v ", consumerError)
+// failure keeps the first unrecoverable error and stops Run when it happens.
+type failure struct {
+	mu     sync.Mutex
+	err    error
+	cancel context.CancelFunc
+}
 
-		case <-signals:
-			log.Infof("Got UNIX signal, shutting down")
+func (f *failure) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-			return msgCount
+	if f.err == nil {
+		f.err = err
+		f.cancel()
+	}
+}
+
+func (f *failure) get() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.err
+}
+
+// groupHandler dumps messages of partitions claimed by consumer group session.
+// Each claimed partition is consumed in its own goroutine.
+type groupHandler struct {
+	sink      *sink
+	committer *committer
+	failure   *failure
+	progress  *progress
+	metrics   *dumperMetrics
+	health    *health
+	log       Logger
+	msgCount  uint64
+}
+
+// Setup is called at the beginning of new session, after partitions are assigned.
+func (h *groupHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	// Rebalancing
+	js, err := json.Marshal(sess.Claims())
+	if err != nil {
+		h.log.Errorf("Error when Marshal json from session claims: %v", err)
+	}
+
+	h.log.Infof("Rebalancing: generation [%d] member [%s] claims: %s", sess.GenerationID(), sess.MemberID(), string(js))
+
+	h.progress.setClaims(sess.Claims())
+	h.metrics.rebalanced()
+	h.health.rebalanced(sess)
+
+	return nil
+}
+
+// Cleanup is called at the end of session, after all ConsumeClaim goroutines exited.
+func (h *groupHandler) Cleanup(_ sarama.ConsumerGroupSession) error {
+	h.health.revoked()
+
+	if err := h.committer.Commit(); err != nil {
+		h.failure.set(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeClaim dumps messages of one partition until partition is revoked, session ends
+// or partition is consumed up to its high watermark in bounded mode.
+// Unrecoverable errors stop the whole dumper.
+func (h *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	mark := func(msg *sarama.ConsumerMessage) {
+		// tell kafka we are done with this message
+		sess.MarkMessage(msg, "")
+	}
+
+	h.log.Infof("Partition [%s:%d] claimed from offset %d", claim.Topic(), claim.Partition(), claim.InitialOffset())
+
+	if h.progress.reached(claim.Topic(), claim.Partition(), claim.InitialOffset()) {
+		return h.releaseClaim(claim.Topic(), claim.Partition())
+	}
+
+	tp := topicPartition{topic: claim.Topic(), partition: claim.Partition()}
+	received := time.Now()
+
+	ticker := time.NewTicker(drainInterval())
+	defer ticker.Stop()
+
+loop:
+	for {
+		var msg *sarama.ConsumerMessage
+
+		select {
+		case m, ok := <-claim.Messages():
+			if !ok {
+				break loop
+			}
+
+			msg = m
+		case <-ticker.C:
+			if h.progress.drained(tp, claim.HighWaterMarkOffset(), len(claim.Messages()), time.Since(received)) {
+				break loop
+			}
+
+			continue
+		}
+
+		received = time.Now()
+
+		if h.progress.beyond(msg) {
+			break loop
+		}
+
+		total := atomic.AddUint64(&h.msgCount, 1)
+
+		h.log.Debugf("received message from topic [%s]:[part[%d];offset[%d];key[%s]]",
+			msg.Topic, msg.Partition, msg.Offset, msg.Key)
+		h.log.Debugf("Total amount of received messages: %d", total)
+
+		size, dumped, err := h.sink.consume(msg)
+		if err != nil {
+			h.failure.set(err)
+
+			return err
+		}
+
+		h.metrics.consumed(msg, claim.HighWaterMarkOffset())
+		h.health.consumed(msg, claim.HighWaterMarkOffset())
+
+		reached := h.progress.add(msg, size, dumped)
+
+		if err = h.committer.Done(msg, mark); err != nil {
+			h.failure.set(err)
+
+			return err
+		}
+
+		// session context is canceled when dumper stops.
+		if reached || sess.Context().Err() != nil {
+			break loop
 		}
 	}
+
+	if err := h.releaseClaim(claim.Topic(), claim.Partition()); err != nil {
+		h.failure.set(err)
+
+		return err
+	}
+
+	return nil
+}
+
+// releaseClaim commits dumped messages of revoked partition, flushes and closes its files.
+func (h *groupHandler) releaseClaim(topic string, partition int32) error {
+	h.log.Infof("Partition [%s:%d] released", topic, partition)
+
+	h.metrics.released(topic, partition)
+	h.health.released(topic, partition)
+
+	if err := h.committer.CommitPartition(topic, partition); err != nil {
+		return err
+	}
+
+	if err := h.sink.ReleasePartition(topic, partition); err != nil {
+		return &WriteError{Err: err}
+	}
+
+	return nil
+}
+
+// tickInterval returns how often writer pool should be checked for files to flush or close.
+func tickInterval(opts WriterOptions) time.Duration {
+	if opts.IdleTimeout < opts.FlushInterval {
+		return opts.IdleTimeout
+	}
+
+	return opts.FlushInterval
 }
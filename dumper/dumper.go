@@ -1,66 +1,588 @@
 package dumper
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
-	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/redact"
 )
 
-func dumpMessage(outputDir string, msg *sarama.ConsumerMessage) error {
-	// filename to use
-	filename := generateFileName(msg)
+// sink writes consumed messages to dump files.
+// It is safe for concurrent use by consumers of different partitions.
+type sink struct {
+	mu       sync.Mutex
+	pool     *writerPool
+	rotation RotationPolicy
+	// segments holds current segment of each file when path template has {offset} placeholder,
+	// keyed by path rendered without offset.
+	segments map[string]*segmentState
+	// retained is a time of the last check of retention.
+	retained time.Time
+	// shared holds sanitized names of topics that share dump files with other topics and are not retained.
+	shared  map[string]bool
+	metrics *dumperMetrics
+	log     Logger
+
+	defaults  topicSettings
+	overrides []TopicOverride
+	topicsMu  sync.Mutex
+	// topics holds resolved settings of dumped topics.
+	topics map[string]topicSettings
+	// lastIndexed holds offset of the last entry of index files, entries are appended only in increasing
+	// offsets order, so index could be searched with binary search.
+	lastIndexed map[string]int64
+	// snapshot collects the latest records of keys instead of writing them in snapshot mode.
+	snapshot *snapshotter
+}
+
+func newSink(pool *writerPool, defaults topicSettings, overrides []TopicOverride, rotation RotationPolicy,
+	m *dumperMetrics, logger Logger) *sink {
+	logger = loggerOrDefault(logger)
+	defaults.layout.Bucketer.log = logger
+
+	return &sink{
+		pool:      pool,
+		rotation:  rotation,
+		segments:  make(map[string]*segmentState),
+		retained:  time.Now(),
+		shared:    make(map[string]bool),
+		metrics:   m,
+		log:       logger,
+		defaults:  defaults,
+		overrides: overrides,
+		topics:    make(map[string]topicSettings),
+
+		lastIndexed: make(map[string]int64),
+	}
+}
+
+// settings returns dump settings of topic.
+func (s *sink) settings(topic string) topicSettings {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+
+	settings, ok := s.topics[topic]
+	if !ok {
+		settings = settingsFor(topic, s.defaults, s.overrides)
+		s.topics[topic] = settings
+	}
+
+	return settings
+}
+
+// consume dumps message when it passes filter of its topic and returns size of its record and whether
+// it was dumped. Filtered out message is only counted, so its offset is committed as dumped one,
+// in snapshot mode it removes its key from snapshot.
+func (s *sink) consume(msg *sarama.ConsumerMessage) (int, bool, error) {
+	if f := s.settings(msg.Topic).filter; f != nil && !f.Match(msg) {
+		s.log.Debugf("Message [%s:%d:%d] is filtered out", msg.Topic, msg.Partition, msg.Offset)
+		s.metrics.filteredOut(msg.Topic)
+
+		if s.snapshot != nil {
+			if err := s.snapshot.remove(msg); err != nil {
+				return 0, false, &WriteError{Err: err}
+			}
+		}
+
+		return 0, false, nil
+	}
+
+	size, err := s.dumpMessage(msg)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return size, true, nil
+}
+
+// dumpMessage writes record of message and returns its size.
+func (s *sink) dumpMessage(msg *sarama.ConsumerMessage) (int, error) {
+	s.log.Debugf("Timestamp: %s, BlockTimestamp: %s", msg.Timestamp, msg.BlockTimestamp)
+
+	settings := s.settings(msg.Topic)
+
+	decoded := settings.decoders.Decode(msg)
+	settings.redactor.Redact(decoded)
+	s.decodeFailed(decoded.Key, "key", settings.redactor.RedactsKey(), msg)
+	s.decodeFailed(decoded.Value, "value", settings.redactor.RedactsValue(), msg)
+
+	record, err := format.EncodeDecoded(settings.encoder, decoded)
+	if err != nil {
+		s.log.Errorf("Failed encoding record for offset %v. Err: %v", msg.Offset, err)
+
+		return 0, &DecodeError{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset, Err: err}
+	}
+
+	if s.snapshot != nil {
+		if err = s.snapshot.add(msg, record); err != nil {
+			return 0, &WriteError{Err: err}
+		}
+
+		return len(record), nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	started := time.Now()
+
+	if err = s.write(msg, settings, record); err != nil {
+		s.metrics.writeFailed()
+
+		return 0, &WriteError{Err: err}
+	}
+
+	s.metrics.written(time.Since(started))
+
+	return len(record), nil
+}
+
+// decodeFailed reports failed decoding of message field, raw bytes of field are dumped in this case
+// unless field has redaction rules.
+func (s *sink) decodeFailed(f *format.DecodedField, field string, redacted bool, msg *sarama.ConsumerMessage) {
+	if !f.Failed() {
+		return
+	}
+
+	kept := "raw bytes are dumped"
+	if redacted {
+		kept = "it is withheld by redaction rules"
+	}
+
+	s.log.Warnf("Failed decoding %s of message [%s:%d:%d], %s. Err: %v",
+		field, msg.Topic, msg.Partition, msg.Offset, kept, f.Err)
+	s.metrics.decodeFailed(msg.Topic, field)
+}
+
+// write writes record of message and its index entry.
+func (s *sink) write(msg *sarama.ConsumerMessage, settings topicSettings, record []byte) error {
+	owner := topicPartition{topic: msg.Topic, partition: msg.Partition}
+
+	// file to use
+	fileLocation, err := s.filePath(msg, settings, len(record))
+	if err != nil {
+		s.log.Errorf("Failed building file path for offset %v. Err: %v", msg.Offset, err)
+
+		return err
+	}
+
+	if !format.Indexed(settings.encoder) {
+		if err = s.pool.Append(owner, fileLocation, record); err != nil {
+			s.log.Errorf("Failed writing file for offset %v. Err: %v", msg.Offset, err)
 
-	err := writeLineToFile(outputDir, msg.Value, filename, msg.Topic, msg.Partition)
+			return err
+		}
+
+		return nil
+	}
+
+	position, err := s.pool.Write(owner, fileLocation, record)
 	if err != nil {
-		log.Errorf("Failed writing file for offset %v. Err: %v", msg.Offset, err)
+		s.log.Errorf("Failed writing file for offset %v. Err: %v", msg.Offset, err)
+
+		return err
+	}
+
+	return s.index(owner, format.IndexPath(fileLocation), msg.Offset, position)
+}
+
+// index appends index entry of record when its offset is greater than offset of the last entry.
+// Segment written again from earlier offsets, e.g. after offsets reset, keeps its records,
+// but only the ones with increasing offsets are indexed. Indexed segments always belong to one partition,
+// path template without {topic} and {partition} is rejected for indexed formats.
+func (s *sink) index(owner topicPartition, indexPath string, offset, position int64) error {
+	last, ok := s.lastIndexed[indexPath]
+	if !ok {
+		var err error
+
+		// the first write of segment has already recovered it together with index.
+		if last, ok, err = format.LastIndexedOffset(indexPath); err != nil {
+			s.log.Errorf("Failed reading index for offset %v. Err: %v", offset, err)
+
+			return err
+		}
+	}
+
+	if ok && offset <= last {
+		s.log.Debugf("Offset %d is not indexed in %s, its last entry has offset %d", offset, indexPath, last)
+
+		return nil
+	}
+
+	if err := s.pool.Append(owner, indexPath, format.IndexEntry(offset, position)); err != nil {
+		s.log.Errorf("Failed writing index for offset %v. Err: %v", offset, err)
 
 		return err
 	}
 
+	s.lastIndexed[indexPath] = offset
+
 	return nil
 }
 
-func generateFileName(msg *sarama.ConsumerMessage) string {
-	// timezone := svc.GetTimeZone()
-	log.Debugf("Timestamp: %s", msg.BlockTimestamp)
+// filePath returns location of file for record of message.
+// When segments are enabled it also starts new segment if current one is absent or full.
+func (s *sink) filePath(msg *sarama.ConsumerMessage, settings topicSettings, recordSize int) (string, error) {
+	ext := settings.extension()
+
+	if !settings.layout.Template.Uses(string(phOffset)) {
+		return settings.layout.Path(msg, ext, -1)
+	}
+
+	key, err := settings.layout.Path(msg, ext, -1)
+	if err != nil {
+		return "", err
+	}
+
+	msgTime := settings.layout.Bucketer.Time(msg)
 
-	return fmt.Sprintf("%s_Partition_%d.txt", msg.Timestamp.Format("2006-01-02"), msg.Partition)
+	seg, ok := s.segments[key]
+	if !ok || s.rotation.full(seg, recordSize, msgTime) {
+		if seg, err = s.startSegment(seg, msg, settings, msgTime); err != nil {
+			return "", err
+		}
+
+		s.segments[key] = seg
+	}
+
+	seg.bytes += int64(recordSize)
+	seg.records++
+	seg.lastWrite = time.Now()
+
+	return seg.path, nil
 }
 
-func writeLineToFile(outputDir string, line []byte, filename string, topic string, partition int32) error {
-	fileLocation := filepath.Join(outputDir, topic, fmt.Sprintf("partition-%d", partition), filename)
-	// create necessary dirs
-	if err := os.MkdirAll(filepath.Dir(fileLocation), 0o700); err != nil {
-		log.Errorf("failed creating all dirs at %v for offset %v", filepath.Dir(fileLocation), err)
+// startSegment closes previous segment and starts new one from message offset.
+// Segment file is truncated, so repeated dump of the same offsets overwrites segment instead of duplicating records,
+// and the first segment of file started by this process truncates segment of previous run that covers message offset.
+func (s *sink) startSegment(prev *segmentState, msg *sarama.ConsumerMessage, settings topicSettings,
+	msgTime time.Time) (*segmentState, error) {
+	if prev != nil {
+		s.log.Infof("Rotating segment %s: %d records, %d bytes", prev.path, prev.records, prev.bytes)
 
-		return fmt.Errorf("failed create dir: %w", err)
+		if err := s.closeSegment(prev); err != nil {
+			return nil, err
+		}
+	} else if err := s.truncateCoveringSegment(msg, settings); err != nil {
+		return nil, fmt.Errorf("failed to start segment: %w", err)
 	}
 
-	var f *os.File
+	path, err := settings.layout.Path(msg, settings.extension(), msg.Offset)
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := os.Stat(fileLocation); os.IsNotExist(err) {
-		// path/to/whatever does not exist.
-		log.Infof("Will be create new file: %s", fileLocation)
+	s.log.Infof("Starting segment %s", path)
 
-		f, err = os.OpenFile(fileLocation, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.ModePerm)
-		if err != nil {
-			log.Fatal(err)
+	indexed := format.Indexed(settings.encoder)
+
+	for _, p := range segmentFiles(path, indexed) {
+		if err = s.pool.Truncate(p); err != nil {
+			return nil, fmt.Errorf("failed to start segment: %w", err)
+		}
+	}
+
+	delete(s.lastIndexed, format.IndexPath(path))
+
+	return &segmentState{
+		owner:       topicPartition{topic: msg.Topic, partition: msg.Partition},
+		path:        path,
+		indexed:     indexed,
+		firstOffset: msg.Offset,
+		firstTime:   msgTime,
+	}, nil
+}
+
+// truncateCoveringSegment removes records of message offset and later ones from existing segment that starts
+// before message. Such segment is left by previous run that dumped messages after committed offset, and they
+// are dumped again to segment of message. Only indexed segments could be truncated by offset.
+func (s *sink) truncateCoveringSegment(msg *sarama.ConsumerMessage, settings topicSettings) error {
+	segments, err := settings.layout.existingSegments(msg, settings.extension())
+	if err != nil {
+		return err
+	}
+
+	covering := int64(-1)
+
+	for first := range segments {
+		if first < msg.Offset && first > covering {
+			covering = first
+		}
+	}
+
+	if covering < 0 {
+		return nil
+	}
+
+	path := segments[covering]
+
+	if !format.Indexed(settings.encoder) {
+		s.log.Infof("Segment %s could have records from offset %d that will be dumped again, "+
+			"segments without index are not truncated", path, msg.Offset)
+
+		return nil
+	}
+
+	owner := topicPartition{topic: msg.Topic, partition: msg.Partition}
+
+	for _, p := range segmentFiles(path, true) {
+		if err = s.pool.CloseFile(p); err != nil {
+			return err
+		}
+	}
+
+	// partial record of crashed run is removed before index is used.
+	if err = s.pool.recover(owner, path); err != nil {
+		return err
+	}
+
+	n, err := format.TruncateSegment(path, msg.Offset)
+	if err != nil {
+		return err
+	}
+
+	delete(s.lastIndexed, format.IndexPath(path))
+
+	if n > 0 {
+		s.log.Warnf("Truncated %d records of segment %s from offset %d that will be dumped again", n, path, msg.Offset)
+	}
+
+	return nil
+}
+
+func (s *sink) closeSegment(seg *segmentState) error {
+	for _, p := range segmentFiles(seg.path, seg.indexed) {
+		if err := s.pool.CloseFile(p); err != nil {
+			return fmt.Errorf("failed to close segment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// segmentFiles returns segment file and its index file when format is indexed.
+func segmentFiles(path string, indexed bool) []string {
+	if indexed {
+		return []string{path, format.IndexPath(path)}
+	}
+
+	return []string{path}
+}
+
+// Tick flushes and closes files according to writer options and forgets segments
+// that were not written longer than idle timeout, next message of such segment starts new one.
+func (s *sink) Tick() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, seg := range s.segments {
+		if time.Since(seg.lastWrite) >= s.pool.opts.IdleTimeout {
+			delete(s.segments, key)
+		}
+	}
+
+	if err := s.pool.Tick(); err != nil {
+		s.metrics.writeFailed()
+
+		return err
+	}
+
+	if now := time.Now(); now.Sub(s.retained) >= retentionInterval {
+		s.retained = now
+		s.applyRetention(now)
+	}
+
+	return nil
+}
+
+// Sync flushes all files and commits them to stable storage.
+func (s *sink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.pool.Sync(); err != nil {
+		s.metrics.writeFailed()
+
+		return err
+	}
+
+	return nil
+}
+
+// openFiles returns number of open dump files.
+func (s *sink) openFiles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pool.OpenFiles()
+}
+
+// ReleasePartition flushes and closes files of partition and forgets its segments,
+// it is called when partition is revoked from consumer.
+func (s *sink) ReleasePartition(topic string, partition int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := topicPartition{topic: topic, partition: partition}
+
+	for key, seg := range s.segments {
+		if seg.owner == owner {
+			delete(s.segments, key)
+		}
+	}
+
+	return s.pool.CloseOwner(owner)
+}
+
+// logRedactions logs numbers of fields redacted by rules of topic overrides during run.
+func (s *sink) logRedactions() {
+	logged := make(map[*redact.Redactor]bool)
+
+	for _, o := range s.overrides {
+		if o.Redactor == nil || logged[o.Redactor] {
+			continue
+		}
+
+		logged[o.Redactor] = true
+
+		counts := o.Redactor.Summary()
+		if len(counts) == 0 {
+			s.log.Infof("No fields were redacted by rules of %s", o)
+		}
+
+		for _, c := range counts {
+			s.log.Infof("Redacted %d fields of topic [%s] by rule %s", c.Fields, c.Topic, c.Rule)
+		}
+	}
+}
+
+// Close flushes and closes all files.
+func (s *sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pool.Close()
+}
+
+// recoverFile is a writer recovery hook that truncates partial trailing records of files
+// written by encoder of topic of partition that opens file.
+func (s *sink) recoverFile(owner topicPartition, path string) error {
+	encoder := s.settings(owner.topic).encoder
+
+	// index is repaired together with its segment.
+	if format.Indexed(encoder) && strings.HasSuffix(path, format.IndexExtension) {
+		return nil
+	}
+
+	n, err := format.Recover(encoder, path)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		if c, _ := format.CompressionOf(path); c.Enabled() {
+			s.log.Warnf("Rewrote %d bytes of %s without damaged frame or partial record at its end", n, path)
+		} else {
+			s.log.Warnf("Truncated %d bytes of partial record at the end of %s", n, path)
 		}
-	} else {
-		log.Infof("Will be used existed file: %s", fileLocation)
+	}
 
-		f, err = os.OpenFile(fileLocation, os.O_APPEND|os.O_WRONLY, os.ModePerm)
+	return nil
+}
+
+// recoverCompressedFile repairs compressed file. Frames appended after damaged frame are not readable,
+// so compressed files are recovered even when durable mode is off.
+func (s *sink) recoverCompressedFile(owner topicPartition, path string) error {
+	if c, _ := format.CompressionOf(path); !c.Enabled() {
+		return nil
+	}
+
+	return s.recoverFile(owner, path)
+}
+
+// applyRetention removes closed dump files of dumped topics that were not modified for their retention.
+// Topics which names are sanitized to the same path component are skipped.
+// Errors are logged, so retention does not stop dumper.
+func (s *sink) applyRetention(now time.Time) {
+	s.topicsMu.Lock()
+
+	topics := make(map[string]topicSettings, len(s.topics))
+	for topic, settings := range s.topics {
+		topics[topic] = settings
+	}
+
+	s.topicsMu.Unlock()
+
+	names := make(map[string]int, len(topics))
+	for topic := range topics {
+		names[sanitizePathComponent(topic)]++
+	}
+
+	for topic, settings := range topics {
+		if settings.retention <= 0 {
+			continue
+		}
+
+		// files of topics with the same sanitized name could not be told apart,
+		// so they are not removed by retention of one of them.
+		if name := sanitizePathComponent(topic); names[name] > 1 {
+			if !s.shared[name] {
+				s.shared[name] = true
+				s.log.Warnf("Retention of topic [%s] is skipped: other dumped topic has the same file name [%s]", topic, name)
+			}
+
+			continue
+		}
+
+		files, err := settings.expired(topic, now)
 		if err != nil {
-			panic(err)
+			s.log.Errorf("Failed to apply retention: %v", err)
+
+			continue
+		}
+
+		removed := 0
+
+		for _, path := range files {
+			if s.pool.isOpen(path) {
+				continue
+			}
+
+			if err = removeDumpFile(path, format.Indexed(settings.encoder)); err != nil {
+				s.log.Errorf("Failed to remove expired dump file: %v", err)
+
+				continue
+			}
+
+			delete(s.lastIndexed, format.IndexPath(path))
+
+			removed++
+		}
+
+		if removed != 0 {
+			s.log.Infof("Removed %d dump files of topic [%s] not modified for %s", removed, topic, settings.retention)
 		}
 	}
+}
 
-	if _, err := f.Write(line); err != nil {
-		panic(err)
+// removeDumpFile removes file with its index and its directory when it becomes empty.
+func removeDumpFile(path string, indexed bool) error {
+	for _, p := range segmentFiles(path, indexed) {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
 	}
 
+	// directory is removed only when it is empty.
+	_ = os.Remove(filepath.Dir(path))
+
 	return nil
 }
@@ -0,0 +1,287 @@
+package dumper
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+var testTime = time.Date(2021, time.February, 3, 10, 0, 0, 0, time.UTC)
+
+// testSettings returns settings of topics dumped to dir in format f with path template tmpl.
+func testSettings(t testing.TB, dir string, f format.Format, tmpl string) topicSettings {
+	t.Helper()
+
+	enc, err := format.NewEncoder(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := ParsePathTemplate(tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return topicSettings{
+		encoder: enc,
+		layout: Layout{
+			OutputDir: dir,
+			Template:  template,
+			Bucketer:  Bucketer{Source: TimestampCreate},
+		},
+	}
+}
+
+// newTestSink creates sink with durable pool that recovers files like durable dumper does.
+func newTestSink(defaults topicSettings, overrides []TopicOverride, rotation RotationPolicy) *sink {
+	pool := newWriterPool(WriterOptions{Sync: true}, nil)
+	s := newSink(pool, defaults, overrides, rotation, nil, nil)
+	pool.opts.Recover = s.recoverFile
+
+	return s
+}
+
+func testMessage(topic string, partition int32, offset int64) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Timestamp: testTime,
+	}
+}
+
+func dumpMessages(t *testing.T, s *sink, msgs ...*sarama.ConsumerMessage) {
+	t.Helper()
+
+	for _, msg := range msgs {
+		if _, err := s.dumpMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readOffsets returns offsets of messages of dump file.
+func readOffsets(t *testing.T, path string) []int64 {
+	t.Helper()
+
+	f, ok := format.FormatOf(path)
+	if !ok {
+		t.Fatalf("unknown format of %s", path)
+	}
+
+	file, err := format.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	r, err := format.NewReader(f, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offsets []int64
+
+	for {
+		msg, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return offsets
+		}
+
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+
+		offsets = append(offsets, msg.Offset)
+	}
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func equalOffsets(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TestRecoverTornRecord checks that partial records left by crash are truncated with encoder
+// of topic of each file, whatever topic was written last.
+func TestRecoverTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := "{topic}/{partition}{ext}"
+
+	defaults := testSettings(t, dir, format.Binary, tmpl)
+	jsonl := testSettings(t, dir, format.JSONL, tmpl)
+	overrides := []TopicOverride{{Name: "events", Encoder: jsonl.encoder}}
+
+	s := newTestSink(defaults, overrides, RotationPolicy{})
+	dumpMessages(t, s,
+		testMessage("audit", 0, 0), testMessage("events", 0, 0),
+		testMessage("audit", 0, 1), testMessage("events", 0, 1))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	auditPath := filepath.Join(dir, "audit", "0.bin")
+	eventsPath := filepath.Join(dir, "events", "0.jsonl")
+
+	record, err := defaults.encoder.Encode(testMessage("audit", 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendFile(t, auditPath, record[:len(record)/2])
+	appendFile(t, eventsPath, []byte(`{"topic":"events","partition":0,"off`))
+
+	// the last written topic is not the topic of reopened file.
+	s = newTestSink(defaults, overrides, RotationPolicy{})
+	dumpMessages(t, s, testMessage("events", 0, 2), testMessage("audit", 0, 2))
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{0, 1, 2}
+
+	for _, path := range []string{auditPath, eventsPath} {
+		if got := readOffsets(t, path); !equalOffsets(got, want) {
+			t.Errorf("offsets of %s = %v, want %v", path, got, want)
+		}
+	}
+
+	// binary segment index is repaired together with segment.
+	if _, position, err := format.LookupOffset(format.IndexPath(auditPath), 2); err != nil || position != 2*int64(len(record)) {
+		t.Errorf("index entry of offset 2 = %d, %v, want %d", position, err, 2*len(record))
+	}
+}
+
+// TestResumeInsideSegment checks that run resuming from offset inside segment of previous run
+// truncates that segment, so resumed records are not duplicated.
+func TestResumeInsideSegment(t *testing.T) {
+	for _, c := range []format.Compression{format.NoCompression, format.Zstd} {
+		t.Run(string(c), func(t *testing.T) {
+			dir := t.TempDir()
+
+			settings := testSettings(t, dir, format.Binary, "{topic}/{partition}+{offset}{ext}")
+			settings.compression = c
+
+			s := newTestSink(settings, nil, RotationPolicy{})
+			for offset := int64(0); offset < 5; offset++ {
+				dumpMessages(t, s, testMessage("t", 0, offset))
+			}
+
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// offsets after 1 were dumped but not committed.
+			s = newTestSink(settings, nil, RotationPolicy{})
+			for offset := int64(2); offset < 6; offset++ {
+				dumpMessages(t, s, testMessage("t", 0, offset))
+			}
+
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for first, want := range map[int64][]int64{0: {0, 1}, 2: {2, 3, 4, 5}} {
+				path, err := settings.layout.Path(testMessage("t", 0, first), settings.extension(), first)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := readOffsets(t, path); !equalOffsets(got, want) {
+					t.Errorf("offsets of %s = %v, want %v", path, got, want)
+				}
+
+				if _, _, err := format.LookupOffset(format.IndexPath(path), want[len(want)-1]+1); !errors.Is(err, format.ErrOffsetNotFound) {
+					t.Errorf("index of %s has entries after offset %d: %v", path, want[len(want)-1], err)
+				}
+			}
+		})
+	}
+}
+
+// TestIndexIsIncreasing checks that records of file shared by partitions are indexed only in increasing
+// offsets order, so binary search of index finds them.
+func TestIndexIsIncreasing(t *testing.T) {
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.Binary, "{topic}{ext}")
+
+	s := newTestSink(settings, nil, RotationPolicy{})
+	dumpMessages(t, s, testMessage("t", 0, 0), testMessage("t", 1, 0), testMessage("t", 0, 1),
+		testMessage("t", 1, 1), testMessage("t", 1, 2))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next run appends to the same file.
+	s = newTestSink(settings, nil, RotationPolicy{})
+	dumpMessages(t, s, testMessage("t", 0, 2), testMessage("t", 0, 3))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "t.bin")
+
+	for offset, want := range map[int64]int64{0: 0, 1: 2, 2: 4, 3: 6} {
+		_, position, err := format.LookupOffset(format.IndexPath(path), offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, c, err := format.SeekOffset(path, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := int64(0)
+		for _, err = r.Next(); err == nil; _, err = r.Next() {
+			n++
+		}
+
+		_ = c.Close()
+
+		if records := int64(7) - n; records != want {
+			t.Errorf("offset %d is found at record %d (position %d), want record %d", offset, records, position, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package dumper
+
+import (
+	"fmt"
+)
+
+// ConfigError is returned when dumper options are invalid.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration: %v", e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectionError is returned when dumper could not connect to Kafka or consumer group session failed.
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("kafka connection failed: %v", e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError is returned when dumped data could not be written, flushed or synced to dump files.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("failed to write dump: %v", e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned when consumed message could not be converted to dump record
+// or dump record could not be read back. Topic is empty when message coordinates are unknown.
+type DecodeError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Topic == "" {
+		return fmt.Sprintf("failed to decode message: %v", e.Err)
+	}
+
+	return fmt.Sprintf("failed to decode message [%s:%d:%d]: %v", e.Topic, e.Partition, e.Offset, e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IncompleteError is returned when dumper bounded by high watermarks or by range was stopped by other stop condition
+// or interrupted before all partitions were dumped up to their end.
+type IncompleteError struct {
+	Reason string
+	// Remaining is a number of partitions that were not dumped up to their end.
+	Remaining int
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("stopped before reaching end of partitions (%s), %d partitions remaining", e.Reason, e.Remaining)
+}
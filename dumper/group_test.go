@@ -0,0 +1,199 @@
+package dumper
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+func joinGroupResponse(t *testing.T, generation int32, topic string) sarama.MockResponse {
+	return sarama.NewMockJoinGroupResponse(t).
+		SetGenerationId(generation).
+		SetGroupProtocol(sarama.RangeBalanceStrategyName).
+		SetMemberId("member").
+		SetLeaderId("member").
+		SetMember("member", &sarama.ConsumerGroupMemberMetadata{Topics: []string{topic}})
+}
+
+func syncGroupResponse(t *testing.T, topic string, partitions ...int32) sarama.MockResponse {
+	return sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+		Topics: map[string][]int32{topic: partitions},
+	})
+}
+
+// committedOffsets returns the last offsets of partitions committed to broker.
+func committedOffsets(b *sarama.MockBroker, topic string) map[int32]int64 {
+	offsets := make(map[int32]int64)
+
+	for _, r := range b.History() {
+		req, ok := r.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+
+		for partition := int32(0); partition < 2; partition++ {
+			if offset, _, err := req.Offset(topic, partition); err == nil {
+				offsets[partition] = offset
+			}
+		}
+	}
+
+	return offsets
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// TestGroupRebalance checks that partitions of session ended by rebalance are committed at Cleanup
+// and their files are closed, and that the next session claims only newly assigned partitions.
+func TestGroupRebalance(t *testing.T) {
+	const topic = "orders"
+
+	b := sarama.NewMockBroker(t, 1)
+	defer b.Close()
+
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(topic, 0, b.BrokerID()).
+			SetLeader(topic, 1, b.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 2).
+			SetOffset(topic, 1, sarama.OffsetOldest, 0).
+			SetOffset(topic, 1, sarama.OffsetNewest, 2),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group", b),
+		"JoinGroupRequest": joinGroupResponse(t, 1, topic),
+		"SyncGroupRequest": syncGroupResponse(t, topic, 0, 1),
+		"HeartbeatRequest": sarama.NewMockWrapper(&sarama.HeartbeatResponse{}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group", topic, 0, -1, "", sarama.ErrNoError).
+			SetOffset("group", topic, 1, -1, "", sarama.ErrNoError).
+			SetError(sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetVersion(7).
+			SetMessage(topic, 0, 0, sarama.StringEncoder("a")).
+			SetMessage(topic, 0, 1, sarama.StringEncoder("b")).
+			SetMessage(topic, 1, 0, sarama.StringEncoder("c")).
+			SetMessage(topic, 1, 1, sarama.StringEncoder("d")).
+			SetHighWaterMark(topic, 0, 2).
+			SetHighWaterMark(topic, 1, 2),
+	}
+
+	b.SetHandlerByMap(handlers)
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_0_0_0
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	group, err := sarama.NewConsumerGroup([]string{b.Addr()}, "group", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = group.Close()
+	}()
+
+	dir := t.TempDir()
+	s := newTestSink(testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}"), nil, RotationPolicy{})
+
+	h := &groupHandler{
+		sink:      s,
+		committer: newCommitter(CommitOptions{Durable: true, Policy: CommitPerBatch, BatchSize: 100}, s.Sync, nil),
+		failure:   &failure{cancel: func() {}},
+		progress:  newProgress(StopOptions{}, func() {}, nil),
+		health:    newHealth(dir, 0),
+		log:       loggerOrDefault(nil),
+	}
+
+	// the first session is ended by rebalance after all messages are dumped.
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan error, 1)
+
+	go func() {
+		consumed <- group.Consume(ctx, []string{topic}, h)
+	}()
+
+	waitFor(t, "messages of the first session", func() bool { return atomic.LoadUint64(&h.msgCount) == 4 })
+
+	if offsets := committedOffsets(b, topic); len(offsets) != 0 {
+		t.Errorf("offsets %v are committed before batch is full", offsets)
+	}
+
+	cancel()
+
+	if err = <-consumed; err != nil {
+		t.Fatal(err)
+	}
+
+	if offsets := committedOffsets(b, topic); offsets[0] != 2 || offsets[1] != 2 {
+		t.Errorf("committed offsets after the first session = %v, want 2 of both partitions", offsets)
+	}
+
+	if n := s.openFiles(); n != 0 {
+		t.Errorf("%d files of released partitions are open", n)
+	}
+
+	if h.committer.count != 0 {
+		t.Errorf("%d messages are pending after cleanup", h.committer.count)
+	}
+
+	// the second session claims only partition 1 that was consumed up to its end.
+	handlers["JoinGroupRequest"] = joinGroupResponse(t, 2, topic)
+	handlers["SyncGroupRequest"] = syncGroupResponse(t, topic, 1)
+	handlers["OffsetFetchRequest"] = sarama.NewMockOffsetFetchResponse(t).
+		SetOffset("group", topic, 1, 2, "", sarama.ErrNoError).
+		SetError(sarama.ErrNoError)
+
+	b.SetHandlerByMap(handlers)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		consumed <- group.Consume(ctx, []string{topic}, h)
+	}()
+
+	waitFor(t, "the second session", func() bool {
+		status := h.health.status()
+
+		return status.Generation == 2 && len(status.Partitions) == 1
+	})
+
+	if status := h.health.status(); status.Partitions[0].Partition != 1 {
+		t.Errorf("partitions of the second session = %+v, want only partition 1", status.Partitions)
+	}
+
+	cancel()
+
+	if err = <-consumed; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadUint64(&h.msgCount); n != 4 {
+		t.Errorf("%d messages are consumed, committed messages are consumed again", n)
+	}
+
+	for partition, want := range map[string][]int64{"0": {0, 1}, "1": {0, 1}} {
+		if got := readOffsets(t, dir+"/"+topic+"/"+partition+".jsonl"); !equalOffsets(got, want) {
+			t.Errorf("offsets of partition %s = %v, want %v", partition, got, want)
+		}
+	}
+}
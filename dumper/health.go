@@ -0,0 +1,295 @@
+package dumper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// defaultLivenessTimeout is a time without consumer loop iterations after which dumper is not live,
+// it is used when LivenessTimeout is not set.
+const defaultLivenessTimeout = time.Minute
+
+// PartitionStatus is a state of consumed partition reported by health endpoints.
+type PartitionStatus struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Offset is an offset of the last dumped message, -1 when nothing is dumped yet.
+	Offset        int64     `json:"offset"`
+	HighWatermark int64     `json:"high_watermark"`
+	Lag           int64     `json:"lag"`
+	Messages      uint64    `json:"messages"`
+	LastMessage   time.Time `json:"last_message,omitempty"`
+}
+
+// HealthStatus is a body of health endpoints.
+type HealthStatus struct {
+	Status string `json:"status"`
+	// Checks holds result of each check, "ok" or failure reason.
+	Checks map[string]string `json:"checks"`
+	// Generation and Member identify consumer group session, they are empty when consumer group is not used.
+	Generation int32             `json:"generation,omitempty"`
+	Member     string            `json:"member,omitempty"`
+	Partitions []PartitionStatus `json:"partitions"`
+}
+
+// Health check statuses.
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// health tracks state of running dumper for liveness and readiness checks. It is safe for concurrent use.
+type health struct {
+	outputDir string
+	timeout   time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+	// lastTick is a time of the last consumer loop iteration, zero when loop is not started.
+	lastTick time.Time
+	// assigned is true while partitions are assigned to consumer.
+	assigned   bool
+	generation int32
+	member     string
+	partitions map[topicPartition]*PartitionStatus
+}
+
+func newHealth(outputDir string, timeout time.Duration) *health {
+	if timeout <= 0 {
+		timeout = defaultLivenessTimeout
+	}
+
+	return &health{
+		outputDir:  outputDir,
+		timeout:    timeout,
+		now:        time.Now,
+		partitions: make(map[topicPartition]*PartitionStatus),
+	}
+}
+
+// reset forgets state of previous run.
+func (h *health) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastTick = time.Time{}
+	h.assigned = false
+	h.generation = 0
+	h.member = ""
+	h.partitions = make(map[topicPartition]*PartitionStatus)
+}
+
+// tick records iteration of consumer loop.
+func (h *health) tick() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastTick = h.now()
+}
+
+// rebalanced records partitions claimed by new consumer group session.
+func (h *health) rebalanced(sess sarama.ConsumerGroupSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.generation = sess.GenerationID()
+	h.member = sess.MemberID()
+	h.partitions = make(map[topicPartition]*PartitionStatus)
+
+	for topic, partitions := range sess.Claims() {
+		for _, partition := range partitions {
+			h.add(topic, partition)
+		}
+	}
+
+	h.assigned = len(h.partitions) != 0
+}
+
+// revoked records end of consumer group session.
+func (h *health) revoked() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.assigned = false
+}
+
+// started records partition consumed without consumer group.
+func (h *health) started(topic string, partition int32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.add(topic, partition)
+	h.assigned = true
+}
+
+func (h *health) add(topic string, partition int32) {
+	h.partitions[topicPartition{topic: topic, partition: partition}] = &PartitionStatus{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    -1,
+	}
+}
+
+// released forgets partition that is not consumed anymore.
+func (h *health) released(topic string, partition int32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.partitions, topicPartition{topic: topic, partition: partition})
+}
+
+// consumed records dumped message of partition with high watermark hwm.
+func (h *health) consumed(msg *sarama.ConsumerMessage, hwm int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.partitions[topicPartition{topic: msg.Topic, partition: msg.Partition}]
+	if !ok {
+		return
+	}
+
+	p.Offset = msg.Offset
+	p.HighWatermark = hwm
+	p.Messages++
+	p.LastMessage = h.now()
+
+	if p.Lag = hwm - msg.Offset - 1; p.Lag < 0 {
+		p.Lag = 0
+	}
+}
+
+// liveness checks that consumer loop is not stuck and output directory is writable.
+func (h *health) liveness() HealthStatus {
+	status := h.status()
+
+	h.mu.Lock()
+	lastTick := h.lastTick
+	h.mu.Unlock()
+
+	status.Checks["consumer_loop"] = statusOK
+
+	// consumer loop is not started while dumper connects to brokers.
+	if !lastTick.IsZero() && h.now().Sub(lastTick) > h.timeout {
+		status.Checks["consumer_loop"] = fmt.Sprintf("no iterations since %s", lastTick.Format(time.RFC3339))
+	}
+
+	status.Checks["output_dir"] = statusOK
+
+	if err := probeDir(h.outputDir); err != nil {
+		status.Checks["output_dir"] = err.Error()
+	}
+
+	return status.result()
+}
+
+// readiness checks that partitions are assigned to consumer.
+func (h *health) readiness() HealthStatus {
+	status := h.status()
+
+	h.mu.Lock()
+	assigned := h.assigned
+	h.mu.Unlock()
+
+	status.Checks["partitions_assigned"] = statusOK
+
+	if !assigned {
+		status.Checks["partitions_assigned"] = "no partitions assigned"
+	}
+
+	return status.result()
+}
+
+// status returns status with sorted partitions and without checks.
+func (h *health) status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := HealthStatus{
+		Checks:     make(map[string]string),
+		Generation: h.generation,
+		Member:     h.member,
+		Partitions: make([]PartitionStatus, 0, len(h.partitions)),
+	}
+
+	for _, p := range h.partitions {
+		status.Partitions = append(status.Partitions, *p)
+	}
+
+	sort.Slice(status.Partitions, func(i, j int) bool {
+		a, b := status.Partitions[i], status.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+
+		return a.Partition < b.Partition
+	})
+
+	return status
+}
+
+// result sets status to ok when all checks passed.
+func (s HealthStatus) result() HealthStatus {
+	s.Status = statusOK
+
+	for _, check := range s.Checks {
+		if check != statusOK {
+			s.Status = statusFail
+		}
+	}
+
+	return s
+}
+
+// probeDir checks that file could be created in dir, dir is created when it is absent.
+func probeDir(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output dir: %w", err)
+	}
+
+	f, err := ioutil.TempFile(dir, ".healthz-")
+	if err != nil {
+		return fmt.Errorf("failed to create file in output dir: %w", err)
+	}
+
+	_, err = f.Write([]byte("ok"))
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write file in output dir: %w", err)
+	}
+
+	return nil
+}
+
+// healthHandler returns HTTP handler that responds with JSON of check, status code is 503 when check fails.
+func healthHandler(check func() HealthStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		status := check()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if status.Status != statusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		_ = enc.Encode(status)
+	})
+}
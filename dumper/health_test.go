@@ -0,0 +1,166 @@
+package dumper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// testSession is a consumer group session with fixed claims.
+type testSession struct {
+	sarama.ConsumerGroupSession
+	claims map[string][]int32
+}
+
+func (s testSession) Claims() map[string][]int32 { return s.claims }
+func (s testSession) MemberID() string           { return "member-1" }
+func (s testSession) GenerationID() int32        { return 3 }
+
+// testHealth returns health of dir with clock that is moved by returned function.
+func testHealth(dir string) (*health, func(time.Duration)) {
+	now := testTime
+	h := newHealth(dir, time.Minute)
+	h.now = func() time.Time { return now }
+
+	return h, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLiveness(t *testing.T) {
+	h, advance := testHealth(t.TempDir())
+
+	// consumer loop is not started yet.
+	advance(time.Hour)
+
+	if got := h.liveness(); got.Status != statusOK {
+		t.Errorf("liveness() before start = %+v, want ok", got)
+	}
+
+	h.tick()
+	advance(30 * time.Second)
+
+	if got := h.liveness(); got.Status != statusOK || got.Checks["consumer_loop"] != statusOK || got.Checks["output_dir"] != statusOK {
+		t.Errorf("liveness() after tick = %+v, want ok", got)
+	}
+
+	advance(time.Minute)
+
+	if got := h.liveness(); got.Status != statusFail || got.Checks["consumer_loop"] == statusOK {
+		t.Errorf("liveness() of stuck loop = %+v, want fail", got)
+	}
+
+	h.tick()
+
+	if got := h.liveness(); got.Status != statusOK {
+		t.Errorf("liveness() after next tick = %+v, want ok", got)
+	}
+
+	h.reset()
+	advance(time.Hour)
+
+	if got := h.liveness(); got.Status != statusOK {
+		t.Errorf("liveness() after reset = %+v, want ok", got)
+	}
+}
+
+func TestLivenessOutputDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+
+	if err := ioutil.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	created := filepath.Join(t.TempDir(), "output")
+
+	for dir, want := range map[string]string{created: statusOK, file: statusFail} {
+		h, _ := testHealth(dir)
+
+		if got := h.liveness(); got.Status != want {
+			t.Errorf("liveness() of output dir %s = %+v, want %s", dir, got, want)
+		}
+	}
+
+	if files, err := ioutil.ReadDir(created); err != nil || len(files) != 0 {
+		t.Errorf("output dir after probe has %d files, %v, want empty dir", len(files), err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	h, advance := testHealth(t.TempDir())
+
+	if got := h.readiness(); got.Status != statusFail || got.Checks["partitions_assigned"] == statusOK {
+		t.Errorf("readiness() before assignment = %+v, want fail", got)
+	}
+
+	h.rebalanced(testSession{claims: map[string][]int32{"orders": {1, 0}, "audit": {0}}})
+	advance(time.Second)
+	h.consumed(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 7}, 10)
+	h.consumed(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 9}, 10)
+	h.consumed(&sarama.ConsumerMessage{Topic: "payments", Partition: 0, Offset: 1}, 10)
+
+	got := h.readiness()
+
+	want := HealthStatus{
+		Status:     statusOK,
+		Checks:     map[string]string{"partitions_assigned": statusOK},
+		Generation: 3,
+		Member:     "member-1",
+		Partitions: []PartitionStatus{
+			{Topic: "audit", Partition: 0, Offset: -1},
+			{Topic: "orders", Partition: 0, Offset: -1},
+			{Topic: "orders", Partition: 1, Offset: 9, HighWatermark: 10, Lag: 0, Messages: 2, LastMessage: testTime.Add(time.Second)},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readiness() = %+v, want %+v", got, want)
+	}
+
+	h.revoked()
+
+	if got = h.readiness(); got.Status != statusFail {
+		t.Errorf("readiness() after revoke = %+v, want fail", got)
+	}
+
+	// partitions consumed without consumer group.
+	h.reset()
+	h.started("orders", 2)
+	h.started("orders", 3)
+	h.released("orders", 2)
+
+	got = h.readiness()
+	if got.Status != statusOK || got.Generation != 0 || len(got.Partitions) != 1 || got.Partitions[0].Partition != 3 {
+		t.Errorf("readiness() of started partitions = %+v, want ok with partition 3", got)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	for _, tc := range []struct {
+		status   string
+		wantCode int
+	}{
+		{status: statusOK, wantCode: http.StatusOK},
+		{status: statusFail, wantCode: http.StatusServiceUnavailable},
+	} {
+		want := HealthStatus{Status: tc.status, Checks: map[string]string{"check": tc.status}, Partitions: []PartitionStatus{}}
+
+		rec := httptest.NewRecorder()
+		healthHandler(func() HealthStatus { return want }).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		var got HealthStatus
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Code != tc.wantCode || rec.Header().Get("Content-Type") != "application/json" || !reflect.DeepEqual(got, want) {
+			t.Errorf("response of %s check = %d %s %+v, want %d %+v", tc.status, rec.Code, rec.Header().Get("Content-Type"), got, tc.wantCode, want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package dumper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultPathTemplate reproduces classic layout <topic>/partition-<n>/<bucket>_Partition_<n>.<ext>.
+const DefaultPathTemplate = "{topic}/partition-{partition}/{bucket}_Partition_{partition}{ext}"
+
+// DefaultSegmentPathTemplate is used instead of DefaultPathTemplate when rotation is enabled:
+// segments are named Kafka-Connect style <topic>+<partition>+<first offset>.<ext>.
+const DefaultSegmentPathTemplate = "{topic}/partition-{partition}/{bucket}/{topic}+{partition}+{offset}{ext}"
+
+// defaultOffsetWidth is a width of zero padded {offset} placeholder.
+const defaultOffsetWidth = "10"
+
+// nullValue is rendered for placeholders of absent keys and headers.
+const nullValue = "null"
+
+// maxComponentLength is a maximum length in bytes of sanitized value of one placeholder. It leaves room
+// for literals and other placeholders of the same path component within 255 bytes limit of file systems.
+const maxComponentLength = 128
+
+// ErrPathTraversal returned when rendered path points outside of output directory.
+var ErrPathTraversal = errors.New("path points outside of output directory")
+
+type placeholder string
+
+const (
+	phTopic         placeholder = "topic"
+	phPartition     placeholder = "partition"
+	phBucket        placeholder = "bucket"
+	phDate          placeholder = "date"
+	phYear          placeholder = "yyyy"
+	phMonth         placeholder = "mm"
+	phDay           placeholder = "dd"
+	phHour          placeholder = "hh"
+	phKeyHashBucket placeholder = "key_hash_bucket"
+	phHeader        placeholder = "header"
+	phCluster       placeholder = "cluster"
+	phExt           placeholder = "ext"
+	phOffset        placeholder = "offset"
+)
+
+// segment of path template: literal text or placeholder with argument.
+type segment struct {
+	literal string
+	ph      placeholder
+	arg     string
+}
+
+// PathTemplate is a parsed template of dump file path relative to output directory.
+//
+// Supported placeholders:
+//
+//	{topic}              topic name
+//	{partition}          partition number, {partition:4} pads it with zeroes to 4 digits
+//	{bucket}             time bucket according to Bucketing (2006-01-02 or 2006-01-02_15)
+//	{date}               date of message, 2006-01-02
+//	{yyyy} {mm} {dd} {hh} year, month, day and hour of message
+//	{key_hash_bucket}    hash of message key modulo KeyHashBuckets ("null" for messages without key)
+//	{header:<name>}      value of message header ("null" when header is absent)
+//	{cluster}            configured cluster name
+//	{ext}                extension of output format
+//	{offset}             first offset of segment, zero padded to 10 digits, {offset:20} changes width
+type PathTemplate struct {
+	segments []segment
+}
+
+// ParsePathTemplate parses and validates path template.
+func ParsePathTemplate(s string) (*PathTemplate, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errors.New("empty path template")
+	}
+
+	var t PathTemplate
+
+	for rest := s; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.segments = append(t.segments, segment{literal: rest})
+
+			break
+		}
+
+		if open > 0 {
+			t.segments = append(t.segments, segment{literal: rest[:open]})
+		}
+
+		closing := strings.IndexByte(rest[open:], '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in path template [%s]", s)
+		}
+
+		seg, err := parsePlaceholder(rest[open+1 : open+closing])
+		if err != nil {
+			return nil, fmt.Errorf("invalid path template [%s]: %w", s, err)
+		}
+
+		t.segments = append(t.segments, seg)
+		rest = rest[open+closing+1:]
+	}
+
+	return &t, nil
+}
+
+func parsePlaceholder(s string) (segment, error) {
+	name, arg := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+
+	ph := placeholder(name)
+
+	switch ph {
+	case phTopic, phBucket, phDate, phYear, phMonth, phDay, phHour, phKeyHashBucket, phCluster, phExt:
+		if arg != "" {
+			return segment{}, fmt.Errorf("placeholder {%s} does not accept arguments", name)
+		}
+	case phPartition, phOffset:
+		if arg != "" {
+			if w, err := strconv.Atoi(arg); err != nil || w <= 0 {
+				return segment{}, fmt.Errorf("invalid %s width [%s]", name, arg)
+			}
+		}
+	case phHeader:
+		if arg == "" {
+			return segment{}, errors.New("placeholder {header:<name>} requires header name")
+		}
+	default:
+		return segment{}, fmt.Errorf("unknown placeholder {%s}", s)
+	}
+
+	return segment{ph: ph, arg: arg}, nil
+}
+
+// Uses reports whether template contains placeholder with passed name.
+func (t *PathTemplate) Uses(name string) bool {
+	for _, seg := range t.segments {
+		if seg.ph == placeholder(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Layout builds locations of dump files.
+type Layout struct {
+	OutputDir      string
+	Template       *PathTemplate
+	Bucketer       Bucketer
+	Cluster        string
+	KeyHashBuckets int
+}
+
+// Path returns location of dump file for message.
+// firstOffset is a first offset of segment used for {offset} placeholder, negative value renders it empty.
+// Values taken from message (topic, key, headers) are sanitized, so they could not produce
+// additional directories or point outside of OutputDir.
+func (l Layout) Path(msg *sarama.ConsumerMessage, ext string, firstOffset int64) (string, error) {
+	var b strings.Builder
+
+	for _, seg := range l.Template.segments {
+		switch seg.ph {
+		case "":
+			b.WriteString(seg.literal)
+		case phOffset:
+			b.WriteString(renderOffset(seg.arg, firstOffset))
+		default:
+			b.WriteString(l.render(seg, msg, ext))
+		}
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(b.String()))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrPathTraversal, b.String())
+	}
+
+	return filepath.Join(l.OutputDir, rel), nil
+}
+
+func (l Layout) render(seg segment, msg *sarama.ConsumerMessage, ext string) string {
+	switch seg.ph {
+	case phTopic:
+		return sanitizePathComponent(msg.Topic)
+	case phPartition:
+		return l.renderPartition(seg.arg, msg.Partition)
+	case phBucket:
+		return l.Bucketer.Bucket(msg)
+	case phKeyHashBucket:
+		return l.keyHashBucket(msg.Key)
+	case phHeader:
+		return sanitizePathComponent(headerValue(msg, seg.arg))
+	case phCluster:
+		return sanitizePathComponent(l.Cluster)
+	case phExt:
+		return ext
+	default:
+		return l.renderTime(seg.ph, msg)
+	}
+}
+
+func (l Layout) renderPartition(width string, partition int32) string {
+	if width == "" {
+		return strconv.Itoa(int(partition))
+	}
+
+	return fmt.Sprintf("%0"+width+"d", partition)
+}
+
+func renderOffset(width string, offset int64) string {
+	if offset < 0 {
+		return ""
+	}
+
+	if width == "" {
+		width = defaultOffsetWidth
+	}
+
+	return fmt.Sprintf("%0"+width+"d", offset)
+}
+
+func (l Layout) renderTime(ph placeholder, msg *sarama.ConsumerMessage) string {
+	layouts := map[placeholder]string{
+		phDate:  "2006-01-02",
+		phYear:  "2006",
+		phMonth: "01",
+		phDay:   "02",
+		phHour:  "15",
+	}
+
+	return l.Bucketer.Time(msg).Format(layouts[ph])
+}
+
+func (l Layout) keyHashBucket(key []byte) string {
+	if key == nil {
+		return nullValue
+	}
+
+	buckets := l.KeyHashBuckets
+	if buckets <= 0 {
+		buckets = 1
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+
+	return strconv.Itoa(int(h.Sum32() % uint32(buckets)))
+}
+
+func headerValue(msg *sarama.ConsumerMessage, name string) string {
+	for _, h := range msg.Headers {
+		if h != nil && bytes.Equal(h.Key, []byte(name)) {
+			if h.Value == nil {
+				return nullValue
+			}
+
+			return string(h.Value)
+		}
+	}
+
+	return nullValue
+}
+
+// sanitizePathComponent makes value safe to use as one path component: path separators,
+// control characters and characters reserved on common file systems are replaced with '_',
+// special names "." and ".." are escaped, and values longer than maxComponentLength are truncated
+// and suffixed with hash of the whole value, so different long values do not share files.
+func sanitizePathComponent(s string) string {
+	if s == "" {
+		return "_"
+	}
+
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/', r == '\\', r == ':', r == '*', r == '?', r == '"', r == '<', r == '>', r == '|':
+			return '_'
+		case unicode.IsControl(r), r == unicode.ReplacementChar:
+			return '_'
+		default:
+			return r
+		}
+	}, s)
+
+	if strings.Trim(s, ".") == "" {
+		s = strings.Repeat("_", len(s))
+	}
+
+	if len(s) > maxComponentLength {
+		s = truncateComponent(s)
+	}
+
+	return s
+}
+
+// truncateComponent returns prefix of s followed by '~' and hex of its 64-bit hash, maxComponentLength bytes at most.
+// Prefix is cut at rune boundary.
+func truncateComponent(s string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	suffix := fmt.Sprintf("~%016x", h.Sum64())
+
+	n := maxComponentLength - len(suffix)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n] + suffix
+}
+
+// topicComponent reports whether template has path component with {topic} and without placeholders
+// of varying values, so glob of one topic files could not match files of another topic.
+func (t *PathTemplate) topicComponent() bool {
+	hasTopic, fixed := false, true
+
+	for _, seg := range t.segments {
+		switch seg.ph {
+		case "":
+			if strings.Contains(seg.literal, "/") {
+				if hasTopic && fixed {
+					return true
+				}
+
+				hasTopic, fixed = false, true
+			}
+		case phTopic:
+			hasTopic = true
+		case phCluster, phExt:
+		default:
+			fixed = false
+		}
+	}
+
+	return hasTopic && fixed
+}
+
+// glob returns pattern of all dump files of topic with extension ext. Placeholders with values
+// that depend on message or segment match any value.
+func (l Layout) glob(topic, ext string) string {
+	var b strings.Builder
+
+	for _, seg := range l.Template.segments {
+		switch seg.ph {
+		case "":
+			b.WriteString(escapeGlob(seg.literal))
+		case phTopic:
+			b.WriteString(escapeGlob(sanitizePathComponent(topic)))
+		case phCluster:
+			b.WriteString(escapeGlob(sanitizePathComponent(l.Cluster)))
+		case phExt:
+			b.WriteString(escapeGlob(ext))
+		default:
+			b.WriteString("*")
+		}
+	}
+
+	return filepath.Join(escapeGlob(l.OutputDir), filepath.FromSlash(b.String()))
+}
+
+// existingSegments returns segments of file of message keyed by their first offsets.
+func (l Layout) existingSegments(msg *sarama.ConsumerMessage, ext string) (map[int64]string, error) {
+	var glob, pattern strings.Builder
+
+	pattern.WriteString("^")
+
+	for _, seg := range l.Template.segments {
+		var s string
+
+		switch seg.ph {
+		case "":
+			s = seg.literal
+		case phOffset:
+			glob.WriteString("*")
+			pattern.WriteString(`(\d+)`)
+
+			continue
+		default:
+			s = l.render(seg, msg, ext)
+		}
+
+		glob.WriteString(escapeGlob(s))
+		pattern.WriteString(regexp.QuoteMeta(s))
+	}
+
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(escapeGlob(l.OutputDir), filepath.FromSlash(glob.String())))
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make(map[int64]string)
+
+	for _, path := range matches {
+		rel, err := filepath.Rel(l.OutputDir, path)
+		if err != nil {
+			continue
+		}
+
+		m := re.FindStringSubmatch(filepath.ToSlash(rel))
+		if m == nil {
+			continue
+		}
+
+		offset, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segments[offset] = path
+	}
+
+	return segments, nil
+}
+
+// escapeGlob escapes characters that have special meaning in glob patterns.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(s)
+}
@@ -0,0 +1,167 @@
+package dumper
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestSanitizePathComponent(t *testing.T) {
+	long := strings.Repeat("x", 300)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "orders.v1-eu_west", want: "orders.v1-eu_west"},
+		{name: "empty", in: "", want: "_"},
+		{name: "dot", in: ".", want: "_"},
+		{name: "dot dot", in: "..", want: "__"},
+		{name: "dots", in: "...", want: "___"},
+		{name: "dot dot prefix", in: "..a", want: "..a"},
+		{name: "slash", in: "../etc/passwd", want: ".._etc_passwd"},
+		{name: "backslash", in: `..\windows`, want: ".._windows"},
+		{name: "reserved characters", in: `a:b*c?d"e<f>g|h`, want: "a_b_c_d_e_f_g_h"},
+		{name: "control characters", in: "a\x00b\nc\td\x7f", want: "a_b_c_d_"},
+		{name: "invalid utf-8", in: "a\xffb", want: "a_b"},
+		{name: "unicode", in: "заказы", want: "заказы"},
+		{name: "limit", in: long[:maxComponentLength], want: long[:maxComponentLength]},
+	}
+
+	for _, tc := range tests {
+		if got := sanitizePathComponent(tc.in); got != tc.want {
+			t.Errorf("%s: sanitizePathComponent(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizeLongPathComponent(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	unicodeLong := strings.Repeat("ж", 200)
+
+	for _, in := range []string{long, long + "y", long[:maxComponentLength+1], unicodeLong, strings.Repeat("/", 300)} {
+		got := sanitizePathComponent(in)
+
+		switch {
+		case len(got) > maxComponentLength:
+			t.Errorf("sanitizePathComponent() of %d bytes = %d bytes, want at most %d", len(in), len(got), maxComponentLength)
+		case !utf8.ValidString(got):
+			t.Errorf("sanitizePathComponent() = %q, want valid UTF-8", got)
+		case got != sanitizePathComponent(in):
+			t.Errorf("sanitizePathComponent() of %d bytes is not stable", len(in))
+		}
+	}
+
+	if a, b := sanitizePathComponent(long), sanitizePathComponent(long+"y"); a == b || a[:100] != b[:100] {
+		t.Errorf("sanitizePathComponent() of long values = %q and %q, want same prefix and different hashes", a, b)
+	}
+
+	// over-long value is written as one file.
+	dir := t.TempDir()
+	path := filepath.Join(dir, sanitizePathComponent(strings.Repeat("v", 1000))+".jsonl")
+
+	if err := ioutil.WriteFile(path, nil, 0o600); err != nil {
+		t.Errorf("failed to create file of over-long value: %v", err)
+	}
+}
+
+func TestLayoutPath(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("cust-7"),
+		Timestamp: testTime,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("tenant"), Value: []byte("../../etc")},
+			{Key: []byte("empty"), Value: []byte{}},
+			{Key: []byte("nil")},
+			{Key: []byte("long"), Value: []byte(strings.Repeat("t", 300))},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		offset   int64
+		want     string
+		wantErr  error
+	}{
+		{name: "default", template: DefaultPathTemplate, offset: -1, want: "orders/partition-3/2021-02-03_Partition_3.jsonl"},
+		{
+			name:     "segment",
+			template: DefaultSegmentPathTemplate,
+			offset:   42,
+			want:     "orders/partition-3/2021-02-03/orders+3+0000000042.jsonl",
+		},
+		{name: "widths", template: "{partition:4}/{offset:3}{ext}", offset: 7, want: "0003/007.jsonl"},
+		{name: "time", template: "{yyyy}/{mm}/{dd}/{hh}/{date}{ext}", offset: -1, want: "2021/02/03/10/2021-02-03.jsonl"},
+		{name: "cluster", template: "{cluster}/{topic}{ext}", offset: -1, want: "eu_1/orders.jsonl"},
+		{name: "key hash bucket", template: "{key_hash_bucket}{ext}", offset: -1, want: "2.jsonl"},
+		{name: "traversal in header", template: "{header:tenant}/{topic}{ext}", offset: -1, want: ".._.._etc/orders.jsonl"},
+		{name: "empty header", template: "{header:empty}{ext}", offset: -1, want: "_.jsonl"},
+		{name: "null header", template: "{header:nil}-{header:missing}{ext}", offset: -1, want: "null-null.jsonl"},
+		{
+			name:     "long header",
+			template: "{header:long}{ext}",
+			offset:   -1,
+			want:     sanitizePathComponent(strings.Repeat("t", 300)) + ".jsonl",
+		},
+		{name: "literal dot dot inside", template: "a/../{topic}{ext}", offset: -1, want: "orders.jsonl"},
+		{name: "literal traversal", template: "../{topic}{ext}", offset: -1, wantErr: ErrPathTraversal},
+		{name: "nested literal traversal", template: "a/../../{topic}{ext}", offset: -1, wantErr: ErrPathTraversal},
+		{name: "absolute", template: "/{topic}{ext}", offset: -1, wantErr: ErrPathTraversal},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := ParsePathTemplate(tc.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			l := Layout{
+				OutputDir:      "out",
+				Template:       tmpl,
+				Bucketer:       Bucketer{Source: TimestampCreate, Bucketing: BucketDaily},
+				Cluster:        "eu:1",
+				KeyHashBuckets: 4,
+			}
+
+			got, err := l.Path(msg, ".jsonl", tc.offset)
+
+			switch {
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("Path() = %q, %v, want %v", got, err, tc.wantErr)
+				}
+			case err != nil || got != filepath.Join("out", filepath.FromSlash(tc.want)):
+				t.Errorf("Path() = %q, %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestParsePathTemplate(t *testing.T) {
+	for _, tmpl := range []string{
+		"",
+		"  ",
+		"{topic",
+		"{unknown}",
+		"{topic:1}",
+		"{partition:0}",
+		"{offset:x}",
+		"{header}",
+		"{header:}",
+	} {
+		if _, err := ParsePathTemplate(tmpl); err == nil {
+			t.Errorf("ParsePathTemplate(%q) error = nil", tmpl)
+		}
+	}
+}
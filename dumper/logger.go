@@ -0,0 +1,23 @@
+package dumper
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Logger is used by dumper to report its progress.
+// It is implemented by *logrus.Logger and *logrus.Entry.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// loggerOrDefault returns l or standard logrus logger when l is nil.
+func loggerOrDefault(l Logger) Logger {
+	if l == nil {
+		return log.StandardLogger()
+	}
+
+	return l
+}
@@ -0,0 +1,179 @@
+package dumper
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/metrics"
+)
+
+const (
+	openFilesMetric = "kafka_dump_open_files"
+	openFilesHelp   = "Number of open dump files."
+)
+
+// dumperMetrics exports dumper progress to metrics registry. It is nil when metrics are disabled,
+// all its methods do nothing in this case.
+type dumperMetrics struct {
+	registry      *metrics.Registry
+	messages      *metrics.CounterVec
+	bytes         *metrics.CounterVec
+	lag           *metrics.GaugeVec
+	writeDuration *metrics.HistogramVec
+	writeErrors   *metrics.CounterVec
+	rebalances    *metrics.CounterVec
+	decodeErrors  *metrics.CounterVec
+	filtered      *metrics.CounterVec
+}
+
+// newDumperMetrics registers dumper metrics, it fails when their names are registered as other metrics.
+func newDumperMetrics(registry *metrics.Registry) (*dumperMetrics, error) {
+	if registry == nil {
+		return nil, nil
+	}
+
+	m := &dumperMetrics{registry: registry}
+
+	var err error
+
+	counters := []struct {
+		c      **metrics.CounterVec
+		name   string
+		help   string
+		labels []string
+	}{
+		{c: &m.messages, name: "kafka_dump_messages_total", help: "Number of consumed messages.", labels: []string{"topic", "partition"}},
+		{
+			c:      &m.bytes,
+			name:   "kafka_dump_bytes_total",
+			help:   "Size of keys and values of consumed messages in bytes.",
+			labels: []string{"topic", "partition"},
+		},
+		{c: &m.writeErrors, name: "kafka_dump_write_errors_total", help: "Number of failed writes of dump files."},
+		{c: &m.rebalances, name: "kafka_dump_rebalances_total", help: "Number of consumer group rebalances."},
+		{
+			c:      &m.decodeErrors,
+			name:   "kafka_dump_decode_errors_total",
+			help:   "Number of message keys and values that could not be decoded and were dumped as is.",
+			labels: []string{"topic", "field"},
+		},
+		{
+			c:      &m.filtered,
+			name:   "kafka_dump_filtered_messages_total",
+			help:   "Number of consumed messages that did not pass filter and were not dumped.",
+			labels: []string{"topic"},
+		},
+	}
+
+	for _, c := range counters {
+		if *c.c, err = registry.Counter(c.name, c.help, c.labels...); err != nil {
+			return nil, fmt.Errorf("failed to register metric: %w", err)
+		}
+	}
+
+	m.lag, err = registry.Gauge("kafka_dump_partition_lag",
+		"Number of messages between high watermark and the last consumed message of partition.", "topic", "partition")
+	if err != nil {
+		return nil, fmt.Errorf("failed to register metric: %w", err)
+	}
+
+	m.writeDuration, err = registry.Histogram("kafka_dump_write_duration_seconds", "Time of writing record to dump files.", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register metric: %w", err)
+	}
+
+	if err = registry.GaugeFunc(openFilesMetric, openFilesHelp, func() float64 { return 0 }); err != nil {
+		return nil, fmt.Errorf("failed to register metric: %w", err)
+	}
+
+	// counters without labels are exported from start.
+	m.writeErrors.Add(0)
+	m.rebalances.Add(0)
+
+	return m, nil
+}
+
+// consumed records consumed message of partition with high watermark hwm.
+func (m *dumperMetrics) consumed(msg *sarama.ConsumerMessage, hwm int64) {
+	if m == nil {
+		return
+	}
+
+	partition := strconv.Itoa(int(msg.Partition))
+
+	m.messages.Inc(msg.Topic, partition)
+	m.bytes.Add(float64(len(msg.Key)+len(msg.Value)), msg.Topic, partition)
+
+	if lag := hwm - msg.Offset - 1; lag >= 0 {
+		m.lag.Set(float64(lag), msg.Topic, partition)
+	}
+}
+
+// released forgets lag of partition that is not consumed anymore.
+func (m *dumperMetrics) released(topic string, partition int32) {
+	if m == nil {
+		return
+	}
+
+	m.lag.Delete(topic, strconv.Itoa(int(partition)))
+}
+
+// written records time of successful write of record.
+func (m *dumperMetrics) written(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.writeDuration.Observe(d.Seconds())
+}
+
+// writeFailed records failed write.
+func (m *dumperMetrics) writeFailed() {
+	if m == nil {
+		return
+	}
+
+	m.writeErrors.Inc()
+}
+
+// rebalanced records new consumer group session.
+func (m *dumperMetrics) rebalanced() {
+	if m == nil {
+		return
+	}
+
+	m.rebalances.Inc()
+}
+
+// decodeFailed records failed decoding of message key or value.
+func (m *dumperMetrics) decodeFailed(topic, field string) {
+	if m == nil {
+		return
+	}
+
+	m.decodeErrors.Inc(topic, field)
+}
+
+// filteredOut records message that did not pass filter.
+func (m *dumperMetrics) filteredOut(topic string) {
+	if m == nil {
+		return
+	}
+
+	m.filtered.Inc(topic)
+}
+
+// openFiles exports number of open files returned by count.
+func (m *dumperMetrics) openFiles(count func() int) {
+	if m == nil {
+		return
+	}
+
+	// gauge is registered by newDumperMetrics, so replacing its function does not fail.
+	_ = m.registry.GaugeFunc(openFilesMetric, openFilesHelp, func() float64 {
+		return float64(count())
+	})
+}
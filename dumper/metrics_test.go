@@ -0,0 +1,39 @@
+package dumper
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/metrics"
+)
+
+func TestNewMetricsConflict(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	if _, err := registry.Gauge("kafka_dump_messages_total", "Not a counter."); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := testSettings(t, t.TempDir(), format.JSONL, DefaultPathTemplate)
+
+	_, err := New(Options{
+		ClientID: "test",
+		Brokers:  []string{"localhost:9092"},
+		GroupID:  "test",
+		Version:  sarama.V0_10_2_0,
+		Topics:   []string{"orders"},
+		Layout:   settings.layout,
+		Encoder:  settings.encoder,
+		Metrics:  registry,
+		Logger:   discardLogger(),
+	})
+
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || !strings.Contains(err.Error(), "kafka_dump_messages_total") {
+		t.Errorf("New() with conflicting metric error = %v, want *ConfigError", err)
+	}
+}
@@ -0,0 +1,194 @@
+package dumper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/obalunenko/kafka-dump/filter"
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/redact"
+)
+
+// retentionInterval is how often dump files are checked for retention.
+const retentionInterval = time.Minute
+
+// TopicOverride changes dump settings of topic with Name or of topics matched by Pattern.
+// Nil fields inherit values of Options.
+type TopicOverride struct {
+	Name    string
+	Pattern *regexp.Regexp
+
+	Encoder  format.Encoder
+	Decoders format.Decoders
+	// Compression overrides Options.Compression when it is not empty.
+	Compression format.Compression
+	// Filter replaces Options.Filter when it is not nil.
+	Filter *filter.Filter
+	// Redactor removes personal data from decoded keys and values of topic, it is set only by override.
+	Redactor *redact.Redactor
+	Template *PathTemplate
+	// Retention overrides Options.Retention when positive, negative value disables retention of topic.
+	Retention time.Duration
+}
+
+func (o TopicOverride) matches(topic string) bool {
+	if o.Pattern != nil {
+		return o.Pattern.MatchString(topic)
+	}
+
+	return o.Name == topic
+}
+
+func (o TopicOverride) String() string {
+	if o.Pattern != nil {
+		return "pattern [" + o.Pattern.String() + "]"
+	}
+
+	return "topic [" + o.Name + "]"
+}
+
+// topicSettings are dump settings of topic with override applied.
+type topicSettings struct {
+	encoder     format.Encoder
+	decoders    format.Decoders
+	compression format.Compression
+	filter      *filter.Filter
+	redactor    *redact.Redactor
+	layout      Layout
+	retention   time.Duration
+}
+
+// extension returns extension of dump files: extension of format followed by extension of compression.
+func (s topicSettings) extension() string {
+	return s.encoder.Extension() + s.compression.Extension()
+}
+
+// apply returns settings changed by override.
+func (o TopicOverride) apply(settings topicSettings) topicSettings {
+	if o.Encoder != nil {
+		settings.encoder = o.Encoder
+	}
+
+	if o.Decoders.Key != nil {
+		settings.decoders.Key = o.Decoders.Key
+	}
+
+	if o.Decoders.Value != nil {
+		settings.decoders.Value = o.Decoders.Value
+	}
+
+	if o.Compression != "" {
+		settings.compression = o.Compression
+	}
+
+	if o.Filter != nil {
+		settings.filter = o.Filter
+	}
+
+	if o.Redactor != nil {
+		settings.redactor = o.Redactor
+	}
+
+	if o.Template != nil {
+		settings.layout.Template = o.Template
+	}
+
+	switch {
+	case o.Retention > 0:
+		settings.retention = o.Retention
+	case o.Retention < 0:
+		settings.retention = 0
+	}
+
+	return settings
+}
+
+// settingsFor returns settings of topic: defaults changed by the first matching override.
+func settingsFor(topic string, defaults topicSettings, overrides []TopicOverride) topicSettings {
+	for _, o := range overrides {
+		if o.matches(topic) {
+			return o.apply(defaults)
+		}
+	}
+
+	return defaults
+}
+
+// validate checks that settings do not contradict rotation policy.
+func (s topicSettings) validate(rotation RotationPolicy) error {
+	switch {
+	case s.encoder == nil:
+		return errors.New("no encoder")
+	case s.layout.Template == nil:
+		return errors.New("no path template")
+	case s.compression.Enabled() && s.compression.Extension() == "":
+		return fmt.Errorf("unknown compression [%s]", s.compression)
+	case s.redactor.RedactsKey() && format.IsRaw(s.decoders.Key):
+		return errors.New("redaction rules of key require KeyDecoder that decodes keys")
+	case s.redactor.RedactsValue() && format.IsRaw(s.decoders.Value):
+		return errors.New("redaction rules of value require ValueDecoder that decodes values")
+	case format.Indexed(s.encoder) && !(s.layout.Template.Uses(string(phTopic)) && s.layout.Template.Uses(string(phPartition))):
+		// index is keyed by offset, so segment could not hold records of several partitions.
+		return errors.New("path template should contain {topic} and {partition} placeholders for indexed output format")
+	case rotation.Enabled() && !s.layout.Template.Uses(string(phOffset)):
+		return errors.New("path template should contain {offset} placeholder when rotation is enabled")
+	case s.retention > 0 && !s.layout.Template.topicComponent():
+		return errors.New("path template should have {topic} placeholder as whole directory or file name when retention is set")
+	default:
+		return nil
+	}
+}
+
+// validateOverrides checks overrides and settings they produce.
+func validateOverrides(defaults topicSettings, overrides []TopicOverride, rotation RotationPolicy) error {
+	names := make(map[string]bool)
+
+	for _, o := range overrides {
+		switch {
+		case o.Name == "" && o.Pattern == nil:
+			return errors.New("topic override should have name or pattern")
+		case o.Name != "" && o.Pattern != nil:
+			return fmt.Errorf("topic override [%s] should have either name or pattern", o.Name)
+		case o.Name != "" && names[o.Name]:
+			return fmt.Errorf("duplicate override of topic [%s]", o.Name)
+		}
+
+		names[o.Name] = true
+
+		if err := o.apply(defaults).validate(rotation); err != nil {
+			return fmt.Errorf("invalid override of %s: %w", o, err)
+		}
+	}
+
+	return nil
+}
+
+// expired returns dump files of topic that were not modified for retention.
+// Files are found by path template, so files of previous runs are found too.
+func (s topicSettings) expired(topic string, now time.Time) ([]string, error) {
+	pattern := s.layout.glob(topic, s.extension())
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find dump files of topic [%s]: %w", topic, err)
+	}
+
+	var files []string
+
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		if now.Sub(info.ModTime()) > s.retention {
+			files = append(files, path)
+		}
+	}
+
+	return files, nil
+}
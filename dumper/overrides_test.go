@@ -0,0 +1,170 @@
+package dumper
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+// TestIndexedTemplate checks that segments of indexed format could not be shared by partitions or topics,
+// index entries are keyed by offset only.
+func TestIndexedTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		f        format.Format
+		valid    bool
+	}{
+		{template: DefaultPathTemplate, f: format.Binary, valid: true},
+		{template: "{topic}-{partition:3}{ext}", f: format.Binary, valid: true},
+		{template: "{topic}/{bucket}{ext}", f: format.Binary},
+		{template: "{partition}/{bucket}{ext}", f: format.Binary},
+		{template: "{topic}/{bucket}{ext}", f: format.JSONL, valid: true},
+	}
+
+	for _, tc := range tests {
+		err := testSettings(t, "out", tc.f, tc.template).validate(RotationPolicy{})
+		if (err == nil) != tc.valid {
+			t.Errorf("validate() of %s template %s = %v, want valid %v", tc.f, tc.template, err, tc.valid)
+		}
+	}
+}
+
+func TestSettingsFor(t *testing.T) {
+	defaults := testSettings(t, "out", format.JSONL, DefaultPathTemplate)
+	defaults.retention = 720 * time.Hour
+
+	binary := testSettings(t, "out", format.Binary, DefaultPathTemplate).encoder
+
+	overrides := []TopicOverride{
+		{Name: "orders", Encoder: binary, Retention: 168 * time.Hour},
+		{Pattern: regexp.MustCompile(`^ord`), Compression: format.Gzip},
+		{Pattern: regexp.MustCompile(`^audit\.`), Retention: -1},
+		{Name: "audit.login", Compression: format.Zstd},
+	}
+
+	tests := []struct {
+		topic           string
+		wantEncoder     format.Encoder
+		wantCompression format.Compression
+		wantRetention   time.Duration
+	}{
+		{topic: "orders", wantEncoder: binary, wantRetention: 168 * time.Hour},
+		{topic: "ordinals", wantEncoder: defaults.encoder, wantCompression: format.Gzip, wantRetention: 720 * time.Hour},
+		// the first matching override is applied, whether it has name or pattern.
+		{topic: "audit.login", wantEncoder: defaults.encoder, wantRetention: 0},
+		{topic: "payments", wantEncoder: defaults.encoder, wantRetention: 720 * time.Hour},
+	}
+
+	for _, tc := range tests {
+		got := settingsFor(tc.topic, defaults, overrides)
+
+		if got.encoder != tc.wantEncoder || got.compression != tc.wantCompression || got.retention != tc.wantRetention {
+			t.Errorf("settingsFor(%s) = %s, %q, %s, want %s, %q, %s", tc.topic,
+				got.encoder.Extension(), got.compression, got.retention,
+				tc.wantEncoder.Extension(), tc.wantCompression, tc.wantRetention)
+		}
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	defaults := testSettings(t, "out", format.JSONL, DefaultPathTemplate)
+
+	noTopic, err := ParsePathTemplate("{partition}/{date}{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pattern := regexp.MustCompile(`^orders`)
+
+	tests := []struct {
+		name      string
+		overrides []TopicOverride
+		rotation  RotationPolicy
+		valid     bool
+	}{
+		{name: "name and pattern overrides", overrides: []TopicOverride{{Name: "orders"}, {Pattern: pattern}}, valid: true},
+		{name: "neither name nor pattern", overrides: []TopicOverride{{Retention: time.Hour}}},
+		{name: "both name and pattern", overrides: []TopicOverride{{Name: "orders", Pattern: pattern}}},
+		{name: "duplicate name", overrides: []TopicOverride{{Name: "orders"}, {Name: "orders"}}},
+		{name: "retention without topic component", overrides: []TopicOverride{{Name: "orders", Template: noTopic, Retention: time.Hour}}},
+		{
+			name:      "disabled retention without topic component",
+			overrides: []TopicOverride{{Name: "orders", Template: noTopic, Retention: -1}},
+			valid:     true,
+		},
+		{name: "rotation without offset", overrides: []TopicOverride{{Name: "orders"}}, rotation: RotationPolicy{MaxRecords: 10}},
+	}
+
+	for _, tc := range tests {
+		err := validateOverrides(defaults, tc.overrides, tc.rotation)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: validateOverrides() = %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+}
+
+// TestApplyRetention checks that expired files of each topic are removed with its retention
+// and files shared by topics with the same sanitized name are kept.
+func TestApplyRetention(t *testing.T) {
+	dir := t.TempDir()
+	defaults := testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}")
+	defaults.retention = time.Hour
+
+	overrides := []TopicOverride{
+		{Name: "kept", Retention: -1},
+		{Name: "short", Retention: 10 * time.Minute},
+	}
+
+	s := newTestSink(defaults, overrides, RotationPolicy{})
+	dumpMessages(t, s,
+		testMessage("orders", 0, 0), testMessage("recent", 0, 0), testMessage("kept", 0, 0),
+		testMessage("short", 0, 0), testMessage("a/b", 0, 0), testMessage("a_b", 0, 0))
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	for topic, age := range map[string]time.Duration{
+		"orders": 2 * time.Hour,
+		"recent": 30 * time.Minute,
+		"kept":   2 * time.Hour,
+		"short":  30 * time.Minute,
+		"a_b":    2 * time.Hour,
+	} {
+		modified := now.Add(-age)
+
+		if err := os.Chtimes(filepath.Join(dir, topic, "0.jsonl"), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.applyRetention(now)
+
+	for topic, removed := range map[string]bool{
+		"orders": true,
+		"recent": false,
+		"kept":   false,
+		"short":  true,
+		"a_b":    false,
+	} {
+		_, err := os.Stat(filepath.Join(dir, topic, "0.jsonl"))
+
+		if os.IsNotExist(err) != removed {
+			t.Errorf("file of topic %s: stat error = %v, want removed %v", topic, err, removed)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "orders")); !os.IsNotExist(err) {
+		t.Errorf("empty directory of expired files is kept: %v", err)
+	}
+
+	if got := readOffsets(t, filepath.Join(dir, "a_b", "0.jsonl")); !equalOffsets(got, []int64{0, 0}) {
+		t.Errorf("offsets of shared file = %v, want records of both topics", got)
+	}
+}
@@ -0,0 +1,370 @@
+package dumper
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// RangeOptions selects messages to dump by time or by offsets. When set, dumper consumes partitions directly
+// without joining consumer group, so committed offsets of the group are not changed, and stops when
+// all selected partitions are dumped up to their end.
+type RangeOptions struct {
+	// From starts partitions from the first message with timestamp at or after this time.
+	From time.Time
+	// To stops partitions at the first message with timestamp at or after this time. Offset of such message
+	// is looked up at start, when To is in the future partitions are consumed past high watermarks at start
+	// until such message is received, or until To passes and partition has no new messages.
+	To time.Time
+	// Offsets are explicit offsets of partitions, they override From and To for these partitions.
+	// When From and To are not set, only these partitions are dumped.
+	Offsets []PartitionOffsets
+}
+
+// Enabled reports whether dumper consumes range of messages instead of consumer group.
+func (o RangeOptions) Enabled() bool {
+	return !o.From.IsZero() || !o.To.IsZero() || len(o.Offsets) != 0
+}
+
+func (o RangeOptions) validate(version sarama.KafkaVersion) error {
+	timed := !o.From.IsZero() || !o.To.IsZero()
+
+	switch {
+	case timed && !version.IsAtLeast(sarama.V0_10_1_0):
+		return fmt.Errorf("kafka version at least 0.10.1.0 is required to lookup offsets by time, got %s", version)
+	case !o.From.IsZero() && !o.To.IsZero() && !o.From.Before(o.To):
+		return fmt.Errorf("from [%s] should be before to [%s]", o.From, o.To)
+	}
+
+	for _, po := range o.Offsets {
+		if po.End >= 0 && po.End < po.Start {
+			return fmt.Errorf("end offset of [%s:%d] is before start offset", po.Topic, po.Partition)
+		}
+	}
+
+	return nil
+}
+
+// PartitionOffsets is a range of offsets [Start, End) of partition.
+// Negative End is a high watermark of partition at start.
+type PartitionOffsets struct {
+	Topic     string
+	Partition int32
+	Start     int64
+	End       int64
+}
+
+// ParsePartitionOffsets parses partition offsets in topic:partition:start-end format, end could be omitted.
+func ParsePartitionOffsets(s string) (PartitionOffsets, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return PartitionOffsets{}, fmt.Errorf("partition offsets should be in topic:partition:start-end format, got [%s]", s)
+	}
+
+	partition, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return PartitionOffsets{}, fmt.Errorf("invalid partition in [%s]: %w", s, err)
+	}
+
+	bounds := strings.SplitN(parts[2], "-", 2)
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 {
+		return PartitionOffsets{}, fmt.Errorf("invalid start offset in [%s]", s)
+	}
+
+	end := int64(-1)
+
+	if len(bounds) == 2 && bounds[1] != "" {
+		if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < 0 {
+			return PartitionOffsets{}, fmt.Errorf("invalid end offset in [%s]", s)
+		}
+	}
+
+	return PartitionOffsets{Topic: parts[0], Partition: int32(partition), Start: start, End: end}, nil
+}
+
+// partitionRange is a resolved range of offsets [start, end) of partition.
+type partitionRange struct {
+	topicPartition
+	start int64
+	end   int64
+	// to ends range before the first message with timestamp at or after it, zero when range is not bounded by time.
+	to time.Time
+}
+
+// resolveRanges resolves offsets of selected partitions of topics, all partitions are selected when partitions is empty.
+// Time bounds are looked up by message timestamps, ranges are limited by offsets available at start.
+// Without time bounds only partitions with explicit offsets are selected unless whole is set.
+func resolveRanges(client sarama.Client, topics []string, partitions []int32, opts RangeOptions, whole bool,
+	logger Logger) ([]partitionRange, error) {
+	explicit := make(map[topicPartition]PartitionOffsets, len(opts.Offsets))
+
+	for _, po := range opts.Offsets {
+		explicit[topicPartition{topic: po.Topic, partition: po.Partition}] = po
+
+		if !contains(topics, po.Topic) {
+			topics = append(topics, po.Topic)
+		}
+	}
+
+	timed := !opts.From.IsZero() || !opts.To.IsZero()
+	found := make(map[topicPartition]bool, len(explicit))
+
+	var ranges []partitionRange
+
+	for _, topic := range topics {
+		all, err := client.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get partitions of topic [%s]: %w", topic, err)
+		}
+
+		for _, partition := range all {
+			tp := topicPartition{topic: topic, partition: partition}
+
+			po, ok := explicit[tp]
+			found[tp] = ok
+
+			if !ok && (!timed && !whole || len(partitions) != 0 && !containsPartition(partitions, partition)) {
+				continue
+			}
+
+			oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get oldest offset of [%s:%d]: %w", topic, partition, err)
+			}
+
+			hwm, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get high watermark of [%s:%d]: %w", topic, partition, err)
+			}
+
+			r := partitionRange{topicPartition: tp, start: oldest, end: hwm}
+
+			if ok {
+				r.start = po.Start
+
+				if po.End >= 0 {
+					r.end = po.End
+				}
+			} else {
+				if r.start, err = offsetForTime(client, tp, opts.From, oldest, hwm); err != nil {
+					return nil, err
+				}
+
+				// messages with timestamps before future To could be produced after start.
+				end := hwm
+				if opts.To.After(time.Now()) {
+					end = unbounded
+				}
+
+				if r.end, err = offsetForTime(client, tp, opts.To, hwm, end); err != nil {
+					return nil, err
+				}
+
+				r.to = opts.To
+			}
+
+			if r.start < oldest {
+				logger.Warnf("Start offset %d of [%s:%d] is not available anymore, oldest offset %d is used",
+					r.start, topic, partition, oldest)
+
+				r.start = oldest
+			}
+
+			if r.end > hwm && r.end != unbounded {
+				r.end = hwm
+			}
+
+			if r.end == unbounded {
+				logger.Infof("Partition [%s:%d] will be dumped from offset %d up to the first message at or after %s",
+					topic, partition, r.start, r.to)
+			} else {
+				logger.Infof("Partition [%s:%d] will be dumped from offset %d up to offset %d", topic, partition, r.start, r.end)
+			}
+
+			ranges = append(ranges, r)
+		}
+	}
+
+	for tp := range explicit {
+		if !found[tp] {
+			return nil, fmt.Errorf("partition [%s:%d] not found", tp.topic, tp.partition)
+		}
+	}
+
+	return ranges, nil
+}
+
+// offsetForTime returns offset of the first message of partition with timestamp at or after t.
+// It returns unset when t is zero and hwm when there are no such messages.
+func offsetForTime(client sarama.Client, tp topicPartition, t time.Time, unset, hwm int64) (int64, error) {
+	if t.IsZero() {
+		return unset, nil
+	}
+
+	offset, err := client.GetOffset(tp.topic, tp.partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset of [%s:%d] for time %s: %w", tp.topic, tp.partition, t, err)
+	}
+
+	if offset < 0 {
+		return hwm, nil
+	}
+
+	return offset, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unbounded is the end of partition range that is consumed until dumper stops.
+const unbounded = math.MaxInt64
+
+// rangeConsumer dumps resolved ranges of partitions with partition consumers without consumer group.
+// Offsets of dumped messages are passed to mark through committer, they are not tracked when mark is nil.
+type rangeConsumer struct {
+	sink      *sink
+	committer *committer
+	mark      marker
+	failure   *failure
+	progress  *progress
+	metrics   *dumperMetrics
+	health    *health
+	log       Logger
+	msgCount  uint64
+	wg        sync.WaitGroup
+}
+
+// start starts consumers of all non-empty ranges. It should not be called after wait.
+func (rc *rangeConsumer) start(ctx context.Context, consumer sarama.Consumer, ranges []partitionRange) {
+	for _, r := range ranges {
+		if r.start >= r.end {
+			continue
+		}
+
+		pc, err := consumer.ConsumePartition(r.topic, r.partition, r.start)
+		if err != nil {
+			rc.failure.set(&ConnectionError{Err: fmt.Errorf("failed to consume partition [%s:%d]: %w", r.topic, r.partition, err)})
+
+			return
+		}
+
+		rc.health.started(r.topic, r.partition)
+
+		rc.wg.Add(1)
+
+		go func(r partitionRange, pc sarama.PartitionConsumer) {
+			defer rc.wg.Done()
+
+			if err := rc.consumePartition(ctx, r, pc); err != nil {
+				rc.failure.set(err)
+			}
+		}(r, pc)
+	}
+}
+
+// wait waits until all started consumers exit.
+func (rc *rangeConsumer) wait() {
+	rc.wg.Wait()
+}
+
+// consumePartition dumps messages of one partition until its end or until ctx is canceled.
+func (rc *rangeConsumer) consumePartition(ctx context.Context, r partitionRange, pc sarama.PartitionConsumer) error {
+	defer func() {
+		if err := pc.Close(); err != nil {
+			rc.log.Errorf("Failed to close consumer of partition [%s:%d]: %v", r.topic, r.partition, err)
+		}
+	}()
+
+	rc.log.Infof("Partition [%s:%d] consumed from offset %d", r.topic, r.partition, r.start)
+
+	received := time.Now()
+
+	ticker := time.NewTicker(drainInterval())
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				break loop
+			}
+
+			received = time.Now()
+
+			if rc.progress.beyond(msg) {
+				break loop
+			}
+
+			total := atomic.AddUint64(&rc.msgCount, 1)
+
+			rc.log.Debugf("received message from topic [%s]:[part[%d];offset[%d];key[%s]]",
+				msg.Topic, msg.Partition, msg.Offset, msg.Key)
+			rc.log.Debugf("Total amount of received messages: %d", total)
+
+			size, dumped, err := rc.sink.consume(msg)
+			if err != nil {
+				return err
+			}
+
+			rc.metrics.consumed(msg, pc.HighWaterMarkOffset())
+			rc.health.consumed(msg, pc.HighWaterMarkOffset())
+
+			reached := rc.progress.add(msg, size, dumped)
+
+			if rc.mark != nil {
+				if err = rc.committer.Done(msg, rc.mark); err != nil {
+					return err
+				}
+			}
+
+			if reached {
+				break loop
+			}
+
+		case <-ticker.C:
+			if rc.progress.drained(r.topicPartition, pc.HighWaterMarkOffset(), len(pc.Messages()), time.Since(received)) {
+				break loop
+			}
+
+		case consumerError, ok := <-pc.Errors():
+			if ok {
+				rc.log.Errorf("Received consumerError: %v ", consumerError)
+			}
+
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	rc.log.Infof("Partition [%s:%d] released", r.topic, r.partition)
+
+	rc.metrics.released(r.topic, r.partition)
+	rc.health.released(r.topic, r.partition)
+
+	if err := rc.committer.CommitPartition(r.topic, r.partition); err != nil {
+		return err
+	}
+
+	if err := rc.sink.ReleasePartition(r.topic, r.partition); err != nil {
+		return &WriteError{Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,474 @@
+package dumper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+var (
+	rangeFrom = time.Date(2021, time.February, 3, 9, 0, 0, 0, time.UTC)
+	rangeTo   = time.Date(2021, time.February, 3, 11, 0, 0, 0, time.UTC)
+	// rangeLate is a time after all messages of partitions.
+	rangeLate = time.Date(2021, time.February, 4, 0, 0, 0, 0, time.UTC)
+)
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// rangeBroker returns broker with topic "orders" of two partitions:
+// partition 0 has offsets [2, 10), offset 4 is the first at or after rangeFrom and 7 at or after rangeTo,
+// partition 1 is empty. Responses to fetch requests are set by fetch when it is not nil.
+func rangeBroker(t *testing.T, fetch *sarama.MockFetchResponse) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader("orders", 0, b.BrokerID()).
+			SetLeader("orders", 1, b.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetOldest, 2).
+			SetOffset("orders", 0, sarama.OffsetNewest, 10).
+			SetOffset("orders", 0, millis(rangeFrom), 4).
+			SetOffset("orders", 0, millis(rangeTo), 7).
+			SetOffset("orders", 0, millis(rangeLate), -1).
+			SetOffset("orders", 1, sarama.OffsetOldest, 0).
+			SetOffset("orders", 1, sarama.OffsetNewest, 0).
+			SetOffset("orders", 1, millis(rangeFrom), -1).
+			SetOffset("orders", 1, millis(rangeTo), -1).
+			SetOffset("orders", 1, millis(rangeLate), -1),
+	}
+
+	if fetch != nil {
+		handlers["FetchRequest"] = fetch
+	}
+
+	b.SetHandlerByMap(handlers)
+
+	return b
+}
+
+func rangeClient(t *testing.T, b *sarama.MockBroker) sarama.Client {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_10_1_0
+
+	client, err := sarama.NewClient([]string{b.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestParsePartitionOffsets(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    PartitionOffsets
+		wantErr string
+	}{
+		{s: "orders:0:1500-2000", want: PartitionOffsets{Topic: "orders", Partition: 0, Start: 1500, End: 2000}},
+		{s: "orders:3:1200-", want: PartitionOffsets{Topic: "orders", Partition: 3, Start: 1200, End: -1}},
+		{s: "orders:3:1200", want: PartitionOffsets{Topic: "orders", Partition: 3, Start: 1200, End: -1}},
+		{s: "my.topic-1:2:0-0", want: PartitionOffsets{Topic: "my.topic-1", Partition: 2, Start: 0, End: 0}},
+		{s: "orders:0", wantErr: "topic:partition:start-end format"},
+		{s: ":0:1-2", wantErr: "topic:partition:start-end format"},
+		{s: "a:b:0:1-2", wantErr: "topic:partition:start-end format"},
+		{s: "orders:x:1-2", wantErr: "invalid partition"},
+		{s: "orders:4294967296:1-2", wantErr: "invalid partition"},
+		{s: "orders:0:-2", wantErr: "invalid start offset"},
+		{s: "orders:0:x-2", wantErr: "invalid start offset"},
+		{s: "orders:0:1-x", wantErr: "invalid end offset"},
+		{s: "orders:0:1--2", wantErr: "invalid end offset"},
+	}
+
+	for _, tc := range tests {
+		got, err := ParsePartitionOffsets(tc.s)
+
+		switch {
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("ParsePartitionOffsets(%q) error = %v, want %q", tc.s, err, tc.wantErr)
+		case tc.wantErr == "" && (err != nil || got != tc.want):
+			t.Errorf("ParsePartitionOffsets(%q) = %+v, %v, want %+v", tc.s, got, err, tc.want)
+		}
+	}
+}
+
+func TestOffsetForTime(t *testing.T) {
+	b := rangeBroker(t, nil)
+	defer b.Close()
+
+	client := rangeClient(t, b)
+
+	defer func() {
+		_ = client.Close()
+	}()
+
+	tp := topicPartition{topic: "orders"}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want int64
+	}{
+		{name: "zero time", want: -5},
+		{name: "from", t: rangeFrom, want: 4},
+		{name: "to", t: rangeTo, want: 7},
+		{name: "after all messages", t: rangeLate, want: 10},
+	}
+
+	for _, tc := range tests {
+		got, err := offsetForTime(client, tp, tc.t, -5, 10)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: offsetForTime = %d, %v, want %d", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestResolveRanges(t *testing.T) {
+	b := rangeBroker(t, nil)
+	defer b.Close()
+
+	client := rangeClient(t, b)
+
+	defer func() {
+		_ = client.Close()
+	}()
+
+	orders := func(partition int32, start, end int64) partitionRange {
+		return partitionRange{topicPartition: topicPartition{topic: "orders", partition: partition}, start: start, end: end}
+	}
+
+	until := func(to time.Time, ranges ...partitionRange) []partitionRange {
+		for i := range ranges {
+			ranges[i].to = to
+		}
+
+		return ranges
+	}
+
+	tests := []struct {
+		name       string
+		partitions []int32
+		opts       RangeOptions
+		whole      bool
+		want       []partitionRange
+		wantErr    string
+	}{
+		{
+			name: "time range",
+			opts: RangeOptions{From: rangeFrom, To: rangeTo},
+			want: until(rangeTo, orders(0, 4, 7), orders(1, 0, 0)),
+		},
+		{
+			name: "from only",
+			opts: RangeOptions{From: rangeFrom},
+			want: []partitionRange{orders(0, 4, 10), orders(1, 0, 0)},
+		},
+		{
+			name: "to after all messages",
+			opts: RangeOptions{To: rangeLate},
+			want: until(rangeLate, orders(0, 2, 10), orders(1, 0, 0)),
+		},
+		{
+			name:       "selected partitions",
+			partitions: []int32{0},
+			opts:       RangeOptions{From: rangeFrom, To: rangeTo},
+			want:       until(rangeTo, orders(0, 4, 7)),
+		},
+		{
+			name: "explicit offsets override time",
+			opts: RangeOptions{From: rangeFrom, To: rangeTo, Offsets: []PartitionOffsets{
+				{Topic: "orders", Partition: 0, Start: 5, End: -1},
+			}},
+			want: append([]partitionRange{orders(0, 5, 10)}, until(rangeTo, orders(1, 0, 0))...),
+		},
+		{
+			name: "explicit offsets only",
+			opts: RangeOptions{Offsets: []PartitionOffsets{{Topic: "orders", Partition: 0, Start: 3, End: 6}}},
+			want: []partitionRange{orders(0, 3, 6)},
+		},
+		{
+			name: "explicit offsets out of available",
+			opts: RangeOptions{Offsets: []PartitionOffsets{{Topic: "orders", Partition: 0, Start: 0, End: 100}}},
+			want: []partitionRange{orders(0, 2, 10)},
+		},
+		{
+			name:  "whole partitions",
+			whole: true,
+			want:  []partitionRange{orders(0, 2, 10), orders(1, 0, 0)},
+		},
+		{
+			name:    "unknown partition",
+			opts:    RangeOptions{Offsets: []PartitionOffsets{{Topic: "orders", Partition: 5, Start: 0, End: -1}}},
+			wantErr: "partition [orders:5] not found",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveRanges(client, []string{"orders"}, tc.partitions, tc.opts, tc.whole, discardLogger())
+
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !reflect.DeepEqual(got, tc.want):
+				t.Errorf("ranges = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRangeEnds checks that range mode dumps messages up to the offset looked up for To or up to high watermark,
+// and finishes partition which last offsets before its end are not data records.
+func TestRangeEnds(t *testing.T) {
+	defer func(idle time.Duration) {
+		drainIdle = idle
+	}(drainIdle)
+
+	drainIdle = 200 * time.Millisecond
+
+	tests := []struct {
+		name string
+		opts RangeOptions
+		// markers are offsets of transaction markers that are not returned to consumer.
+		markers []int64
+		want    []int64
+	}{
+		{name: "to", opts: RangeOptions{From: rangeFrom, To: rangeTo}, want: []int64{4, 5, 6}},
+		{name: "marker before to", opts: RangeOptions{From: rangeFrom, To: rangeTo}, markers: []int64{6}, want: []int64{4, 5}},
+		{name: "high watermark", opts: RangeOptions{From: rangeFrom}, want: []int64{4, 5, 6, 7, 8, 9}},
+		{
+			name:    "markers before high watermark",
+			opts:    RangeOptions{Offsets: []PartitionOffsets{{Topic: "orders", Partition: 0, Start: 4, End: -1}}},
+			markers: []int64{8, 9},
+			want:    []int64{4, 5, 6, 7},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dumpRange(t, tc.opts, tc.markers...); !equalOffsets(got, tc.want) {
+				t.Errorf("dumped offsets = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// dumpRange dumps range of partition 0 of rangeBroker, all its offsets but markers are data records,
+// and returns dumped offsets.
+func dumpRange(t *testing.T, opts RangeOptions, markers ...int64) []int64 {
+	fetch := sarama.NewMockFetchResponse(t, 1).SetVersion(3).SetHighWaterMark("orders", 0, 10)
+
+	for offset := int64(2); offset < 10; offset++ {
+		if !containsOffset(markers, offset) {
+			fetch.SetMessage("orders", 0, offset, sarama.StringEncoder("v"))
+		}
+	}
+
+	b := rangeBroker(t, fetch)
+	defer b.Close()
+
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}")
+
+	d, err := New(Options{
+		Brokers:  []string{b.Addr()},
+		ClientID: "test",
+		Version:  sarama.V0_10_2_0,
+		Topics:   []string{"orders"},
+		Layout:   settings.layout,
+		Encoder:  settings.encoder,
+		Range:    opts,
+		Logger:   discardLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = d.Run(ctx); err != nil {
+		t.Fatalf("Run() = %v, want range dumped", err)
+	}
+
+	return readOffsets(t, filepath.Join(dir, "orders", "0.jsonl"))
+}
+
+func containsOffset(offsets []int64, offset int64) bool {
+	for _, o := range offsets {
+		if o == offset {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestOverwrite(t *testing.T) {
+	fetch := sarama.NewMockFetchResponse(t, 1).SetVersion(3).SetHighWaterMark("orders", 0, 10)
+
+	for offset := int64(2); offset < 10; offset++ {
+		fetch.SetMessage("orders", 0, offset, sarama.StringEncoder("v"))
+	}
+
+	b := rangeBroker(t, fetch)
+	defer b.Close()
+
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}")
+	stale := filepath.Join(dir, "payments", "0.jsonl")
+
+	if err := os.MkdirAll(filepath.Dir(stale), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(stale, []byte("{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{
+		ClientID:  "test",
+		Version:   sarama.V0_10_2_0,
+		Topics:    []string{"orders"},
+		Layout:    settings.layout,
+		Encoder:   settings.encoder,
+		Range:     RangeOptions{From: rangeFrom, To: rangeTo},
+		Overwrite: true,
+		Logger:    discardLogger(),
+	}
+
+	if _, err := New(opts); err == nil {
+		t.Fatal("New() without brokers error = nil")
+	}
+
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("file of previous run is removed by invalid options: %v", err)
+	}
+
+	opts.Brokers = []string{b.Addr()}
+
+	d, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = d.Run(ctx); err != nil {
+		t.Fatalf("Run() = %v, want range dumped", err)
+	}
+
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("file of previous run is not removed: %v", err)
+	}
+
+	if got, want := readOffsets(t, filepath.Join(dir, "orders", "0.jsonl")), []int64{4, 5, 6}; !equalOffsets(got, want) {
+		t.Errorf("dumped offsets = %v, want %v", got, want)
+	}
+
+	for _, outputDir := range []string{".", "/", ""} {
+		opts.Layout.OutputDir = outputDir
+
+		if _, err = New(opts); err == nil {
+			t.Errorf("New() with Overwrite of %q error = nil", outputDir)
+		}
+	}
+}
+
+// TestFutureTo checks that range with To in the future is consumed past high watermark at start
+// up to the first message with timestamp at or after To, or until To passes.
+func TestFutureTo(t *testing.T) {
+	defer func(idle time.Duration) {
+		drainIdle = idle
+	}(drainIdle)
+
+	drainIdle = 200 * time.Millisecond
+
+	t.Run("message at to", func(t *testing.T) {
+		to := time.Now().Add(time.Hour)
+
+		if got, want := dumpUntil(t, to, to.Add(time.Second)), []int64{2, 3, 4, 5, 6, 7, 8, 9, 10, 11}; !equalOffsets(got, want) {
+			t.Errorf("dumped offsets = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("to passes", func(t *testing.T) {
+		to := time.Now().Add(500 * time.Millisecond)
+
+		if got, want := dumpUntil(t, to, rangeFrom), []int64{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}; !equalOffsets(got, want) {
+			t.Errorf("dumped offsets = %v, want %v", got, want)
+		}
+	})
+}
+
+// dumpUntil dumps partition with high watermark 10 at start and messages at offsets [2, 13) until to,
+// message at offset 12 has timestamp last, and returns dumped offsets.
+func dumpUntil(t *testing.T, to, last time.Time) []int64 {
+	b := sarama.NewMockBroker(t, 1)
+	defer b.Close()
+
+	fetch := &sarama.FetchResponse{Version: 3}
+
+	for offset := int64(2); offset < 12; offset++ {
+		fetch.AddMessageWithTimestamp("orders", 0, nil, sarama.StringEncoder("v"), offset, rangeFrom, 1)
+	}
+
+	fetch.AddMessageWithTimestamp("orders", 0, nil, sarama.StringEncoder("v"), 12, last, 1)
+	fetch.GetBlock("orders", 0).HighWaterMarkOffset = 13
+
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader("orders", 0, b.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetOldest, 2).
+			SetOffset("orders", 0, sarama.OffsetNewest, 10).
+			SetOffset("orders", 0, millis(to), -1),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.JSONL, "{topic}/{partition}{ext}")
+
+	d, err := New(Options{
+		Brokers:  []string{b.Addr()},
+		ClientID: "test",
+		Version:  sarama.V0_10_2_0,
+		Topics:   []string{"orders"},
+		Layout:   settings.layout,
+		Encoder:  settings.encoder,
+		Range:    RangeOptions{To: to},
+		Logger:   discardLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = d.Run(ctx); err != nil {
+		t.Fatalf("Run() = %v, want range dumped", err)
+	}
+
+	return readOffsets(t, filepath.Join(dir, "orders", "0.jsonl"))
+}
@@ -0,0 +1,54 @@
+package dumper
+
+import (
+	"time"
+)
+
+// RotationPolicy limits size of dump segments, new segment is started when any limit is reached.
+// Zero limit is disabled.
+type RotationPolicy struct {
+	// MaxBytes is a maximum size of segment in bytes, sizes of compressed segments are sizes of uncompressed records.
+	MaxBytes int64
+	// MaxRecords is a maximum number of records in segment.
+	MaxRecords int64
+	// MaxAge is a maximum difference between times of the first and the last messages of segment.
+	// Message times are taken from configured timestamp source, so rotation does not depend on
+	// time when dump was made and repeated dump of the same offsets produces the same segments.
+	MaxAge time.Duration
+}
+
+// Enabled reports whether any limit is set.
+func (r RotationPolicy) Enabled() bool {
+	return r.MaxBytes > 0 || r.MaxRecords > 0 || r.MaxAge > 0
+}
+
+// segmentState is a state of currently written segment.
+type segmentState struct {
+	owner       topicPartition
+	path        string
+	indexed     bool
+	firstOffset int64
+	firstTime   time.Time
+	bytes       int64
+	records     int64
+	lastWrite   time.Time
+}
+
+// full reports whether record could not be added to segment without exceeding limits.
+// Segment always gets at least one record, even when it is larger than MaxBytes.
+func (r RotationPolicy) full(seg *segmentState, recordSize int, msgTime time.Time) bool {
+	if seg.records == 0 {
+		return false
+	}
+
+	switch {
+	case r.MaxBytes > 0 && seg.bytes+int64(recordSize) > r.MaxBytes:
+		return true
+	case r.MaxRecords > 0 && seg.records >= r.MaxRecords:
+		return true
+	case r.MaxAge > 0 && msgTime.Sub(seg.firstTime) >= r.MaxAge:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,599 @@
+package dumper
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+// SnapshotOptions configures snapshot mode. Snapshot of compacted topic is its state: the latest value of each key.
+// Partitions are consumed without consumer group up to high watermarks at start or up to At, and each topic
+// is written to one file sorted by key, keys with tombstones as latest messages are removed.
+type SnapshotOptions struct {
+	Enabled bool
+	// At stops partitions at the first offset with timestamp at or after this time, high watermarks when zero.
+	At time.Time
+	// MemoryBytes is a size of latest records kept in memory, they are spilled to sorted run files when it is exceeded.
+	MemoryBytes int64
+	// SpillDir is a directory of run files, Layout.OutputDir when empty.
+	SpillDir string
+}
+
+const (
+	defaultSnapshotMemory = 64 << 20
+	// snapshotEntryOverhead is an approximate size of snapshot entry in memory besides key and record.
+	snapshotEntryOverhead = 96
+	// snapshotExtension is inserted before extension of format in names of snapshot files.
+	snapshotExtension = ".snapshot"
+)
+
+// snapshotEntry is the latest record of key.
+type snapshotEntry struct {
+	key       []byte
+	partition int32
+	offset    int64
+	timestamp int64
+	// removed entry is a tombstone or message that is filtered out, it has no record.
+	removed bool
+	record  []byte
+}
+
+// supersedes reports whether e is a later message of key than other. Messages of the same partition are ordered
+// by offset, messages of key in different partitions (e.g. after partitions were added) by timestamp.
+func (e *snapshotEntry) supersedes(other *snapshotEntry) bool {
+	if e.partition == other.partition {
+		return e.offset > other.offset
+	}
+
+	if e.timestamp != other.timestamp {
+		return e.timestamp > other.timestamp
+	}
+
+	return e.partition > other.partition
+}
+
+func (e *snapshotEntry) size() int64 {
+	return int64(2*len(e.key)+len(e.record)) + snapshotEntryOverhead
+}
+
+// topicSnapshot holds the latest entries of topic that are not spilled yet and sorted run files of spilled ones.
+type topicSnapshot struct {
+	entries map[string]*snapshotEntry
+	runs    []string
+	// keyless is a number of skipped messages without key.
+	keyless int64
+}
+
+// snapshotter collects the latest records of keys of topics, it is safe for concurrent use.
+type snapshotter struct {
+	mu     sync.Mutex
+	opts   SnapshotOptions
+	dir    string
+	topics map[string]*topicSnapshot
+	size   int64
+	log    Logger
+}
+
+func newSnapshotter(opts SnapshotOptions, outputDir string, topics []string, logger Logger) *snapshotter {
+	if opts.MemoryBytes <= 0 {
+		opts.MemoryBytes = defaultSnapshotMemory
+	}
+
+	if opts.SpillDir == "" {
+		opts.SpillDir = outputDir
+	}
+
+	s := &snapshotter{opts: opts, topics: make(map[string]*topicSnapshot), log: loggerOrDefault(logger)}
+
+	for _, topic := range topics {
+		s.topic(topic)
+	}
+
+	return s
+}
+
+func (s *snapshotter) topic(name string) *topicSnapshot {
+	t, ok := s.topics[name]
+	if !ok {
+		t = &topicSnapshot{entries: make(map[string]*snapshotEntry)}
+		s.topics[name] = t
+	}
+
+	return t
+}
+
+// add keeps record of message as the latest one of its key, tombstone removes key.
+func (s *snapshotter) add(msg *sarama.ConsumerMessage, record []byte) error {
+	return s.put(msg, msg.Value == nil, record)
+}
+
+// remove removes key of message that is filtered out, so older values of key do not stay in snapshot.
+func (s *snapshotter) remove(msg *sarama.ConsumerMessage) error {
+	return s.put(msg, true, nil)
+}
+
+func (s *snapshotter) put(msg *sarama.ConsumerMessage, removed bool, record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.topic(msg.Topic)
+
+	if msg.Key == nil {
+		t.keyless++
+
+		return nil
+	}
+
+	e := &snapshotEntry{
+		key:       msg.Key,
+		partition: msg.Partition,
+		offset:    msg.Offset,
+		removed:   removed,
+	}
+
+	if !msg.Timestamp.IsZero() {
+		e.timestamp = msg.Timestamp.UnixNano()
+	}
+
+	if !removed {
+		e.record = record
+	}
+
+	if prev, ok := t.entries[string(e.key)]; ok {
+		if prev.supersedes(e) {
+			return nil
+		}
+
+		s.size -= prev.size()
+	}
+
+	t.entries[string(e.key)] = e
+	s.size += e.size()
+
+	if s.size > s.opts.MemoryBytes {
+		return s.spill()
+	}
+
+	return nil
+}
+
+// spill writes entries of all topics to sorted run files and frees memory.
+func (s *snapshotter) spill() error {
+	if s.dir == "" {
+		if err := os.MkdirAll(s.opts.SpillDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create spill directory: %w", err)
+		}
+
+		dir, err := ioutil.TempDir(s.opts.SpillDir, ".snapshot-spill-")
+		if err != nil {
+			return fmt.Errorf("failed to create spill directory: %w", err)
+		}
+
+		s.dir = dir
+	}
+
+	for name, t := range s.topics {
+		if len(t.entries) == 0 {
+			continue
+		}
+
+		path, err := writeRun(s.dir, sortedEntries(t.entries))
+		if err != nil {
+			return fmt.Errorf("failed to spill snapshot of topic [%s]: %w", name, err)
+		}
+
+		s.log.Debugf("Spilled %d keys of topic [%s] snapshot to [%s]", len(t.entries), name, path)
+
+		t.runs = append(t.runs, path)
+		t.entries = make(map[string]*snapshotEntry)
+	}
+
+	s.size = 0
+
+	return nil
+}
+
+func sortedEntries(entries map[string]*snapshotEntry) []*snapshotEntry {
+	sorted := make([]*snapshotEntry, 0, len(entries))
+
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].key, sorted[j].key) < 0
+	})
+
+	return sorted
+}
+
+// writeRun writes entries sorted by key to new run file in dir and returns its path. Each entry is written as
+// key, partition, offset, timestamp, removed flag and record, all numbers and lengths of bytes are varints.
+func writeRun(dir string, entries []*snapshotEntry) (string, error) {
+	f, err := ioutil.TempFile(dir, "run-")
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(f)
+
+	var buf []byte
+
+	for _, e := range entries {
+		removed := int64(0)
+		if e.removed {
+			removed = 1
+		}
+
+		buf = appendRunBytes(buf[:0], e.key)
+		buf = appendRunVarint(buf, int64(e.partition))
+		buf = appendRunVarint(buf, e.offset)
+		buf = appendRunVarint(buf, e.timestamp)
+		buf = appendRunVarint(buf, removed)
+		buf = appendRunBytes(buf, e.record)
+
+		if _, err = w.Write(buf); err != nil {
+			_ = f.Close()
+
+			return "", err
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		_ = f.Close()
+
+		return "", err
+	}
+
+	return f.Name(), f.Close()
+}
+
+func appendRunVarint(dst []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	return append(dst, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendRunBytes(dst, b []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	dst = append(dst, buf[:binary.PutUvarint(buf[:], uint64(len(b)))]...)
+
+	return append(dst, b...)
+}
+
+// runReader reads entries of run file in order.
+type runReader struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+func openRun(path string) (*runReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runReader{f: f, r: bufio.NewReader(f)}, nil
+}
+
+// next returns next entry, io.EOF at the end of run.
+func (rr *runReader) next() (*snapshotEntry, error) {
+	key, err := rr.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &snapshotEntry{key: key}
+
+	var nums [4]int64
+
+	for i := range nums {
+		if nums[i], err = binary.ReadVarint(rr.r); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+
+	e.partition, e.offset, e.timestamp, e.removed = int32(nums[0]), nums[1], nums[2], nums[3] == 1
+
+	if e.record, err = rr.bytes(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return e, nil
+}
+
+func (rr *runReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+
+	if _, err = io.ReadFull(rr.r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return b, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// entrySource is a sorted source of entries: run file or entries kept in memory.
+type entrySource struct {
+	head *snapshotEntry
+	next func() (*snapshotEntry, error)
+}
+
+// entryHeap orders sources by key of their head entries.
+type entryHeap []*entrySource
+
+func (h entryHeap) Len() int            { return len(h) }
+func (h entryHeap) Less(i, j int) bool  { return bytes.Compare(h[i].head.key, h[j].head.key) < 0 }
+func (h entryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x interface{}) { *h = append(*h, x.(*entrySource)) }
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return src
+}
+
+// merge calls fn with the latest entry of each key in order of keys, entries of all sources are merged.
+func merge(sources []*entrySource, fn func(e *snapshotEntry) error) error {
+	h := make(entryHeap, 0, len(sources))
+
+	for _, src := range sources {
+		if err := advance(src); err != nil {
+			return err
+		}
+
+		if src.head != nil {
+			h = append(h, src)
+		}
+	}
+
+	heap.Init(&h)
+
+	for h.Len() != 0 {
+		latest := h[0].head
+
+		for h.Len() != 0 && bytes.Equal(h[0].head.key, latest.key) {
+			src := h[0]
+
+			if src.head.supersedes(latest) {
+				latest = src.head
+			}
+
+			if err := advance(src); err != nil {
+				return err
+			}
+
+			if src.head == nil {
+				heap.Pop(&h)
+			} else {
+				heap.Fix(&h, 0)
+			}
+		}
+
+		if err := fn(latest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func advance(src *entrySource) error {
+	e, err := src.next()
+
+	switch {
+	case errors.Is(err, io.EOF):
+		src.head = nil
+	case err != nil:
+		return fmt.Errorf("failed to read spilled snapshot: %w", err)
+	default:
+		src.head = e
+	}
+
+	return nil
+}
+
+// write writes snapshot files of all topics with their settings and removes run files.
+func (s *snapshotter) write(settings func(topic string) topicSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer s.cleanup()
+
+	names := make([]string, 0, len(s.topics))
+
+	for name := range s.topics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := s.writeTopic(name, s.topics[name], settings(name)); err != nil {
+			return fmt.Errorf("failed to write snapshot of topic [%s]: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// IsSnapshotFile reports whether path is a snapshot file written in snapshot mode.
+func IsSnapshotFile(path string) bool {
+	_, path = format.CompressionOf(path)
+
+	return strings.HasSuffix(strings.TrimSuffix(path, filepath.Ext(path)), snapshotExtension)
+}
+
+// snapshotPath returns path of topic snapshot file in output directory,
+// snapshotExtension marks it as snapshot file that is skipped by restore.
+func snapshotPath(topic string, settings topicSettings) string {
+	return filepath.Join(settings.layout.OutputDir, sanitizePathComponent(topic)+snapshotExtension+settings.extension())
+}
+
+func (s *snapshotter) writeTopic(name string, t *topicSnapshot, settings topicSettings) error {
+	var (
+		sources []*entrySource
+		readers []*runReader
+	)
+
+	defer func() {
+		for _, rr := range readers {
+			_ = rr.f.Close()
+		}
+	}()
+
+	for _, path := range t.runs {
+		rr, err := openRun(path)
+		if err != nil {
+			return fmt.Errorf("failed to open spilled snapshot: %w", err)
+		}
+
+		readers = append(readers, rr)
+		sources = append(sources, &entrySource{next: rr.next})
+	}
+
+	inMemory := sortedEntries(t.entries)
+
+	sources = append(sources, &entrySource{next: func() (*snapshotEntry, error) {
+		if len(inMemory) == 0 {
+			return nil, io.EOF
+		}
+
+		e := inMemory[0]
+		inMemory = inMemory[1:]
+
+		return e, nil
+	}})
+
+	path := snapshotPath(name, settings)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	var keys, removed, size int64
+
+	err := writeAtomically(path, settings.compression, func(w io.Writer) error {
+		return merge(sources, func(e *snapshotEntry) error {
+			if e.removed {
+				removed++
+
+				return nil
+			}
+
+			keys++
+			size += int64(len(e.record))
+
+			_, err := w.Write(e.record)
+
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.log.Infof("Snapshot of topic [%s] is written to [%s]: %d keys (%d bytes), %d keys removed",
+		name, path, keys, size, removed)
+
+	if t.keyless != 0 {
+		s.log.Warnf("%d messages of topic [%s] without key are not included in snapshot", t.keyless, name)
+	}
+
+	return nil
+}
+
+// writeAtomically writes file with compression by fn to temporary file and renames it to path after fsync.
+func writeAtomically(path string, c format.Compression, fn func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	var w bufferedWriter = bufio.NewWriterSize(f, defaultBufferSize)
+
+	if c.Enabled() {
+		if w, err = format.NewCompressedWriter(f, c, defaultBufferSize); err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+
+			return err
+		}
+	}
+
+	err = fn(w)
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+
+	return err
+}
+
+// cleanup removes run files.
+func (s *snapshotter) cleanup() {
+	if s.dir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(s.dir); err != nil {
+		s.log.Errorf("Failed to remove spill directory [%s]: %v", s.dir, err)
+	}
+
+	s.dir = ""
+}
+
+// discard removes run files of incomplete snapshot.
+func (s *snapshotter) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanup()
+}
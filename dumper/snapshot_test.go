@@ -0,0 +1,174 @@
+package dumper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+func TestSnapshotPath(t *testing.T) {
+	settings := testSettings(t, "out", format.JSONL, "{topic}{ext}")
+
+	for topic, want := range map[string]string{
+		"orders":     "out/orders.snapshot.jsonl",
+		"my.topic-1": "out/my.topic-1.snapshot.jsonl",
+		"../etc":     "out/.._etc.snapshot.jsonl",
+		"..":         "out/__.snapshot.jsonl",
+		"a/b":        "out/a_b.snapshot.jsonl",
+	} {
+		path := snapshotPath(topic, settings)
+		if path != filepath.FromSlash(want) {
+			t.Errorf("snapshotPath(%q) = %q, want %q", topic, path, want)
+		}
+
+		if !IsSnapshotFile(path) {
+			t.Errorf("%s is not reported as snapshot file", path)
+		}
+	}
+}
+
+// TestSnapshotSpill checks that snapshot merges spilled runs with entries in memory, keeps the latest record
+// of each key and removes keys with tombstones.
+func TestSnapshotSpill(t *testing.T) {
+	dir := t.TempDir()
+	settings := testSettings(t, dir, format.JSONL, "{topic}{ext}")
+
+	s := newSnapshotter(SnapshotOptions{Enabled: true, MemoryBytes: 512}, dir, []string{"users", "empty"}, discardLogger())
+
+	want := make(map[string]int64)
+
+	for offset := int64(0); offset < 200; offset++ {
+		key := fmt.Sprintf("k%02d", offset%30)
+		msg := testMessage("users", int32(offset%2), offset)
+		msg.Key = []byte(key)
+
+		var err error
+
+		if offset%7 == 0 {
+			msg.Value = nil
+			err = s.add(msg, nil)
+
+			delete(want, key)
+		} else {
+			var record []byte
+
+			if record, err = settings.encoder.Encode(msg); err == nil {
+				err = s.add(msg, record)
+			}
+
+			want[key] = offset
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.dir == "" {
+		t.Fatal("nothing is spilled")
+	}
+
+	spillDir := s.dir
+
+	if err := s.write(func(string) topicSettings { return settings }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(spillDir); !os.IsNotExist(err) {
+		t.Errorf("spill directory is not removed: %v", err)
+	}
+
+	f, err := format.OpenFile(snapshotPath("users", settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r, err := format.NewReader(format.JSONL, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last string
+
+	for {
+		msg, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := string(msg.Key)
+
+		if key <= last {
+			t.Errorf("key %s follows %s", key, last)
+		}
+
+		if offset, ok := want[key]; !ok || msg.Offset != offset {
+			t.Errorf("key %s has offset %d, want %d (present: %v)", key, msg.Offset, offset, ok)
+		}
+
+		delete(want, key)
+
+		last = key
+	}
+
+	if len(want) != 0 {
+		t.Errorf("keys %v are missing in snapshot", want)
+	}
+
+	if b, err := ioutil.ReadFile(snapshotPath("empty", settings)); err != nil || len(b) != 0 {
+		t.Errorf("snapshot of empty topic has %q, %v", b, err)
+	}
+}
+
+func TestWriteAtomically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+
+	if err := ioutil.WriteFile(path, []byte("previous"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+
+	err := writeAtomically(path, format.NoCompression, func(w io.Writer) error {
+		_, _ = w.Write([]byte("partial"))
+
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("writeAtomically() = %v, want %v", err, failure)
+	}
+
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "previous" {
+		t.Errorf("file after failed write has %q, %v", b, err)
+	}
+
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file is not removed: %v", err)
+	}
+
+	if err = writeAtomically(path, format.NoCompression, func(w io.Writer) error {
+		_, err := w.Write([]byte("current"))
+
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "current" {
+		t.Errorf("file after write has %q, %v", b, err)
+	}
+}
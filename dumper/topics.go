@@ -0,0 +1,195 @@
+package dumper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// defaultTopicsRefresh is how often topics are discovered when TopicsRefresh is not set.
+const defaultTopicsRefresh = time.Minute
+
+// internalTopicPrefix is a prefix of Kafka internal topics like __consumer_offsets.
+const internalTopicPrefix = "__"
+
+// topicMatcher selects topics to consume: listed topics and topics matched by include and not matched by exclude.
+type topicMatcher struct {
+	topics   []string
+	include  *regexp.Regexp
+	exclude  *regexp.Regexp
+	internal bool
+}
+
+// dynamic reports whether topics should be discovered by names of cluster topics.
+func (m topicMatcher) dynamic() bool {
+	return m.include != nil
+}
+
+// match returns sorted listed topics and topics from all that match patterns.
+func (m topicMatcher) match(all []string) []string {
+	selected := make(map[string]bool, len(m.topics))
+
+	for _, t := range m.topics {
+		selected[t] = true
+	}
+
+	if m.include != nil {
+		for _, t := range all {
+			switch {
+			case !m.internal && strings.HasPrefix(t, internalTopicPrefix):
+			case !m.include.MatchString(t):
+			case m.exclude != nil && m.exclude.MatchString(t):
+			default:
+				selected[t] = true
+			}
+		}
+	}
+
+	topics := make([]string, 0, len(selected))
+
+	for t := range selected {
+		topics = append(topics, t)
+	}
+
+	sort.Strings(topics)
+
+	return topics
+}
+
+// resolve refreshes metadata of all cluster topics when topics are discovered and returns topics to consume.
+func (m topicMatcher) resolve(client sarama.Client) ([]string, error) {
+	if !m.dynamic() {
+		return m.topics, nil
+	}
+
+	if err := client.RefreshMetadata(); err != nil {
+		return nil, fmt.Errorf("failed to refresh topics metadata: %w", err)
+	}
+
+	all, err := client.Topics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get topics: %w", err)
+	}
+
+	return m.match(all), nil
+}
+
+// topicWatcher periodically resolves topics to consume and notifies about changes.
+type topicWatcher struct {
+	client   sarama.Client
+	matcher  topicMatcher
+	interval time.Duration
+	log      Logger
+
+	mu     sync.Mutex
+	topics []string
+	// changed receives a value when topics are changed, it is not blocked by pending notification.
+	changed chan struct{}
+	// added is called with topics that appeared since the previous resolve,
+	// change is dropped and retried on the next resolve when it fails.
+	added func(topics []string) error
+}
+
+func newTopicWatcher(client sarama.Client, matcher topicMatcher, interval time.Duration, topics []string, logger Logger) *topicWatcher {
+	if interval <= 0 {
+		interval = defaultTopicsRefresh
+	}
+
+	return &topicWatcher{
+		client:   client,
+		matcher:  matcher,
+		interval: interval,
+		log:      logger,
+		topics:   topics,
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// current returns the last resolved topics and drops pending change notification.
+func (w *topicWatcher) current() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.changed:
+	default:
+	}
+
+	return w.topics
+}
+
+// run resolves topics each interval until ctx is canceled. Topics are not resolved when they are not discovered.
+func (w *topicWatcher) run(ctx context.Context) {
+	if !w.matcher.dynamic() {
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			topics, err := w.matcher.resolve(w.client)
+			if err != nil {
+				w.log.Errorf("Failed to discover topics: %v", err)
+
+				continue
+			}
+
+			w.update(topics)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *topicWatcher) update(topics []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	added, removed := diffTopics(w.topics, topics)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	if len(added) != 0 && w.added != nil {
+		if err := w.added(added); err != nil {
+			w.log.Errorf("Failed to start consuming of new topics %v: %v", added, err)
+
+			return
+		}
+	}
+
+	w.log.Infof("Topics changed: added %v, removed %v", added, removed)
+
+	w.topics = topics
+
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// diffTopics returns topics of next that are not in prev and topics of prev that are not in next.
+func diffTopics(prev, next []string) (added, removed []string) {
+	for _, t := range next {
+		if !contains(prev, t) {
+			added = append(added, t)
+		}
+	}
+
+	for _, t := range prev {
+		if !contains(next, t) {
+			removed = append(removed, t)
+		}
+	}
+
+	return added, removed
+}
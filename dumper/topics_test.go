@@ -0,0 +1,203 @@
+package dumper
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestTopicMatch(t *testing.T) {
+	all := []string{"orders.v1", "orders.v2", "orders.dlq", "payments", "__consumer_offsets", "__orders.internal"}
+
+	tests := []struct {
+		name    string
+		matcher topicMatcher
+		want    []string
+	}{
+		{name: "listed", matcher: topicMatcher{topics: []string{"payments", "missing"}}, want: []string{"missing", "payments"}},
+		{
+			name:    "include",
+			matcher: topicMatcher{include: regexp.MustCompile(`^orders\.`)},
+			want:    []string{"orders.dlq", "orders.v1", "orders.v2"},
+		},
+		{
+			name:    "include and exclude",
+			matcher: topicMatcher{include: regexp.MustCompile(`^orders\.`), exclude: regexp.MustCompile(`\.dlq$`)},
+			want:    []string{"orders.v1", "orders.v2"},
+		},
+		{
+			name:    "listed and included",
+			matcher: topicMatcher{topics: []string{"payments", "orders.v1"}, include: regexp.MustCompile(`v1$`)},
+			want:    []string{"orders.v1", "payments"},
+		},
+		{
+			name:    "exclude does not drop listed",
+			matcher: topicMatcher{topics: []string{"orders.dlq"}, include: regexp.MustCompile(`^orders`), exclude: regexp.MustCompile(`dlq`)},
+			want:    []string{"orders.dlq", "orders.v1", "orders.v2"},
+		},
+		{
+			name:    "internal skipped",
+			matcher: topicMatcher{include: regexp.MustCompile(`orders`)},
+			want:    []string{"orders.dlq", "orders.v1", "orders.v2"},
+		},
+		{
+			name:    "internal included",
+			matcher: topicMatcher{include: regexp.MustCompile(`orders`), internal: true},
+			want:    []string{"__orders.internal", "orders.dlq", "orders.v1", "orders.v2"},
+		},
+	}
+
+	for _, tc := range tests {
+		if got := tc.matcher.match(all); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: match() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDiffTopics(t *testing.T) {
+	added, removed := diffTopics([]string{"a", "b", "c"}, []string{"b", "c", "d", "e"})
+
+	if !reflect.DeepEqual(added, []string{"d", "e"}) || !reflect.DeepEqual(removed, []string{"a"}) {
+		t.Errorf("diffTopics() = %v, %v, want [d e], [a]", added, removed)
+	}
+
+	if added, removed = diffTopics([]string{"a"}, []string{"a"}); added != nil || removed != nil {
+		t.Errorf("diffTopics() of same topics = %v, %v, want none", added, removed)
+	}
+}
+
+// topicsBroker returns broker with metadata of topics.
+func topicsBroker(t *testing.T, topics ...string) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(b.Addr(), b.BrokerID())
+	for _, topic := range topics {
+		metadata.SetLeader(topic, 0, b.BrokerID())
+	}
+
+	b.SetHandlerByMap(map[string]sarama.MockResponse{"MetadataRequest": metadata})
+
+	return b
+}
+
+func TestTopicResolve(t *testing.T) {
+	b := topicsBroker(t, "orders.v1", "payments", "__consumer_offsets")
+	defer b.Close()
+
+	client := rangeClient(t, b)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	static := topicMatcher{topics: []string{"missing"}}
+	if got, err := static.resolve(client); err != nil || !reflect.DeepEqual(got, []string{"missing"}) {
+		t.Errorf("resolve() of listed topics = %v, %v, want [missing]", got, err)
+	}
+
+	dynamic := topicMatcher{topics: []string{"audit"}, include: regexp.MustCompile(`.`)}
+	if got, err := dynamic.resolve(client); err != nil || !reflect.DeepEqual(got, []string{"audit", "orders.v1", "payments"}) {
+		t.Errorf("resolve() of discovered topics = %v, %v, want [audit orders.v1 payments]", got, err)
+	}
+}
+
+func TestTopicWatcherUpdate(t *testing.T) {
+	w := newTopicWatcher(nil, topicMatcher{}, 0, []string{"a"}, discardLogger())
+
+	if w.interval != defaultTopicsRefresh {
+		t.Errorf("interval = %s, want %s", w.interval, defaultTopicsRefresh)
+	}
+
+	var calls [][]string
+
+	failed := true
+	w.added = func(topics []string) error {
+		calls = append(calls, topics)
+
+		if failed {
+			return errors.New("rebalance failed")
+		}
+
+		return nil
+	}
+
+	// failed start of new topics keeps previous topics, so change is retried.
+	w.update([]string{"a", "b"})
+
+	if got := w.current(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("current() after failed update = %v, want [a]", got)
+	}
+
+	failed = false
+	w.update([]string{"a", "b"})
+
+	select {
+	case <-w.changed:
+		w.changed <- struct{}{}
+	default:
+		t.Error("change is not notified")
+	}
+
+	if got := w.current(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("current() = %v, want [a b]", got)
+	}
+
+	select {
+	case <-w.changed:
+		t.Error("current() did not drop change notification")
+	default:
+	}
+
+	// removed topics do not start consuming.
+	w.update([]string{"b"})
+
+	if want := [][]string{{"b"}, {"b"}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("added() calls = %v, want %v", calls, want)
+	}
+
+	if got := w.current(); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("current() = %v, want [b]", got)
+	}
+}
+
+func TestTopicWatcherRun(t *testing.T) {
+	b := topicsBroker(t, "orders.v1", "orders.v2")
+	defer b.Close()
+
+	client := rangeClient(t, b)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	matcher := topicMatcher{include: regexp.MustCompile(`^orders\.`)}
+	w := newTopicWatcher(client, matcher, 10*time.Millisecond, []string{"orders.v1"}, discardLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-w.changed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("new topic is not discovered")
+	}
+
+	cancel()
+	<-done
+
+	if got := w.current(); !reflect.DeepEqual(got, []string{"orders.v1", "orders.v2"}) {
+		t.Errorf("current() = %v, want [orders.v1 orders.v2]", got)
+	}
+
+	// watcher of listed topics returns at once.
+	newTopicWatcher(client, topicMatcher{topics: []string{"orders.v1"}}, time.Millisecond, nil, discardLogger()).run(context.Background())
+}
@@ -0,0 +1,536 @@
+package dumper
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+// WriterOptions configures pool of open dump files.
+type WriterOptions struct {
+	// MaxOpenFiles is a maximum number of simultaneously open files, least recently used file is closed
+	// when limit is reached.
+	MaxOpenFiles int
+	// BufferSize is a size of write buffer of each file, buffer is flushed to disk when it is full.
+	BufferSize int
+	// FlushInterval is a maximum time that written data could stay in buffer.
+	FlushInterval time.Duration
+	// IdleTimeout is a time without writes after which file is closed.
+	IdleTimeout time.Duration
+	// Sync enables fsync of files before close, so closed files are always durable.
+	Sync bool
+	// Recover is called once per process before the first open of existing non-empty file
+	// to repair it after possible crash, owner is a partition that opens file.
+	Recover func(owner topicPartition, path string) error
+}
+
+const (
+	defaultMaxOpenFiles  = 256
+	defaultBufferSize    = 64 * 1024
+	defaultFlushInterval = time.Second
+	defaultIdleTimeout   = time.Minute
+)
+
+func (o WriterOptions) withDefaults() WriterOptions {
+	if o.MaxOpenFiles <= 0 {
+		o.MaxOpenFiles = defaultMaxOpenFiles
+	}
+
+	if o.BufferSize <= 0 {
+		o.BufferSize = defaultBufferSize
+	}
+
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = defaultFlushInterval
+	}
+
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = defaultIdleTimeout
+	}
+
+	return o
+}
+
+// bufferedWriter is a write buffer of file: bufio.Writer or format.CompressedWriter of compressed file.
+type bufferedWriter interface {
+	io.Writer
+	Flush() error
+	Buffered() int
+}
+
+// fileWriter is an open buffered dump file.
+type fileWriter struct {
+	path string
+	f    *os.File
+	w    bufferedWriter
+	// size is a size of file including buffered data, i.e. position of the next record.
+	// Sizes of compressed files are sizes of decompressed data, -1 means that size of reopened
+	// compressed file is not known yet.
+	size      int64
+	lastWrite time.Time
+	// dirtySince is a time of the first write that is not flushed yet, zero when buffer is empty.
+	dirtySince time.Time
+	// unsynced is true when file has writes that are not fsynced yet.
+	unsynced bool
+	sync     bool
+	elem     *list.Element
+	// owner is a partition that wrote to file last.
+	owner topicPartition
+}
+
+// position returns position of the next record, compressed file is decompressed when its size is not known.
+func (fw *fileWriter) position() (int64, error) {
+	if fw.size >= 0 {
+		return fw.size, nil
+	}
+
+	if err := fw.flush(); err != nil {
+		return 0, err
+	}
+
+	size, err := format.UncompressedSize(fw.path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file [%s]: %w", fw.path, err)
+	}
+
+	fw.size = size
+
+	return size, nil
+}
+
+func (fw *fileWriter) flush() error {
+	if fw.w.Buffered() == 0 {
+		return nil
+	}
+
+	if err := fw.w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush file [%s]: %w", fw.path, err)
+	}
+
+	fw.dirtySince = time.Time{}
+
+	return nil
+}
+
+// fsync flushes buffered data and commits file contents to stable storage.
+func (fw *fileWriter) fsync() error {
+	if err := fw.flush(); err != nil {
+		return err
+	}
+
+	if !fw.unsynced {
+		return nil
+	}
+
+	if err := fw.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file [%s]: %w", fw.path, err)
+	}
+
+	fw.unsynced = false
+
+	return nil
+}
+
+func (fw *fileWriter) close() error {
+	var syncErr error
+
+	if fw.sync {
+		syncErr = fw.fsync()
+	} else {
+		syncErr = fw.flush()
+	}
+
+	if err := fw.f.Close(); err != nil && syncErr == nil {
+		return fmt.Errorf("failed to close file [%s]: %w", fw.path, err)
+	}
+
+	return syncErr
+}
+
+// writerPool keeps LRU of open buffered files, so each message does not reopen its file.
+type writerPool struct {
+	opts  WriterOptions
+	files map[string]*fileWriter
+	// lru has most recently used files at the front.
+	lru *list.List
+	now func() time.Time
+	// recovered holds files that were already opened by this process and do not need recovery.
+	recovered map[string]bool
+	// newDirs holds directories with new entries that should be fsynced for durability of created files.
+	newDirs map[string]bool
+	log     Logger
+}
+
+func newWriterPool(opts WriterOptions, logger Logger) *writerPool {
+	return &writerPool{
+		opts:  opts.withDefaults(),
+		log:   loggerOrDefault(logger),
+		files: make(map[string]*fileWriter),
+		lru:   list.New(),
+		now:   time.Now,
+
+		recovered: make(map[string]bool),
+		newDirs:   make(map[string]bool),
+	}
+}
+
+// Write appends data of partition to file and returns byte position in file where data starts.
+// Positions in compressed files are positions in decompressed data.
+func (p *writerPool) Write(owner topicPartition, path string, data []byte) (int64, error) {
+	fw, err := p.get(owner, path)
+	if err != nil {
+		return 0, err
+	}
+
+	position, err := fw.position()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = p.write(fw, owner, data); err != nil {
+		return 0, err
+	}
+
+	return position, nil
+}
+
+// Append appends data of partition to file. Unlike Write it does not need position of data,
+// so reopened compressed file is not decompressed to learn its size.
+func (p *writerPool) Append(owner topicPartition, path string, data []byte) error {
+	fw, err := p.get(owner, path)
+	if err != nil {
+		return err
+	}
+
+	return p.write(fw, owner, data)
+}
+
+func (p *writerPool) write(fw *fileWriter, owner topicPartition, data []byte) error {
+	fw.owner = owner
+
+	if _, err := fw.w.Write(data); err != nil {
+		return fmt.Errorf("failed to write file [%s]: %w", fw.path, err)
+	}
+
+	now := p.now()
+
+	if fw.size >= 0 {
+		fw.size += int64(len(data))
+	}
+
+	fw.lastWrite = now
+	fw.unsynced = true
+
+	if fw.w.Buffered() == 0 {
+		fw.dirtySince = time.Time{}
+	} else if fw.dirtySince.IsZero() {
+		fw.dirtySince = now
+	}
+
+	return nil
+}
+
+func (p *writerPool) get(owner topicPartition, path string) (*fileWriter, error) {
+	if fw, ok := p.files[path]; ok {
+		p.lru.MoveToFront(fw.elem)
+
+		return fw, nil
+	}
+
+	for p.lru.Len() >= p.opts.MaxOpenFiles {
+		oldest, ok := p.lru.Back().Value.(*fileWriter)
+		if !ok {
+			break
+		}
+
+		p.log.Debugf("Max open files limit reached, closing least recently used file: %s", oldest.path)
+
+		if err := p.remove(oldest); err != nil {
+			return nil, err
+		}
+	}
+
+	fw, err := p.open(owner, path)
+	if err != nil {
+		return nil, err
+	}
+
+	fw.elem = p.lru.PushFront(fw)
+	p.files[path] = fw
+
+	return fw, nil
+}
+
+func (p *writerPool) open(owner topicPartition, path string) (*fileWriter, error) {
+	if p.opts.Sync {
+		p.trackNewEntries(path)
+	}
+
+	// create necessary dirs
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		p.log.Errorf("failed creating all dirs at %v: %v", filepath.Dir(path), err)
+
+		return nil, fmt.Errorf("failed create dir: %w", err)
+	}
+
+	if err := p.recover(owner, path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file [%s]: %w", path, err)
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+
+		return nil, fmt.Errorf("failed to stat file [%s]: %w", path, err)
+	}
+
+	if st.Size() == 0 {
+		p.log.Infof("Will be create new file: %s", path)
+	} else {
+		p.log.Infof("Will be used existed file: %s", path)
+	}
+
+	fw := &fileWriter{
+		path: path,
+		f:    f,
+		w:    bufio.NewWriterSize(f, p.opts.BufferSize),
+		size: st.Size(),
+		sync: p.opts.Sync,
+	}
+
+	if c, _ := format.CompressionOf(path); c.Enabled() {
+		if fw.w, err = format.NewCompressedWriter(f, c, p.opts.BufferSize); err != nil {
+			_ = f.Close()
+
+			return nil, fmt.Errorf("failed to open file [%s]: %w", path, err)
+		}
+
+		if fw.size != 0 {
+			fw.size = -1
+		}
+	}
+
+	return fw, nil
+}
+
+// recover repairs existing file when it is opened for the first time by this process.
+func (p *writerPool) recover(owner topicPartition, path string) error {
+	if p.recovered[path] {
+		return nil
+	}
+
+	p.recovered[path] = true
+
+	if p.opts.Recover == nil {
+		return nil
+	}
+
+	if st, err := os.Stat(path); err != nil || st.Size() == 0 {
+		return nil
+	}
+
+	if err := p.opts.Recover(owner, path); err != nil {
+		return fmt.Errorf("failed to recover file [%s]: %w", path, err)
+	}
+
+	return nil
+}
+
+// trackNewEntries remembers directories that will get new entries when file and its missing
+// parent directories are created.
+func (p *writerPool) trackNewEntries(path string) {
+	for dir := path; ; {
+		if _, err := os.Stat(dir); err == nil {
+			return
+		}
+
+		parent := filepath.Dir(dir)
+		p.newDirs[parent] = true
+
+		if parent == dir {
+			return
+		}
+
+		dir = parent
+	}
+}
+
+func (p *writerPool) remove(fw *fileWriter) error {
+	p.lru.Remove(fw.elem)
+	delete(p.files, fw.path)
+
+	return fw.close()
+}
+
+// Truncate closes file if it is open and truncates it, so next write starts new file.
+func (p *writerPool) Truncate(path string) error {
+	if err := p.CloseFile(path); err != nil {
+		return err
+	}
+
+	if err := os.Truncate(path, 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to truncate file [%s]: %w", path, err)
+	}
+
+	return nil
+}
+
+// CloseFile flushes and closes file if it is open.
+func (p *writerPool) CloseFile(path string) error {
+	fw, ok := p.files[path]
+	if !ok {
+		return nil
+	}
+
+	return p.remove(fw)
+}
+
+// CloseOwner flushes and closes all files written by partition.
+func (p *writerPool) CloseOwner(owner topicPartition) error {
+	var errs []error
+
+	for _, fw := range p.files {
+		if fw.owner != owner {
+			continue
+		}
+
+		if err := p.remove(fw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// Tick flushes files with buffered data older than FlushInterval and closes files idle longer than IdleTimeout.
+func (p *writerPool) Tick() error {
+	now := p.now()
+
+	var errs []error
+
+	for e := p.lru.Back(); e != nil; {
+		fw, ok := e.Value.(*fileWriter)
+		e = e.Prev()
+
+		if !ok {
+			continue
+		}
+
+		switch {
+		case now.Sub(fw.lastWrite) >= p.opts.IdleTimeout:
+			p.log.Debugf("Closing idle file: %s", fw.path)
+
+			if err := p.remove(fw); err != nil {
+				errs = append(errs, err)
+			}
+		case !fw.dirtySince.IsZero() && now.Sub(fw.dirtySince) >= p.opts.FlushInterval:
+			if err := fw.flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// Sync flushes buffered data of all open files and commits them with all new directory entries to stable storage.
+func (p *writerPool) Sync() error {
+	var errs []error
+
+	for _, fw := range p.files {
+		if err := fw.fsync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for dir := range p.newDirs {
+		if err := syncDir(dir); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		delete(p.newDirs, dir)
+	}
+
+	return joinErrors(errs)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dir [%s]: %w", dir, err)
+	}
+
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+
+		return fmt.Errorf("failed to sync dir [%s]: %w", dir, err)
+	}
+
+	if err = d.Close(); err != nil {
+		return fmt.Errorf("failed to close dir [%s]: %w", dir, err)
+	}
+
+	return nil
+}
+
+// Flush flushes buffered data of all open files.
+func (p *writerPool) Flush() error {
+	var errs []error
+
+	for _, fw := range p.files {
+		if err := fw.flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// Close flushes and closes all open files.
+func (p *writerPool) Close() error {
+	var errs []error
+
+	for _, fw := range p.files {
+		if err := p.remove(fw); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// OpenFiles returns number of currently open files.
+func (p *writerPool) OpenFiles() int {
+	return p.lru.Len()
+}
+
+// isOpen reports whether file is open.
+func (p *writerPool) isOpen(path string) bool {
+	_, ok := p.files[path]
+
+	return ok
+}
+
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return fmt.Errorf("%w (and %d more errors)", errs[0], len(errs)-1)
+	}
+}
@@ -0,0 +1,215 @@
+package dumper
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+func discardLogger() Logger {
+	l := log.New()
+	l.Out = ioutil.Discard
+
+	return l
+}
+
+// openFDs returns number of open file descriptors of process, -1 when it is not known.
+func openFDs() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	return len(fds)
+}
+
+func TestWriterPoolEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	owner := topicPartition{topic: "t"}
+
+	p := newWriterPool(WriterOptions{MaxOpenFiles: 3}, discardLogger())
+	fds := openFDs()
+
+	appendTo := func(name string) {
+		t.Helper()
+
+		if err := p.Append(owner, path(name), []byte(name+"\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.OpenFiles() > 3 {
+			t.Fatalf("%d files are open after write to %s, limit is 3", p.OpenFiles(), name)
+		}
+
+		if fds >= 0 && openFDs()-fds > 3 {
+			t.Fatalf("%d descriptors are open after write to %s, limit is 3", openFDs()-fds, name)
+		}
+	}
+
+	assertOpen := func(names ...string) {
+		t.Helper()
+
+		open := make(map[string]bool)
+		for _, name := range names {
+			open[name] = true
+		}
+
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if p.isOpen(path(name)) != open[name] {
+				t.Errorf("file %s is open: %v, want %v", name, p.isOpen(path(name)), open[name])
+			}
+		}
+	}
+
+	appendTo("a")
+	appendTo("b")
+	appendTo("c")
+	assertOpen("a", "b", "c")
+
+	// a becomes the most recently used, so b is evicted.
+	appendTo("a")
+	appendTo("d")
+	assertOpen("a", "c", "d")
+
+	// data of evicted file is flushed on close.
+	if b, err := ioutil.ReadFile(path("b")); err != nil || string(b) != "b\n" {
+		t.Errorf("evicted file has %q, %v", b, err)
+	}
+
+	appendTo("b")
+	assertOpen("a", "d", "b")
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.OpenFiles() != 0 {
+		t.Errorf("%d files are open after close", p.OpenFiles())
+	}
+
+	for name, want := range map[string]string{"a": "a\na\n", "b": "b\nb\n", "c": "c\n", "d": "d\n"} {
+		if b, err := ioutil.ReadFile(path(name)); err != nil || string(b) != want {
+			t.Errorf("file %s has %q, %v, want %q", name, b, err, want)
+		}
+	}
+}
+
+// BenchmarkWriterPool dumps messages of partitions consumed from mock consumer to file per partition,
+// with more partitions than open files limit, so pool keeps evicting and reopening files.
+func BenchmarkWriterPool(b *testing.B) {
+	const partitions = 64
+
+	for _, maxOpen := range []int{8, 32, partitions} {
+		b.Run("max-open-"+strconv.Itoa(maxOpen), func(b *testing.B) {
+			benchmarkWriterPool(b, partitions, maxOpen)
+		})
+	}
+}
+
+func benchmarkWriterPool(b *testing.B, partitions, maxOpen int) {
+	settings := testSettings(b, b.TempDir(), format.JSONL, "{topic}/{partition}{ext}")
+	pool := newWriterPool(WriterOptions{MaxOpenFiles: maxOpen}, discardLogger())
+	s := newSink(pool, settings, nil, RotationPolicy{}, nil, discardLogger())
+
+	consumer := mocks.NewConsumer(b, nil)
+	expected := make([]*mocks.PartitionConsumer, partitions)
+
+	for i := range expected {
+		expected[i] = consumer.ExpectConsumePartition("bench", int32(i), sarama.OffsetOldest)
+	}
+
+	value := []byte(`{"id":1,"name":"benchmark","payload":"0123456789abcdef0123456789abcdef"}`)
+	fds := openFDs()
+
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		maxFDs       int
+		maxOpenFiles int
+		written      int64
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	started := time.Now()
+
+	for i := 0; i < partitions; i++ {
+		pc, err := consumer.ConsumePartition("bench", int32(i), sarama.OffsetOldest)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		n := b.N / partitions
+		if i < b.N%partitions {
+			n++
+		}
+
+		wg.Add(1)
+
+		go func(pc sarama.PartitionConsumer, n int) {
+			defer wg.Done()
+
+			for j := 0; j < n; j++ {
+				size, err := s.dumpMessage(<-pc.Messages())
+				if err != nil {
+					b.Error(err)
+
+					return
+				}
+
+				mu.Lock()
+				written += int64(size)
+
+				if open := s.openFiles(); open > maxOpenFiles {
+					maxOpenFiles = open
+				}
+
+				if j%256 == 0 && fds >= 0 {
+					if open := openFDs() - fds; open > maxFDs {
+						maxFDs = open
+					}
+				}
+
+				mu.Unlock()
+			}
+		}(pc, n)
+	}
+
+	for i := 0; i < b.N; i++ {
+		expected[i%partitions].YieldMessage(&sarama.ConsumerMessage{Value: value, Timestamp: testTime})
+	}
+
+	wg.Wait()
+
+	if err := s.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	elapsed := time.Since(started)
+
+	b.StopTimer()
+
+	if err := consumer.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	if maxOpenFiles > maxOpen {
+		b.Errorf("%d files were open, limit is %d", maxOpenFiles, maxOpen)
+	}
+
+	b.SetBytes(written / int64(b.N))
+	b.ReportMetric(float64(maxOpenFiles), "open-files")
+	b.ReportMetric(float64(maxFDs), "max-fds")
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "msgs/s")
+}
@@ -0,0 +1,323 @@
+package filter
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fieldKind int
+
+const (
+	fieldKey fieldKind = iota
+	fieldValue
+	fieldHeader
+	fieldTopic
+	fieldPartition
+	fieldOffset
+	fieldTimestamp
+	fieldSize
+	fieldKeySize
+	fieldValueSize
+)
+
+var fieldNames = map[string]fieldKind{
+	"key":        fieldKey,
+	"value":      fieldValue,
+	"header":     fieldHeader,
+	"topic":      fieldTopic,
+	"partition":  fieldPartition,
+	"offset":     fieldOffset,
+	"timestamp":  fieldTimestamp,
+	"size":       fieldSize,
+	"key_size":   fieldKeySize,
+	"value_size": fieldValueSize,
+}
+
+// field is a field of message used in expression.
+type field struct {
+	kind fieldKind
+	// name is a name of header.
+	name string
+	// path is a JSON path of value field, whole value is used when it is empty.
+	path []pathElem
+}
+
+// pathElem is an element of JSON path: object member name or array index (when index is not negative).
+type pathElem struct {
+	name  string
+	index int
+}
+
+func (f field) String() string {
+	for name, kind := range fieldNames {
+		if kind == f.kind {
+			return name
+		}
+	}
+
+	return "field"
+}
+
+// numeric reports whether field is always a number.
+func (f field) numeric() bool {
+	switch f.kind {
+	case fieldPartition, fieldOffset, fieldSize, fieldKeySize, fieldValueSize:
+		return true
+	default:
+		return false
+	}
+}
+
+type valueKind int
+
+const (
+	kindMissing valueKind = iota
+	kindNull
+	kindString
+	kindNumber
+	kindBool
+	kindTime
+	// kindComposite is a JSON object or array.
+	kindComposite
+)
+
+// value is a value of field or literal.
+type value struct {
+	kind valueKind
+	// s is a string or text of number.
+	s string
+	n float64
+	b bool
+	t time.Time
+}
+
+func number(n int64) value {
+	return value{kind: kindNumber, n: float64(n), s: strconv.FormatInt(n, 10)}
+}
+
+// bytesValue returns string value of bytes, nil bytes are null.
+func bytesValue(b []byte) value {
+	if b == nil {
+		return value{kind: kindNull}
+	}
+
+	return value{kind: kindString, s: string(b)}
+}
+
+func (f field) get(m *message) value {
+	msg := m.msg
+
+	switch f.kind {
+	case fieldKey:
+		return bytesValue(msg.Key)
+	case fieldValue:
+		if len(f.path) == 0 {
+			return bytesValue(msg.Value)
+		}
+
+		doc, ok := m.document()
+		if !ok {
+			return value{}
+		}
+
+		return jsonValue(lookup(doc, f.path))
+	case fieldHeader:
+		for _, h := range msg.Headers {
+			if h != nil && string(h.Key) == f.name {
+				return bytesValue(h.Value)
+			}
+		}
+
+		return value{}
+	case fieldTopic:
+		return value{kind: kindString, s: msg.Topic}
+	case fieldPartition:
+		return number(int64(msg.Partition))
+	case fieldOffset:
+		return number(msg.Offset)
+	case fieldTimestamp:
+		if msg.Timestamp.IsZero() {
+			return value{}
+		}
+
+		return value{kind: kindTime, t: msg.Timestamp}
+	case fieldSize:
+		return number(int64(len(msg.Key) + len(msg.Value)))
+	case fieldKeySize:
+		return number(int64(len(msg.Key)))
+	case fieldValueSize:
+		return number(int64(len(msg.Value)))
+	default:
+		return value{}
+	}
+}
+
+// missing is returned by lookup when path is not found.
+type missing struct{}
+
+func lookup(doc interface{}, path []pathElem) interface{} {
+	for _, elem := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			member, ok := v[elem.name]
+			if elem.index >= 0 || !ok {
+				return missing{}
+			}
+
+			doc = member
+		case []interface{}:
+			if elem.index < 0 || elem.index >= len(v) {
+				return missing{}
+			}
+
+			doc = v[elem.index]
+		default:
+			return missing{}
+		}
+	}
+
+	return doc
+}
+
+func jsonValue(v interface{}) value {
+	switch v := v.(type) {
+	case nil:
+		return value{kind: kindNull}
+	case string:
+		return value{kind: kindString, s: v}
+	case bool:
+		return value{kind: kindBool, b: v}
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return value{}
+		}
+
+		return value{kind: kindNumber, n: n, s: v.String()}
+	case map[string]interface{}, []interface{}:
+		return value{kind: kindComposite}
+	default:
+		return value{}
+	}
+}
+
+// text returns text of scalar value for regular expressions.
+func (v value) text() (string, bool) {
+	switch v.kind {
+	case kindString, kindNumber:
+		return v.s, true
+	case kindBool:
+		return strconv.FormatBool(v.b), true
+	case kindTime:
+		return v.t.Format(time.RFC3339Nano), true
+	default:
+		return "", false
+	}
+}
+
+// compare applies operator to field value and literal, comparison of missing field is always false.
+func compare(v value, op string, literal value, re *regexp.Regexp) bool {
+	if v.kind == kindMissing {
+		return false
+	}
+
+	switch op {
+	case "=~", "!~":
+		s, ok := v.text()
+		if !ok {
+			return false
+		}
+
+		return re.MatchString(s) == (op == "=~")
+	case "==":
+		return equal(v, literal)
+	case "!=":
+		return !equal(v, literal)
+	}
+
+	c, ok := order(v, literal)
+	if !ok {
+		return false
+	}
+
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	default:
+		return false
+	}
+}
+
+func equal(v, literal value) bool {
+	if literal.kind == kindNull || v.kind == kindNull {
+		return v.kind == literal.kind
+	}
+
+	if literal.kind == kindBool {
+		return v.kind == kindBool && v.b == literal.b
+	}
+
+	c, ok := order(v, literal)
+
+	return ok && c == 0
+}
+
+// order compares value with literal of the same type. Strings (keys and headers) are compared with numbers
+// as numbers when they could be parsed.
+func order(v, literal value) (int, bool) {
+	switch literal.kind {
+	case kindNumber:
+		n := v.n
+
+		switch v.kind {
+		case kindNumber:
+		case kindString:
+			var err error
+
+			if n, err = strconv.ParseFloat(strings.TrimSpace(v.s), 64); err != nil {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+
+		switch {
+		case n < literal.n:
+			return -1, true
+		case n > literal.n:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case kindString:
+		if v.kind != kindString {
+			return 0, false
+		}
+
+		return strings.Compare(v.s, literal.s), true
+	case kindTime:
+		if v.kind != kindTime {
+			return 0, false
+		}
+
+		switch {
+		case v.t.Before(literal.t):
+			return -1, true
+		case v.t.After(literal.t):
+			return 1, true
+		default:
+			return 0, true
+		}
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,98 @@
+// Package filter implements expressions that select dumped messages.
+//
+// Expression compares message fields with literals and combines comparisons with and, or, not and parentheses:
+//
+//	value.event_type == "refund" and (key =~ `^cust-` or exists(header.trace-id)) and value_size < 65536
+//
+// Fields:
+//
+//	key                      message key as string, null when message has no key
+//	value                    message value as string, null for tombstones
+//	value.<path>             field of value parsed as JSON, path is a sequence of .name, ["name"] and [index]
+//	header.<name>            value of the first header with name, header["name"] for names with special characters
+//	topic, partition, offset message coordinates
+//	timestamp                message timestamp, compared with RFC3339 string
+//	size, key_size, value_size  sizes in bytes of key and value, of key and of value
+//
+// Operators are ==, !=, <, <=, >, >= and regular expression matches =~ and !~. Literals are strings
+// (double quoted with escapes or back quoted raw), numbers, true, false and null.
+// Comparison of missing field (absent header, JSON path not found or value that is not JSON) is false,
+// exists(field) checks that field is present.
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// Filter is a parsed expression, it is safe for concurrent use.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Parse parses filter expression.
+func Parse(expr string) (*Filter, error) {
+	p := &parser{lex: newLexer(expr)}
+
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter [%s]: %w", expr, err)
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether message passes filter.
+func (f *Filter) Match(msg *sarama.ConsumerMessage) bool {
+	return f.root.eval(&message{msg: msg})
+}
+
+// String returns source expression of filter.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// message is a message evaluated by filter, its value is parsed as JSON once when JSON path is used.
+type message struct {
+	msg    *sarama.ConsumerMessage
+	parsed bool
+	// doc is a value parsed as JSON, valid is false when value is not valid JSON.
+	doc   interface{}
+	valid bool
+}
+
+func (m *message) document() (interface{}, bool) {
+	if !m.parsed {
+		m.parsed = true
+
+		var doc interface{}
+
+		if m.msg.Value != nil && unmarshalNumbers(m.msg.Value, &doc) == nil {
+			m.doc, m.valid = doc, true
+		}
+	}
+
+	return m.doc, m.valid
+}
+
+// unmarshalNumbers unmarshals JSON keeping numbers as json.Number, so large integers are not rounded.
+func unmarshalNumbers(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+
+	// trailing data makes value invalid JSON.
+	if d.More() {
+		return errors.New("unexpected data after JSON value")
+	}
+
+	return nil
+}
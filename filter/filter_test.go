@@ -0,0 +1,183 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+var testTime = time.Date(2021, time.February, 3, 10, 0, 0, 0, time.UTC)
+
+func testMessages() (msg, tombstone, text *sarama.ConsumerMessage) {
+	msg = &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 1,
+		Offset:    42,
+		Timestamp: testTime,
+		Key:       []byte("cust-7"),
+		Value: []byte(`{"event_type":"refund","amount":12.5,"items":[{"sku":"a"},{"sku":"b"}],` +
+			`"meta":{"a.b":1},"big":12345678901234567890,"flag":true,"none":null,"path":"a\\b","count":"17"}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("trace-id"), Value: []byte("abc")},
+			{Key: []byte("x y"), Value: []byte(`q"uote`)},
+			{Key: []byte("nil")},
+			{Key: []byte("trace-id"), Value: []byte("second")},
+		},
+	}
+
+	tombstone = &sarama.ConsumerMessage{Topic: "orders", Offset: 43}
+	text = &sarama.ConsumerMessage{Topic: "orders", Offset: 44, Key: []byte("17"), Value: []byte("plain text {")}
+
+	return msg, tombstone, text
+}
+
+func TestMatch(t *testing.T) {
+	msg, tombstone, text := testMessages()
+
+	tests := []struct {
+		name string
+		expr string
+		msg  *sarama.ConsumerMessage
+		want bool
+	}{
+		// precedence: not binds tighter than and, and binds tighter than or.
+		{name: "and before or", expr: `topic == "orders" or topic == "x" and partition == 2`, msg: msg, want: true},
+		{name: "and before or symbols", expr: `topic == "x" || partition == 1 && offset == 42`, msg: msg, want: true},
+		{name: "parentheses", expr: `(topic == "orders" or topic == "x") and partition == 2`, msg: msg, want: false},
+		{name: "not before and", expr: `not topic == "orders" and partition == 2`, msg: msg, want: false},
+		{name: "not of parentheses", expr: `!(topic == "orders" and partition == 2)`, msg: msg, want: true},
+		{name: "double not", expr: `not not topic == "orders"`, msg: msg, want: true},
+		{name: "left to right or", expr: `offset == 1 or offset == 2 or offset == 42`, msg: msg, want: true},
+
+		// string literals.
+		{name: "hex escape", expr: `value.event_type == "re\x66und"`, msg: msg, want: true},
+		{name: "unicode escape", expr: `value.event_type == "\u0072efund"`, msg: msg, want: true},
+		{name: "escaped quote", expr: `header["x y"] == "q\"uote"`, msg: msg, want: true},
+		{name: "escaped backslash", expr: `value.path == "a\\b"`, msg: msg, want: true},
+		{name: "raw string", expr: "value.path == `a\\b`", msg: msg, want: true},
+		{name: "raw regular expression", expr: "key =~ `^cust-\\d+$`", msg: msg, want: true},
+		{name: "escaped regular expression", expr: `key =~ "^cust-\\d+$"`, msg: msg, want: true},
+		{name: "not matching regular expression", expr: `key !~ "^acct-"`, msg: msg, want: true},
+
+		// key predicates.
+		{name: "key", expr: `key == "cust-7"`, msg: msg, want: true},
+		{name: "key order", expr: `key > "cust-1" and key < "cust-8"`, msg: msg, want: true},
+		{name: "key size", expr: `key_size == 6 and size > 100`, msg: msg, want: true},
+		{name: "numeric key", expr: `key >= 17 and key < 17.5`, msg: text, want: true},
+		{name: "non-numeric key", expr: `key < 100 or key >= 100`, msg: msg, want: false},
+		{name: "null key", expr: `key == null and value == null`, msg: tombstone, want: true},
+		{name: "null key is not equal to string", expr: `key != "x"`, msg: tombstone, want: true},
+		{name: "null key does not match", expr: `key =~ "" or key !~ ""`, msg: tombstone, want: false},
+		{name: "key is not null", expr: `key != null`, msg: msg, want: true},
+
+		// header predicates.
+		{name: "header", expr: `header.trace-id == "abc"`, msg: msg, want: true},
+		{name: "first header wins", expr: `header.trace-id == "second"`, msg: msg, want: false},
+		{name: "bracket header", expr: `header["trace-id"] =~ "^a"`, msg: msg, want: true},
+		{name: "header exists", expr: `exists(header.trace-id)`, msg: msg, want: true},
+		{name: "header with null value exists", expr: `exists(header.nil) and header.nil == null`, msg: msg, want: true},
+		{name: "missing header", expr: `exists(header.missing)`, msg: msg, want: false},
+		{name: "missing header is not unequal", expr: `header.missing != "x"`, msg: msg, want: false},
+		{name: "not missing header", expr: `not exists(header.missing)`, msg: msg, want: true},
+
+		// value predicates.
+		{name: "array path", expr: `value.items[1].sku == "b"`, msg: msg, want: true},
+		{name: "bracket path", expr: `value["meta"]["a.b"] == 1`, msg: msg, want: true},
+		{name: "index out of range", expr: `exists(value.items[2])`, msg: msg, want: false},
+		{name: "index of object", expr: `exists(value.meta[0])`, msg: msg, want: false},
+		{name: "name of array", expr: `exists(value.items.sku)`, msg: msg, want: false},
+		{name: "number", expr: `value.amount >= 12.5 and value.amount < 13`, msg: msg, want: true},
+		{name: "large number", expr: `value.big =~ "^12345678901234567890$"`, msg: msg, want: true},
+		{name: "numeric string", expr: `value.count > 9`, msg: msg, want: true},
+		{name: "bool", expr: `value.flag == true and value.flag != false`, msg: msg, want: true},
+		{name: "null member", expr: `value.none == null and exists(value.none)`, msg: msg, want: true},
+		{name: "composite is not null", expr: `value.items != null and exists(value.items)`, msg: msg, want: true},
+		{name: "composite is not comparable", expr: `value.items == 1 or value.items == "x"`, msg: msg, want: false},
+		{name: "non-JSON value", expr: `exists(value.x) or value.x != 1`, msg: text, want: false},
+		{name: "non-JSON value as string", expr: `value =~ "^plain"`, msg: text, want: true},
+		{name: "path of tombstone", expr: `exists(value.x)`, msg: tombstone, want: false},
+
+		// coordinates.
+		{
+			name: "timestamp",
+			expr: `timestamp >= "2021-02-03T10:00:00Z" and timestamp < "2021-02-03T10:00:00.001Z"`,
+			msg:  msg,
+			want: true,
+		},
+		{name: "timestamp with zone", expr: `timestamp == "2021-02-03T11:00:00+01:00"`, msg: msg, want: true},
+		{name: "missing timestamp", expr: `timestamp < "2030-01-01T00:00:00Z"`, msg: tombstone, want: false},
+	}
+
+	for _, tc := range tests {
+		f, err := Parse(tc.expr)
+		if err != nil {
+			t.Errorf("%s: Parse(%s) = %v", tc.name, tc.expr, err)
+
+			continue
+		}
+
+		if got := f.Match(tc.msg); got != tc.want {
+			t.Errorf("%s: Match(%s) = %v, want %v", tc.name, tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: ``, wantErr: "expected field, got end of expression at position 0"},
+		{expr: `topic == `, wantErr: "expected literal, got end of expression at position 9"},
+		{expr: `topic = "x"`, wantErr: "unexpected character '=' at position 6"},
+		{expr: `key # 1`, wantErr: "unexpected character '#' at position 4"},
+		{expr: `topic == "x`, wantErr: "unterminated string at position 9"},
+		{expr: "topic == `x", wantErr: "unterminated string at position 9"},
+		{expr: `topic == "x\"`, wantErr: "unterminated string at position 9"},
+		{expr: `topic == "\q"`, wantErr: "invalid string at position 9"},
+		{expr: `topic == 1.2.3`, wantErr: `invalid number "1.2.3" at position 9`},
+		{expr: `foo == 1`, wantErr: `unknown field "foo" at position 0`},
+		{expr: `topic == "x" and`, wantErr: "expected field, got end of expression at position 16"},
+		{expr: `topic == "x" topic`, wantErr: "unexpected 'topic' at position 13"},
+		{expr: `(topic == "x"`, wantErr: "unexpected end of expression at position 13"},
+		{expr: `topic == "x")`, wantErr: "unexpected ')' at position 12"},
+		{expr: `topic "x"`, wantErr: `unexpected "x" at position 6`},
+		{expr: `exists(key`, wantErr: "unexpected end of expression at position 10"},
+		{expr: `exists key`, wantErr: "unexpected 'key' at position 7"},
+		{expr: `partition == "1"`, wantErr: "partition should be compared with number at position 13"},
+		{expr: `key < null`, wantErr: "null could be compared only with == and != at position 6"},
+		{expr: `key =~ 1`, wantErr: "=~ expects regular expression string at position 7"},
+		{expr: `key !~ "("`, wantErr: "invalid regular expression: error parsing regexp: missing closing ): `(` at position 7"},
+		{expr: `timestamp > "yesterday"`, wantErr: "timestamp should be compared with RFC3339 time string"},
+		{expr: `timestamp > 1`, wantErr: "timestamp should be compared with RFC3339 time string at position 12"},
+		{expr: `value.items[-1] == 1`, wantErr: "invalid index -1 at position 12"},
+		{expr: `value.items[1.5] == 1`, wantErr: "invalid index 1.5 at position 12"},
+		{expr: `value.items[true] == 1`, wantErr: "expected name or index, got 'true' at position 12"},
+		{expr: `value.items["a" == 1`, wantErr: "unexpected '==' at position 16"},
+		{expr: `value. == 1`, wantErr: "expected name after dot, got '==' at position 7"},
+		{expr: `header == "x"`, wantErr: `expected .name or ["name"], got '==' at position 7`},
+		{expr: `header[0] == "x"`, wantErr: "header name expected at position 0"},
+	}
+
+	for _, tc := range tests {
+		_, err := Parse(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("Parse(%s) error = %v, want %q", tc.expr, err, tc.wantErr)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	const expr = ` key == "a" `
+
+	f, err := Parse(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.String() != expr {
+		t.Errorf("String() = %q, want %q", f.String(), expr)
+	}
+}
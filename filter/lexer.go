@@ -0,0 +1,170 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokDot
+)
+
+type token struct {
+	kind tokenKind
+	// text is an identifier, operator, number or unquoted string.
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src}
+}
+
+// operators are sorted so longer operators are matched first.
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!"}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+
+	start := l.pos
+
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+
+	switch {
+	case c == '(':
+		l.pos++
+
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case c == ')':
+		l.pos++
+
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case c == '[':
+		l.pos++
+
+		return token{kind: tokLBracket, text: "[", pos: start}, nil
+	case c == ']':
+		l.pos++
+
+		return token{kind: tokRBracket, text: "]", pos: start}, nil
+	case c == '.':
+		l.pos++
+
+		return token{kind: tokDot, text: ".", pos: start}, nil
+	case c == '"' || c == '`':
+		return l.string()
+	case c == '-' || c >= '0' && c <= '9':
+		return l.number()
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.pos++
+		}
+
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("unexpected character %q at position %d", c, start)
+}
+
+// string reads double quoted string with Go escapes or back quoted raw string.
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	quote := l.src[l.pos]
+
+	for i := l.pos + 1; i < len(l.src); i++ {
+		switch l.src[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			s, err := strconv.Unquote(l.src[start : i+1])
+			if err != nil {
+				return token{}, fmt.Errorf("invalid string at position %d: %w", start, err)
+			}
+
+			l.pos = i + 1
+
+			return token{kind: tokString, text: s, pos: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("unterminated string at position %d", start)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+
+	for l.pos < len(l.src) && strings.IndexByte("0123456789.eE+-", l.src[l.pos]) >= 0 {
+		// sign is a part of number only after exponent.
+		if c := l.src[l.pos]; (c == '+' || c == '-') && l.src[l.pos-1] != 'e' && l.src[l.pos-1] != 'E' {
+			break
+		}
+
+		l.pos++
+	}
+
+	text := l.src[start:l.pos]
+
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		return token{}, fmt.Errorf("invalid number %q at position %d", text, start)
+	}
+
+	return token{kind: tokNumber, text: text, pos: start}, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isIdentPart allows dashes in identifiers, so header names like trace-id could follow dot.
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c == '-' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,385 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// node is a boolean node of expression tree.
+type node interface {
+	eval(m *message) bool
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(m *message) bool {
+	return n.left.eval(m) && n.right.eval(m)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(m *message) bool {
+	return n.left.eval(m) || n.right.eval(m)
+}
+
+type notNode struct {
+	expr node
+}
+
+func (n notNode) eval(m *message) bool {
+	return !n.expr.eval(m)
+}
+
+type existsNode struct {
+	field field
+}
+
+func (n existsNode) eval(m *message) bool {
+	return n.field.get(m).kind != kindMissing
+}
+
+type compareNode struct {
+	field   field
+	op      string
+	literal value
+	re      *regexp.Regexp
+}
+
+func (n compareNode) eval(m *message) bool {
+	return compare(n.field.get(m), n.op, n.literal, n.re)
+}
+
+// parser is a recursive descent parser of expression:
+//
+//	or      = and { ("or" | "||") and }
+//	and     = unary { ("and" | "&&") unary }
+//	unary   = ("not" | "!") unary | "(" or ")" | "exists" "(" field ")" | field op literal
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) parse() (node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+
+	return n, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+
+	p.tok = tok
+
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	return fmt.Errorf("unexpected %s at position %d", p.tok, p.tok.pos)
+}
+
+// is reports whether the current token is identifier or operator with text.
+func (p *parser) is(texts ...string) bool {
+	if p.tok.kind != tokIdent && p.tok.kind != tokOp {
+		return false
+	}
+
+	for _, t := range texts {
+		if p.tok.text == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) expect(kind tokenKind) error {
+	if p.tok.kind != kind {
+		return p.unexpected()
+	}
+
+	return p.advance()
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is("or", "||") {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.is("and", "&&") {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	switch {
+	case p.is("not", "!"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{expr: n}, nil
+	case p.tok.kind == tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		return n, p.expect(tokRParen)
+	case p.is("exists"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokLParen); err != nil {
+			return nil, err
+		}
+
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+
+		return existsNode{field: f}, p.expect(tokRParen)
+	default:
+		return p.comparison()
+	}
+}
+
+var comparisonOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "=~": true, "!~": true}
+
+func (p *parser) comparison() (node, error) {
+	f, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokOp || !comparisonOps[p.tok.text] {
+		return nil, p.unexpected()
+	}
+
+	n := compareNode{field: f, op: p.tok.text}
+
+	if err = p.advance(); err != nil {
+		return nil, err
+	}
+
+	pos := p.tok.pos
+
+	if n.literal, err = p.literal(); err != nil {
+		return nil, err
+	}
+
+	if err = n.check(); err != nil {
+		return nil, fmt.Errorf("%w at position %d", err, pos)
+	}
+
+	return n, nil
+}
+
+// check validates literal of comparison and prepares it: compiles regular expression and parses time.
+func (n *compareNode) check() error {
+	var err error
+
+	switch {
+	case n.op == "=~" || n.op == "!~":
+		if n.literal.kind != kindString {
+			return fmt.Errorf("%s expects regular expression string", n.op)
+		}
+
+		if n.re, err = regexp.Compile(n.literal.s); err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+	case n.field.kind == fieldTimestamp:
+		if n.literal.kind != kindString {
+			return fmt.Errorf("%s should be compared with RFC3339 time string", n.field)
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, n.literal.s)
+		if err != nil {
+			return fmt.Errorf("%s should be compared with RFC3339 time string: %w", n.field, err)
+		}
+
+		n.literal = value{kind: kindTime, t: t}
+	case n.field.numeric() && n.literal.kind != kindNumber:
+		return fmt.Errorf("%s should be compared with number", n.field)
+	case n.literal.kind == kindNull && n.op != "==" && n.op != "!=":
+		return fmt.Errorf("null could be compared only with == and !=")
+	}
+
+	return nil
+}
+
+func (p *parser) literal() (value, error) {
+	tok := p.tok
+
+	var v value
+
+	switch {
+	case tok.kind == tokString:
+		v = value{kind: kindString, s: tok.text}
+	case tok.kind == tokNumber:
+		// number is validated by lexer.
+		n, _ := strconv.ParseFloat(tok.text, 64)
+		v = value{kind: kindNumber, n: n, s: tok.text}
+	case p.is("true", "false"):
+		v = value{kind: kindBool, b: tok.text == "true"}
+	case p.is("null"):
+		v = value{kind: kindNull}
+	default:
+		return value{}, fmt.Errorf("expected literal, got %s at position %d", tok, tok.pos)
+	}
+
+	return v, p.advance()
+}
+
+func (p *parser) field() (field, error) {
+	tok := p.tok
+	if tok.kind != tokIdent {
+		return field{}, fmt.Errorf("expected field, got %s at position %d", tok, tok.pos)
+	}
+
+	kind, ok := fieldNames[tok.text]
+	if !ok {
+		return field{}, fmt.Errorf("unknown field %q at position %d", tok.text, tok.pos)
+	}
+
+	if err := p.advance(); err != nil {
+		return field{}, err
+	}
+
+	f := field{kind: kind}
+
+	switch kind {
+	case fieldValue:
+		for p.tok.kind == tokDot || p.tok.kind == tokLBracket {
+			elem, err := p.pathElem()
+			if err != nil {
+				return field{}, err
+			}
+
+			f.path = append(f.path, elem)
+		}
+	case fieldHeader:
+		elem, err := p.pathElem()
+		if err != nil {
+			return field{}, err
+		}
+
+		if elem.index >= 0 {
+			return field{}, fmt.Errorf("header name expected at position %d", tok.pos)
+		}
+
+		f.name = elem.name
+	}
+
+	return f, nil
+}
+
+// pathElem parses .name, ["name"] or [index].
+func (p *parser) pathElem() (pathElem, error) {
+	switch p.tok.kind {
+	case tokDot:
+		if err := p.advance(); err != nil {
+			return pathElem{}, err
+		}
+
+		if p.tok.kind != tokIdent {
+			return pathElem{}, fmt.Errorf("expected name after dot, got %s at position %d", p.tok, p.tok.pos)
+		}
+
+		elem := pathElem{name: p.tok.text, index: -1}
+
+		return elem, p.advance()
+	case tokLBracket:
+		if err := p.advance(); err != nil {
+			return pathElem{}, err
+		}
+
+		elem := pathElem{index: -1}
+
+		switch p.tok.kind {
+		case tokString:
+			elem.name = p.tok.text
+		case tokNumber:
+			i, err := strconv.Atoi(p.tok.text)
+			if err != nil || i < 0 {
+				return pathElem{}, fmt.Errorf("invalid index %s at position %d", p.tok.text, p.tok.pos)
+			}
+
+			elem.index = i
+		default:
+			return pathElem{}, fmt.Errorf("expected name or index, got %s at position %d", p.tok, p.tok.pos)
+		}
+
+		if err := p.advance(); err != nil {
+			return pathElem{}, err
+		}
+
+		return elem, p.expect(tokRBracket)
+	default:
+		return pathElem{}, fmt.Errorf("expected .name or [\"name\"], got %s at position %d", p.tok, p.tok.pos)
+	}
+}
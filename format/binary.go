@@ -0,0 +1,369 @@
+package format
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Binary writes length-prefixed records with all message metadata and checksum.
+//
+// Each record has layout:
+//
+//	uvarint  body length
+//	body:
+//	  byte     record version
+//	  byte     attributes (bit 0 - timestamp is set, bit 1 - block timestamp is set, bit 2 - headers are not null)
+//	  varint   offset
+//	  varint   partition
+//	  string   topic (uvarint length + bytes)
+//	  time     timestamp (only when attribute bit 0 is set)
+//	  time     block timestamp (only when attribute bit 1 is set)
+//	  bytes    key (varint length, -1 for null, + bytes)
+//	  bytes    value (varint length, -1 for null, + bytes)
+//	  uvarint  headers count, followed by key bytes and value bytes of each header (key length -2 for null header)
+//	uint32   CRC-32C (Castagnoli) of body, big endian
+//
+// Time is varint unix seconds followed by uvarint nanoseconds, so any time is kept exactly.
+const Binary Format = "binary"
+
+const (
+	binaryRecordVersion byte = 1
+
+	attrTimestamp      byte = 1 << 0
+	attrBlockTimestamp byte = 1 << 1
+	attrHeaders        byte = 1 << 2
+
+	// nullHeader is a key length of null header.
+	nullHeader = -2
+
+	crcSize = 4
+	// maxRecordSize protects reader from allocating huge buffers on corrupted length prefix.
+	maxRecordSize = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptedRecord returned when binary record could not be decoded or has invalid checksum.
+var ErrCorruptedRecord = errors.New("corrupted record")
+
+type binaryEncoder struct{}
+
+// Encode returns binary record of message.
+func (binaryEncoder) Encode(msg *sarama.ConsumerMessage) ([]byte, error) {
+	body := make([]byte, 0, len(msg.Topic)+len(msg.Key)+len(msg.Value)+64)
+
+	var attrs byte
+
+	if !msg.Timestamp.IsZero() {
+		attrs |= attrTimestamp
+	}
+
+	if !msg.BlockTimestamp.IsZero() {
+		attrs |= attrBlockTimestamp
+	}
+
+	if msg.Headers != nil {
+		attrs |= attrHeaders
+	}
+
+	body = append(body, binaryRecordVersion, attrs)
+	body = appendVarint(body, msg.Offset)
+	body = appendVarint(body, int64(msg.Partition))
+	body = appendUvarint(body, uint64(len(msg.Topic)))
+	body = append(body, msg.Topic...)
+
+	if attrs&attrTimestamp != 0 {
+		body = appendTime(body, msg.Timestamp)
+	}
+
+	if attrs&attrBlockTimestamp != 0 {
+		body = appendTime(body, msg.BlockTimestamp)
+	}
+
+	body = appendBytes(body, msg.Key)
+	body = appendBytes(body, msg.Value)
+
+	body = appendUvarint(body, uint64(len(msg.Headers)))
+
+	for _, h := range msg.Headers {
+		if h == nil {
+			body = appendVarint(body, nullHeader)
+
+			continue
+		}
+
+		body = appendBytes(body, h.Key)
+		body = appendBytes(body, h.Value)
+	}
+
+	rec := make([]byte, 0, len(body)+binary.MaxVarintLen64+crcSize)
+	rec = appendUvarint(rec, uint64(len(body)))
+	rec = append(rec, body...)
+	rec = appendUint32(rec, crc32.Checksum(body, crcTable))
+
+	return rec, nil
+}
+
+// Extension of binary files.
+func (binaryEncoder) Extension() string {
+	return ".bin"
+}
+
+// Indexed reports that binary segments have offset index.
+func (binaryEncoder) Indexed() bool {
+	return true
+}
+
+func appendVarint(dst []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	n := binary.PutVarint(buf[:], v)
+
+	return append(dst, buf[:n]...)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(buf[:], v)
+
+	return append(dst, buf[:n]...)
+}
+
+func appendTime(dst []byte, t time.Time) []byte {
+	dst = appendVarint(dst, t.Unix())
+
+	return appendUvarint(dst, uint64(t.Nanosecond()))
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	var buf [4]byte
+
+	binary.BigEndian.PutUint32(buf[:], v)
+
+	return append(dst, buf[:]...)
+}
+
+func appendBytes(dst, b []byte) []byte {
+	if b == nil {
+		return appendVarint(dst, -1)
+	}
+
+	dst = appendVarint(dst, int64(len(b)))
+
+	return append(dst, b...)
+}
+
+// BinaryReader reads messages from binary segment.
+type BinaryReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// NewBinaryReader creates reader of binary records.
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: bufio.NewReader(r)}
+}
+
+// Next returns next message. At the end of segment io.EOF is returned.
+// When segment ends in the middle of record io.ErrUnexpectedEOF is returned.
+func (br *BinaryReader) Next() (*sarama.ConsumerMessage, error) {
+	size, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("%w: record length %d", ErrCorruptedRecord, size)
+	}
+
+	buf := make([]byte, int(size)+crcSize)
+	if _, err = io.ReadFull(br.r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	body := buf[:size]
+
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(buf[size:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptedRecord)
+	}
+
+	msg, err := decodeBinaryBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	br.n += int64(len(appendUvarint(nil, size)) + len(buf))
+
+	return msg, nil
+}
+
+// BytesRead returns number of bytes of successfully read records.
+func (br *BinaryReader) BytesRead() int64 {
+	return br.n
+}
+
+type bodyReader struct {
+	b   []byte
+	err error
+}
+
+func (r *bodyReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.b) == 0 {
+		r.err = ErrCorruptedRecord
+
+		return 0
+	}
+
+	v := r.b[0]
+	r.b = r.b[1:]
+
+	return v
+}
+
+func (r *bodyReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = ErrCorruptedRecord
+
+		return 0
+	}
+
+	r.b = r.b[n:]
+
+	return v
+}
+
+func (r *bodyReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = ErrCorruptedRecord
+
+		return 0
+	}
+
+	r.b = r.b[n:]
+
+	return v
+}
+
+func (r *bodyReader) take(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n > uint64(len(r.b)) {
+		r.err = ErrCorruptedRecord
+
+		return nil
+	}
+
+	v := make([]byte, n)
+	copy(v, r.b[:n])
+	r.b = r.b[n:]
+
+	return v
+}
+
+func (r *bodyReader) bytes() []byte {
+	return r.bytesOfLength(r.varint())
+}
+
+// bytesOfLength reads bytes which length was already read.
+func (r *bodyReader) bytesOfLength(l int64) []byte {
+	if l < 0 {
+		if l != -1 {
+			r.err = ErrCorruptedRecord
+		}
+
+		return nil
+	}
+
+	return r.take(uint64(l))
+}
+
+// time reads unix seconds and nanoseconds.
+func (r *bodyReader) time() time.Time {
+	sec := r.varint()
+
+	nsec := r.uvarint()
+	if nsec >= uint64(time.Second) {
+		r.err = ErrCorruptedRecord
+	}
+
+	return time.Unix(sec, int64(nsec))
+}
+
+func decodeBinaryBody(body []byte) (*sarama.ConsumerMessage, error) {
+	r := &bodyReader{b: body}
+
+	version := r.byte()
+	if r.err == nil && version != binaryRecordVersion {
+		return nil, fmt.Errorf("%w: unsupported record version %d", ErrCorruptedRecord, version)
+	}
+
+	attrs := r.byte()
+
+	msg := &sarama.ConsumerMessage{
+		Offset:    r.varint(),
+		Partition: int32(r.varint()),
+	}
+
+	msg.Topic = string(r.take(r.uvarint()))
+
+	if attrs&attrTimestamp != 0 {
+		msg.Timestamp = r.time()
+	}
+
+	if attrs&attrBlockTimestamp != 0 {
+		msg.BlockTimestamp = r.time()
+	}
+
+	msg.Key = r.bytes()
+	msg.Value = r.bytes()
+
+	if attrs&attrHeaders != 0 {
+		msg.Headers = []*sarama.RecordHeader{}
+	}
+
+	headers := r.uvarint()
+	for i := uint64(0); i < headers && r.err == nil; i++ {
+		l := r.varint()
+		if l == nullHeader {
+			msg.Headers = append(msg.Headers, nil)
+
+			continue
+		}
+
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: r.bytesOfLength(l), Value: r.bytes()})
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,153 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func encodeBinary(t *testing.T, msg *sarama.ConsumerMessage) []byte {
+	t.Helper()
+
+	rec, err := binaryEncoder{}.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rec
+}
+
+func readBinary(t *testing.T, rec []byte) *sarama.ConsumerMessage {
+	t.Helper()
+
+	msg, err := NewBinaryReader(bytes.NewReader(rec)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  sarama.ConsumerMessage
+	}{
+		{
+			name: "null key and value",
+			msg:  sarama.ConsumerMessage{Topic: "t", Offset: 1},
+		},
+		{
+			name: "empty key and value",
+			msg:  sarama.ConsumerMessage{Topic: "t", Key: []byte{}, Value: []byte{}},
+		},
+		{
+			name: "null headers",
+			msg:  sarama.ConsumerMessage{Topic: "t", Value: []byte("v")},
+		},
+		{
+			name: "empty headers",
+			msg:  sarama.ConsumerMessage{Topic: "t", Value: []byte("v"), Headers: []*sarama.RecordHeader{}},
+		},
+		{
+			name: "headers",
+			msg: sarama.ConsumerMessage{Topic: "t", Headers: []*sarama.RecordHeader{
+				{Key: []byte("k"), Value: []byte("v")},
+				nil,
+				{},
+				{Key: []byte{}, Value: []byte{}},
+				{Key: []byte("k"), Value: nil},
+			}},
+		},
+		{
+			name: "timestamps",
+			msg: sarama.ConsumerMessage{
+				Topic:          "t",
+				Partition:      7,
+				Offset:         1 << 40,
+				Key:            []byte{0, 1, 2},
+				Value:          []byte("value"),
+				Timestamp:      time.Unix(1612346400, 123456789),
+				BlockTimestamp: time.Unix(1612346401, 0),
+			},
+		},
+		{
+			name: "unix epoch",
+			msg:  sarama.ConsumerMessage{Topic: "t", Timestamp: time.Unix(0, 0)},
+		},
+		{
+			name: "time out of unix nanoseconds range",
+			msg: sarama.ConsumerMessage{
+				Topic:          "t",
+				Timestamp:      time.Date(1000, time.January, 1, 0, 0, 0, 1, time.UTC),
+				BlockTimestamp: time.Date(3000, time.January, 1, 0, 0, 0, 999999999, time.UTC),
+			},
+		},
+		{
+			name: "negative offset and empty topic",
+			msg:  sarama.ConsumerMessage{Offset: -1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := readBinary(t, encodeBinary(t, &tc.msg))
+
+			if !got.Timestamp.Equal(tc.msg.Timestamp) || got.Timestamp.IsZero() != tc.msg.Timestamp.IsZero() {
+				t.Errorf("timestamp = %v, want %v", got.Timestamp, tc.msg.Timestamp)
+			}
+
+			if !got.BlockTimestamp.Equal(tc.msg.BlockTimestamp) || got.BlockTimestamp.IsZero() != tc.msg.BlockTimestamp.IsZero() {
+				t.Errorf("block timestamp = %v, want %v", got.BlockTimestamp, tc.msg.BlockTimestamp)
+			}
+
+			// times are compared above, locations are not kept.
+			got.Timestamp, got.BlockTimestamp = tc.msg.Timestamp, tc.msg.BlockTimestamp
+
+			if !reflect.DeepEqual(*got, tc.msg) {
+				t.Errorf("decoded message = %+v, want %+v", *got, tc.msg)
+			}
+		})
+	}
+}
+
+func TestBinaryCorruption(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Topic: "t", Offset: 1, Key: []byte("k"), Value: []byte("value")}
+	rec := encodeBinary(t, msg)
+
+	for i := 1; i < len(rec); i++ {
+		damaged := append([]byte(nil), rec...)
+		damaged[i] ^= 0x40
+
+		if _, err := NewBinaryReader(bytes.NewReader(damaged)).Next(); !errors.Is(err, ErrCorruptedRecord) {
+			t.Errorf("record with damaged byte %d: error = %v, want %v", i, err, ErrCorruptedRecord)
+		}
+	}
+
+	for n := 1; n < len(rec); n++ {
+		if _, err := NewBinaryReader(bytes.NewReader(rec[:n])).Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("record cut to %d bytes: error = %v, want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	r := NewBinaryReader(bytes.NewReader(append(rec, rec...)))
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("error at the end = %v, want %v", err, io.EOF)
+	}
+
+	if r.BytesRead() != int64(2*len(rec)) {
+		t.Errorf("bytes read = %d, want %d", r.BytesRead(), 2*len(rec))
+	}
+}
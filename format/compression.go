@@ -0,0 +1,287 @@
+package format
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// Compression is a name of compression of dump files.
+type Compression string
+
+const (
+	// NoCompression writes records as is.
+	NoCompression Compression = "none"
+	// Gzip writes gzip members, files have .gz extension.
+	Gzip Compression = "gzip"
+	// Zstd writes zstd frames, files have .zst extension.
+	Zstd Compression = "zstd"
+	// Snappy writes streams of snappy framing format, files have .sz extension.
+	Snappy Compression = "snappy"
+	// LZ4 writes lz4 frames, files have .lz4 extension.
+	LZ4 Compression = "lz4"
+)
+
+var compressionExtensions = map[Compression]string{
+	NoCompression: "",
+	Gzip:          ".gz",
+	Zstd:          ".zst",
+	Snappy:        ".sz",
+	LZ4:           ".lz4",
+}
+
+// ParseCompression parses compression name (case insensitive), empty name means no compression.
+func ParseCompression(s string) (Compression, error) {
+	c := Compression(strings.ToLower(strings.TrimSpace(s)))
+	if c == "" {
+		return NoCompression, nil
+	}
+
+	if _, ok := compressionExtensions[c]; !ok {
+		return "", fmt.Errorf("unknown compression [%s]", s)
+	}
+
+	return c, nil
+}
+
+// Extension returns extension (with leading dot) that is appended to extension of output format
+// of compressed files, it is empty when there is no compression.
+func (c Compression) Extension() string {
+	return compressionExtensions[c]
+}
+
+// Enabled reports whether files are compressed.
+func (c Compression) Enabled() bool {
+	return c != "" && c != NoCompression
+}
+
+// CompressionOf returns compression of dump file by its extension and path without compression extension.
+func CompressionOf(path string) (Compression, string) {
+	ext := filepath.Ext(path)
+
+	for c, e := range compressionExtensions {
+		if c.Enabled() && e == ext {
+			return c, strings.TrimSuffix(path, ext)
+		}
+	}
+
+	return NoCompression, path
+}
+
+// OpenFile opens dump file for reading, compressed files are decompressed transparently.
+func OpenFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, _ := CompressionOf(path)
+	if !c.Enabled() {
+		return f, nil
+	}
+
+	r, err := NewDecompressor(c, f)
+	if err != nil {
+		_ = f.Close()
+
+		return nil, fmt.Errorf("failed to read %s stream: %w", c, err)
+	}
+
+	return readCloser{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc readCloser) Close() error {
+	var err error
+
+	for _, c := range rc.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// NewDecompressor returns reader of data decompressed from r. Concatenated frames (streams, members)
+// are read as one stream.
+func NewDecompressor(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case NoCompression:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.IOReadCloser(), nil
+	case Snappy:
+		return ioutil.NopCloser(snappy.NewReader(r)), nil
+	case LZ4:
+		return ioutil.NopCloser(lz4.NewReader(r)), nil
+	default:
+		return nil, fmt.Errorf("unknown compression [%s]", c)
+	}
+}
+
+// CompressedWriter buffers written data and writes it to underlying writer as independent compressed
+// frames: each flush compresses buffered data to a complete frame that is written by single write,
+// so file stays readable up to the last flushed frame when process is killed.
+// Data of one write is never split between frames, so records are not split either.
+type CompressedWriter struct {
+	w    io.Writer
+	size int
+	buf  bytes.Buffer
+	// frame holds compressed frame before it is written.
+	frame bytes.Buffer
+	// compress writes complete frame of passed data to frame.
+	compress func(data []byte) error
+}
+
+// NewCompressedWriter creates writer of frames of compression c, buffered data is flushed when it exceeds size.
+func NewCompressedWriter(w io.Writer, c Compression, size int) (*CompressedWriter, error) {
+	cw := &CompressedWriter{w: w, size: size}
+
+	switch c {
+	case Gzip:
+		gw := gzip.NewWriter(&cw.frame)
+		cw.compress = func(data []byte) error {
+			gw.Reset(&cw.frame)
+
+			return writeAndClose(gw, data)
+		}
+	case Zstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+
+		cw.compress = func(data []byte) error {
+			cw.frame.Write(enc.EncodeAll(data, nil))
+
+			return nil
+		}
+	case Snappy:
+		sw := snappy.NewBufferedWriter(&cw.frame)
+		cw.compress = func(data []byte) error {
+			sw.Reset(&cw.frame)
+
+			return writeAndClose(sw, data)
+		}
+	case LZ4:
+		lw := lz4.NewWriter(&cw.frame)
+		cw.compress = func(data []byte) error {
+			lw.Reset(&cw.frame)
+
+			return writeAndClose(lw, data)
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression [%s]", c)
+	}
+
+	return cw, nil
+}
+
+func writeAndClose(w io.WriteCloser, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdErr  error
+)
+
+// zstdEncoder returns encoder shared by all writers, its EncodeAll is safe for concurrent use.
+func zstdEncoder() (*zstd.Encoder, error) {
+	zstdOnce.Do(func() {
+		zstdEnc, zstdErr = zstd.NewWriter(nil)
+	})
+
+	return zstdEnc, zstdErr
+}
+
+// Write buffers data, buffered frame is flushed first when data does not fit into buffer.
+func (cw *CompressedWriter) Write(data []byte) (int, error) {
+	if cw.buf.Len() != 0 && cw.buf.Len()+len(data) > cw.size {
+		if err := cw.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	cw.buf.Write(data)
+
+	if cw.buf.Len() >= cw.size {
+		if err := cw.Flush(); err != nil {
+			return len(data), err
+		}
+	}
+
+	return len(data), nil
+}
+
+// Flush writes buffered data as one compressed frame.
+func (cw *CompressedWriter) Flush() error {
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+
+	cw.frame.Reset()
+
+	if err := cw.compress(cw.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to compress frame: %w", err)
+	}
+
+	if _, err := cw.w.Write(cw.frame.Bytes()); err != nil {
+		return err
+	}
+
+	cw.buf.Reset()
+
+	return nil
+}
+
+// Buffered returns number of bytes that are not flushed yet.
+func (cw *CompressedWriter) Buffered() int {
+	return cw.buf.Len()
+}
+
+// UncompressedSize returns size of decompressed data of file.
+func UncompressedSize(path string) (int64, error) {
+	r, err := OpenFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decompress file: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,253 @@
+package format
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+var testCompressions = []Compression{Gzip, Zstd, Snappy, LZ4}
+
+// writeCompressed writes records to compressed file of extension ext, each record is written as separate frame.
+// Returns path of file and sizes of its frames.
+func writeCompressed(t *testing.T, c Compression, ext string, records ...[]byte) (string, []int) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "segment"+ext+c.Extension())
+
+	var (
+		buf    bytes.Buffer
+		frames []int
+	)
+
+	cw, err := NewCompressedWriter(&buf, c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range records {
+		before := buf.Len()
+
+		if _, err = cw.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+
+		frames = append(frames, buf.Len()-before)
+	}
+
+	if err = ioutil.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, frames
+}
+
+// jsonlRecords returns records of messages with offsets encoded as JSON lines.
+func jsonlRecords(t *testing.T, offsets ...int64) [][]byte {
+	t.Helper()
+
+	records := make([][]byte, 0, len(offsets))
+
+	for _, offset := range offsets {
+		msg := &sarama.ConsumerMessage{Topic: "t", Offset: offset, Value: []byte("v" + strconv.FormatInt(offset, 10))}
+
+		rec, err := (jsonlEncoder{}).Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func readAll(t *testing.T, path string) []byte {
+	t.Helper()
+
+	r, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	return data
+}
+
+func TestCompressionOf(t *testing.T) {
+	for path, want := range map[string]Compression{
+		"a/0.jsonl.gz":  Gzip,
+		"a/0.bin.zst":   Zstd,
+		"a/0.raw.sz":    Snappy,
+		"a/0.jsonl.lz4": LZ4,
+		"a/0.jsonl":     NoCompression,
+		"a/0.gz.jsonl":  NoCompression,
+	} {
+		if got, _ := CompressionOf(path); got != want {
+			t.Errorf("CompressionOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// TestConcatenatedFrames checks that files of independent frames are read as one stream.
+func TestConcatenatedFrames(t *testing.T) {
+	records := jsonlRecords(t, 1, 2, 3)
+	want := bytes.Join(records, nil)
+
+	for _, c := range testCompressions {
+		t.Run(string(c), func(t *testing.T) {
+			path, frames := writeCompressed(t, c, ".jsonl", records...)
+
+			if len(frames) != len(records) {
+				t.Fatalf("frames = %v, want one frame per record", frames)
+			}
+
+			if got := readAll(t, path); !bytes.Equal(got, want) {
+				t.Errorf("OpenFile() read %q, want %q", got, want)
+			}
+
+			if size, err := UncompressedSize(path); err != nil || size != int64(len(want)) {
+				t.Errorf("UncompressedSize() = %d, %v, want %d", size, err, len(want))
+			}
+		})
+	}
+}
+
+// TestOpenFileDetection checks that OpenFile decompresses files by their extension only.
+func TestOpenFileDetection(t *testing.T) {
+	records := jsonlRecords(t, 1)
+
+	for _, c := range testCompressions {
+		path, _ := writeCompressed(t, c, ".jsonl", records...)
+
+		if got := readAll(t, path); !bytes.Equal(got, records[0]) {
+			t.Errorf("%s: OpenFile() read %q, want %q", c, got, records[0])
+		}
+
+		// the same data without compression extension is read as is.
+		plain := path[:len(path)-len(c.Extension())]
+
+		if err := os.Rename(path, plain); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := readAll(t, plain); bytes.Equal(got, records[0]) {
+			t.Errorf("%s: OpenFile() of file without compression extension decompressed it", c)
+		}
+	}
+
+	plain := filepath.Join(t.TempDir(), "segment.jsonl")
+
+	if err := ioutil.WriteFile(plain, records[0], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, plain); !bytes.Equal(got, records[0]) {
+		t.Errorf("OpenFile() of uncompressed file read %q, want %q", got, records[0])
+	}
+}
+
+// TestRecoverTruncatedFrame checks that partially written last frame is removed by Recover
+// and complete frames are kept.
+func TestRecoverTruncatedFrame(t *testing.T) {
+	records := jsonlRecords(t, 1, 2, 3)
+	want := bytes.Join(records[:2], nil)
+
+	for _, c := range testCompressions {
+		t.Run(string(c), func(t *testing.T) {
+			path, frames := writeCompressed(t, c, ".jsonl", records...)
+
+			size := int64(frames[0] + frames[1] + frames[2]/2)
+			if err := os.Truncate(path, size); err != nil {
+				t.Fatal(err)
+			}
+
+			truncated, err := Recover(jsonlEncoder{}, path)
+			if err != nil || truncated != size {
+				t.Fatalf("Recover() = %d, %v, want former size %d", truncated, err, size)
+			}
+
+			if got := readAll(t, path); !bytes.Equal(got, want) {
+				t.Errorf("recovered data = %q, want %q", got, want)
+			}
+
+			if truncated, err = Recover(jsonlEncoder{}, path); err != nil || truncated != 0 {
+				t.Errorf("Recover() of recovered segment = %d, %v, want 0", truncated, err)
+			}
+		})
+	}
+}
+
+// TestRecoverPartialRecord checks that partial record of complete frame is truncated.
+func TestRecoverPartialRecord(t *testing.T) {
+	records := jsonlRecords(t, 1, 2)
+	partial := records[1][:len(records[1])/2]
+
+	for _, c := range testCompressions {
+		t.Run(string(c), func(t *testing.T) {
+			path, _ := writeCompressed(t, c, ".jsonl", records[0], partial)
+
+			if truncated, err := Recover(jsonlEncoder{}, path); err != nil || truncated == 0 {
+				t.Fatalf("Recover() = %d, %v, want segment rewritten", truncated, err)
+			}
+
+			if got := readAll(t, path); !bytes.Equal(got, records[0]) {
+				t.Errorf("recovered data = %q, want %q", got, records[0])
+			}
+		})
+	}
+}
+
+// TestTruncateCompressedSegment checks truncation of compressed indexed segment and its index.
+func TestTruncateCompressedSegment(t *testing.T) {
+	var records [][]byte
+
+	for _, offset := range []int64{1, 2, 3, 4} {
+		records = append(records, encodeBinary(t, &sarama.ConsumerMessage{Topic: "t", Offset: offset, Value: []byte("v")}))
+	}
+
+	for _, c := range testCompressions {
+		t.Run(string(c), func(t *testing.T) {
+			path, _ := writeCompressed(t, c, ".bin", records...)
+
+			// index of compressed segment is built by recovery.
+			if truncated, err := Recover(binaryEncoder{}, path); err != nil || truncated != 0 {
+				t.Fatalf("Recover() = %d, %v, want intact segment", truncated, err)
+			}
+
+			if got := readSegmentOffsets(t, path, 2); !equalInt64s(got, []int64{2, 3, 4}) {
+				t.Errorf("offsets from 2 = %v, want [2 3 4]", got)
+			}
+
+			n, err := TruncateSegment(path, 3)
+			if err != nil || n != 2 {
+				t.Fatalf("TruncateSegment() = %d, %v, want 2 records", n, err)
+			}
+
+			if got := readSegmentOffsets(t, path, 0); !equalInt64s(got, []int64{1, 2}) {
+				t.Errorf("offsets after truncation = %v, want [1 2]", got)
+			}
+
+			if last, ok, err := LastIndexedOffset(IndexPath(path)); err != nil || !ok || last != 2 {
+				t.Errorf("LastIndexedOffset() = %d, %t, %v, want 2", last, ok, err)
+			}
+
+			if got := readAll(t, path); !bytes.Equal(got, bytes.Join(records[:2], nil)) {
+				t.Errorf("truncated segment has %d bytes, want 2 records", len(got))
+			}
+		})
+	}
+}
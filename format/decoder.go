@@ -0,0 +1,374 @@
+package format
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+)
+
+// Encodings of decoded bytes fields in JSON envelope, besides EncodingUTF8 and EncodingBase64.
+const (
+	// EncodingJSON - bytes are JSON document stored as is.
+	EncodingJSON = "json"
+	// EncodingHex - bytes are stored as lower case hex string.
+	EncodingHex = "hex"
+	// EncodingInt32 - bytes are big endian int32 stored as JSON number.
+	EncodingInt32 = "int32"
+	// EncodingInt64 - bytes are big endian int64 stored as JSON number.
+	EncodingInt64 = "int64"
+	// EncodingUUID - bytes are UUID stored as canonical string.
+	EncodingUUID = "uuid"
+)
+
+// Decoder converts bytes of message key or value to readable representation.
+type Decoder interface {
+	// Decode returns JSON value that represents b and name of its encoding.
+	Decode(b []byte) (json.RawMessage, string, error)
+}
+
+// DecoderFactory creates decoder from argument of decoder spec, e.g. "indent" of "json:indent".
+// Argument is empty when spec has no colon.
+type DecoderFactory func(arg string) (Decoder, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]DecoderFactory{
+		"raw":    noArg(rawDecoder{}),
+		"string": noArg(stringDecoder{}),
+		"json":   newJSONDecoder,
+		"hex":    noArg(hexDecoder{}),
+		"base64": noArg(base64Decoder{}),
+		"int32":  noArg(intDecoder{size: 4}),
+		"int64":  noArg(intDecoder{size: 8}),
+		"uuid":   noArg(uuidDecoder{}),
+	}
+)
+
+// RegisterDecoder adds decoder factory to registry, factory registered with the same name is replaced.
+func RegisterDecoder(name string, factory DecoderFactory) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	decoders[strings.ToLower(name)] = factory
+}
+
+// NewDecoder creates decoder by spec: registered decoder name optionally followed by colon and argument.
+func NewDecoder(spec string) (Decoder, error) {
+	name, arg := strings.TrimSpace(spec), ""
+
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name, arg = name[:i], name[i+1:]
+	}
+
+	decodersMu.RLock()
+	factory, ok := decoders[strings.ToLower(name)]
+	decodersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown decoder [%s], known decoders: %s", spec, strings.Join(DecoderNames(), ", "))
+	}
+
+	d, err := factory(arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decoder [%s]: %w", spec, err)
+	}
+
+	return d, nil
+}
+
+// DecoderNames returns sorted names of registered decoders.
+func DecoderNames() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	names := make([]string, 0, len(decoders))
+
+	for name := range decoders {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func noArg(d Decoder) DecoderFactory {
+	return func(arg string) (Decoder, error) {
+		if arg != "" {
+			return nil, errors.New("decoder has no arguments")
+		}
+
+		return d, nil
+	}
+}
+
+// IsRaw reports whether decoder keeps bytes as is.
+func IsRaw(d Decoder) bool {
+	if d == nil {
+		return true
+	}
+
+	_, ok := d.(rawDecoder)
+
+	return ok
+}
+
+// Decoders are decoders of message key and value, nil decoder keeps bytes as is.
+type Decoders struct {
+	Key   Decoder
+	Value Decoder
+}
+
+// DecodedField is key or value of message converted by decoder.
+type DecodedField struct {
+	// JSON is a decoded value, it holds representation of raw bytes returned by EncodeBytes when decoding failed.
+	JSON     json.RawMessage
+	Encoding string
+	// Err is an error of decoder.
+	Err error
+}
+
+// Failed reports whether decoder failed and raw bytes are kept.
+func (f *DecodedField) Failed() bool {
+	return f != nil && f.Err != nil
+}
+
+// Decoded is a message with key and value converted by decoders.
+// Key and Value are nil when they are kept as is: decoder is raw or bytes are null.
+type Decoded struct {
+	*sarama.ConsumerMessage
+	Key   *DecodedField
+	Value *DecodedField
+}
+
+// Decode converts key and value of message. Failed decoding is not an error: raw bytes are kept in failed field.
+func (d Decoders) Decode(msg *sarama.ConsumerMessage) *Decoded {
+	return &Decoded{
+		ConsumerMessage: msg,
+		Key:             decodeField(d.Key, msg.Key),
+		Value:           decodeField(d.Value, msg.Value),
+	}
+}
+
+func decodeField(d Decoder, b []byte) *DecodedField {
+	if IsRaw(d) || b == nil {
+		return nil
+	}
+
+	js, enc, err := d.Decode(b)
+	if err != nil {
+		js, enc = EncodeBytes(b)
+
+		return &DecodedField{JSON: js, Encoding: enc, Err: err}
+	}
+
+	return &DecodedField{JSON: js, Encoding: enc}
+}
+
+// Text returns decoded value as text: JSON strings are unquoted, other JSON values are returned as is.
+func (f *DecodedField) Text() []byte {
+	var s string
+
+	if len(f.JSON) != 0 && f.JSON[0] == '"' && json.Unmarshal(f.JSON, &s) == nil {
+		return []byte(s)
+	}
+
+	return f.JSON
+}
+
+// DecodingEncoder is implemented by encoders that write decoded keys and values.
+type DecodingEncoder interface {
+	EncodeDecoded(d *Decoded) ([]byte, error)
+}
+
+// EncodeDecoded returns record of decoded message. Encoders that do not support decoded messages
+// (e.g. binary one that keeps messages as is for restore) encode original message.
+func EncodeDecoded(enc Encoder, d *Decoded) ([]byte, error) {
+	if de, ok := enc.(DecodingEncoder); ok {
+		return de.EncodeDecoded(d)
+	}
+
+	return enc.Encode(d.ConsumerMessage)
+}
+
+type rawDecoder struct{}
+
+// Decode returns bytes as UTF-8 string when they are valid UTF-8 and as base64 otherwise.
+func (rawDecoder) Decode(b []byte) (json.RawMessage, string, error) {
+	js, enc := EncodeBytes(b)
+
+	return js, enc, nil
+}
+
+type stringDecoder struct{}
+
+// Decode returns bytes as string, they should be valid UTF-8.
+func (stringDecoder) Decode(b []byte) (json.RawMessage, string, error) {
+	if !utf8.Valid(b) {
+		return nil, "", errors.New("invalid UTF-8")
+	}
+
+	js, enc := EncodeBytes(b)
+
+	return js, enc, nil
+}
+
+type jsonDecoder struct {
+	indent bool
+}
+
+func newJSONDecoder(arg string) (Decoder, error) {
+	switch arg {
+	case "":
+		return jsonDecoder{}, nil
+	case "indent":
+		return jsonDecoder{indent: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown argument [%s], only indent is supported", arg)
+	}
+}
+
+// Decode validates JSON document and returns it compacted or re-indented.
+// JSON Lines records always have compacted documents, indentation is kept by raw format.
+func (d jsonDecoder) Decode(b []byte) (json.RawMessage, string, error) {
+	var buf bytes.Buffer
+
+	var err error
+
+	if d.indent {
+		err = json.Indent(&buf, b, "", "  ")
+	} else {
+		err = json.Compact(&buf, b)
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return buf.Bytes(), EncodingJSON, nil
+}
+
+type hexDecoder struct{}
+
+// Decode returns hex string of bytes.
+func (hexDecoder) Decode(b []byte) (json.RawMessage, string, error) {
+	return quote(hex.EncodeToString(b)), EncodingHex, nil
+}
+
+type base64Decoder struct{}
+
+// Decode returns standard base64 string of bytes.
+func (base64Decoder) Decode(b []byte) (json.RawMessage, string, error) {
+	return quote(base64.StdEncoding.EncodeToString(b)), EncodingBase64, nil
+}
+
+type intDecoder struct {
+	size int
+}
+
+// Decode returns big endian integer as JSON number.
+func (d intDecoder) Decode(b []byte) (json.RawMessage, string, error) {
+	if len(b) != d.size {
+		return nil, "", fmt.Errorf("expected %d bytes of int%d, got %d", d.size, d.size*8, len(b))
+	}
+
+	if d.size == 4 {
+		return json.RawMessage(strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(b))), 10)), EncodingInt32, nil
+	}
+
+	return json.RawMessage(strconv.FormatInt(int64(binary.BigEndian.Uint64(b)), 10)), EncodingInt64, nil
+}
+
+type uuidDecoder struct{}
+
+const uuidSize = 16
+
+// Decode returns 16 bytes as canonical UUID string.
+func (uuidDecoder) Decode(b []byte) (json.RawMessage, string, error) {
+	if len(b) != uuidSize {
+		return nil, "", fmt.Errorf("expected %d bytes of UUID, got %d", uuidSize, len(b))
+	}
+
+	h := hex.EncodeToString(b)
+
+	return quote(h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]), EncodingUUID, nil
+}
+
+func quote(s string) json.RawMessage {
+	// marshaling of string never fails.
+	js, _ := json.Marshal(s)
+
+	return js
+}
+
+// reverseDecoded converts value written by decoder with encoding enc back to bytes.
+// Documents of json encoding are restored compacted.
+func reverseDecoded(js json.RawMessage, enc string) ([]byte, error) {
+	if enc == EncodingJSON {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, js); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	if enc == EncodingInt32 || enc == EncodingInt64 {
+		return reverseInt(js, enc)
+	}
+
+	if enc != EncodingHex && enc != EncodingUUID {
+		return nil, fmt.Errorf("encoding [%s]: %w", enc, ErrNotReversible)
+	}
+
+	var s string
+	if err := json.Unmarshal(js, &s); err != nil {
+		return nil, err
+	}
+
+	if enc == EncodingHex {
+		return hex.DecodeString(s)
+	}
+
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err == nil && len(b) != uuidSize {
+		err = fmt.Errorf("invalid UUID [%s]", s)
+	}
+
+	return b, err
+}
+
+func reverseInt(js json.RawMessage, enc string) ([]byte, error) {
+	if enc == EncodingInt32 {
+		v, err := strconv.ParseInt(string(js), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(v))
+
+		return b, nil
+	}
+
+	v, err := strconv.ParseInt(string(js), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+
+	return b, nil
+}
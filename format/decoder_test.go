@@ -0,0 +1,151 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestNewDecoder(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr string
+	}{
+		{spec: "raw"},
+		{spec: " JSON:indent "},
+		{spec: "uuid"},
+		{spec: "nope", wantErr: "unknown decoder [nope]"},
+		{spec: "json:x", wantErr: "unknown argument [x]"},
+		{spec: "hex:1", wantErr: "decoder has no arguments"},
+	}
+
+	for _, tc := range tests {
+		_, err := NewDecoder(tc.spec)
+
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("NewDecoder(%q) = %v", tc.spec, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("NewDecoder(%q) error = %v, want %q", tc.spec, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	tests := []struct {
+		spec     string
+		in       []byte
+		wantJSON string
+		wantEnc  string
+		wantErr  string
+	}{
+		{spec: "raw", in: []byte("text"), wantJSON: `"text"`, wantEnc: EncodingUTF8},
+		{spec: "raw", in: []byte{0xff}, wantJSON: `"/w=="`, wantEnc: EncodingBase64},
+		{spec: "string", in: []byte("hello"), wantJSON: `"hello"`, wantEnc: EncodingUTF8},
+		{spec: "string", in: []byte{0xff, 0xfe}, wantErr: "invalid UTF-8"},
+		{spec: "json", in: []byte(`{ "a" : [1, 2] }`), wantJSON: `{"a":[1,2]}`, wantEnc: EncodingJSON},
+		{spec: "json:indent", in: []byte(`{"a":1}`), wantJSON: "{\n  \"a\": 1\n}", wantEnc: EncodingJSON},
+		{spec: "json", in: []byte(`{bad`), wantErr: "invalid JSON"},
+		{spec: "hex", in: []byte{1, 2, 0xff}, wantJSON: `"0102ff"`, wantEnc: EncodingHex},
+		{spec: "base64", in: []byte("hi"), wantJSON: `"aGk="`, wantEnc: EncodingBase64},
+		{spec: "int32", in: []byte{0xff, 0xff, 0xff, 0xfe}, wantJSON: "-2", wantEnc: EncodingInt32},
+		{spec: "int32", in: []byte{0, 1}, wantErr: "expected 4 bytes of int32, got 2"},
+		{spec: "int64", in: []byte{0, 0, 0, 0, 0, 0, 1, 0}, wantJSON: "256", wantEnc: EncodingInt64},
+		{spec: "int64", in: []byte{1}, wantErr: "expected 8 bytes of int64, got 1"},
+		{
+			spec:     "uuid",
+			in:       []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+			wantJSON: `"123e4567-e89b-12d3-a456-426614174000"`,
+			wantEnc:  EncodingUUID,
+		},
+		{spec: "uuid", in: []byte{1}, wantErr: "expected 16 bytes of UUID, got 1"},
+	}
+
+	for _, tc := range tests {
+		d, err := NewDecoder(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		js, enc, err := d.Decode(tc.in)
+
+		switch {
+		case tc.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: Decode(%x) error = %v, want %q", tc.spec, tc.in, err, tc.wantErr)
+			}
+		case err != nil || string(js) != tc.wantJSON || enc != tc.wantEnc:
+			t.Errorf("%s: Decode(%x) = %s, %s, %v, want %s, %s", tc.spec, tc.in, js, enc, err, tc.wantJSON, tc.wantEnc)
+		}
+	}
+}
+
+func TestDecodeFailed(t *testing.T) {
+	d, err := NewDecoder("int32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := Decoders{Key: d, Value: d}.Decode(&sarama.ConsumerMessage{Key: []byte("key")})
+
+	if decoded.Value != nil {
+		t.Errorf("null value is decoded to %+v", decoded.Value)
+	}
+
+	if !decoded.Key.Failed() || string(decoded.Key.JSON) != `"key"` || decoded.Key.Encoding != EncodingUTF8 {
+		t.Errorf("failed key is decoded to %+v, want raw bytes kept", decoded.Key)
+	}
+
+	if raw := (Decoders{}).Decode(&sarama.ConsumerMessage{Key: []byte("key")}); raw.Key != nil {
+		t.Errorf("key is decoded by nil decoder to %+v", raw.Key)
+	}
+}
+
+func TestReverseDecoded(t *testing.T) {
+	tests := []struct {
+		js      string
+		enc     string
+		want    []byte
+		wantErr error
+	}{
+		{js: "{\n  \"a\": 1\n}", enc: EncodingJSON, want: []byte(`{"a":1}`)},
+		{js: `"0102ff"`, enc: EncodingHex, want: []byte{1, 2, 0xff}},
+		{js: "-2", enc: EncodingInt32, want: []byte{0xff, 0xff, 0xff, 0xfe}},
+		{js: "256", enc: EncodingInt64, want: []byte{0, 0, 0, 0, 0, 0, 1, 0}},
+		{
+			js:   `"123e4567-e89b-12d3-a456-426614174000"`,
+			enc:  EncodingUUID,
+			want: []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+		},
+		{js: `{"id":1}`, enc: "avro", wantErr: ErrNotReversible},
+		{js: `[{"field":1,"type":"varint","value":1}]`, enc: "protobuf", wantErr: ErrNotReversible},
+	}
+
+	for _, tc := range tests {
+		got, err := reverseDecoded(json.RawMessage(tc.js), tc.enc)
+
+		switch {
+		case tc.wantErr != nil:
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("reverseDecoded(%s, %s) error = %v, want %v", tc.js, tc.enc, err, tc.wantErr)
+			}
+		case err != nil || !bytes.Equal(got, tc.want):
+			t.Errorf("reverseDecoded(%s, %s) = %x, %v, want %x", tc.js, tc.enc, got, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct{ js, enc string }{
+		{js: `{bad`, enc: EncodingJSON},
+		{js: `"zz"`, enc: EncodingHex},
+		{js: "2147483648", enc: EncodingInt32},
+		{js: `"123e4567"`, enc: EncodingUUID},
+	} {
+		if _, err := reverseDecoded(json.RawMessage(tc.js), tc.enc); err == nil || errors.Is(err, ErrNotReversible) {
+			t.Errorf("reverseDecoded(%s, %s) error = %v, want invalid value", tc.js, tc.enc, err)
+		}
+	}
+}
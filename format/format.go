@@ -0,0 +1,54 @@
+// Package format implements on-disk representations of dumped kafka messages.
+package format
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// Format is a name of output format for dumped messages.
+type Format string
+
+const (
+	// Raw writes only message value followed by the record separator.
+	Raw Format = "raw"
+	// JSONL writes each message as one JSON object per line with all message metadata.
+	JSONL Format = "jsonl"
+)
+
+// Encoder serializes consumed messages into records of a specific format.
+type Encoder interface {
+	// Encode returns bytes of one record for passed message.
+	Encode(msg *sarama.ConsumerMessage) ([]byte, error)
+	// Extension returns file extension (with leading dot) of files with records of this format.
+	Extension() string
+}
+
+// Parse parses format name (case insensitive).
+func Parse(s string) (Format, error) {
+	f := Format(strings.ToLower(strings.TrimSpace(s)))
+
+	switch f {
+	case Raw, JSONL, Binary:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format [%s]", s)
+	}
+}
+
+// NewEncoder creates encoder for passed format.
+// recordSeparator is used only by Raw format.
+func NewEncoder(f Format, recordSeparator string) (Encoder, error) {
+	switch f {
+	case Raw:
+		return newRawEncoder(recordSeparator), nil
+	case JSONL:
+		return jsonlEncoder{}, nil
+	case Binary:
+		return binaryEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format [%s]", f)
+	}
+}
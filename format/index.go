@@ -0,0 +1,174 @@
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// IndexExtension is appended to segment file name to get name of its offset index file.
+const IndexExtension = ".idx"
+
+// IndexEntrySize is a size in bytes of one index entry: big endian int64 offset and int64 byte position.
+const IndexEntrySize = 16
+
+// ErrOffsetNotFound returned when index has no records with offset greater or equal than requested.
+var ErrOffsetNotFound = errors.New("offset not found in index")
+
+// Indexed reports whether segments of encoder format should have sidecar offset index.
+func Indexed(enc Encoder) bool {
+	i, ok := enc.(interface{ Indexed() bool })
+
+	return ok && i.Indexed()
+}
+
+// IndexPath returns path of offset index file of segment.
+func IndexPath(segmentPath string) string {
+	return segmentPath + IndexExtension
+}
+
+// IndexEntry returns index entry that points record with offset to byte position in segment.
+func IndexEntry(offset, position int64) []byte {
+	e := make([]byte, IndexEntrySize)
+
+	binary.BigEndian.PutUint64(e[:8], uint64(offset))
+	binary.BigEndian.PutUint64(e[8:], uint64(position))
+
+	return e
+}
+
+// LastIndexedOffset returns offset of the last entry of index file, false is returned for missing or empty index.
+func LastIndexedOffset(indexPath string) (int64, bool, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to stat index: %w", err)
+	}
+
+	n := st.Size() / IndexEntrySize
+	if n == 0 {
+		return 0, false, nil
+	}
+
+	var buf [IndexEntrySize]byte
+
+	if _, err = f.ReadAt(buf[:], (n-1)*IndexEntrySize); err != nil {
+		return 0, false, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	return int64(binary.BigEndian.Uint64(buf[:8])), true, nil
+}
+
+// LookupOffset searches index file for the first record with offset greater or equal than passed one
+// and returns its offset and byte position in segment.
+// Writers append only entries with offsets greater than the last one (see LastIndexedOffset), so binary search
+// is used and only log2(n) entries are read. Records without entries (offset was not greater than the last
+// indexed one) follow an entry with greater or equal offset, so reading from found position never misses them.
+func LookupOffset(indexPath string, offset int64) (foundOffset, position int64, err error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	st, err := f.Stat()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat index: %w", err)
+	}
+
+	n := int(st.Size() / IndexEntrySize)
+
+	var (
+		buf     [IndexEntrySize]byte
+		readErr error
+	)
+
+	entry := func(i int) (int64, int64) {
+		if _, rerr := f.ReadAt(buf[:], int64(i)*IndexEntrySize); rerr != nil && !errors.Is(rerr, io.EOF) {
+			readErr = rerr
+		}
+
+		return int64(binary.BigEndian.Uint64(buf[:8])), int64(binary.BigEndian.Uint64(buf[8:]))
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		o, _ := entry(i)
+
+		return o >= offset
+	})
+
+	if readErr != nil {
+		return 0, 0, fmt.Errorf("failed to read index: %w", readErr)
+	}
+
+	if i == n {
+		return 0, 0, ErrOffsetNotFound
+	}
+
+	foundOffset, position = entry(i)
+	if readErr != nil {
+		return 0, 0, fmt.Errorf("failed to read index: %w", readErr)
+	}
+
+	return foundOffset, position, nil
+}
+
+// SeekOffset opens binary segment positioned at the first record with offset greater or equal than passed one.
+// Positions of compressed segments refer to decompressed data, so such segments are decompressed up to position.
+func SeekOffset(segmentPath string, offset int64) (*BinaryReader, io.Closer, error) {
+	_, pos, err := LookupOffset(IndexPath(segmentPath), offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c, _ := CompressionOf(segmentPath); c.Enabled() {
+		return seekCompressed(segmentPath, pos)
+	}
+
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	if _, err = f.Seek(pos, io.SeekStart); err != nil {
+		_ = f.Close()
+
+		return nil, nil, fmt.Errorf("failed to seek segment: %w", err)
+	}
+
+	return NewBinaryReader(f), f, nil
+}
+
+func seekCompressed(segmentPath string, pos int64) (*BinaryReader, io.Closer, error) {
+	r, err := OpenFile(segmentPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	if _, err = io.CopyN(ioutil.Discard, r, pos); err != nil {
+		_ = r.Close()
+
+		return nil, nil, fmt.Errorf("failed to seek segment: %w", err)
+	}
+
+	return NewBinaryReader(r), r, nil
+}
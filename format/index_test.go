@@ -0,0 +1,158 @@
+package format
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// writeSegment writes binary segment of messages with offsets and its index built like recovery does.
+func writeSegment(t *testing.T, offsets ...int64) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "segment.bin")
+
+	var data []byte
+
+	for _, offset := range offsets {
+		data = append(data, encodeBinary(t, &sarama.ConsumerMessage{Topic: "t", Offset: offset, Value: []byte("v")})...)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (binaryEncoder{}).recoverSegment(path); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func readSegmentOffsets(t *testing.T, path string, from int64) []int64 {
+	t.Helper()
+
+	r, c, err := SeekOffset(path, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = c.Close()
+	}()
+
+	var offsets []int64
+
+	for {
+		msg, err := r.Next()
+		if err != nil {
+			return offsets
+		}
+
+		offsets = append(offsets, msg.Offset)
+	}
+}
+
+func TestLookupOffset(t *testing.T) {
+	path := writeSegment(t, 10, 11, 13, 20)
+	recordSize := int64(len(encodeBinary(t, &sarama.ConsumerMessage{Topic: "t", Offset: 10, Value: []byte("v")})))
+
+	tests := []struct {
+		offset       int64
+		wantOffset   int64
+		wantPosition int64
+		wantErr      error
+	}{
+		{offset: 0, wantOffset: 10, wantPosition: 0},
+		{offset: 10, wantOffset: 10, wantPosition: 0},
+		{offset: 12, wantOffset: 13, wantPosition: 2 * recordSize},
+		{offset: 20, wantOffset: 20, wantPosition: 3 * recordSize},
+		{offset: 21, wantErr: ErrOffsetNotFound},
+	}
+
+	for _, tc := range tests {
+		offset, position, err := LookupOffset(IndexPath(path), tc.offset)
+		if !errors.Is(err, tc.wantErr) || offset != tc.wantOffset || position != tc.wantPosition {
+			t.Errorf("LookupOffset(%d) = %d, %d, %v, want %d, %d, %v",
+				tc.offset, offset, position, err, tc.wantOffset, tc.wantPosition, tc.wantErr)
+		}
+	}
+
+	if last, ok, err := LastIndexedOffset(IndexPath(path)); err != nil || !ok || last != 20 {
+		t.Errorf("LastIndexedOffset = %d, %v, %v, want 20", last, ok, err)
+	}
+
+	if _, ok, err := LastIndexedOffset(filepath.Join(t.TempDir(), "missing.idx")); err != nil || ok {
+		t.Errorf("LastIndexedOffset of missing index = %v, %v", ok, err)
+	}
+}
+
+// TestIndexOfRewrittenSegment checks that records with offsets lower than already indexed ones
+// (segment written again after offsets reset) are not indexed and are not missed by seek.
+func TestIndexOfRewrittenSegment(t *testing.T) {
+	path := writeSegment(t, 5, 6, 7, 5, 6, 7, 8)
+
+	entries, err := readIndex(IndexPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < len(entries); i++ {
+		if entries[i].offset <= entries[i-1].offset {
+			t.Fatalf("index entries are not increasing: %v", entries)
+		}
+	}
+
+	for from, want := range map[int64][]int64{
+		5: {5, 6, 7, 5, 6, 7, 8},
+		6: {6, 7, 5, 6, 7, 8},
+		8: {8},
+	} {
+		if got := readSegmentOffsets(t, path, from); !equalInt64s(got, want) {
+			t.Errorf("offsets from %d = %v, want %v", from, got, want)
+		}
+	}
+}
+
+func TestTruncateSegment(t *testing.T) {
+	path := writeSegment(t, 1, 2, 3, 4)
+
+	n, err := TruncateSegment(path, 3)
+	if err != nil || n != 2 {
+		t.Fatalf("TruncateSegment = %d, %v, want 2 records", n, err)
+	}
+
+	if got := readSegmentOffsets(t, path, 0); !equalInt64s(got, []int64{1, 2}) {
+		t.Errorf("offsets after truncation = %v", got)
+	}
+
+	if last, _, _ := LastIndexedOffset(IndexPath(path)); last != 2 {
+		t.Errorf("last indexed offset = %d, want 2", last)
+	}
+
+	if n, err = TruncateSegment(path, 10); err != nil || n != 0 {
+		t.Errorf("TruncateSegment after the last record = %d, %v", n, err)
+	}
+
+	if _, err = os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func equalInt64s(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
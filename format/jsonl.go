@@ -0,0 +1,213 @@
+package format
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+)
+
+// Encodings of bytes fields in JSON envelope.
+const (
+	// EncodingUTF8 - bytes are valid UTF-8 and stored as JSON string.
+	EncodingUTF8 = "utf8"
+	// EncodingBase64 - bytes are binary and stored as standard base64 JSON string.
+	EncodingBase64 = "base64"
+)
+
+// Timestamp types of dumped message.
+// sarama does not expose timestamp type attribute of consumed messages, so it could be detected
+// only by presence of timestamp.
+const (
+	// TimestampCreateTime - timestamp is set by producer or broker.
+	TimestampCreateTime = "CreateTime"
+	// TimestampNone - message has no timestamp (message format before kafka 0.10).
+	TimestampNone = "NoTimestampType"
+)
+
+// Envelope is a JSON representation of dumped message.
+type Envelope struct {
+	Topic          string           `json:"topic"`
+	Partition      int32            `json:"partition"`
+	Offset         int64            `json:"offset"`
+	Key            json.RawMessage  `json:"key"`
+	KeyEncoding    string           `json:"key_encoding,omitempty"`
+	KeyDecodeError bool             `json:"key_decode_error,omitempty"`
+	Headers        []EnvelopeHeader `json:"headers,omitempty"`
+	Timestamp      *time.Time       `json:"timestamp,omitempty"`
+	TimestampType  string           `json:"timestamp_type"`
+	BlockTimestamp *time.Time       `json:"block_timestamp,omitempty"`
+	Value          json.RawMessage  `json:"value"`
+	ValueEncoding  string           `json:"value_encoding,omitempty"`
+	// ValueDecodeError and KeyDecodeError are set when decoder failed and raw bytes are stored.
+	ValueDecodeError bool `json:"value_decode_error,omitempty"`
+	Tombstone        bool `json:"tombstone,omitempty"`
+}
+
+// EnvelopeHeader is a JSON representation of kafka record header.
+type EnvelopeHeader struct {
+	Key           string          `json:"key"`
+	Value         json.RawMessage `json:"value"`
+	ValueEncoding string          `json:"value_encoding,omitempty"`
+}
+
+type jsonlEncoder struct{}
+
+// Encode returns JSON envelope of message followed by new line.
+func (e jsonlEncoder) Encode(msg *sarama.ConsumerMessage) ([]byte, error) {
+	return e.marshal(NewEnvelope(msg))
+}
+
+// EncodeDecoded returns JSON envelope of message with decoded key and value followed by new line.
+func (e jsonlEncoder) EncodeDecoded(d *Decoded) ([]byte, error) {
+	env := NewEnvelope(d.ConsumerMessage)
+
+	if d.Key != nil {
+		env.Key, env.KeyEncoding, env.KeyDecodeError = d.Key.JSON, d.Key.Encoding, d.Key.Failed()
+	}
+
+	if d.Value != nil {
+		env.Value, env.ValueEncoding, env.ValueDecodeError = d.Value.JSON, d.Value.Encoding, d.Value.Failed()
+	}
+
+	return e.marshal(env)
+}
+
+func (jsonlEncoder) marshal(env *Envelope) ([]byte, error) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope for offset %d: %w", env.Offset, err)
+	}
+
+	return append(b, '\n'), nil
+}
+
+// Extension of JSON Lines files.
+func (jsonlEncoder) Extension() string {
+	return ".jsonl"
+}
+
+// NewEnvelope creates JSON envelope of message.
+func NewEnvelope(msg *sarama.ConsumerMessage) *Envelope {
+	env := &Envelope{
+		Topic:         msg.Topic,
+		Partition:     msg.Partition,
+		Offset:        msg.Offset,
+		TimestampType: TimestampNone,
+		Tombstone:     msg.Value == nil,
+	}
+
+	env.Key, env.KeyEncoding = EncodeBytes(msg.Key)
+	env.Value, env.ValueEncoding = EncodeBytes(msg.Value)
+
+	if !msg.Timestamp.IsZero() {
+		ts := msg.Timestamp
+		env.Timestamp = &ts
+		env.TimestampType = TimestampCreateTime
+	}
+
+	if !msg.BlockTimestamp.IsZero() {
+		bts := msg.BlockTimestamp
+		env.BlockTimestamp = &bts
+	}
+
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+
+		eh := EnvelopeHeader{Key: string(h.Key)}
+		eh.Value, eh.ValueEncoding = EncodeBytes(h.Value)
+
+		env.Headers = append(env.Headers, eh)
+	}
+
+	return env
+}
+
+// EncodeBytes returns JSON representation of bytes and its encoding.
+// nil bytes are represented as JSON null with empty encoding.
+func EncodeBytes(b []byte) (json.RawMessage, string) {
+	if b == nil {
+		return json.RawMessage("null"), ""
+	}
+
+	var (
+		s   string
+		enc string
+	)
+
+	if utf8.Valid(b) {
+		s, enc = string(b), EncodingUTF8
+	} else {
+		s, enc = base64.StdEncoding.EncodeToString(b), EncodingBase64
+	}
+
+	// marshaling of string never fails.
+	js, _ := json.Marshal(s)
+
+	return js, enc
+}
+
+// Message converts envelope back to message.
+func (env *Envelope) Message() (*sarama.ConsumerMessage, error) {
+	msg := &sarama.ConsumerMessage{
+		Topic:     env.Topic,
+		Partition: env.Partition,
+		Offset:    env.Offset,
+	}
+
+	var err error
+
+	if msg.Key, err = DecodeBytes(env.Key, env.KeyEncoding); err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+
+	if msg.Value, err = DecodeBytes(env.Value, env.ValueEncoding); err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+
+	if env.Timestamp != nil {
+		msg.Timestamp = *env.Timestamp
+	}
+
+	if env.BlockTimestamp != nil {
+		msg.BlockTimestamp = *env.BlockTimestamp
+	}
+
+	for _, eh := range env.Headers {
+		v, herr := DecodeBytes(eh.Value, eh.ValueEncoding)
+		if herr != nil {
+			return nil, fmt.Errorf("invalid header [%s]: %w", eh.Key, herr)
+		}
+
+		msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(eh.Key), Value: v})
+	}
+
+	return msg, nil
+}
+
+// DecodeBytes decodes bytes from JSON representation returned by EncodeBytes or by built-in decoders.
+func DecodeBytes(js json.RawMessage, enc string) ([]byte, error) {
+	if len(js) == 0 || string(js) == "null" {
+		return nil, nil
+	}
+
+	if enc != EncodingUTF8 && enc != EncodingBase64 && enc != "" {
+		return reverseDecoded(js, enc)
+	}
+
+	var s string
+	if err := json.Unmarshal(js, &s); err != nil {
+		return nil, err
+	}
+
+	if enc == EncodingBase64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+
+	return []byte(s), nil
+}
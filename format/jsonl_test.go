@@ -0,0 +1,124 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestJSONLEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  sarama.ConsumerMessage
+		want string
+	}{
+		{
+			name: "text and binary",
+			msg: sarama.ConsumerMessage{
+				Topic:          "orders",
+				Partition:      3,
+				Offset:         42,
+				Key:            []byte("cust-7"),
+				Value:          []byte{0xff, 0x00},
+				Headers:        []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte(`"id"`)}, nil, {Key: []byte("empty")}},
+				Timestamp:      time.Date(2021, time.February, 3, 10, 0, 0, 5, time.UTC),
+				BlockTimestamp: time.Date(2021, time.February, 3, 10, 0, 1, 0, time.UTC),
+			},
+			want: `{"topic":"orders","partition":3,"offset":42,"key":"cust-7","key_encoding":"utf8",` +
+				`"headers":[{"key":"trace","value":"\"id\"","value_encoding":"utf8"},{"key":"empty","value":null}],` +
+				`"timestamp":"2021-02-03T10:00:00.000000005Z","timestamp_type":"CreateTime",` +
+				`"block_timestamp":"2021-02-03T10:00:01Z","value":"/wA=","value_encoding":"base64"}` + "\n",
+		},
+		{
+			name: "tombstone without timestamp",
+			msg:  sarama.ConsumerMessage{Topic: "orders", Key: []byte{}},
+			want: `{"topic":"orders","partition":0,"offset":0,"key":"","key_encoding":"utf8",` +
+				`"timestamp_type":"NoTimestampType","value":null,"tombstone":true}` + "\n",
+		},
+	}
+
+	for _, tc := range tests {
+		got, err := (jsonlEncoder{}).Encode(&tc.msg)
+		if err != nil || string(got) != tc.want {
+			t.Errorf("%s: Encode() = %s, %v, want %s", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+	msgs := []*sarama.ConsumerMessage{
+		{Topic: "t", Offset: 1},
+		{Topic: "t", Offset: 2, Key: []byte{}, Value: []byte{}},
+		{
+			Topic:     "t",
+			Partition: 7,
+			Offset:    1 << 40,
+			Key:       []byte{0, 1, 2},
+			Value:     []byte("значение\n{\"json\": true}"),
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte("k"), Value: []byte("v")},
+				{Key: []byte("binary"), Value: []byte{0xfe}},
+				{Key: []byte("null"), Value: nil},
+			},
+			Timestamp:      time.Date(2021, time.February, 3, 10, 0, 0, 123456789, time.UTC),
+			BlockTimestamp: time.Date(2021, time.February, 3, 10, 0, 1, 0, time.UTC),
+		},
+	}
+
+	var buf bytes.Buffer
+
+	for _, msg := range msgs {
+		rec, err := (jsonlEncoder{}).Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf.Write(rec)
+		// empty lines are skipped by reader.
+		buf.WriteString("\n")
+	}
+
+	r, err := NewReader(JSONL, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range msgs {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decoded message = %+v, want %+v", got, want)
+		}
+	}
+
+	if _, err = r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Next() after the last record = %v, want EOF", err)
+	}
+}
+
+func TestJSONLReaderErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"partial record":   `{"topic":"t","offset":1`,
+		"invalid json":     "{\n",
+		"invalid base64":   `{"topic":"t","key":"!","key_encoding":"base64","value":null}` + "\n",
+		"invalid header":   `{"topic":"t","headers":[{"key":"h","value":"!","value_encoding":"base64"}],"value":null}` + "\n",
+		"non-string value": `{"topic":"t","key":null,"value":1,"value_encoding":"utf8"}` + "\n",
+	} {
+		r, err := NewReader(JSONL, bytes.NewBufferString(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg, err := r.Next(); err == nil {
+			t.Errorf("%s: Next() = %+v, want error", name, msg)
+		}
+	}
+}
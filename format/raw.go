@@ -0,0 +1,56 @@
+package format
+
+import (
+	"strconv"
+
+	"github.com/Shopify/sarama"
+)
+
+type rawEncoder struct {
+	separator []byte
+}
+
+func newRawEncoder(separator string) rawEncoder {
+	return rawEncoder{separator: []byte(UnescapeSeparator(separator))}
+}
+
+// Encode returns message value followed by the separator.
+func (e rawEncoder) Encode(msg *sarama.ConsumerMessage) ([]byte, error) {
+	rec := make([]byte, 0, len(msg.Value)+len(e.separator))
+	rec = append(rec, msg.Value...)
+	rec = append(rec, e.separator...)
+
+	return rec, nil
+}
+
+// EncodeDecoded returns decoded message value followed by the separator.
+// Value is written as is when it is not decoded or decoder failed.
+func (e rawEncoder) EncodeDecoded(d *Decoded) ([]byte, error) {
+	if d.Value == nil || d.Value.Failed() {
+		return e.Encode(d.ConsumerMessage)
+	}
+
+	value := d.Value.Text()
+
+	rec := make([]byte, 0, len(value)+len(e.separator))
+	rec = append(rec, value...)
+	rec = append(rec, e.separator...)
+
+	return rec, nil
+}
+
+// Extension of raw files.
+func (rawEncoder) Extension() string {
+	return ".txt"
+}
+
+// UnescapeSeparator interprets Go escape sequences (e.g. `\n`, `\x00`, `\r\n`) in separator
+// passed from config file, flags or environment. When separator could not be unescaped it is returned as is.
+func UnescapeSeparator(s string) string {
+	u, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return s
+	}
+
+	return u
+}
@@ -0,0 +1,99 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrNotRestorable is returned for formats that do not keep message metadata and could not be read back.
+var ErrNotRestorable = errors.New("format does not keep message metadata")
+
+// ErrNotReversible is returned for records with keys, values or headers written by decoders
+// which output could not be converted back to bytes, e.g. avro or protobuf.
+var ErrNotReversible = errors.New("decoded value could not be converted back to bytes")
+
+// Reader reads messages back from dump file.
+type Reader interface {
+	// Next returns next message. At the end of file io.EOF is returned.
+	// When file ends in the middle of record io.ErrUnexpectedEOF is returned.
+	Next() (*sarama.ConsumerMessage, error)
+}
+
+// NewReader creates reader of records of passed format.
+func NewReader(f Format, r io.Reader) (Reader, error) {
+	switch f {
+	case JSONL:
+		return &jsonlReader{r: bufio.NewReader(r)}, nil
+	case Binary:
+		return NewBinaryReader(r), nil
+	case Raw:
+		return nil, fmt.Errorf("%s: %w", f, ErrNotRestorable)
+	default:
+		return nil, fmt.Errorf("unknown output format [%s]", f)
+	}
+}
+
+// FormatOf returns format of dump file by its extension, compression extension is ignored.
+// Index files and files of unknown formats are reported as not found.
+func FormatOf(path string) (Format, bool) {
+	_, path = CompressionOf(path)
+
+	switch filepath.Ext(path) {
+	case jsonlEncoder{}.Extension():
+		return JSONL, true
+	case binaryEncoder{}.Extension():
+		return Binary, true
+	case rawEncoder{}.Extension():
+		return Raw, true
+	default:
+		return "", false
+	}
+}
+
+type jsonlReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// Next decodes envelope from the next line.
+func (jr *jsonlReader) Next() (*sarama.ConsumerMessage, error) {
+	for {
+		b, err := jr.r.ReadBytes('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+
+			if len(bytes.TrimSpace(b)) == 0 {
+				return nil, io.EOF
+			}
+
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		jr.line++
+
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+
+		var env Envelope
+		if err = json.Unmarshal(b, &env); err != nil {
+			return nil, fmt.Errorf("line %d: %w", jr.line, err)
+		}
+
+		msg, err := env.Message()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", jr.line, err)
+		}
+
+		return msg, nil
+	}
+}
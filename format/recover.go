@@ -0,0 +1,497 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// recoverer is implemented by encoders that could detect partial trailing record in their segments.
+type recoverer interface {
+	recoverSegment(path string) (int64, error)
+}
+
+// Recover truncates partial trailing record that could be left in segment written by encoder
+// when process crashed in the middle of write. For indexed formats index is repaired as well:
+// entries of truncated records are removed and missing entries of complete records are added.
+// Compressed segments are decompressed up to the first damaged frame and recovered the same way,
+// damaged segment is compressed again as a whole.
+// Returns number of truncated bytes of segment, for rewritten compressed segment its former size is returned.
+func Recover(enc Encoder, path string) (int64, error) {
+	if c, _ := CompressionOf(path); c.Enabled() {
+		return recoverCompressed(enc, path, c)
+	}
+
+	r, ok := enc.(recoverer)
+	if !ok {
+		return 0, nil
+	}
+
+	return r.recoverSegment(path)
+}
+
+// recoverFrameSize is a size of uncompressed data of frames of recovered segment.
+const recoverFrameSize = 64 * 1024
+
+// recoverCompressed decompresses segment to temporary file that is recovered as uncompressed segment,
+// segment is compressed again from it only when damaged frame or partial record was found.
+// Index of compressed segment is rebuilt from all records, as its positions refer to decompressed data.
+func recoverCompressed(enc Encoder, path string, c Compression) (int64, error) {
+	size, err := fileSize(path)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := path + ".recover"
+
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	complete, err := decompressTo(path, c, tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	var truncated int64
+
+	if r, ok := enc.(recoverer); ok {
+		if truncated, err = r.recoverSegment(tmp); err != nil {
+			return 0, err
+		}
+	}
+
+	if Indexed(enc) {
+		if err = os.Rename(IndexPath(tmp), IndexPath(path)); err != nil {
+			return 0, fmt.Errorf("failed to replace index: %w", err)
+		}
+	}
+
+	if complete && truncated == 0 {
+		return 0, nil
+	}
+
+	if err = compressTo(tmp, c, path); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// TruncateSegment removes records of indexed segment starting from the first one with offset greater
+// or equal than passed one, together with their index entries. Compressed segment is decompressed,
+// truncated and compressed again. Returns number of removed records.
+func TruncateSegment(path string, offset int64) (int, error) {
+	entries, err := readIndex(IndexPath(path))
+	if err != nil {
+		return 0, err
+	}
+
+	i := 0
+	for i < len(entries) && entries[i].offset < offset {
+		i++
+	}
+
+	if i == len(entries) {
+		return 0, nil
+	}
+
+	position := entries[i].position
+
+	if c, _ := CompressionOf(path); c.Enabled() {
+		err = truncateCompressed(path, c, position)
+	} else {
+		err = os.Truncate(path, position)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to truncate segment: %w", err)
+	}
+
+	if err = writeIndex(IndexPath(path), entries[:i]); err != nil {
+		return 0, err
+	}
+
+	return len(entries) - i, nil
+}
+
+// truncateCompressed replaces compressed file with its decompressed data truncated to size.
+func truncateCompressed(path string, c Compression, size int64) error {
+	tmp := path + ".truncate"
+
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	if _, err := decompressTo(path, c, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Truncate(tmp, size); err != nil {
+		return err
+	}
+
+	return compressTo(tmp, c, path)
+}
+
+// decompressTo writes decompressed data of file to dst and reports whether all its frames are valid.
+// Data of damaged frame is written up to the damage.
+func decompressTo(path string, c Compression, dst string) (bool, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("failed to create decompressed segment: %w", err)
+	}
+
+	complete := false
+
+	r, err := NewDecompressor(c, in)
+	if err == nil {
+		w := &errWriter{w: out}
+
+		_, err = io.Copy(w, r)
+		if w.err != nil {
+			_ = out.Close()
+
+			return false, fmt.Errorf("failed to write decompressed segment: %w", w.err)
+		}
+
+		complete = err == nil
+
+		_ = r.Close()
+	}
+
+	if err = out.Close(); err != nil {
+		return false, fmt.Errorf("failed to close decompressed segment: %w", err)
+	}
+
+	return complete, nil
+}
+
+// compressTo replaces file at path with data of src compressed by c.
+func compressTo(src string, c Compression, path string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open decompressed segment: %w", err)
+	}
+
+	defer func() {
+		_ = in.Close()
+	}()
+
+	tmp := path + ".recovered"
+
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+
+	cw, err := NewCompressedWriter(out, c, recoverFrameSize)
+	if err == nil {
+		if _, err = io.Copy(cw, in); err == nil {
+			err = cw.Flush()
+		}
+	}
+
+	if err == nil {
+		err = out.Sync()
+	}
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace segment: %w", err)
+	}
+
+	return nil
+}
+
+// errWriter remembers error of underlying writer, so it could be distinguished from error of reader.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	n, err := ew.w.Write(p)
+	if err != nil {
+		ew.err = err
+	}
+
+	return n, err
+}
+
+// recoverSegment truncates everything after the last record separator.
+// Separator inside of message value could not be distinguished from record end, so partial record
+// that contains separator is truncated only to its last separator.
+func (e rawEncoder) recoverSegment(path string) (int64, error) {
+	if len(e.separator) == 0 {
+		return 0, nil
+	}
+
+	return truncateAfterLast(path, e.separator)
+}
+
+// recoverSegment truncates everything after the last new line.
+func (jsonlEncoder) recoverSegment(path string) (int64, error) {
+	return truncateAfterLast(path, []byte{'\n'})
+}
+
+// recoverSegment truncates segment after the last complete record with valid checksum and repairs index.
+func (binaryEncoder) recoverSegment(path string) (int64, error) {
+	size, err := fileSize(path)
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := readIndex(IndexPath(path))
+	if err != nil {
+		return 0, err
+	}
+
+	// index is written after record, so all indexed records except the last one are complete
+	// and scan could start from the last of them.
+	for len(entries) > 0 && entries[len(entries)-1].position >= size {
+		entries = entries[:len(entries)-1]
+	}
+
+	var start int64
+	if len(entries) > 0 {
+		start = entries[len(entries)-1].position
+		entries = entries[:len(entries)-1]
+	}
+
+	scanned, validLen, err := scanBinary(path, start)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(scanned) == 0 && start > 0 {
+		// the last indexed record is damaged, rescan whole segment.
+		entries = nil
+
+		if scanned, validLen, err = scanBinary(path, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	entries = increasing(append(entries, scanned...))
+
+	if err = truncate(path, size, validLen); err != nil {
+		return 0, err
+	}
+
+	if err = writeIndex(IndexPath(path), entries); err != nil {
+		return 0, err
+	}
+
+	return size - validLen, nil
+}
+
+type indexEntry struct {
+	offset   int64
+	position int64
+}
+
+// increasing drops entries with offsets that are not greater than offset of previous kept entry,
+// as writers do, so index stays sorted.
+func increasing(entries []indexEntry) []indexEntry {
+	kept := entries[:0]
+
+	for _, e := range entries {
+		if len(kept) == 0 || e.offset > kept[len(kept)-1].offset {
+			kept = append(kept, e)
+		}
+	}
+
+	return kept
+}
+
+// scanBinary reads records starting from position and returns their index entries and
+// length of segment up to the end of the last valid record.
+func scanBinary(path string, position int64) ([]indexEntry, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err = f.Seek(position, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to seek segment: %w", err)
+	}
+
+	br := NewBinaryReader(f)
+
+	var entries []indexEntry
+
+	for {
+		recordPosition := position + br.BytesRead()
+
+		msg, nerr := br.Next()
+		if nerr != nil {
+			break
+		}
+
+		entries = append(entries, indexEntry{offset: msg.Offset, position: recordPosition})
+	}
+
+	return entries, position + br.BytesRead(), nil
+}
+
+func readIndex(path string) ([]indexEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	entries := make([]indexEntry, 0, len(b)/IndexEntrySize)
+
+	for i := 0; i+IndexEntrySize <= len(b); i += IndexEntrySize {
+		entries = append(entries, indexEntry{
+			offset:   int64(binary.BigEndian.Uint64(b[i : i+8])),
+			position: int64(binary.BigEndian.Uint64(b[i+8 : i+IndexEntrySize])),
+		})
+	}
+
+	return entries, nil
+}
+
+func writeIndex(path string, entries []indexEntry) error {
+	b := make([]byte, 0, len(entries)*IndexEntrySize)
+
+	for _, e := range entries {
+		b = append(b, IndexEntry(e.offset, e.position)...)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open index: %w", err)
+	}
+
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("failed to sync index: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close index: %w", err)
+	}
+
+	return nil
+}
+
+// truncateAfterLast truncates file after the last occurrence of sep.
+func truncateAfterLast(path string, sep []byte) (int64, error) {
+	size, err := fileSize(path)
+	if err != nil {
+		return 0, err
+	}
+
+	validLen, err := lastIndexEnd(path, size, sep)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = truncate(path, size, validLen); err != nil {
+		return 0, err
+	}
+
+	return size - validLen, nil
+}
+
+// lastIndexEnd returns position right after the last occurrence of sep in file, reading it from the end by chunks.
+func lastIndexEnd(path string, size int64, sep []byte) (int64, error) {
+	const chunkSize = 64 * 1024
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open segment: %w", err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	overlap := int64(len(sep) - 1)
+	buf := make([]byte, chunkSize+overlap)
+
+	for end := size; end > 0; {
+		start := end - chunkSize
+		if start < 0 {
+			start = 0
+		}
+
+		readEnd := end + overlap
+		if readEnd > size {
+			readEnd = size
+		}
+
+		chunk := buf[:readEnd-start]
+		if _, err = f.ReadAt(chunk, start); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read segment: %w", err)
+		}
+
+		if i := bytes.LastIndex(chunk, sep); i >= 0 {
+			return start + int64(i) + int64(len(sep)), nil
+		}
+
+		end = start
+	}
+
+	return 0, nil
+}
+
+func truncate(path string, size, validLen int64) error {
+	if validLen == size {
+		return nil
+	}
+
+	if err := os.Truncate(path, validLen); err != nil {
+		return fmt.Errorf("failed to truncate segment: %w", err)
+	}
+
+	return nil
+}
+
+func fileSize(path string) (int64, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat segment: %w", err)
+	}
+
+	return st.Size(), nil
+}
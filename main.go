@@ -1,10 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/obalunenko/kafka-dump/config"
 	"github.com/obalunenko/kafka-dump/dumper"
+	"github.com/obalunenko/kafka-dump/metrics"
+	"github.com/obalunenko/kafka-dump/restore"
 )
 
 var (
@@ -13,12 +26,177 @@
 	commit  string
 )
 
+// Exit codes.
+const (
+	exitOK = iota
+	exitUnknown
+	exitConfig
+	exitConnection
+	exitWrite
+	exitDecode
+	exitIncomplete
+)
+
 func main() {
 	fmt.Printf("Version info: %s:%s\n", version, date)
 	fmt.Printf("commit: %s \n", commit)
 
-	svcCfg := config.LoadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args[2:]))
+	}
+
+	os.Exit(run())
+}
+
+func run() int {
+	svcCfg, err := config.LoadConfig()
+	if err != nil {
+		if errors.Is(err, config.ErrConfigInitialized) {
+			return exitOK
+		}
+
+		return fail(err)
+	}
+
+	opts, err := svcCfg.DumperOptions()
+	if err != nil {
+		return fail(err)
+	}
+
+	mux := http.NewServeMux()
+
+	if svcCfg.HTTPAddr != "" {
+		opts.Metrics = metrics.NewRegistry()
+		mux.Handle("/metrics", opts.Metrics.Handler())
+	}
+
+	d, err := dumper.New(opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	if svcCfg.HTTPAddr != "" {
+		mux.Handle("/healthz", d.LivenessHandler())
+		mux.Handle("/readyz", d.ReadinessHandler())
+
+		var stop func()
+
+		if stop, err = serveHTTP(svcCfg.HTTPAddr, mux); err != nil {
+			return fail(err)
+		}
+
+		defer stop()
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if err = d.Run(ctx); err != nil {
+		return fail(err)
+	}
+
+	return exitOK
+}
+
+// runRestore runs restore command with its arguments.
+func runRestore(args []string) int {
+	cfg, err := config.LoadRestoreConfig(args)
+	if err != nil {
+		return fail(err)
+	}
+
+	r, err := restore.New(cfg.RestoreOptions())
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if _, err = r.Run(ctx); err != nil {
+		return fail(err)
+	}
+
+	return exitOK
+}
+
+// signalContext returns context that is canceled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+			log.Infof("Got UNIX signal, shutting down")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// httpShutdownTimeout is a time given to HTTP listener to finish requests on exit.
+const httpShutdownTimeout = 5 * time.Second
+
+// serveHTTP starts HTTP listener on addr and returns function that stops it.
+func serveHTTP(addr string, handler http.Handler) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, &dumper.ConfigError{Err: fmt.Errorf("failed to listen HTTP address: %w", err)}
+	}
+
+	srv := &http.Server{Handler: handler}
+
+	log.Infof("HTTP listener started at %s", ln.Addr())
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("HTTP listener failed: %v", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("Failed to stop HTTP listener: %v", err)
+		}
+	}, nil
+}
+
+// fail logs error and returns exit code of its kind.
+func fail(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	log.Errorf("%v", err)
+
+	var (
+		configErr     *dumper.ConfigError
+		connectionErr *dumper.ConnectionError
+		writeErr      *dumper.WriteError
+		decodeErr     *dumper.DecodeError
+		incompleteErr *dumper.IncompleteError
+	)
 
-	dumper.Start(svcCfg.KafkaBrokers, svcCfg.KafkaGroupID, svcCfg.KafkaClientID,
-		svcCfg.KafkaVersion(), svcCfg.Newest, svcCfg.Topics, svcCfg.OutputDir)
+	switch {
+	case errors.As(err, &configErr):
+		return exitConfig
+	case errors.As(err, &connectionErr):
+		return exitConnection
+	case errors.As(err, &writeErr):
+		return exitWrite
+	case errors.As(err, &decodeErr):
+		return exitDecode
+	case errors.As(err, &incompleteErr):
+		return exitIncomplete
+	default:
+		return exitUnknown
+	}
 }
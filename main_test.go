@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/dumper"
+)
+
+func TestFail(t *testing.T) {
+	out := log.StandardLogger().Out
+	log.SetOutput(ioutil.Discard)
+
+	defer log.SetOutput(out)
+
+	cause := errors.New("cause")
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "help", err: flag.ErrHelp, want: exitOK},
+		{name: "wrapped help", err: fmt.Errorf("failed to parse flags: %w", flag.ErrHelp), want: exitOK},
+		{name: "config", err: &dumper.ConfigError{Err: cause}, want: exitConfig},
+		{name: "connection", err: &dumper.ConnectionError{Err: cause}, want: exitConnection},
+		{name: "write", err: &dumper.WriteError{Err: cause}, want: exitWrite},
+		{name: "decode", err: &dumper.DecodeError{Topic: "orders", Err: cause}, want: exitDecode},
+		{name: "incomplete", err: &dumper.IncompleteError{Reason: "stopped", Remaining: 1}, want: exitIncomplete},
+		{name: "wrapped config", err: fmt.Errorf("failed to load config: %w", &dumper.ConfigError{Err: cause}), want: exitConfig},
+		{name: "wrapped write", err: fmt.Errorf("run: %w", fmt.Errorf("dump: %w", &dumper.WriteError{Err: cause})), want: exitWrite},
+		{name: "unknown", err: cause, want: exitUnknown},
+		{name: "wrapped unknown", err: fmt.Errorf("failed to run: %w", cause), want: exitUnknown},
+	}
+
+	for _, tc := range tests {
+		if got := fail(tc.err); got != tc.want {
+			t.Errorf("%s: fail(%v) = %d, want %d", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
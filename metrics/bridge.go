@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// bridgeQuantiles are quantiles exported for histograms and timers of go-metrics.
+var bridgeQuantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// bridgeLabels are name suffixes of per broker and per topic go-metrics of sarama, they are exported as labels.
+// Sarama also keeps aggregate of all brokers or topics under name without suffix, it is exported
+// in the same family with bridgeAll label value, so all samples of family have the same labels.
+var bridgeLabels = []struct {
+	marker string
+	label  string
+}{
+	{marker: "-for-broker-", label: "broker"},
+	{marker: "-for-topic-", label: "topic"},
+}
+
+// bridgeAll is a label value of aggregate of all brokers or topics.
+const bridgeAll = "all"
+
+// bridge exports metrics of go-metrics registry, it is used for metrics of sarama client.
+type bridge struct {
+	prefix   string
+	registry gometrics.Registry
+}
+
+// Bridge exports metrics of go-metrics registry with prefix. Metric names are converted to snake case,
+// broker and topic name suffixes of sarama metrics become labels, aggregates get "all" value of them.
+// Meters are exported as counters without "_rate" suffix, histograms and timers as summaries.
+func (r *Registry) Bridge(prefix string, registry gometrics.Registry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bridges = append(r.bridges, bridge{prefix: prefix, registry: registry})
+}
+
+// families returns current values of bridged metrics.
+func (b bridge) families() []*family {
+	byName := make(map[string]*family)
+
+	add := func(name, origin, typ string, samples ...sample) {
+		f, ok := byName[name]
+		if !ok {
+			f = &family{name: name, help: "Kafka client metric " + origin + ".", typ: typ}
+			byName[name] = f
+		}
+
+		f.static = append(f.static, samples...)
+	}
+
+	metrics := make(map[string]interface{})
+
+	b.registry.Each(func(name string, metric interface{}) {
+		metrics[name] = metric
+	})
+
+	names := make([]string, 0, len(metrics))
+
+	for name := range metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	// labelled holds names of labels used by any metric of family.
+	labelled := make(map[string]map[string]bool)
+
+	for _, name := range names {
+		base, _, labels := b.split(name)
+
+		if labelled[base] == nil {
+			labelled[base] = make(map[string]bool)
+		}
+
+		for _, l := range labels {
+			labelled[base][l.name] = true
+		}
+	}
+
+	for _, name := range names {
+		base, origin, labels := b.split(name)
+		labels = withAll(labels, labelled[base])
+
+		switch m := metrics[name].(type) {
+		case gometrics.Meter:
+			add(strings.TrimSuffix(base, "_rate")+"_total", origin, typeCounter, sample{labels: labels, value: float64(m.Snapshot().Count())})
+		case gometrics.Counter:
+			add(base, origin, typeGauge, sample{labels: labels, value: float64(m.Count())})
+		case gometrics.Gauge:
+			add(base, origin, typeGauge, sample{labels: labels, value: float64(m.Value())})
+		case gometrics.GaugeFloat64:
+			add(base, origin, typeGauge, sample{labels: labels, value: m.Value()})
+		case gometrics.Histogram:
+			s := m.Snapshot()
+			add(base, origin, typeSummary, summary(labels, s.Percentiles(bridgeQuantiles), s.Sum(), s.Count())...)
+		case gometrics.Timer:
+			s := m.Snapshot()
+			add(base, origin, typeSummary, summary(labels, s.Percentiles(bridgeQuantiles), s.Sum(), s.Count())...)
+		}
+	}
+
+	families := make([]*family, 0, len(byName))
+
+	for _, f := range byName {
+		families = append(families, f)
+	}
+
+	return families
+}
+
+// split converts go-metrics name to metric name, name without label suffixes and labels.
+func (b bridge) split(name string) (string, string, []labelPair) {
+	var labels []labelPair
+
+	for _, l := range bridgeLabels {
+		if i := strings.Index(name, l.marker); i >= 0 {
+			labels = append(labels, labelPair{name: l.label, value: name[i+len(l.marker):]})
+			name = name[:i]
+		}
+	}
+
+	return b.prefix + "_" + sanitizeName(name), name, labels
+}
+
+// withAll returns labels of metric in bridgeLabels order with bridgeAll value of labels
+// that are used by other metrics of family and are missing in metric.
+func withAll(labels []labelPair, used map[string]bool) []labelPair {
+	if len(used) == len(labels) {
+		return labels
+	}
+
+	values := make(map[string]string, len(labels))
+
+	for _, l := range labels {
+		values[l.name] = l.value
+	}
+
+	all := make([]labelPair, 0, len(used))
+
+	for _, l := range bridgeLabels {
+		if !used[l.label] {
+			continue
+		}
+
+		v, ok := values[l.label]
+		if !ok {
+			v = bridgeAll
+		}
+
+		all = append(all, labelPair{name: l.label, value: v})
+	}
+
+	return all
+}
+
+// summary returns samples of summary with bridgeQuantiles values.
+func summary(labels []labelPair, quantiles []float64, sum, count int64) []sample {
+	samples := make([]sample, 0, len(quantiles)+2)
+
+	for i, q := range bridgeQuantiles {
+		samples = append(samples, sample{
+			labels: append(labels[:len(labels):len(labels)], labelPair{name: "quantile", value: formatFloat(q)}),
+			value:  quantiles[i],
+		})
+	}
+
+	return append(samples,
+		sample{suffix: "_sum", labels: labels, value: float64(sum)},
+		sample{suffix: "_count", labels: labels, value: float64(count)},
+	)
+}
+
+// sanitizeName replaces characters that are not allowed in metric names by underscores.
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
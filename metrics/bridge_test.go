@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+func TestBridge(t *testing.T) {
+	registry := gometrics.NewRegistry()
+
+	gometrics.GetOrRegisterMeter("request-rate", registry).Mark(3)
+	gometrics.GetOrRegisterMeter("request-rate-for-broker-1", registry).Mark(1)
+	gometrics.GetOrRegisterMeter("request-rate-for-broker-2", registry).Mark(2)
+	gometrics.GetOrRegisterCounter("requests-in-flight", registry).Inc(4)
+	gometrics.GetOrRegisterGauge("consumer-batch-size-for-topic-orders", registry).Update(5)
+	gometrics.GetOrRegisterHistogram("response-size", registry, gometrics.NewUniformSample(10)).Update(8)
+
+	r := NewRegistry()
+	r.Bridge("kafka", registry)
+
+	got := scrape(t, r)
+
+	for _, want := range []string{
+		"# TYPE kafka_request_total counter\n" +
+			`kafka_request_total{broker="all"} 3` + "\n" +
+			`kafka_request_total{broker="1"} 1` + "\n" +
+			`kafka_request_total{broker="2"} 2` + "\n",
+		"# TYPE kafka_requests_in_flight gauge\nkafka_requests_in_flight 4\n",
+		`kafka_consumer_batch_size{topic="orders"} 5` + "\n",
+		"# TYPE kafka_response_size summary\n" +
+			`kafka_response_size{quantile="0.5"} 8` + "\n" +
+			`kafka_response_size{quantile="0.75"} 8` + "\n" +
+			`kafka_response_size{quantile="0.95"} 8` + "\n" +
+			`kafka_response_size{quantile="0.99"} 8` + "\n" +
+			"kafka_response_size_sum 8\nkafka_response_size_count 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("scrape has no\n%s\nscrape:\n%s", want, got)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	b := bridge{prefix: "kafka"}
+
+	tests := []struct {
+		name       string
+		wantBase   string
+		wantOrigin string
+		wantLabels []labelPair
+	}{
+		{name: "request-rate", wantBase: "kafka_request_rate", wantOrigin: "request-rate"},
+		{
+			name:       "request-latency-in-ms-for-broker-3",
+			wantBase:   "kafka_request_latency_in_ms",
+			wantOrigin: "request-latency-in-ms",
+			wantLabels: []labelPair{{name: "broker", value: "3"}},
+		},
+		{
+			name:       "record-send-rate-for-topic-my.topic",
+			wantBase:   "kafka_record_send_rate",
+			wantOrigin: "record-send-rate",
+			wantLabels: []labelPair{{name: "topic", value: "my.topic"}},
+		},
+	}
+
+	for _, tc := range tests {
+		base, origin, labels := b.split(tc.name)
+		if base != tc.wantBase || origin != tc.wantOrigin || !equalLabels(labels, tc.wantLabels) {
+			t.Errorf("split(%q) = %q, %q, %v, want %q, %q, %v",
+				tc.name, base, origin, labels, tc.wantBase, tc.wantOrigin, tc.wantLabels)
+		}
+	}
+}
+
+func TestWithAll(t *testing.T) {
+	broker := labelPair{name: "broker", value: "1"}
+	topic := labelPair{name: "topic", value: "orders"}
+
+	tests := []struct {
+		labels []labelPair
+		used   map[string]bool
+		want   []labelPair
+	}{
+		{},
+		{labels: []labelPair{broker}, used: map[string]bool{"broker": true}, want: []labelPair{broker}},
+		{used: map[string]bool{"broker": true}, want: []labelPair{{name: "broker", value: "all"}}},
+		{
+			labels: []labelPair{topic},
+			used:   map[string]bool{"broker": true, "topic": true},
+			want:   []labelPair{{name: "broker", value: "all"}, topic},
+		},
+	}
+
+	for _, tc := range tests {
+		if got := withAll(tc.labels, tc.used); !equalLabels(got, tc.want) {
+			t.Errorf("withAll(%v, %v) = %v, want %v", tc.labels, tc.used, got, tc.want)
+		}
+	}
+}
+
+func equalLabels(a, b []labelPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
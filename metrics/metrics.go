@@ -0,0 +1,412 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types of Prometheus text format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+)
+
+// DefaultBuckets are upper bounds of histogram buckets in seconds for latencies from 100µs to 10s.
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Registry keeps metrics families. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	bridges  []bridge
+}
+
+// NewRegistry creates empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric with all its series.
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+	// fn returns value of gauge without labels computed at scrape time.
+	fn func() float64
+	// static holds samples of bridged metrics.
+	static []sample
+}
+
+// series is a value of family with particular label values.
+type series struct {
+	labels []string
+	value  float64
+	// counts holds cumulative counts of histogram buckets.
+	counts []uint64
+	count  uint64
+}
+
+// sample is a line of text format.
+type sample struct {
+	suffix string
+	labels []labelPair
+	value  float64
+}
+
+type labelPair struct {
+	name, value string
+}
+
+// family returns registered family or registers new one. Family is shared by all callers
+// with the same name, so metrics could be registered again by each run of their owner.
+// It fails when name is registered with another type or label names.
+func (r *Registry) family(name, help, typ string, labels []string, buckets []float64) (*family, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || !sameLabelNames(f.labels, labels) {
+			return nil, fmt.Errorf("metric %s is already registered as %s with labels %v", name, f.typ, f.labels)
+		}
+
+		return f, nil
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	r.families[name] = f
+
+	return f, nil
+}
+
+func sameLabelNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Counter registers counter with label names.
+func (r *Registry) Counter(name, help string, labels ...string) (*CounterVec, error) {
+	f, err := r.family(name, help, typeCounter, labels, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CounterVec{f: f}, nil
+}
+
+// Gauge registers gauge with label names.
+func (r *Registry) Gauge(name, help string, labels ...string) (*GaugeVec, error) {
+	f, err := r.family(name, help, typeGauge, labels, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GaugeVec{f: f}, nil
+}
+
+// GaugeFunc registers gauge without labels which value is returned by fn on each scrape.
+// Registering it again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) error {
+	f, err := r.family(name, help, typeGauge, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.fn = fn
+	f.mu.Unlock()
+
+	return nil
+}
+
+// Histogram registers histogram with upper bounds of buckets and label names, DefaultBuckets are used when nil.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) (*HistogramVec, error) {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	f, err := r.family(name, help, typeHistogram, labels, buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HistogramVec{f: f}, nil
+}
+
+// with returns series of label values, creating it when absent.
+// It returns nil when number of values differs from number of labels, such update is dropped.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		return nil
+	}
+
+	key := strings.Join(values, "\xff")
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+
+		if f.typ == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+
+		f.series[key] = s
+	}
+
+	return s
+}
+
+func (f *family) delete(values []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.series, strings.Join(values, "\xff"))
+}
+
+// samples returns sorted samples of family.
+func (f *family) samples() []sample {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fn != nil {
+		return []sample{{value: f.fn()}}
+	}
+
+	if f.static != nil {
+		return f.static
+	}
+
+	keys := make([]string, 0, len(f.series))
+
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	samples := make([]sample, 0, len(keys))
+
+	for _, k := range keys {
+		s := f.series[k]
+
+		labels := make([]labelPair, len(f.labels))
+		for i, name := range f.labels {
+			labels[i] = labelPair{name: name, value: s.labels[i]}
+		}
+
+		if f.typ != typeHistogram {
+			samples = append(samples, sample{labels: labels, value: s.value})
+
+			continue
+		}
+
+		for i, bound := range f.buckets {
+			samples = append(samples, sample{
+				suffix: "_bucket",
+				labels: append(labels[:len(labels):len(labels)], labelPair{name: "le", value: formatFloat(bound)}),
+				value:  float64(s.counts[i]),
+			})
+		}
+
+		samples = append(samples,
+			sample{suffix: "_bucket", labels: append(labels[:len(labels):len(labels)], labelPair{name: "le", value: "+Inf"}),
+				value: float64(s.count)},
+			sample{suffix: "_sum", labels: labels, value: s.value},
+			sample{suffix: "_count", labels: labels, value: float64(s.count)},
+		)
+	}
+
+	return samples
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	f *family
+}
+
+// Add adds v to counter of label values. Counter could not be decreased, so negative v is dropped.
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 || math.IsNaN(v) {
+		return
+	}
+
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+
+	if s := c.f.with(values); s != nil {
+		s.value += v
+	}
+}
+
+// Inc increments counter of label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// GaugeVec is a gauge partitioned by label values.
+type GaugeVec struct {
+	f *family
+}
+
+// Set sets gauge of label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+
+	if s := g.f.with(values); s != nil {
+		s.value = v
+	}
+}
+
+// Delete removes series of label values, it is used when partition or file is gone.
+func (g *GaugeVec) Delete(values ...string) {
+	g.f.delete(values)
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	f *family
+}
+
+// Observe adds v to histogram of label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.with(values)
+	if s == nil {
+		return
+	}
+
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+
+	s.count++
+	s.value += v
+}
+
+// WriteText writes all metrics in Prometheus text format sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+
+	families := make([]*family, 0, len(r.families))
+
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+
+	bridges := append([]bridge(nil), r.bridges...)
+
+	r.mu.Unlock()
+
+	for _, b := range bridges {
+		families = append(families, b.families()...)
+	}
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+
+	for _, f := range families {
+		samples := f.samples()
+		if len(samples) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+
+		for _, s := range samples {
+			bw.WriteString(f.name + s.suffix)
+
+			if len(s.labels) != 0 {
+				bw.WriteByte('{')
+
+				for i, l := range s.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+
+					bw.WriteString(l.name + `="` + escapeLabel(l.value) + `"`)
+				}
+
+				bw.WriteByte('}')
+			}
+
+			bw.WriteString(" " + formatFloat(s.value) + "\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Handler returns HTTP handler that serves metrics in Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
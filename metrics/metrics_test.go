@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns response body of registry handler.
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type = %q", ct)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+// counter registers counter or fails test.
+func counter(t *testing.T, r *Registry, name string, labels ...string) *CounterVec {
+	t.Helper()
+
+	c, err := r.Counter(name, "Consumed messages.", labels...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// gauge registers gauge or fails test.
+func gauge(t *testing.T, r *Registry, name, help string, labels ...string) *GaugeVec {
+	t.Helper()
+
+	g, err := r.Gauge(name, help, labels...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+
+	messages := counter(t, r, "test_messages_total", "topic", "partition")
+	messages.Inc("orders", "1")
+	messages.Add(2, "orders", "0")
+	messages.Inc("quoted\"\\\ntopic", "0")
+
+	files := gauge(t, r, "test_open_files", "Open files.\nSecond line with \\.")
+	files.Set(3)
+
+	lag := gauge(t, r, "test_lag", "Lag.", "partition")
+	lag.Set(5, "0")
+	lag.Set(7, "1")
+	lag.Delete("1")
+
+	if err := r.GaugeFunc("test_uptime", "Uptime.", func() float64 { return 1.5 }); err != nil {
+		t.Fatal(err)
+	}
+
+	gauge(t, r, "test_empty", "Gauge without series.", "topic")
+
+	latency, err := r.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(2)
+
+	// families are shared by registrations with the same name.
+	counter(t, r, "test_messages_total", "topic", "partition").Inc("orders", "1")
+
+	want := `# HELP test_lag Lag.
+# TYPE test_lag gauge
+test_lag{partition="0"} 5
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 2.55
+test_latency_seconds_count 3
+# HELP test_messages_total Consumed messages.
+# TYPE test_messages_total counter
+test_messages_total{topic="orders",partition="0"} 2
+test_messages_total{topic="orders",partition="1"} 2
+test_messages_total{topic="quoted\"\\\ntopic",partition="0"} 1
+# HELP test_open_files Open files.\nSecond line with \\.
+# TYPE test_open_files gauge
+test_open_files 3
+# HELP test_uptime Uptime.
+# TYPE test_uptime gauge
+test_uptime 1.5
+`
+
+	if got := scrape(t, r); got != want {
+		t.Errorf("scrape:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	r := NewRegistry()
+	counter(t, r, "test_total", "topic")
+
+	if _, err := r.Gauge("test_total", "Test.", "topic"); err == nil {
+		t.Error("registration of gauge with name of counter error = nil")
+	}
+
+	if _, err := r.Counter("test_total", "Test.", "partition"); err == nil {
+		t.Error("registration of counter with other labels error = nil")
+	}
+
+	if _, err := r.Histogram("test_total", "Test.", nil, "topic"); err == nil {
+		t.Error("registration of histogram with name of counter error = nil")
+	}
+
+	if err := r.GaugeFunc("test_total", "Test.", func() float64 { return 1 }); err == nil {
+		t.Error("registration of gauge func with name of counter error = nil")
+	}
+}
+
+func TestDroppedUpdates(t *testing.T) {
+	r := NewRegistry()
+
+	c := counter(t, r, "test_total", "topic")
+	c.Add(2, "orders")
+	c.Add(-1, "orders")
+	c.Inc()
+	c.Inc("orders", "0")
+
+	g := gauge(t, r, "test_lag", "Lag.", "partition")
+	g.Set(1, "0", "extra")
+
+	h, err := r.Histogram("test_seconds", "Latency.", []float64{1}, "topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Observe(0.5)
+
+	want := `# HELP test_total Consumed messages.
+# TYPE test_total counter
+test_total{topic="orders"} 2
+`
+
+	if got := scrape(t, r); got != want {
+		t.Errorf("scrape:\n%s\nwant:\n%s", got, want)
+	}
+}
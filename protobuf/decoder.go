@@ -0,0 +1,367 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Encoding is a name of encoding of values rendered by Decoder.
+const Encoding = "protobuf"
+
+// Decoder decodes protobuf messages to JSON, it is safe for concurrent use.
+type Decoder struct {
+	// message is a type of decoded messages, fields are decoded without schema when it is nil.
+	message *messageType
+}
+
+// NewRawDecoder creates decoder that renders messages without schema, see DecodeRaw.
+func NewRawDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Decoder creates decoder of messages of type with full name (e.g. shop.v1.Order).
+func (set *DescriptorSet) Decoder(typeName string) (*Decoder, error) {
+	m, ok := set.messages[strings.TrimPrefix(typeName, ".")]
+	if !ok {
+		return nil, fmt.Errorf("message type [%s] is not found in descriptor set", typeName)
+	}
+
+	return &Decoder{message: m}, nil
+}
+
+// Decode returns JSON of message. Messages of known type are rendered as objects with declared field names,
+// fields unknown to descriptor are rendered without schema in "_unknown" array.
+func (d *Decoder) Decode(b []byte) (json.RawMessage, string, error) {
+	if d.message == nil {
+		js, err := DecodeRaw(b)
+
+		return js, Encoding, err
+	}
+
+	var buf bytes.Buffer
+
+	if err := writeMessage(&buf, d.message, b, 0); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), Encoding, nil
+}
+
+func writeMessage(buf *bytes.Buffer, m *messageType, b []byte, depth int) error {
+	if depth > maxDepth {
+		return errors.New("too deep nesting of messages")
+	}
+
+	fs, err := fields(b)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[int32][]wireField)
+
+	var unknown []wireField
+
+	for _, f := range fs {
+		if _, ok := m.byNumber[f.number]; ok {
+			values[f.number] = append(values[f.number], f)
+		} else {
+			unknown = append(unknown, f)
+		}
+	}
+
+	buf.WriteByte('{')
+
+	first := true
+
+	for _, fd := range m.fields {
+		vs := values[fd.number]
+		if len(vs) == 0 {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+
+		first = false
+
+		writeString(buf, fd.name)
+		buf.WriteByte(':')
+
+		switch {
+		case fd.repeated && fd.message != nil && fd.message.mapEntry:
+			err = writeMap(buf, fd.message, vs, depth)
+		case fd.repeated:
+			err = writeRepeated(buf, fd, vs, depth)
+		default:
+			// the last value wins for singular fields.
+			err = writeValue(buf, fd, vs[len(vs)-1], depth)
+		}
+
+		if err != nil {
+			return fmt.Errorf("field [%s] of message [%s]: %w", fd.name, m.name, err)
+		}
+	}
+
+	if len(unknown) != 0 {
+		if !first {
+			buf.WriteByte(',')
+		}
+
+		buf.WriteString(`"_unknown":`)
+
+		if err = writeRawFields(buf, unknown, depth+1); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+func writeRepeated(buf *bytes.Buffer, fd *fieldType, vs []wireField, depth int) error {
+	buf.WriteByte('[')
+
+	first := true
+
+	for _, v := range vs {
+		items := []wireField{v}
+
+		if v.wireType == wireBytes && packable(fd.typ) {
+			var err error
+
+			if items, err = unpack(fd, v.bytes); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range items {
+			if !first {
+				buf.WriteByte(',')
+			}
+
+			first = false
+
+			if err := writeValue(buf, fd, item, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	buf.WriteByte(']')
+
+	return nil
+}
+
+// writeMap writes repeated map entries as JSON object.
+func writeMap(buf *bytes.Buffer, entry *messageType, vs []wireField, depth int) error {
+	keyField, valueField := entry.byNumber[1], entry.byNumber[2]
+	if keyField == nil || valueField == nil {
+		return fmt.Errorf("invalid map entry [%s]", entry.name)
+	}
+
+	buf.WriteByte('{')
+
+	for i, v := range vs {
+		if v.wireType != wireBytes {
+			return fmt.Errorf("map entry has wire type %s", wireNames[v.wireType])
+		}
+
+		fs, err := fields(v.bytes)
+		if err != nil {
+			return err
+		}
+
+		var key, value bytes.Buffer
+
+		for _, f := range fs {
+			switch f.number {
+			case 1:
+				key.Reset()
+				err = writeValue(&key, keyField, f, depth)
+			case 2:
+				value.Reset()
+				err = writeValue(&value, valueField, f, depth)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		// absent key and value have default values.
+		if key.Len() == 0 {
+			writeDefault(&key, keyField)
+		}
+
+		if value.Len() == 0 {
+			writeDefault(&value, valueField)
+		}
+
+		if key.Bytes()[0] == '"' {
+			buf.Write(key.Bytes())
+		} else {
+			writeString(buf, key.String())
+		}
+
+		buf.WriteByte(':')
+		buf.Write(value.Bytes())
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+// writeDefault writes default value of field type.
+func writeDefault(buf *bytes.Buffer, fd *fieldType) {
+	switch fd.typ {
+	case typeString, typeBytes:
+		buf.WriteString(`""`)
+	case typeBool:
+		buf.WriteString("false")
+	case typeMessage, typeGroup:
+		buf.WriteString("{}")
+	case typeEnum:
+		if name, ok := fd.enum.values[0]; ok {
+			writeString(buf, name)
+		} else {
+			buf.WriteString("0")
+		}
+	default:
+		buf.WriteString("0")
+	}
+}
+
+// packable reports whether repeated field of type could be packed.
+func packable(typ int) bool {
+	switch typ {
+	case typeString, typeBytes, typeMessage, typeGroup:
+		return false
+	default:
+		return true
+	}
+}
+
+// unpack returns values of packed repeated field.
+func unpack(fd *fieldType, b []byte) ([]wireField, error) {
+	r := &wireReader{b: b}
+
+	wireType := scalarWireType(fd.typ)
+
+	var items []wireField
+
+	for !r.done() {
+		item := wireField{number: fd.number, wireType: wireType}
+
+		switch wireType {
+		case wireFixed32:
+			v, err := r.take(4)
+			if err != nil {
+				return nil, err
+			}
+
+			item.value = uint64(binary.LittleEndian.Uint32(v))
+		case wireFixed64:
+			v, err := r.take(8)
+			if err != nil {
+				return nil, err
+			}
+
+			item.value = binary.LittleEndian.Uint64(v)
+		default:
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+
+			item.value = v
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// scalarWireType returns wire type of values of field type.
+func scalarWireType(typ int) int {
+	switch typ {
+	case typeDouble, typeFixed64, typeSfixed64:
+		return wireFixed64
+	case typeFloat, typeFixed32, typeSfixed32:
+		return wireFixed32
+	case typeString, typeBytes, typeMessage:
+		return wireBytes
+	case typeGroup:
+		return wireStartGroup
+	default:
+		return wireVarint
+	}
+}
+
+func writeValue(buf *bytes.Buffer, fd *fieldType, f wireField, depth int) error {
+	if expected := scalarWireType(fd.typ); f.wireType != expected {
+		return fmt.Errorf("wire type %s does not match field type, expected %s", wireNames[f.wireType], wireNames[expected])
+	}
+
+	v := f.value
+
+	switch fd.typ {
+	case typeDouble:
+		writeFloat(buf, math.Float64frombits(v), 64)
+	case typeFloat:
+		writeFloat(buf, float64(math.Float32frombits(uint32(v))), 32)
+	case typeInt64, typeSfixed64:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case typeUint64, typeFixed64:
+		buf.WriteString(strconv.FormatUint(v, 10))
+	case typeInt32, typeSfixed32:
+		buf.WriteString(strconv.FormatInt(int64(int32(v)), 10))
+	case typeUint32, typeFixed32:
+		buf.WriteString(strconv.FormatUint(uint64(uint32(v)), 10))
+	case typeSint32, typeSint64:
+		buf.WriteString(strconv.FormatInt(int64(v>>1)^-int64(v&1), 10))
+	case typeBool:
+		buf.WriteString(strconv.FormatBool(v != 0))
+	case typeEnum:
+		if name, ok := fd.enum.values[int32(v)]; ok {
+			writeString(buf, name)
+		} else {
+			buf.WriteString(strconv.FormatInt(int64(int32(v)), 10))
+		}
+	case typeString:
+		writeString(buf, string(f.bytes))
+	case typeBytes:
+		writeString(buf, base64.StdEncoding.EncodeToString(f.bytes))
+	case typeMessage, typeGroup:
+		return writeMessage(buf, fd.message, f.bytes, depth+1)
+	default:
+		return fmt.Errorf("unknown field type %d", fd.typ)
+	}
+
+	return nil
+}
+
+// writeFloat writes number, NaN and infinities that have no JSON representation are written as strings.
+func writeFloat(buf *bytes.Buffer, f float64, bitSize int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		writeString(buf, strconv.FormatFloat(f, 'g', -1, bitSize))
+
+		return
+	}
+
+	buf.WriteString(strconv.FormatFloat(f, 'g', -1, bitSize))
+}
@@ -0,0 +1,249 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func fieldDescriptor(name string, number, label, typ int, typeName string) []byte {
+	b := concat(stringField(1, name), varintField(3, uint64(number)), varintField(4, uint64(label)),
+		varintField(5, uint64(typ)))
+
+	if typeName != "" {
+		b = append(b, stringField(6, typeName)...)
+	}
+
+	return b
+}
+
+// shopDescriptorSet returns FileDescriptorSet of shop.proto:
+//
+//	package shop;
+//	enum Status { NEW = 0; DONE = 1; }
+//	message Item { optional string sku = 1; }
+//	message Order {
+//	  optional int64 id = 1;
+//	  optional string name = 2;
+//	  repeated int32 nums = 3;
+//	  optional Status status = 4;
+//	  optional Item item = 5;
+//	  map<string, int32> attrs = 6;
+//	  optional sint32 delta = 7;
+//	  optional double price = 8;
+//	  optional bytes blob = 9;
+//	  optional group Extra = 10 { optional int32 n = 1; }
+//	}
+func shopDescriptorSet() []byte {
+	const (
+		optional = 1
+		repeated = labelRepeated
+	)
+
+	entry := concat(
+		stringField(1, "AttrsEntry"),
+		bytesField(2, fieldDescriptor("key", 1, optional, typeString, "")),
+		bytesField(2, fieldDescriptor("value", 2, optional, typeInt32, "")),
+		bytesField(7, varintField(7, 1)),
+	)
+	extra := concat(stringField(1, "Extra"), bytesField(2, fieldDescriptor("n", 1, optional, typeInt32, "")))
+	order := concat(
+		stringField(1, "Order"),
+		bytesField(2, fieldDescriptor("id", 1, optional, typeInt64, "")),
+		bytesField(2, fieldDescriptor("name", 2, optional, typeString, "")),
+		bytesField(2, fieldDescriptor("nums", 3, repeated, typeInt32, "")),
+		bytesField(2, fieldDescriptor("status", 4, optional, typeEnum, ".shop.Status")),
+		bytesField(2, fieldDescriptor("item", 5, optional, typeMessage, ".shop.Item")),
+		bytesField(2, fieldDescriptor("attrs", 6, repeated, typeMessage, ".shop.Order.AttrsEntry")),
+		bytesField(2, fieldDescriptor("delta", 7, optional, typeSint32, "")),
+		bytesField(2, fieldDescriptor("price", 8, optional, typeDouble, "")),
+		bytesField(2, fieldDescriptor("blob", 9, optional, typeBytes, "")),
+		bytesField(2, fieldDescriptor("extra", 10, optional, typeGroup, ".shop.Order.Extra")),
+		bytesField(3, entry),
+		bytesField(3, extra),
+	)
+	item := concat(stringField(1, "Item"), bytesField(2, fieldDescriptor("sku", 1, optional, typeString, "")))
+	status := concat(
+		stringField(1, "Status"),
+		bytesField(2, concat(stringField(1, "NEW"), varintField(2, 0))),
+		bytesField(2, concat(stringField(1, "DONE"), varintField(2, 1))),
+	)
+	file := concat(stringField(1, "shop.proto"), stringField(2, "shop"), bytesField(4, order), bytesField(4, item),
+		bytesField(5, status))
+
+	return bytesField(1, file)
+}
+
+func orderDecoder(t *testing.T) *Decoder {
+	t.Helper()
+
+	set, err := ParseDescriptorSet(shopDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := set.Decoder(".shop.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestDecode(t *testing.T) {
+	d := orderDecoder(t)
+
+	price := make([]byte, 8)
+	binary.LittleEndian.PutUint64(price, math.Float64bits(2.5))
+
+	var minusFive int64 = -5
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{name: "empty", want: `{}`},
+		{
+			name: "all fields",
+			msg: concat(
+				varintField(1, uint64(minusFive)),
+				stringField(2, "bob"),
+				bytesField(3, []byte{1, 2, 3}),
+				varintField(3, 4),
+				varintField(4, 1),
+				bytesField(5, stringField(1, "A-1")),
+				bytesField(6, concat(stringField(1, "x"), varintField(2, 7))),
+				bytesField(6, stringField(1, "y")),
+				varintField(7, 3),
+				tag(8, wireFixed64), price,
+				bytesField(9, []byte{0, 1}),
+				groupField(10, varintField(1, 5)),
+				varintField(99, 5),
+			),
+			want: `{"id":-5,"name":"bob","nums":[1,2,3,4],"status":"DONE","item":{"sku":"A-1"},"attrs":{"x":7,"y":0},` +
+				`"delta":-2,"price":2.5,"blob":"AAE=","extra":{"n":5},"_unknown":[{"field":99,"type":"varint","value":5}]}`,
+		},
+		{
+			name: "last value of singular field wins",
+			msg:  concat(stringField(2, "a"), stringField(2, "b"), varintField(4, 9)),
+			want: `{"name":"b","status":9}`,
+		},
+		{
+			name: "unknown group",
+			msg:  groupField(20, varintField(1, 1)),
+			want: `{"_unknown":[{"field":20,"type":"group","message":[{"field":1,"type":"varint","value":1}]}]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		js, enc, err := d.Decode(tc.msg)
+		if err != nil || string(js) != tc.want || enc != Encoding {
+			t.Errorf("%s: Decode() = %s, %s, %v, want %s", tc.name, js, enc, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	d := orderDecoder(t)
+
+	tests := []struct {
+		name string
+		msg  []byte
+		// err is an expected error, wantErr is a part of error message when err is nil.
+		err     error
+		wantErr string
+	}{
+		{name: "truncated varint", msg: []byte{0x08, 0x80}, err: errTruncated},
+		{
+			name: "varint overflow",
+			msg:  concat(tag(1, wireVarint), bytes.Repeat([]byte{0xff}, 9), []byte{0x02}),
+			err:  errOverflow,
+		},
+		{name: "truncated string", msg: []byte{0x12, 5, 'b'}, err: errTruncated},
+		{name: "truncated nested message", msg: bytesField(5, []byte{0x0a, 5, 'A'}), err: errTruncated},
+		{name: "truncated packed field", msg: bytesField(3, []byte{1, 0x80}), err: errTruncated},
+		{name: "truncated map entry", msg: bytesField(6, []byte{0x0a, 3, 'x'}), err: errTruncated},
+		{name: "invalid wire type of unknown field", msg: append(tag(99, 6), 1), wantErr: "invalid wire type 6 of field 99"},
+		{
+			name:    "string as varint",
+			msg:     varintField(2, 1),
+			wantErr: "field [name] of message [shop.Order]: wire type varint does not match field type, expected bytes",
+		},
+		{
+			name:    "message as group",
+			msg:     groupField(5, stringField(1, "A-1")),
+			wantErr: "field [item] of message [shop.Order]: wire type group does not match field type, expected bytes",
+		},
+		{
+			name:    "group as message",
+			msg:     bytesField(10, varintField(1, 5)),
+			wantErr: "field [extra] of message [shop.Order]: wire type bytes does not match field type, expected group",
+		},
+		{name: "double as fixed32", msg: append(tag(8, wireFixed32), 0, 0, 0, 0), wantErr: "expected fixed64"},
+		{name: "map entry as varint", msg: varintField(6, 1), wantErr: "map entry has wire type varint"},
+		{name: "unterminated group", msg: concat(tag(10, wireStartGroup), varintField(1, 5)), err: errTruncated},
+		{
+			name:    "mismatched end group",
+			msg:     concat(tag(10, wireStartGroup), varintField(1, 5), tag(11, wireEndGroup)),
+			wantErr: "end of group 11 closes group 10",
+		},
+		{name: "stray end group", msg: tag(10, wireEndGroup), wantErr: "invalid wire type 4 of field 10"},
+		{
+			name:    "mistyped field in group",
+			msg:     groupField(10, stringField(1, "n")),
+			wantErr: "field [n] of message [shop.Order.Extra]",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			js, _, err := d.Decode(tc.msg)
+
+			switch {
+			case tc.err != nil:
+				if !errors.Is(err, tc.err) {
+					t.Errorf("Decode(%x) = %s, %v, want %v", tc.msg, js, err, tc.err)
+				}
+			case err == nil || !strings.Contains(err.Error(), tc.wantErr):
+				t.Errorf("Decode(%x) = %s, %v, want %q", tc.msg, js, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDescriptorSet(t *testing.T) {
+	unresolved := bytesField(1, bytesField(4, concat(
+		stringField(1, "Order"),
+		bytesField(2, fieldDescriptor("item", 1, 1, typeMessage, ".shop.Item")),
+	)))
+
+	tests := []struct {
+		name    string
+		set     []byte
+		wantErr string
+	}{
+		{name: "truncated set", set: []byte{0x0a, 5, 1}, wantErr: "unexpected end of message"},
+		{name: "malformed file", set: bytesField(1, []byte{0x22, 5}), wantErr: "invalid file descriptor"},
+		{name: "malformed message", set: bytesField(1, bytesField(4, []byte{0x0a})), wantErr: "invalid message descriptor"},
+		{name: "unresolved field type", set: unresolved, wantErr: "unknown type [shop.Item] of field [item] of message [Order]"},
+	}
+
+	for _, tc := range tests {
+		if _, err := ParseDescriptorSet(tc.set); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: ParseDescriptorSet() error = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+
+	set, err := ParseDescriptorSet(shopDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = set.Decoder("shop.Nope"); err == nil || !strings.Contains(err.Error(), "[shop.Nope] is not found") {
+		t.Errorf("Decoder() of unknown type error = %v", err)
+	}
+}
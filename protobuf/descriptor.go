@@ -0,0 +1,302 @@
+package protobuf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Field types of FieldDescriptorProto.
+const (
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeGroup    = 10
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeEnum     = 14
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+)
+
+// labelRepeated is a label of repeated fields in FieldDescriptorProto.
+const labelRepeated = 3
+
+// DescriptorSet holds message and enum types of compiled FileDescriptorSet
+// (e.g. produced by protoc --include_imports --descriptor_set_out).
+type DescriptorSet struct {
+	messages map[string]*messageType
+	enums    map[string]*enumType
+}
+
+type messageType struct {
+	name     string
+	fields   []*fieldType
+	byNumber map[int32]*fieldType
+	// mapEntry is set for synthetic types of map fields, their fields are key (1) and value (2).
+	mapEntry bool
+}
+
+type fieldType struct {
+	name     string
+	number   int32
+	typ      int
+	repeated bool
+	// typeName is a full name of message or enum type without leading dot.
+	typeName string
+	message  *messageType
+	enum     *enumType
+}
+
+type enumType struct {
+	values map[int32]string
+}
+
+// LoadDescriptorSet reads FileDescriptorSet file.
+func LoadDescriptorSet(path string) (*DescriptorSet, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+
+	set, err := ParseDescriptorSet(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set [%s]: %w", path, err)
+	}
+
+	return set, nil
+}
+
+// ParseDescriptorSet parses encoded FileDescriptorSet.
+func ParseDescriptorSet(b []byte) (*DescriptorSet, error) {
+	set := &DescriptorSet{
+		messages: make(map[string]*messageType),
+		enums:    make(map[string]*enumType),
+	}
+
+	files, err := fields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		// FileDescriptorSet.file
+		if f.number == 1 && f.wireType == wireBytes {
+			if err = set.addFile(f.bytes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, m := range set.messages {
+		for _, fd := range m.fields {
+			if err = set.resolve(m, fd); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return set, nil
+}
+
+func (set *DescriptorSet) addFile(b []byte) error {
+	fs, err := fields(b)
+	if err != nil {
+		return fmt.Errorf("invalid file descriptor: %w", err)
+	}
+
+	var pkg string
+
+	for _, f := range fs {
+		// FileDescriptorProto.package
+		if f.number == 2 && f.wireType == wireBytes {
+			pkg = string(f.bytes)
+		}
+	}
+
+	for _, f := range fs {
+		if f.wireType != wireBytes {
+			continue
+		}
+
+		switch f.number {
+		case 4: // FileDescriptorProto.message_type
+			err = set.addMessage(f.bytes, pkg)
+		case 5: // FileDescriptorProto.enum_type
+			err = set.addEnum(f.bytes, pkg)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (set *DescriptorSet) addMessage(b []byte, scope string) error {
+	fs, err := fields(b)
+	if err != nil {
+		return fmt.Errorf("invalid message descriptor: %w", err)
+	}
+
+	m := &messageType{byNumber: make(map[int32]*fieldType)}
+
+	for _, f := range fs {
+		if f.number == 1 && f.wireType == wireBytes {
+			m.name = qualify(scope, string(f.bytes))
+		}
+	}
+
+	for _, f := range fs {
+		if f.wireType != wireBytes {
+			continue
+		}
+
+		switch f.number {
+		case 2: // DescriptorProto.field
+			var fd *fieldType
+
+			if fd, err = parseField(f.bytes); err == nil {
+				m.fields = append(m.fields, fd)
+				m.byNumber[fd.number] = fd
+			}
+		case 3: // DescriptorProto.nested_type
+			err = set.addMessage(f.bytes, m.name)
+		case 4: // DescriptorProto.enum_type
+			err = set.addEnum(f.bytes, m.name)
+		case 7: // DescriptorProto.options
+			m.mapEntry, err = boolOption(f.bytes, 7) // MessageOptions.map_entry
+		}
+
+		if err != nil {
+			return fmt.Errorf("message [%s]: %w", m.name, err)
+		}
+	}
+
+	set.messages[m.name] = m
+
+	return nil
+}
+
+func parseField(b []byte) (*fieldType, error) {
+	fs, err := fields(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field descriptor: %w", err)
+	}
+
+	fd := &fieldType{}
+
+	for _, f := range fs {
+		switch {
+		case f.number == 1 && f.wireType == wireBytes:
+			fd.name = string(f.bytes)
+		case f.number == 3 && f.wireType == wireVarint:
+			fd.number = int32(f.value)
+		case f.number == 4 && f.wireType == wireVarint:
+			fd.repeated = f.value == labelRepeated
+		case f.number == 5 && f.wireType == wireVarint:
+			fd.typ = int(f.value)
+		case f.number == 6 && f.wireType == wireBytes:
+			fd.typeName = strings.TrimPrefix(string(f.bytes), ".")
+		}
+	}
+
+	return fd, nil
+}
+
+func (set *DescriptorSet) addEnum(b []byte, scope string) error {
+	fs, err := fields(b)
+	if err != nil {
+		return fmt.Errorf("invalid enum descriptor: %w", err)
+	}
+
+	e := &enumType{values: make(map[int32]string)}
+
+	var name string
+
+	for _, f := range fs {
+		switch {
+		case f.number == 1 && f.wireType == wireBytes:
+			name = qualify(scope, string(f.bytes))
+		case f.number == 2 && f.wireType == wireBytes: // EnumDescriptorProto.value
+			vs, err := fields(f.bytes)
+			if err != nil {
+				return fmt.Errorf("invalid enum value descriptor: %w", err)
+			}
+
+			var (
+				valueName string
+				number    int32
+			)
+
+			for _, v := range vs {
+				switch {
+				case v.number == 1 && v.wireType == wireBytes:
+					valueName = string(v.bytes)
+				case v.number == 2 && v.wireType == wireVarint:
+					number = int32(v.value)
+				}
+			}
+
+			if _, ok := e.values[number]; !ok {
+				e.values[number] = valueName
+			}
+		}
+	}
+
+	set.enums[name] = e
+
+	return nil
+}
+
+// resolve links field of message or enum type to its type.
+func (set *DescriptorSet) resolve(m *messageType, fd *fieldType) error {
+	switch fd.typ {
+	case typeMessage, typeGroup:
+		if fd.message = set.messages[fd.typeName]; fd.message == nil {
+			return fmt.Errorf("unknown type [%s] of field [%s] of message [%s]", fd.typeName, fd.name, m.name)
+		}
+	case typeEnum:
+		if fd.enum = set.enums[fd.typeName]; fd.enum == nil {
+			return fmt.Errorf("unknown type [%s] of field [%s] of message [%s]", fd.typeName, fd.name, m.name)
+		}
+	}
+
+	return nil
+}
+
+// boolOption returns value of bool field with number of options message.
+func boolOption(b []byte, number int32) (bool, error) {
+	fs, err := fields(b)
+	if err != nil {
+		return false, fmt.Errorf("invalid options: %w", err)
+	}
+
+	var v bool
+
+	for _, f := range fs {
+		if f.number == number && f.wireType == wireVarint {
+			v = f.value != 0
+		}
+	}
+
+	return v, nil
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+
+	return scope + "." + name
+}
@@ -0,0 +1,126 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DecodeRaw converts message in wire format to JSON array of its fields without schema, like protoc --decode_raw.
+// Each field is an object with field number, wire type and value:
+//
+//	{"field":1,"type":"varint","value":150}
+//	{"field":2,"type":"bytes","string":"text"}
+//	{"field":3,"type":"bytes","message":[...]}
+//	{"field":4,"type":"bytes","base64":"AAE="}
+//	{"field":5,"type":"group","message":[...]}
+//
+// Length-delimited fields are rendered as string when they are printable UTF-8 text, as nested message when
+// they could be parsed as message and as base64 otherwise.
+func DecodeRaw(b []byte) (json.RawMessage, error) {
+	var buf bytes.Buffer
+
+	if err := writeRaw(&buf, b, 0); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeRaw(buf *bytes.Buffer, b []byte, depth int) error {
+	if depth > maxDepth {
+		return errors.New("too deep nesting of messages")
+	}
+
+	fs, err := fields(b)
+	if err != nil {
+		return err
+	}
+
+	return writeRawFields(buf, fs, depth)
+}
+
+func writeRawFields(buf *bytes.Buffer, fs []wireField, depth int) error {
+	buf.WriteByte('[')
+
+	for i, f := range fs {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+
+		buf.WriteString(`{"field":`)
+		buf.WriteString(strconv.Itoa(int(f.number)))
+		buf.WriteString(`,"type":"`)
+		buf.WriteString(wireNames[f.wireType])
+		buf.WriteString(`",`)
+
+		switch f.wireType {
+		case wireBytes:
+			writeRawBytes(buf, f.bytes, depth)
+		case wireStartGroup:
+			buf.WriteString(`"message":`)
+
+			if err := writeRaw(buf, f.bytes, depth+1); err != nil {
+				return err
+			}
+		default:
+			buf.WriteString(`"value":`)
+			buf.WriteString(strconv.FormatUint(f.value, 10))
+		}
+
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(']')
+
+	return nil
+}
+
+// writeRawBytes writes length-delimited field as text, nested message or base64.
+func writeRawBytes(buf *bytes.Buffer, b []byte, depth int) {
+	if printable(b) {
+		buf.WriteString(`"string":`)
+		writeString(buf, string(b))
+
+		return
+	}
+
+	if len(b) != 0 {
+		var nested bytes.Buffer
+
+		if writeRaw(&nested, b, depth+1) == nil {
+			buf.WriteString(`"message":`)
+			buf.Write(nested.Bytes())
+
+			return
+		}
+	}
+
+	buf.WriteString(`"base64":`)
+	writeString(buf, base64.StdEncoding.EncodeToString(b))
+}
+
+// printable reports whether b is UTF-8 text without control characters except tabs and line breaks.
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+
+	for _, r := range string(b) {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' || r == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	// marshaling of string never fails.
+	js, _ := json.Marshal(s)
+
+	buf.Write(js)
+}
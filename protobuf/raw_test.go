@@ -0,0 +1,175 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// uvarint returns varint encoding of v.
+func uvarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+
+	return b[:binary.PutUvarint(b, v)]
+}
+
+// tag returns key of field with number and wire type.
+func tag(number, wireType int) []byte {
+	return uvarint(uint64(number<<3 | wireType))
+}
+
+func varintField(number int, v uint64) []byte {
+	return append(tag(number, wireVarint), uvarint(v)...)
+}
+
+func bytesField(number int, b []byte) []byte {
+	return concat(tag(number, wireBytes), uvarint(uint64(len(b))), b)
+}
+
+func stringField(number int, s string) []byte {
+	return bytesField(number, []byte(s))
+}
+
+func groupField(number int, fields ...[]byte) []byte {
+	return concat(tag(number, wireStartGroup), concat(fields...), tag(number, wireEndGroup))
+}
+
+func concat(bs ...[]byte) []byte {
+	var b []byte
+
+	for _, v := range bs {
+		b = append(b, v...)
+	}
+
+	return b
+}
+
+func TestDecodeRaw(t *testing.T) {
+	fixed32 := []byte{1, 0, 0, 0}
+	fixed64 := []byte{2, 0, 0, 0, 0, 0, 0, 0}
+
+	msg := concat(
+		varintField(1, 150),
+		stringField(2, "text\n"),
+		bytesField(3, varintField(1, 1)),
+		bytesField(4, []byte{0, 1}),
+		groupField(5, varintField(1, 2), groupField(6, stringField(1, "in"))),
+		tag(7, wireFixed32), fixed32,
+		tag(8, wireFixed64), fixed64,
+		bytesField(9, nil),
+	)
+
+	want := `[{"field":1,"type":"varint","value":150},` +
+		`{"field":2,"type":"bytes","string":"text\n"},` +
+		`{"field":3,"type":"bytes","message":[{"field":1,"type":"varint","value":1}]},` +
+		`{"field":4,"type":"bytes","base64":"AAE="},` +
+		`{"field":5,"type":"group","message":[{"field":1,"type":"varint","value":2},` +
+		`{"field":6,"type":"group","message":[{"field":1,"type":"bytes","string":"in"}]}]},` +
+		`{"field":7,"type":"fixed32","value":1},` +
+		`{"field":8,"type":"fixed64","value":2},` +
+		`{"field":9,"type":"bytes","string":""}]`
+
+	js, enc, err := NewRawDecoder().Decode(msg)
+	if err != nil || string(js) != want || enc != Encoding {
+		t.Errorf("Decode() = %s, %s, %v, want %s", js, enc, err, want)
+	}
+
+	if !json.Valid(js) {
+		t.Errorf("Decode() returned invalid JSON %s", js)
+	}
+}
+
+func TestDecodeRawMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		// err is an expected error, wantErr is a part of error message when err is nil.
+		err     error
+		wantErr string
+	}{
+		{name: "missing varint value", msg: tag(1, wireVarint), err: errTruncated},
+		{name: "truncated varint value", msg: []byte{0x08, 0x80}, err: errTruncated},
+		{name: "truncated tag", msg: []byte{0x80}, err: errTruncated},
+		{name: "varint overflow", msg: concat(tag(1, wireVarint), bytes.Repeat([]byte{0xff}, 9), []byte{0x02}), err: errOverflow},
+		{name: "tag overflow", msg: append(bytes.Repeat([]byte{0x80}, 10), 0x02), err: errOverflow},
+		{name: "field number 0", msg: []byte{0x00, 0x01}, wantErr: "invalid field number 0"},
+		{name: "field number too large", msg: tag(1<<29, wireVarint), wantErr: "invalid field number 536870912"},
+		{name: "truncated length-delimited", msg: []byte{0x0a, 5, 1}, err: errTruncated},
+		{name: "huge length", msg: concat(tag(1, wireBytes), uvarint(1<<62), []byte{1}), err: errTruncated},
+		{name: "truncated length", msg: []byte{0x0a, 0x80}, err: errTruncated},
+		{name: "truncated fixed32", msg: append(tag(1, wireFixed32), 1, 2, 3), err: errTruncated},
+		{name: "truncated fixed64", msg: append(tag(1, wireFixed64), 1, 2, 3, 4, 5, 6, 7), err: errTruncated},
+		{name: "wire type 6", msg: append(tag(1, 6), 1), wantErr: "invalid wire type 6 of field 1"},
+		{name: "wire type 7", msg: append(tag(2, 7), 1), wantErr: "invalid wire type 7 of field 2"},
+		{name: "stray end group", msg: tag(3, wireEndGroup), wantErr: "invalid wire type 4 of field 3"},
+		{name: "unterminated group", msg: concat(tag(3, wireStartGroup), varintField(1, 1)), err: errTruncated},
+		{name: "empty unterminated group", msg: tag(3, wireStartGroup), err: errTruncated},
+		{
+			name:    "mismatched end group",
+			msg:     concat(tag(3, wireStartGroup), varintField(1, 1), tag(4, wireEndGroup)),
+			wantErr: "end of group 4 closes group 3",
+		},
+		{name: "malformed field in group", msg: concat(tag(3, wireStartGroup), []byte{0x0a, 5, 1}), err: errTruncated},
+		{
+			name:    "too deep groups",
+			msg:     bytes.Repeat(tag(1, wireStartGroup), maxDepth+1),
+			wantErr: "too deep nesting of groups",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			js, err := DecodeRaw(tc.msg)
+
+			switch {
+			case tc.err != nil:
+				if !errors.Is(err, tc.err) {
+					t.Errorf("DecodeRaw(%x) = %s, %v, want %v", tc.msg, js, err, tc.err)
+				}
+			case err == nil || !strings.Contains(err.Error(), tc.wantErr):
+				t.Errorf("DecodeRaw(%x) = %s, %v, want %q", tc.msg, js, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestDecodeRawNestedBytes checks that malformed and too deep messages in length-delimited fields
+// are rendered as base64 instead of failing the whole message.
+func TestDecodeRawNestedBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{
+			name: "truncated nested message",
+			msg:  bytesField(1, []byte{0x0a, 5, 1}),
+			want: `[{"field":1,"type":"bytes","base64":"CgUB"}]`,
+		},
+		{
+			name: "invalid wire type in nested message",
+			msg:  bytesField(1, append(tag(1, 7), 0xff)),
+			want: `[{"field":1,"type":"bytes","base64":"D/8="}]`,
+		},
+	}
+
+	for _, tc := range tests {
+		js, err := DecodeRaw(tc.msg)
+		if err != nil || string(js) != tc.want {
+			t.Errorf("%s: DecodeRaw() = %s, %v, want %s", tc.name, js, err, tc.want)
+		}
+	}
+
+	deep := varintField(1, 1)
+	for i := 0; i < 2*maxDepth; i++ {
+		deep = bytesField(1, deep)
+	}
+
+	js, err := DecodeRaw(deep)
+	if err != nil || !json.Valid(js) || !strings.Contains(string(js), `"base64":`) {
+		t.Errorf("DecodeRaw() of too deep message = %.100s, %v, want deep part in base64", js, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Package protobuf decodes protobuf messages to JSON, schema-less like protoc --decode_raw
+// or by message type of compiled FileDescriptorSet.
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Wire types of protobuf encoding.
+const (
+	wireVarint     = 0
+	wireFixed64    = 1
+	wireBytes      = 2
+	wireStartGroup = 3
+	wireEndGroup   = 4
+	wireFixed32    = 5
+)
+
+// maxDepth limits nesting of decoded messages.
+const maxDepth = 100
+
+var (
+	errTruncated = errors.New("unexpected end of message")
+	errOverflow  = errors.New("varint overflows 64 bits")
+)
+
+var wireNames = map[int]string{
+	wireVarint:     "varint",
+	wireFixed64:    "fixed64",
+	wireBytes:      "bytes",
+	wireStartGroup: "group",
+	wireFixed32:    "fixed32",
+}
+
+// wireField is a field of message in wire format.
+type wireField struct {
+	number   int32
+	wireType int
+	// value is a value of varint and fixed fields.
+	value uint64
+	// bytes holds value of length-delimited field or encoded fields of group.
+	bytes []byte
+}
+
+// wireReader reads fields of message in wire format.
+type wireReader struct {
+	b     []byte
+	depth int
+}
+
+func (r *wireReader) done() bool {
+	return len(r.b) == 0
+}
+
+func (r *wireReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.b)
+	if n < 0 {
+		return 0, errOverflow
+	}
+
+	if n == 0 {
+		return 0, errTruncated
+	}
+
+	r.b = r.b[n:]
+
+	return v, nil
+}
+
+func (r *wireReader) take(n uint64) ([]byte, error) {
+	if n > uint64(len(r.b)) {
+		return nil, errTruncated
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+
+	return b, nil
+}
+
+// tag reads field number and wire type.
+func (r *wireReader) tag() (int32, int, error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	number, wireType := key>>3, int(key&7)
+
+	if number == 0 || number > 1<<29-1 {
+		return 0, 0, fmt.Errorf("invalid field number %d", number)
+	}
+
+	return int32(number), wireType, nil
+}
+
+// next reads the next field, group fields are read up to their end group tag.
+func (r *wireReader) next() (wireField, error) {
+	number, wireType, err := r.tag()
+	if err != nil {
+		return wireField{}, err
+	}
+
+	f := wireField{number: number, wireType: wireType}
+
+	switch wireType {
+	case wireVarint:
+		f.value, err = r.varint()
+	case wireFixed64:
+		var b []byte
+		if b, err = r.take(8); err == nil {
+			f.value = binary.LittleEndian.Uint64(b)
+		}
+	case wireFixed32:
+		var b []byte
+		if b, err = r.take(4); err == nil {
+			f.value = uint64(binary.LittleEndian.Uint32(b))
+		}
+	case wireBytes:
+		var n uint64
+		if n, err = r.varint(); err == nil {
+			f.bytes, err = r.take(n)
+		}
+	case wireStartGroup:
+		f.bytes, err = r.group(number)
+	default:
+		err = fmt.Errorf("invalid wire type %d of field %d", wireType, number)
+	}
+
+	return f, err
+}
+
+// group returns encoded fields of group up to its end group tag.
+func (r *wireReader) group(number int32) ([]byte, error) {
+	if r.depth >= maxDepth {
+		return nil, errors.New("too deep nesting of groups")
+	}
+
+	r.depth++
+	defer func() { r.depth-- }()
+
+	start := r.b
+
+	for {
+		if r.done() {
+			return nil, errTruncated
+		}
+
+		rest := r.b
+
+		n, wireType, err := r.tag()
+		if err != nil {
+			return nil, err
+		}
+
+		if wireType == wireEndGroup {
+			if n != number {
+				return nil, fmt.Errorf("end of group %d closes group %d", n, number)
+			}
+
+			return start[:len(start)-len(rest)], nil
+		}
+
+		// tag is read again with field value.
+		r.b = rest
+
+		if _, err = r.next(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fields reads all fields of message.
+func fields(b []byte) ([]wireField, error) {
+	r := &wireReader{b: b}
+
+	var fs []wireField
+
+	for !r.done() {
+		f, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+
+		fs = append(fs, f)
+	}
+
+	return fs, nil
+}
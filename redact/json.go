@@ -0,0 +1,152 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// object is a JSON object that keeps order of its members, so redacted JSON differs from source
+// only by redacted fields.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *object) remove(key string) {
+	delete(o.values, key)
+
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// parseJSON parses JSON to objects, arrays and scalars of encoding/json with numbers kept as json.Number.
+func parseJSON(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	v, err := parseValue(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = d.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+
+	return v, nil
+}
+
+func parseValue(d *json.Decoder) (interface{}, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		o := &object{values: make(map[string]interface{})}
+
+		for d.More() {
+			keyTok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			key, ok := keyTok.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object key %v", keyTok)
+			}
+
+			v, err := parseValue(d)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, dup := o.values[key]; !dup {
+				o.keys = append(o.keys, key)
+			}
+
+			o.values[key] = v
+		}
+
+		// closing brace.
+		_, err = d.Token()
+
+		return o, err
+	case json.Delim('['):
+		a := []interface{}{}
+
+		for d.More() {
+			v, err := parseValue(d)
+			if err != nil {
+				return nil, err
+			}
+
+			a = append(a, v)
+		}
+
+		// closing bracket.
+		_, err = d.Token()
+
+		return a, err
+	default:
+		return tok, nil
+	}
+}
+
+// writeJSON writes value parsed by parseJSON as compact JSON.
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case *object:
+		buf.WriteByte('{')
+
+		for i, k := range v.keys {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := writeJSON(buf, k); err != nil {
+				return err
+			}
+
+			buf.WriteByte(':')
+
+			if err := writeJSON(buf, v.values[k]); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+
+		for i, item := range v {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte(']')
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(b)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package redact
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Roots of field paths.
+const (
+	rootKey   = "key"
+	rootValue = "value"
+)
+
+// pathElem is an element of field path: object member name, array index or wildcard.
+type pathElem struct {
+	name string
+	// index is an array index, it is negative for member names.
+	index int
+	// any matches all members of object or all items of array.
+	any bool
+}
+
+// parsePath parses path like value.customer.email, value.items[*].card or key["odd name"].
+func parsePath(s string) (string, []pathElem, error) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+
+	root := s[:end]
+	if root != rootKey && root != rootValue {
+		return "", nil, fmt.Errorf("path [%s] should start with key or value", s)
+	}
+
+	var path []pathElem
+
+	for rest := s[end:]; rest != ""; {
+		var (
+			elem pathElem
+			err  error
+		)
+
+		switch rest[0] {
+		case '.':
+			elem, rest, err = parseMember(rest[1:])
+		case '[':
+			elem, rest, err = parseIndex(rest[1:])
+		default:
+			err = fmt.Errorf("unexpected %q", rest[0])
+		}
+
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid path [%s]: %w", s, err)
+		}
+
+		path = append(path, elem)
+	}
+
+	return root, path, nil
+}
+
+// parseMember parses member name or * after dot.
+func parseMember(s string) (pathElem, string, error) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+
+	name := s[:end]
+
+	switch name {
+	case "":
+		return pathElem{}, "", fmt.Errorf("empty member name")
+	case "*":
+		return pathElem{any: true, index: -1}, s[end:], nil
+	default:
+		return pathElem{name: name, index: -1}, s[end:], nil
+	}
+}
+
+// parseIndex parses index, * or quoted member name in brackets.
+func parseIndex(s string) (pathElem, string, error) {
+	if strings.HasPrefix(s, `"`) {
+		// closing quote is the first one not escaped by backslash.
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				name, err := strconv.Unquote(s[:i+1])
+				if err != nil || !strings.HasPrefix(s[i+1:], "]") {
+					return pathElem{}, "", fmt.Errorf("invalid member name %s", s[:i+1])
+				}
+
+				return pathElem{name: name, index: -1}, s[i+2:], nil
+			}
+		}
+
+		return pathElem{}, "", fmt.Errorf("unterminated member name")
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return pathElem{}, "", fmt.Errorf("unterminated index")
+	}
+
+	if s[:end] == "*" {
+		return pathElem{any: true, index: -1}, s[end+1:], nil
+	}
+
+	i, err := strconv.Atoi(s[:end])
+	if err != nil || i < 0 {
+		return pathElem{}, "", fmt.Errorf("invalid index [%s]", s[:end])
+	}
+
+	return pathElem{index: i}, s[end+1:], nil
+}
@@ -0,0 +1,434 @@
+// Package redact removes or masks personal data in decoded keys and values of messages before they are dumped.
+//
+// Rule selects fields by path that starts with key or value followed by object members and array items:
+// value.customer.email, value.items[*].card, value["odd name"], value.*.phone. Matched fields are
+// dropped, replaced with constant, replaced with HMAC of their value (equal values have equal hashes,
+// so they could still be joined) or have matches of regular expression masked.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+// Action is what is done with fields matched by rule.
+type Action string
+
+const (
+	// Drop removes field from object or array, the whole key or value is replaced with null.
+	Drop Action = "drop"
+	// Replace replaces field with constant string.
+	Replace Action = "replace"
+	// HMAC replaces field with hex HMAC-SHA256 of its value: text of strings and JSON of other values.
+	HMAC Action = "hmac"
+	// Mask replaces matches of regular expression in string fields.
+	Mask Action = "mask"
+)
+
+// defaultMask replaces matches of Mask rules without Value.
+const defaultMask = "***"
+
+// Rule is a redaction rule of fields matched by path.
+type Rule struct {
+	Field  string
+	Action Action
+	// Value is a constant of Replace and replacement of Mask matches ($1 expands submatch).
+	Value string
+	// Pattern is a regular expression of Mask.
+	Pattern string
+}
+
+func (r Rule) String() string {
+	return r.Field + " (" + string(r.Action) + ")"
+}
+
+// rule is a compiled rule.
+type rule struct {
+	Rule
+	root string
+	path []pathElem
+	re   *regexp.Regexp
+}
+
+// Redactor applies rules to decoded messages and counts redacted fields of topics.
+type Redactor struct {
+	rules []rule
+	key   []byte
+
+	mu     sync.Mutex
+	counts map[string]map[string]int64
+}
+
+// New validates rules and creates redactor, key is required by HMAC rules.
+func New(rules []Rule, key []byte) (*Redactor, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("no redaction rules")
+	}
+
+	r := &Redactor{key: key, counts: make(map[string]map[string]int64)}
+
+	for _, rr := range rules {
+		c, err := compile(rr, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction rule of [%s]: %w", rr.Field, err)
+		}
+
+		r.rules = append(r.rules, c)
+	}
+
+	return r, nil
+}
+
+func compile(r Rule, key []byte) (rule, error) {
+	c := rule{Rule: r}
+
+	var err error
+
+	if c.root, c.path, err = parsePath(r.Field); err != nil {
+		return c, err
+	}
+
+	switch r.Action {
+	case Drop, Replace:
+	case HMAC:
+		if len(key) == 0 {
+			return c, errors.New("hmac action requires key")
+		}
+	case Mask:
+		if r.Pattern == "" {
+			return c, errors.New("mask action requires pattern")
+		}
+
+		if c.re, err = regexp.Compile(r.Pattern); err != nil {
+			return c, fmt.Errorf("failed to parse pattern: %w", err)
+		}
+
+		if c.Value == "" {
+			c.Value = defaultMask
+		}
+	default:
+		return c, fmt.Errorf("unknown action [%s], known actions: drop, replace, hmac, mask", r.Action)
+	}
+
+	if r.Pattern != "" && r.Action != Mask {
+		return c, fmt.Errorf("pattern could be set only for mask action")
+	}
+
+	return c, nil
+}
+
+// RedactsKey reports whether rules change keys, keys should be decoded to JSON then.
+func (r *Redactor) RedactsKey() bool {
+	return r.redacts(rootKey)
+}
+
+// RedactsValue reports whether rules change values, values should be decoded to JSON then.
+func (r *Redactor) RedactsValue() bool {
+	return r.redacts(rootValue)
+}
+
+func (r *Redactor) redacts(root string) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, rr := range r.rules {
+		if rr.root == root {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withheld is a counter label of fields that could not be redacted.
+const withheld = "(not decoded, withheld)"
+
+// Redact applies rules to decoded key and value of message, nil redactor keeps message as is.
+// Fields with rules that were not decoded to JSON (raw decoder or decoding failed) are withheld:
+// they are replaced with null, so data that could not be checked by rules is never dumped.
+//
+// Message of d is replaced with its copy that has redacted bytes, so encoders that write messages
+// as is (binary) dump redacted key and value as JSON text.
+func (r *Redactor) Redact(d *format.Decoded) {
+	if r == nil {
+		return
+	}
+
+	counts := make(map[string]int64)
+
+	key, keyChanged := r.redactField(rootKey, d.Key, d.ConsumerMessage.Key, counts)
+	value, valueChanged := r.redactField(rootValue, d.Value, d.ConsumerMessage.Value, counts)
+
+	if keyChanged || valueChanged {
+		msg := *d.ConsumerMessage
+
+		if keyChanged {
+			d.Key, msg.Key = key, fieldBytes(key)
+		}
+
+		if valueChanged {
+			d.Value, msg.Value = value, fieldBytes(value)
+		}
+
+		d.ConsumerMessage = &msg
+	}
+
+	if len(counts) != 0 {
+		r.count(d.Topic, counts)
+	}
+}
+
+// redactField applies rules of root to field and returns redacted field and whether it was changed.
+func (r *Redactor) redactField(root string, f *format.DecodedField, raw []byte, counts map[string]int64) (*format.DecodedField, bool) {
+	if !r.redacts(root) || raw == nil {
+		return f, false
+	}
+
+	if f == nil || f.Failed() {
+		counts[root+" "+withheld]++
+
+		return withhold(f), true
+	}
+
+	doc, err := parseJSON(f.JSON)
+	if err != nil {
+		counts[root+" "+withheld]++
+
+		return withhold(f), true
+	}
+
+	changed := false
+
+	for _, rr := range r.rules {
+		if rr.root != root {
+			continue
+		}
+
+		var n int
+
+		doc, n = rr.redact(doc, rr.path, r.key)
+		if n != 0 {
+			counts[rr.String()] += int64(n)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return f, false
+	}
+
+	var buf bytes.Buffer
+
+	if err = writeJSON(&buf, doc); err != nil {
+		counts[root+" "+withheld]++
+
+		return withhold(f), true
+	}
+
+	js := buf.Bytes()
+
+	// keep indentation of json:indent decoder.
+	if bytes.IndexByte(f.JSON, '\n') >= 0 {
+		var indented bytes.Buffer
+
+		if json.Indent(&indented, js, "", "  ") == nil {
+			js = indented.Bytes()
+		}
+	}
+
+	return &format.DecodedField{JSON: js, Encoding: f.Encoding}, true
+}
+
+// withhold returns null field that keeps decoding error of f.
+func withhold(f *format.DecodedField) *format.DecodedField {
+	w := &format.DecodedField{JSON: json.RawMessage("null"), Encoding: format.EncodingJSON}
+
+	if f != nil {
+		w.Err = f.Err
+	}
+
+	return w
+}
+
+// fieldBytes returns bytes of redacted field for message, withheld field has empty bytes
+// (not null ones, so message does not become tombstone).
+func fieldBytes(f *format.DecodedField) []byte {
+	if string(f.JSON) == "null" {
+		return []byte{}
+	}
+
+	return f.Text()
+}
+
+func (r *Redactor) count(topic string, counts map[string]int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.counts[topic]
+	if !ok {
+		c = make(map[string]int64)
+		r.counts[topic] = c
+	}
+
+	for label, n := range counts {
+		c[label] += n
+	}
+}
+
+// Count is a number of fields of topic redacted by rule.
+type Count struct {
+	Topic  string
+	Rule   string
+	Fields int64
+}
+
+// Summary returns numbers of redacted fields sorted by topic and rule.
+func (r *Redactor) Summary() []Count {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var counts []Count
+
+	for topic, c := range r.counts {
+		for label, n := range c {
+			counts = append(counts, Count{Topic: topic, Rule: label, Fields: n})
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Topic != counts[j].Topic {
+			return counts[i].Topic < counts[j].Topic
+		}
+
+		return counts[i].Rule < counts[j].Rule
+	})
+
+	return counts
+}
+
+// redact applies rule to elements of v matched by path, it returns changed v and number of redacted fields.
+func (r rule) redact(v interface{}, path []pathElem, key []byte) (interface{}, int) {
+	if len(path) == 0 {
+		return r.transform(v, key)
+	}
+
+	elem, rest := path[0], path[1:]
+	drop := r.Action == Drop && len(rest) == 0
+
+	switch v := v.(type) {
+	case *object:
+		if elem.index >= 0 {
+			return v, 0
+		}
+
+		names := []string{elem.name}
+		if elem.any {
+			names = append([]string(nil), v.keys...)
+		}
+
+		n := 0
+
+		for _, name := range names {
+			member, ok := v.values[name]
+			if !ok {
+				continue
+			}
+
+			if drop {
+				v.remove(name)
+				n++
+
+				continue
+			}
+
+			var c int
+
+			v.values[name], c = r.redact(member, rest, key)
+			n += c
+		}
+
+		return v, n
+	case []interface{}:
+		if !elem.any && (elem.index < 0 || elem.index >= len(v)) {
+			return v, 0
+		}
+
+		n := 0
+		kept := v[:0]
+
+		for i, item := range v {
+			if elem.any || i == elem.index {
+				if drop {
+					n++
+
+					continue
+				}
+
+				var c int
+
+				item, c = r.redact(item, rest, key)
+				n += c
+			}
+
+			kept = append(kept, item)
+		}
+
+		return kept, n
+	default:
+		return v, 0
+	}
+}
+
+// transform applies action to matched field, it returns new value and 1 when field was redacted.
+func (r rule) transform(v interface{}, key []byte) (interface{}, int) {
+	switch r.Action {
+	case Drop:
+		return nil, 1
+	case Replace:
+		return r.Value, 1
+	case HMAC:
+		if v == nil {
+			return v, 0
+		}
+
+		text, ok := v.(string)
+		if !ok {
+			var buf bytes.Buffer
+
+			if err := writeJSON(&buf, v); err != nil {
+				return nil, 1
+			}
+
+			text = buf.String()
+		}
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(text))
+
+		return hex.EncodeToString(mac.Sum(nil)), 1
+	case Mask:
+		s, ok := v.(string)
+		if !ok || !r.re.MatchString(s) {
+			return v, 0
+		}
+
+		return r.re.ReplaceAllString(s, r.Value), 1
+	default:
+		return v, 0
+	}
+}
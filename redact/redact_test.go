@@ -0,0 +1,400 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+const testValue = `{"id":12345678901234567890,"customer":{"email":"a@b.io","name":"Ann","phone":"5551234"},` +
+	`"items":[{"sku":"a","card":"4111"},{"sku":"b"},{"sku":"c","card":"5500"}],` +
+	`"contacts":{"home":{"phone":"5550000"},"work":{"phone":"5559999","ext":7}},` +
+	`"odd name":"x","matrix":[[1,2],[3,4]],"none":null}`
+
+func hmacHex(key, text string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(text))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// decode returns message of topic orders with key and value decoded by decoders of specs, empty spec keeps bytes as is.
+func decode(t *testing.T, key, value []byte, keySpec, valueSpec string) *format.Decoded {
+	t.Helper()
+
+	decoders := format.Decoders{Key: newDecoder(t, keySpec), Value: newDecoder(t, valueSpec)}
+
+	return decoders.Decode(&sarama.ConsumerMessage{Topic: "orders", Key: key, Value: value})
+}
+
+func newDecoder(t *testing.T, spec string) format.Decoder {
+	t.Helper()
+
+	if spec == "" {
+		return nil
+	}
+
+	d, err := format.NewDecoder(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		key   string
+		want  string
+		count int64
+	}{
+		{
+			name:  "nested member",
+			rules: []Rule{{Field: "value.customer.email", Action: Drop}},
+			want:  strings.Replace(testValue, `"email":"a@b.io",`, "", 1),
+			count: 1,
+		},
+		{
+			name:  "array items",
+			rules: []Rule{{Field: "value.items[*].card", Action: Replace, Value: "xxx"}},
+			want:  strings.NewReplacer(`"4111"`, `"xxx"`, `"5500"`, `"xxx"`).Replace(testValue),
+			count: 2,
+		},
+		{
+			name:  "array index",
+			rules: []Rule{{Field: "value.items[0]", Action: Drop}},
+			want:  strings.Replace(testValue, `{"sku":"a","card":"4111"},`, "", 1),
+			count: 1,
+		},
+		{
+			name:  "all array items",
+			rules: []Rule{{Field: "value.items[*]", Action: Drop}},
+			want:  strings.Replace(testValue, `{"sku":"a","card":"4111"},{"sku":"b"},{"sku":"c","card":"5500"}`, "", 1),
+			count: 3,
+		},
+		{
+			name:  "nested arrays",
+			rules: []Rule{{Field: "value.matrix[*][1]", Action: Replace, Value: "-"}},
+			want:  strings.Replace(testValue, `[[1,2],[3,4]]`, `[[1,"-"],[3,"-"]]`, 1),
+			count: 2,
+		},
+		{
+			name:  "wildcard member",
+			rules: []Rule{{Field: "value.contacts.*.phone", Action: Mask, Pattern: `^(\d{3})\d+$`, Value: "$1****"}},
+			want:  strings.NewReplacer(`"5550000"`, `"555****"`, `"5559999"`, `"555****"`).Replace(testValue),
+			count: 2,
+		},
+		{
+			name:  "quoted member",
+			rules: []Rule{{Field: `value["odd name"]`, Action: Replace, Value: "y"}},
+			want:  strings.Replace(testValue, `"odd name":"x"`, `"odd name":"y"`, 1),
+			count: 1,
+		},
+		{
+			name: "missing paths",
+			rules: []Rule{
+				{Field: "value.items[5]", Action: Drop},
+				{Field: "value.customer[0]", Action: Drop},
+				{Field: "value.items.card", Action: Drop},
+				{Field: "value.customer.email.domain", Action: Drop},
+				{Field: "value.absent", Action: Replace, Value: "x"},
+			},
+			want: testValue,
+		},
+		{
+			name:  "hmac of string",
+			rules: []Rule{{Field: "value.customer.email", Action: HMAC}},
+			key:   "k1",
+			want:  strings.Replace(testValue, `"a@b.io"`, `"`+hmacHex("k1", "a@b.io")+`"`, 1),
+			count: 1,
+		},
+		{
+			name:  "hmac depends on key",
+			rules: []Rule{{Field: "value.customer.email", Action: HMAC}},
+			key:   "k2",
+			want:  strings.Replace(testValue, `"a@b.io"`, `"`+hmacHex("k2", "a@b.io")+`"`, 1),
+			count: 1,
+		},
+		{
+			name:  "hmac of number keeps its text",
+			rules: []Rule{{Field: "value.id", Action: HMAC}},
+			key:   "k1",
+			want:  strings.Replace(testValue, `12345678901234567890`, `"`+hmacHex("k1", "12345678901234567890")+`"`, 1),
+			count: 1,
+		},
+		{
+			name:  "hmac of object",
+			rules: []Rule{{Field: "value.contacts.home", Action: HMAC}},
+			key:   "k1",
+			want:  strings.Replace(testValue, `{"phone":"5550000"}`, `"`+hmacHex("k1", `{"phone":"5550000"}`)+`"`, 1),
+			count: 1,
+		},
+		{
+			name:  "hmac keeps null",
+			rules: []Rule{{Field: "value.none", Action: HMAC}},
+			key:   "k1",
+			want:  testValue,
+		},
+		{
+			name:  "mask with default replacement",
+			rules: []Rule{{Field: "value.customer.phone", Action: Mask, Pattern: `\d{4}$`}},
+			want:  strings.Replace(testValue, `"5551234"`, `"555***"`, 1),
+			count: 1,
+		},
+		{
+			name:  "mask skips non-strings and not matching strings",
+			rules: []Rule{{Field: "value.contacts.work.*", Action: Mask, Pattern: `^\d+$`}},
+			want:  strings.Replace(testValue, `"5559999"`, `"***"`, 1),
+			count: 1,
+		},
+		{
+			name: "rules are applied in order",
+			rules: []Rule{
+				{Field: "value.customer.email", Action: Replace, Value: "hidden"},
+				{Field: "value.customer.email", Action: HMAC},
+			},
+			key:   "k1",
+			want:  strings.Replace(testValue, `"a@b.io"`, `"`+hmacHex("k1", "hidden")+`"`, 1),
+			count: 2,
+		},
+		{
+			name:  "whole value",
+			rules: []Rule{{Field: "value", Action: Drop}},
+			want:  "null",
+			count: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := New(tc.rules, []byte(tc.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := decode(t, []byte("k"), []byte(testValue), "", "json")
+			source := d.ConsumerMessage
+
+			r.Redact(d)
+
+			if got := string(d.Value.JSON); got != tc.want {
+				t.Errorf("redacted value = %s, want %s", got, tc.want)
+			}
+
+			var count int64
+			for _, c := range r.Summary() {
+				count += c.Fields
+			}
+
+			if count != tc.count {
+				t.Errorf("redacted %d fields, want %d", count, tc.count)
+			}
+
+			if string(source.Value) != testValue {
+				t.Errorf("source message is changed to %s", source.Value)
+			}
+
+			if tc.count != 0 && tc.want != "null" && string(d.ConsumerMessage.Value) != tc.want {
+				t.Errorf("message value = %s, want %s", d.ConsumerMessage.Value, tc.want)
+			}
+		})
+	}
+}
+
+// TestRedactNotJSON checks that fields that are not decoded to JSON documents are withheld or redacted as scalars.
+func TestRedactNotJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     []byte
+		spec      string
+		rule      Rule
+		wantJSON  string
+		wantBytes []byte
+		wantCount []Count
+	}{
+		{
+			name:      "decoding failed",
+			value:     []byte("not json"),
+			spec:      "json",
+			rule:      Rule{Field: "value.email", Action: Drop},
+			wantJSON:  "null",
+			wantBytes: []byte{},
+			wantCount: []Count{{Topic: "orders", Rule: "value " + withheld, Fields: 1}},
+		},
+		{
+			name:      "raw decoder",
+			value:     []byte(`{"email":"a@b.io"}`),
+			rule:      Rule{Field: "value.email", Action: Drop},
+			wantJSON:  "null",
+			wantBytes: []byte{},
+			wantCount: []Count{{Topic: "orders", Rule: "value " + withheld, Fields: 1}},
+		},
+		{
+			name:      "string value",
+			value:     []byte("call 5551234"),
+			spec:      "string",
+			rule:      Rule{Field: "value", Action: Mask, Pattern: `\d+`},
+			wantJSON:  `"call ***"`,
+			wantBytes: []byte("call ***"),
+			wantCount: []Count{{Topic: "orders", Rule: "value (mask)", Fields: 1}},
+		},
+		{
+			name:      "path of string value",
+			value:     []byte("text"),
+			spec:      "string",
+			rule:      Rule{Field: "value.email", Action: Drop},
+			wantJSON:  `"text"`,
+			wantBytes: []byte("text"),
+		},
+		{
+			name:      "hex value",
+			value:     []byte{0xca, 0xfe},
+			spec:      "hex",
+			rule:      Rule{Field: "value", Action: HMAC},
+			wantJSON:  `"` + hmacHex("k1", "cafe") + `"`,
+			wantBytes: []byte(hmacHex("k1", "cafe")),
+			wantCount: []Count{{Topic: "orders", Rule: "value (hmac)", Fields: 1}},
+		},
+		{
+			name:      "tombstone",
+			spec:      "json",
+			rule:      Rule{Field: "value.email", Action: Drop},
+			wantJSON:  "",
+			wantBytes: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := New([]Rule{tc.rule}, []byte("k1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := decode(t, []byte("k"), tc.value, "", tc.spec)
+
+			r.Redact(d)
+
+			var js string
+			if d.Value != nil {
+				js = string(d.Value.JSON)
+			}
+
+			if js != tc.wantJSON {
+				t.Errorf("redacted value = %s, want %s", js, tc.wantJSON)
+			}
+
+			if !reflect.DeepEqual(d.ConsumerMessage.Value, tc.wantBytes) {
+				t.Errorf("message value = %q, want %q", d.ConsumerMessage.Value, tc.wantBytes)
+			}
+
+			if got := r.Summary(); !reflect.DeepEqual(got, tc.wantCount) {
+				t.Errorf("Summary() = %+v, want %+v", got, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestRedactKey(t *testing.T) {
+	r, err := New([]Rule{{Field: "key.user", Action: Replace, Value: "-"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.RedactsKey() || r.RedactsValue() {
+		t.Errorf("RedactsKey() = %v, RedactsValue() = %v, want only key redacted", r.RedactsKey(), r.RedactsValue())
+	}
+
+	// value is not checked by rules, so it is kept even when it is not decoded.
+	d := decode(t, []byte("{\"user\": \"ann\",\n\"id\": 1}"), []byte("value"), "json:indent", "")
+
+	r.Redact(d)
+
+	if want := "{\n  \"user\": \"-\",\n  \"id\": 1\n}"; string(d.Key.JSON) != want || string(d.ConsumerMessage.Key) != want {
+		t.Errorf("redacted key = %s, message key = %s, want %s", d.Key.JSON, d.ConsumerMessage.Key, want)
+	}
+
+	if d.Value != nil || string(d.ConsumerMessage.Value) != "value" {
+		t.Errorf("value is changed to %+v, %q", d.Value, d.ConsumerMessage.Value)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	r, err := New([]Rule{{Field: "value.a", Action: Drop}, {Field: "value.b", Action: Drop}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, topic := range []string{"users", "orders", "orders"} {
+		d := decode(t, nil, []byte(`{"a":1,"b":2}`), "", "json")
+		d.Topic = topic
+
+		r.Redact(d)
+	}
+
+	want := []Count{
+		{Topic: "orders", Rule: "value.a (drop)", Fields: 2},
+		{Topic: "orders", Rule: "value.b (drop)", Fields: 2},
+		{Topic: "users", Rule: "value.a (drop)", Fields: 1},
+		{Topic: "users", Rule: "value.b (drop)", Fields: 1},
+	}
+
+	if got := r.Summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+
+	var nilRedactor *Redactor
+
+	if nilRedactor.Summary() != nil || nilRedactor.RedactsValue() {
+		t.Error("nil redactor redacts")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		key     string
+		wantErr string
+	}{
+		{name: "no rules", wantErr: "no redaction rules"},
+		{name: "root", rules: []Rule{{Field: "headers.x", Action: Drop}}, wantErr: "should start with key or value"},
+		{name: "empty member", rules: []Rule{{Field: "value..a", Action: Drop}}, wantErr: "empty member name"},
+		{name: "trailing dot", rules: []Rule{{Field: "value.", Action: Drop}}, wantErr: "empty member name"},
+		{name: "index", rules: []Rule{{Field: "value.a[x]", Action: Drop}}, wantErr: "invalid index [x]"},
+		{name: "negative index", rules: []Rule{{Field: "value.a[-1]", Action: Drop}}, wantErr: "invalid index [-1]"},
+		{name: "unterminated index", rules: []Rule{{Field: "value.a[1", Action: Drop}}, wantErr: "unterminated index"},
+		{name: "unterminated name", rules: []Rule{{Field: `value["a`, Action: Drop}}, wantErr: "unterminated member name"},
+		{name: "name without bracket", rules: []Rule{{Field: `value["a"x`, Action: Drop}}, wantErr: `invalid member name "a"`},
+		{name: "hmac without key", rules: []Rule{{Field: "value.a", Action: HMAC}}, wantErr: "hmac action requires key"},
+		{name: "mask without pattern", rules: []Rule{{Field: "value.a", Action: Mask}}, wantErr: "mask action requires pattern"},
+		{name: "invalid pattern", rules: []Rule{{Field: "value.a", Action: Mask, Pattern: "("}}, wantErr: "failed to parse pattern"},
+		{name: "unknown action", rules: []Rule{{Field: "value.a", Action: "hash"}}, wantErr: "unknown action [hash]"},
+		{
+			name:    "pattern of replace",
+			rules:   []Rule{{Field: "value.a", Action: Replace, Pattern: "x"}},
+			wantErr: "pattern could be set only for mask action",
+		},
+		{name: "escaped name", rules: []Rule{{Field: `value["a\"b"][0][*].*`, Action: HMAC}}, key: "k"},
+	}
+
+	for _, tc := range tests {
+		_, err := New(tc.rules, []byte(tc.key))
+
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: New() = %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: New() error = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
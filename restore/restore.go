@@ -0,0 +1,507 @@
+// Package restore produces messages from dump files back to kafka.
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/dumper"
+	"github.com/obalunenko/kafka-dump/format"
+	"github.com/obalunenko/kafka-dump/security"
+)
+
+// batchSize is a maximum number of messages sent to kafka at once.
+const batchSize = 500
+
+// Options configures Restorer.
+type Options struct {
+	Brokers  []string
+	ClientID string
+	// Version should be at least 0.11 to restore headers and 0.10 to restore timestamps.
+	Version sarama.KafkaVersion
+	// Security configures TLS and SASL authentication, connections are plaintext when zero.
+	Security security.Options
+
+	// InputDir is an OutputDir of dumper. Only jsonl and binary files could be restored,
+	// raw files do not keep message metadata and are skipped.
+	InputDir string
+	// Topics selects source topics to restore, all when empty.
+	Topics []string
+	// Partitions selects source partitions to restore, all when empty.
+	Partitions []int32
+	// From and To select messages by timestamp in [From, To) range, zero bound is open.
+	From time.Time
+	To   time.Time
+
+	// TopicMapping renames source topics, topics not in mapping are restored with the same name.
+	TopicMapping map[string]string
+	// KeepPartition produces messages to their original partitions, otherwise they are partitioned by key.
+	KeepPartition bool
+	// RateLimit is a maximum number of produced messages per second, 0 - unlimited.
+	RateLimit float64
+	// DryRun reads and selects messages without producing them.
+	DryRun bool
+
+	// Logger receives restore logs, standard logrus logger is used when nil.
+	Logger dumper.Logger
+}
+
+func (o Options) validate() error {
+	switch {
+	case len(o.Brokers) == 0 && !o.DryRun:
+		return errors.New("no kafka brokers")
+	case o.InputDir == "":
+		return errors.New("empty input dir")
+	case !o.From.IsZero() && !o.To.IsZero() && !o.From.Before(o.To):
+		return fmt.Errorf("empty time range [%s, %s)", o.From, o.To)
+	case o.RateLimit < 0:
+		return fmt.Errorf("negative rate limit %v", o.RateLimit)
+	default:
+		return nil
+	}
+}
+
+// Stats describes result of restore.
+type Stats struct {
+	Files int
+	// Produced is a number of produced messages, in dry run - number of messages that would be produced.
+	Produced int64
+	// Skipped is a number of messages that do not match selection.
+	Skipped int64
+	// NotReversible is a number of skipped records with keys, values or headers decoded by avro or protobuf
+	// decoders, such records could not be converted back to original bytes.
+	NotReversible int64
+	// Interrupted is set when restore was canceled before all files were restored.
+	Interrupted bool
+}
+
+// Restorer reads dump files and produces their messages to kafka.
+type Restorer struct {
+	opts       Options
+	log        dumper.Logger
+	config     *sarama.Config
+	topics     map[string]bool
+	partitions map[int32]bool
+}
+
+// New validates options and creates Restorer. Returned error is *dumper.ConfigError.
+func New(opts Options) (*Restorer, error) {
+	if err := opts.validate(); err != nil {
+		return nil, &dumper.ConfigError{Err: err}
+	}
+
+	r := &Restorer{
+		opts: opts,
+		log:  opts.Logger,
+	}
+
+	if r.log == nil {
+		r.log = log.StandardLogger()
+	}
+
+	var err error
+
+	if r.config, err = r.kafkaConfig(); err != nil {
+		return nil, &dumper.ConfigError{Err: err}
+	}
+
+	if len(opts.Topics) > 0 {
+		r.topics = make(map[string]bool, len(opts.Topics))
+
+		for _, t := range opts.Topics {
+			r.topics[t] = true
+		}
+	}
+
+	if len(opts.Partitions) > 0 {
+		r.partitions = make(map[int32]bool, len(opts.Partitions))
+
+		for _, p := range opts.Partitions {
+			r.partitions[p] = true
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Restorer) kafkaConfig() (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+
+	if r.opts.ClientID != "" {
+		cfg.ClientID = r.opts.ClientID
+	}
+
+	if r.opts.Version != (sarama.KafkaVersion{}) {
+		cfg.Version = r.opts.Version
+	}
+
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+
+	if r.opts.KeepPartition {
+		cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	}
+
+	if err := r.opts.Security.Apply(cfg); err != nil {
+		return nil, fmt.Errorf("failed to configure security: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Run restores all selected messages of dump files in InputDir.
+// Files are read in lexical order of their paths, so messages of partition keep their order
+// when path template sorts files by time or offset (default templates do).
+// When ctx is canceled, already read messages are produced and restore stops without error, see Stats.Interrupted.
+// Returned error is one of *dumper.ConnectionError or *dumper.DecodeError.
+func (r *Restorer) Run(ctx context.Context) (Stats, error) {
+	var stats Stats
+
+	files, err := r.files()
+	if err != nil {
+		return stats, err
+	}
+
+	var producer sarama.SyncProducer
+
+	if r.opts.DryRun {
+		r.log.Infof("Dry run: messages will not be produced")
+	} else {
+		if producer, err = sarama.NewSyncProducer(r.opts.Brokers, r.config); err != nil {
+			return stats, &dumper.ConnectionError{Err: err}
+		}
+
+		defer func() {
+			if cerr := producer.Close(); cerr != nil {
+				r.log.Errorf("Failed to close producer: %v", cerr)
+			}
+		}()
+	}
+
+	s := newSender(producer, r.opts.RateLimit, &stats)
+
+	for _, path := range files {
+		if err = r.restoreFile(ctx, s, path); err != nil {
+			if ctx.Err() == nil || !errors.Is(err, ctx.Err()) {
+				return stats, err
+			}
+
+			r.log.Warnf("Restore interrupted in %s, %d files are not restored", path, len(files)-stats.Files)
+
+			stats.Interrupted = true
+
+			break
+		}
+
+		stats.Files++
+	}
+
+	if err = s.flush(); err != nil {
+		return stats, err
+	}
+
+	produced := "produced"
+	if r.opts.DryRun {
+		produced = "would be produced"
+	}
+
+	r.log.Infof("Restore finished: %d files, %d messages %s, %d skipped, %d not reversible",
+		stats.Files, stats.Produced, produced, stats.Skipped, stats.NotReversible)
+
+	return stats, nil
+}
+
+// files returns restorable dump files of input dir in lexical order.
+func (r *Restorer) files() ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(r.opts.InputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// offset indexes are sidecar files of binary segments.
+		if info.IsDir() || strings.HasSuffix(path, format.IndexExtension) {
+			return nil
+		}
+
+		f, ok := format.FormatOf(path)
+		if !ok {
+			return nil
+		}
+
+		if dumper.IsSnapshotFile(path) {
+			r.log.Infof("Skipping %s: snapshot file", path)
+
+			return nil
+		}
+
+		if f == format.Raw {
+			r.log.Warnf("Skipping %s: raw format does not keep message metadata", path)
+
+			return nil
+		}
+
+		files = append(files, path)
+
+		return nil
+	})
+	if err != nil {
+		return nil, &dumper.ConfigError{Err: fmt.Errorf("failed to list input dir: %w", err)}
+	}
+
+	return files, nil
+}
+
+func (r *Restorer) restoreFile(ctx context.Context, s *sender, path string) error {
+	f, _ := format.FormatOf(path)
+
+	file, err := format.OpenFile(path)
+	if err != nil {
+		return &dumper.ConfigError{Err: fmt.Errorf("failed to open dump file: %w", err)}
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader, err := format.NewReader(f, file)
+	if err != nil {
+		return &dumper.ConfigError{Err: err}
+	}
+
+	r.log.Infof("Restoring %s", path)
+
+	var notReversible int64
+
+	defer func() {
+		if notReversible > 0 {
+			r.log.Warnf("Skipped %d records of %s decoded by avro or protobuf decoders, they could not be restored",
+				notReversible, path)
+		}
+	}()
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		msg, nerr := reader.Next()
+		if nerr != nil {
+			if errors.Is(nerr, io.EOF) {
+				return nil
+			}
+
+			if errors.Is(nerr, io.ErrUnexpectedEOF) {
+				r.log.Warnf("Partial record at the end of %s is skipped", path)
+
+				return nil
+			}
+
+			if errors.Is(nerr, format.ErrNotReversible) {
+				r.log.Debugf("Record of %s is skipped: %v", path, nerr)
+
+				notReversible++
+				s.stats.NotReversible++
+
+				continue
+			}
+
+			return &dumper.DecodeError{Err: fmt.Errorf("%s: %w", path, nerr)}
+		}
+
+		if !r.selected(msg) {
+			s.stats.Skipped++
+
+			continue
+		}
+
+		pm := r.producerMessage(msg)
+
+		if r.opts.DryRun {
+			r.log.Debugf("Dry run: [%s:%d:%d] -> [%s:%d]", msg.Topic, msg.Partition, msg.Offset, pm.Topic, pm.Partition)
+
+			s.stats.Produced++
+
+			continue
+		}
+
+		if err = s.send(ctx, pm); err != nil {
+			return err
+		}
+	}
+}
+
+// selected reports whether message matches topics, partitions and time range selection.
+func (r *Restorer) selected(msg *sarama.ConsumerMessage) bool {
+	if r.topics != nil && !r.topics[msg.Topic] {
+		return false
+	}
+
+	if r.partitions != nil && !r.partitions[msg.Partition] {
+		return false
+	}
+
+	if r.opts.From.IsZero() && r.opts.To.IsZero() {
+		return true
+	}
+
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = msg.BlockTimestamp
+	}
+
+	if ts.IsZero() {
+		return false
+	}
+
+	if !r.opts.From.IsZero() && ts.Before(r.opts.From) {
+		return false
+	}
+
+	if !r.opts.To.IsZero() && !ts.Before(r.opts.To) {
+		return false
+	}
+
+	return true
+}
+
+func (r *Restorer) producerMessage(msg *sarama.ConsumerMessage) *sarama.ProducerMessage {
+	topic := msg.Topic
+	if t, ok := r.opts.TopicMapping[topic]; ok {
+		topic = t
+	}
+
+	pm := &sarama.ProducerMessage{
+		Topic:     topic,
+		Partition: msg.Partition,
+		Timestamp: msg.Timestamp,
+	}
+
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	if msg.Value != nil {
+		pm.Value = sarama.ByteEncoder(msg.Value)
+	}
+
+	for _, h := range msg.Headers {
+		if h != nil {
+			pm.Headers = append(pm.Headers, *h)
+		}
+	}
+
+	return pm
+}
+
+// sender produces messages by batches.
+type sender struct {
+	producer sarama.SyncProducer
+	limiter  *limiter
+	batch    []*sarama.ProducerMessage
+	// max is a batch size, when rate is limited batch holds messages of one second at most.
+	max   int
+	stats *Stats
+}
+
+func newSender(producer sarama.SyncProducer, rate float64, stats *Stats) *sender {
+	max := batchSize
+
+	if rate > 0 && rate < batchSize {
+		max = int(rate)
+		if max < 1 {
+			max = 1
+		}
+	}
+
+	return &sender{
+		producer: producer,
+		limiter:  newLimiter(rate),
+		max:      max,
+		stats:    stats,
+	}
+}
+
+func (s *sender) send(ctx context.Context, pm *sarama.ProducerMessage) error {
+	if err := s.limiter.wait(ctx); err != nil {
+		return err
+	}
+
+	s.batch = append(s.batch, pm)
+
+	if len(s.batch) >= s.max {
+		return s.flush()
+	}
+
+	return nil
+}
+
+func (s *sender) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+
+	if err := s.producer.SendMessages(s.batch); err != nil {
+		return &dumper.ConnectionError{Err: fmt.Errorf("failed to produce messages: %w", err)}
+	}
+
+	s.stats.Produced += int64(len(s.batch))
+	s.batch = s.batch[:0]
+
+	return nil
+}
+
+// limiter limits rate of produced messages.
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+func (l *limiter) enabled() bool {
+	return l.interval > 0
+}
+
+// wait blocks until next message could be produced.
+func (l *limiter) wait(ctx context.Context) error {
+	if !l.enabled() {
+		return nil
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	if d := l.next.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	l.next = l.next.Add(l.interval)
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/obalunenko/kafka-dump/dumper"
+	"github.com/obalunenko/kafka-dump/format"
+)
+
+var testTime = time.Date(2021, time.February, 3, 10, 0, 0, 0, time.UTC)
+
+func discardLogger() dumper.Logger {
+	l := log.New()
+	l.Out = ioutil.Discard
+
+	return l
+}
+
+// writeDump writes messages with offsets of partition 0 of topic orders to file of format f.
+func writeDump(t *testing.T, path string, f format.Format, offsets ...int64) {
+	t.Helper()
+
+	enc, err := format.NewEncoder(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+
+	for _, offset := range offsets {
+		rec, err := enc.Encode(&sarama.ConsumerMessage{
+			Topic:     "orders",
+			Offset:    offset,
+			Key:       []byte("key"),
+			Value:     []byte("value"),
+			Timestamp: testTime,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data = append(data, rec...)
+	}
+
+	writeFile(t, path, data)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restoreBroker returns broker that accepts produced messages of topic.
+func restoreBroker(t *testing.T, topic string) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(topic, 0, b.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	return b
+}
+
+// produceRequests returns number of produce requests received by broker.
+func produceRequests(b *sarama.MockBroker) int {
+	n := 0
+
+	for _, r := range b.History() {
+		if _, ok := r.Request.(*sarama.ProduceRequest); ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	writeDump(t, filepath.Join(dir, "orders", "0.jsonl"), format.JSONL, 0, 1)
+	// record with value decoded by avro decoder is skipped.
+	writeFile(t, filepath.Join(dir, "orders", "1.jsonl"), []byte(
+		`{"topic":"orders","offset":2,"key":"key","value":{"id":1},"value_encoding":"avro"}`+"\n"))
+	writeDump(t, filepath.Join(dir, "orders", "2.jsonl"), format.JSONL, 3)
+	writeDump(t, filepath.Join(dir, "orders", "3.bin"), format.Binary, 4, 5)
+	// offset index, snapshot and raw files are not restored.
+	writeFile(t, filepath.Join(dir, "orders", "3.bin.idx"), []byte("index"))
+	writeFile(t, filepath.Join(dir, "orders.snapshot.jsonl"), []byte("snapshot\n"))
+	writeFile(t, filepath.Join(dir, "orders.snapshot.bin.gz"), []byte("snapshot"))
+	writeFile(t, filepath.Join(dir, "orders", "4.raw"), []byte("raw"))
+
+	// broker knows only mapped topic, so messages produced to source topic fail.
+	b := restoreBroker(t, "orders-restored")
+	defer b.Close()
+
+	r, err := New(Options{
+		Brokers:      []string{b.Addr()},
+		Version:      sarama.V0_11_0_0,
+		InputDir:     dir,
+		TopicMapping: map[string]string{"orders": "orders-restored"},
+		Logger:       discardLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Stats{Files: 4, Produced: 5, NotReversible: 1}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	if produceRequests(b) == 0 {
+		t.Error("no messages are produced")
+	}
+}
+
+// TestRunInterrupted checks that messages read before cancel are produced and restore stops without error.
+func TestRunInterrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	offsets := make([]int64, 50)
+	for i := range offsets {
+		offsets[i] = int64(i)
+	}
+
+	writeDump(t, filepath.Join(dir, "orders", "0.jsonl"), format.JSONL, offsets...)
+
+	b := restoreBroker(t, "orders")
+	defer b.Close()
+
+	// batch holds messages of one second, so cancel happens before the first batch is full.
+	r, err := New(Options{
+		Brokers:   []string{b.Addr()},
+		Version:   sarama.V0_11_0_0,
+		InputDir:  dir,
+		RateLimit: 20,
+		Logger:    discardLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	stats, err := r.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() = %v, want nil on cancel", err)
+	}
+
+	if !stats.Interrupted || stats.Files != 0 || stats.Produced == 0 || stats.Produced >= int64(len(offsets)) {
+		t.Errorf("stats = %+v, want interrupted restore with pending batch produced", stats)
+	}
+
+	if produceRequests(b) == 0 {
+		t.Error("pending batch is not produced")
+	}
+}
+
+func TestIsSnapshotFile(t *testing.T) {
+	for path, want := range map[string]bool{
+		"out/orders.snapshot.jsonl":          true,
+		"out/orders.snapshot.bin.zst":        true,
+		"out/orders/0.jsonl":                 false,
+		"out/snapshot/0.jsonl":               false,
+		"out/orders.snapshots/0.jsonl":       false,
+		"out/orders/0000000000.snapshot.bin": true,
+	} {
+		if got := dumper.IsSnapshotFile(path); got != want {
+			t.Errorf("IsSnapshotFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
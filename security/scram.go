@@ -0,0 +1,223 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// hashFunc creates hash of SCRAM mechanism.
+type hashFunc func() hash.Hash
+
+var (
+	sha256Hash hashFunc = sha256.New
+	sha512Hash hashFunc = sha512.New
+)
+
+// scramNonceSize is a number of random bytes of client nonce.
+const scramNonceSize = 24
+
+// scramClient is a client side of SCRAM exchange (RFC 5802) without channel binding.
+// User names and passwords are used as is, without SASLprep normalization.
+type scramClient struct {
+	hash hashFunc
+	// nonce returns client nonce, it is replaceable to make exchange reproducible.
+	nonce func() (string, error)
+
+	user     string
+	password string
+	authzID  string
+
+	step            int
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte
+	done            bool
+}
+
+func newSCRAMClient(h hashFunc) *scramClient {
+	return &scramClient{hash: h, nonce: randomNonce}
+}
+
+// Begin prepares exchange for user.
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	c.user = userName
+	c.password = password
+	c.authzID = authzID
+	c.step = 0
+	c.done = false
+
+	return nil
+}
+
+// Step returns client-first message on the first call, client-final message for server-first message
+// and verifies server-final message.
+func (c *scramClient) Step(challenge string) (string, error) {
+	c.step++
+
+	switch c.step {
+	case 1:
+		return c.clientFirst()
+	case 2:
+		return c.clientFinal(challenge)
+	case 3:
+		c.done = true
+
+		return "", c.verifyServerFinal(challenge)
+	default:
+		return "", errors.New("SCRAM exchange is already finished")
+	}
+}
+
+// Done reports whether exchange is finished.
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+func (c *scramClient) gs2Header() string {
+	if c.authzID == "" {
+		return "n,,"
+	}
+
+	return "n,a=" + escapeSCRAMName(c.authzID) + ","
+}
+
+func (c *scramClient) clientFirst() (string, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SCRAM nonce: %w", err)
+	}
+
+	c.clientNonce = nonce
+	c.clientFirstBare = "n=" + escapeSCRAMName(c.user) + ",r=" + nonce
+
+	return c.gs2Header() + c.clientFirstBare, nil
+}
+
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := parseSCRAMAttributes(serverFirst)
+
+	if e, ok := attrs['e']; ok {
+		return "", fmt.Errorf("SCRAM server error: %s", e)
+	}
+
+	nonce, salt64, iter := attrs['r'], attrs['s'], attrs['i']
+
+	if !strings.HasPrefix(nonce, c.clientNonce) || len(nonce) == len(c.clientNonce) {
+		return "", errors.New("SCRAM server nonce does not extend client nonce")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil || len(salt) == 0 {
+		return "", errors.New("invalid SCRAM salt")
+	}
+
+	iterations, err := strconv.Atoi(iter)
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("invalid SCRAM iteration count [%s]", iter)
+	}
+
+	salted := pbkdf2(c.hash, []byte(c.password), salt, iterations)
+	clientKey := c.hmac(salted, []byte("Client Key"))
+	storedKey := c.sum(clientKey)
+
+	clientFinalBare := "c=" + base64.StdEncoding.EncodeToString([]byte(c.gs2Header())) + ",r=" + nonce
+	authMessage := []byte(c.clientFirstBare + "," + serverFirst + "," + clientFinalBare)
+
+	proof := c.hmac(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	c.serverSignature = c.hmac(c.hmac(salted, []byte("Server Key")), authMessage)
+
+	return clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (c *scramClient) verifyServerFinal(serverFinal string) error {
+	attrs := parseSCRAMAttributes(serverFinal)
+
+	if e, ok := attrs['e']; ok {
+		return fmt.Errorf("SCRAM server error: %s", e)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(attrs['v'])
+	if err != nil || subtle.ConstantTimeCompare(signature, c.serverSignature) != 1 {
+		return errors.New("SCRAM server signature is invalid")
+	}
+
+	return nil
+}
+
+func (c *scramClient) hmac(key, data []byte) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+func (c *scramClient) sum(data []byte) []byte {
+	h := c.hash()
+	h.Write(data)
+
+	return h.Sum(nil)
+}
+
+// pbkdf2 derives key of hash size from password (RFC 8018), it is Hi function of RFC 5802.
+func pbkdf2(h hashFunc, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1}) // big endian index of the only block
+
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+
+	return result
+}
+
+// parseSCRAMAttributes parses comma separated a=value attributes of SCRAM message.
+func parseSCRAMAttributes(msg string) map[byte]string {
+	attrs := make(map[byte]string)
+
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) >= 2 && attr[1] == '=' {
+			attrs[attr[0]] = attr[2:]
+		}
+	}
+
+	return attrs
+}
+
+// escapeSCRAMName escapes '=' and ',' in user name.
+func escapeSCRAMName(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
+
+func randomNonce() (string, error) {
+	b := make([]byte, scramNonceSize)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
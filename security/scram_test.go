@@ -0,0 +1,240 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	testUser        = "user"
+	testPassword    = "pencil"
+	testClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	testServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+)
+
+// scramVectors are exchanges of RFC 7677 (SHA-256) and the same exchange with SHA-512.
+var scramVectors = []struct {
+	name        string
+	mechanism   string
+	hash        hashFunc
+	clientFinal string
+	serverFinal string
+}{
+	{
+		name:        "SHA-256 RFC 7677",
+		mechanism:   MechanismSCRAMSHA256,
+		hash:        sha256Hash,
+		clientFinal: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		serverFinal: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+	},
+	{
+		name:      "SHA-512",
+		mechanism: MechanismSCRAMSHA512,
+		hash:      sha512Hash,
+		clientFinal: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+			"p=gMGXRcevScNtxZ6/8lQYpGtnsNAc3mGcmNomv+xnoOMw+3R2xNJdMNnzMlTN8PPC6wdp6dybEmDYXYTxwnYPJQ==",
+		serverFinal: "v=ZQnYEgWQMFmmsM8aQMF0nDDCy/AgCzkwk8CmMZYcMg0vSVlKDanekLtifDSeVGT4+5ZxXnJq199RVG2rR7N7Zw==",
+	},
+}
+
+func newTestSCRAMClient(h hashFunc) *scramClient {
+	c := newSCRAMClient(h)
+	c.nonce = func() (string, error) { return testClientNonce, nil }
+
+	return c
+}
+
+func TestSCRAMClientVectors(t *testing.T) {
+	for _, tc := range scramVectors {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestSCRAMClient(tc.hash)
+
+			if err := c.Begin(testUser, testPassword, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := c.Step("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := "n,,n=user,r=" + testClientNonce; first != want {
+				t.Errorf("client-first = %q, want %q", first, want)
+			}
+
+			final, err := c.Step(testServerFirst)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if final != tc.clientFinal {
+				t.Errorf("client-final = %q, want %q", final, tc.clientFinal)
+			}
+
+			if _, err = c.Step(tc.serverFinal); err != nil {
+				t.Errorf("server-final is rejected: %v", err)
+			}
+
+			if !c.Done() {
+				t.Error("exchange is not done")
+			}
+		})
+	}
+}
+
+func TestSCRAMClientRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverFirst string
+		serverFinal string
+		wantErr     string
+	}{
+		{
+			name:        "server signature mismatch",
+			serverFirst: testServerFirst,
+			serverFinal: "v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+			wantErr:     "SCRAM server signature is invalid",
+		},
+		{
+			name:        "server signature is not base64",
+			serverFirst: testServerFirst,
+			serverFinal: "v=***",
+			wantErr:     "SCRAM server signature is invalid",
+		},
+		{
+			name:        "server error",
+			serverFirst: testServerFirst,
+			serverFinal: "e=invalid-proof",
+			wantErr:     "SCRAM server error: invalid-proof",
+		},
+		{
+			name:        "nonce is not extended",
+			serverFirst: "r=" + testClientNonce + ",s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			wantErr:     "SCRAM server nonce does not extend client nonce",
+		},
+		{
+			name:        "other nonce",
+			serverFirst: "r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			wantErr:     "SCRAM server nonce does not extend client nonce",
+		},
+		{
+			name:        "invalid salt",
+			serverFirst: "r=" + testClientNonce + "x,s=***,i=4096",
+			wantErr:     "invalid SCRAM salt",
+		},
+		{
+			name:        "invalid iteration count",
+			serverFirst: "r=" + testClientNonce + "x,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+			wantErr:     "invalid SCRAM iteration count [0]",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestSCRAMClient(sha256Hash)
+
+			if err := c.Begin(testUser, testPassword, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.Step(""); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := c.Step(tc.serverFirst)
+			if err == nil {
+				_, err = c.Step(tc.serverFinal)
+			}
+
+			if err == nil || err.Error() != tc.wantErr {
+				t.Errorf("error = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSCRAMHandshake(t *testing.T) {
+	for _, tc := range scramVectors {
+		for _, accepted := range []bool{true, false} {
+			serverFinal := tc.serverFinal
+			if !accepted {
+				serverFinal = "v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+			}
+
+			b := sarama.NewMockBroker(t, 1)
+			b.SetHandlerByMap(map[string]sarama.MockResponse{
+				"SaslHandshakeRequest": sarama.NewMockSaslHandshakeResponse(t).
+					SetEnabledMechanisms([]string{tc.mechanism}),
+				"SaslAuthenticateRequest": sarama.NewMockSequence(
+					sarama.NewMockSaslAuthenticateResponse(t).SetAuthBytes([]byte(testServerFirst)),
+					sarama.NewMockSaslAuthenticateResponse(t).SetAuthBytes([]byte(serverFinal)),
+				),
+				"MetadataRequest": sarama.NewMockMetadataResponse(t).SetBroker(b.Addr(), b.BrokerID()),
+			})
+
+			cfg := sarama.NewConfig()
+			cfg.Version = sarama.V1_0_0_0
+			cfg.Metadata.Retry.Max = 0
+
+			opts := Options{SASL: SASLOptions{Mechanism: tc.mechanism, User: testUser, Password: testPassword}}
+			if err := opts.Apply(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			generate := cfg.Net.SASL.SCRAMClientGeneratorFunc
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				c := generate().(*scramClient)
+				c.nonce = func() (string, error) { return testClientNonce, nil }
+
+				return c
+			}
+
+			client, err := sarama.NewClient([]string{b.Addr()}, cfg)
+			if err == nil {
+				_ = client.Close()
+			}
+
+			switch {
+			case accepted && err != nil:
+				t.Errorf("%s: handshake failed: %v", tc.name, err)
+			case !accepted && err == nil:
+				t.Errorf("%s: handshake with invalid server signature succeeded", tc.name)
+			}
+
+			var sent []string
+
+			for _, r := range b.History() {
+				if req, ok := r.Request.(*sarama.SaslAuthenticateRequest); ok {
+					sent = append(sent, string(req.SaslAuthBytes))
+				}
+			}
+
+			if len(sent) != 2 || sent[0] != "n,,n=user,r="+testClientNonce || sent[1] != tc.clientFinal {
+				t.Errorf("%s: sent %q", tc.name, sent)
+			}
+
+			b.Close()
+		}
+	}
+}
+
+func TestParseMechanism(t *testing.T) {
+	for in, want := range map[string]string{
+		"":               "",
+		"plain":          MechanismPlain,
+		" scram-sha-512": MechanismSCRAMSHA512,
+		"SCRAM-SHA-256":  MechanismSCRAMSHA256,
+		"oauthbearer":    MechanismOAuthBearer,
+	} {
+		got, err := ParseMechanism(in)
+		if err != nil || got != want {
+			t.Errorf("ParseMechanism(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	if _, err := ParseMechanism("GSSAPI"); err == nil || !strings.Contains(err.Error(), "unknown SASL mechanism") {
+		t.Errorf("GSSAPI error = %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package security configures TLS and SASL authentication of Kafka clients.
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// Options configures encryption and authentication of connections to brokers, zero value is plaintext.
+type Options struct {
+	TLS  TLSOptions
+	SASL SASLOptions
+}
+
+// TLSOptions configures TLS connections to brokers.
+type TLSOptions struct {
+	Enabled bool
+	// CAFile is a PEM bundle of certificate authorities, system roots are used when empty.
+	CAFile string
+	// CertFile and KeyFile are PEM client certificate and its key for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides host name used to verify broker certificates.
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// SASL mechanisms.
+const (
+	MechanismPlain       = sarama.SASLTypePlaintext
+	MechanismSCRAMSHA256 = sarama.SASLTypeSCRAMSHA256
+	MechanismSCRAMSHA512 = sarama.SASLTypeSCRAMSHA512
+	MechanismOAuthBearer = sarama.SASLTypeOAuth
+)
+
+// SASLOptions configures SASL authentication, it is disabled when Mechanism is empty.
+type SASLOptions struct {
+	// Mechanism is one of PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER.
+	Mechanism string
+	User      string
+	// Password is used when PasswordFile is empty.
+	Password string
+	// PasswordFile is a file with password of User, trailing new line is ignored.
+	PasswordFile string
+	// TokenFile is a file with OAUTHBEARER access token, it is read on each authentication,
+	// so token could be refreshed by external process.
+	TokenFile string
+}
+
+// ParseMechanism parses name of SASL mechanism (case insensitive), empty name disables SASL.
+func ParseMechanism(s string) (string, error) {
+	m := strings.ToUpper(strings.TrimSpace(s))
+
+	switch m {
+	case "", MechanismPlain, MechanismSCRAMSHA256, MechanismSCRAMSHA512, MechanismOAuthBearer:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown SASL mechanism [%s]", s)
+	}
+}
+
+// Apply configures TLS and SASL of kafka client config. Files are read at once except token file.
+func (o Options) Apply(cfg *sarama.Config) error {
+	if o.TLS.Enabled {
+		tlsConfig, err := o.TLS.Config()
+		if err != nil {
+			return err
+		}
+
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+
+	if o.SASL.Mechanism != "" {
+		if err := o.SASL.apply(cfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Config returns TLS config with loaded CA bundle and client certificate, Enabled is not checked.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file [%s]", o.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	switch {
+	case o.CertFile != "" && o.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case o.CertFile != "" || o.KeyFile != "":
+		return nil, errors.New("both TLS client certificate and key files should be set")
+	}
+
+	return tlsConfig, nil
+}
+
+func (o SASLOptions) apply(cfg *sarama.Config) error {
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.Mechanism = sarama.SASLMechanism(o.Mechanism)
+
+	if cfg.Version.IsAtLeast(sarama.V1_0_0_0) {
+		cfg.Net.SASL.Version = sarama.SASLHandshakeV1
+	}
+
+	if o.Mechanism == MechanismOAuthBearer {
+		if o.TokenFile == "" {
+			return errors.New("token file is required for OAUTHBEARER SASL mechanism")
+		}
+
+		cfg.Net.SASL.TokenProvider = tokenFile(o.TokenFile)
+
+		return nil
+	}
+
+	password, err := o.password()
+	if err != nil {
+		return err
+	}
+
+	if o.User == "" || password == "" {
+		return fmt.Errorf("user and password are required for %s SASL mechanism", o.Mechanism)
+	}
+
+	cfg.Net.SASL.User = o.User
+	cfg.Net.SASL.Password = password
+
+	switch o.Mechanism {
+	case MechanismSCRAMSHA256:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(sha256Hash) }
+	case MechanismSCRAMSHA512:
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(sha512Hash) }
+	}
+
+	return nil
+}
+
+func (o SASLOptions) password() (string, error) {
+	if o.PasswordFile == "" {
+		return o.Password, nil
+	}
+
+	if o.Password != "" {
+		return "", errors.New("either SASL password or password file should be set")
+	}
+
+	return readSecret(o.PasswordFile)
+}
+
+// readSecret reads secret from file without trailing new line.
+func readSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// tokenFile provides OAUTHBEARER access token read from file.
+type tokenFile string
+
+// Token reads access token from file.
+func (f tokenFile) Token() (*sarama.AccessToken, error) {
+	token, err := readSecret(string(f))
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" {
+		return nil, fmt.Errorf("token file [%s] is empty", string(f))
+	}
+
+	return &sarama.AccessToken{Token: token}, nil
+}
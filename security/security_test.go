@@ -0,0 +1,222 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// writeFile writes data to file name of dir and returns its path.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// writeCertificate writes self-signed PEM certificate and its key to dir and returns their paths.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-dump test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := writeFile(t, dir, "cert.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile := writeFile(t, dir, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+	notPEM := writeFile(t, dir, "ca.txt", "not a certificate")
+
+	o := TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "kafka", InsecureSkipVerify: true}
+
+	tlsConfig, err := o.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case tlsConfig.RootCAs == nil:
+		t.Error("RootCAs are not loaded from CA file")
+	case len(tlsConfig.Certificates) != 1:
+		t.Errorf("client certificates = %d, want 1", len(tlsConfig.Certificates))
+	case tlsConfig.ServerName != "kafka" || !tlsConfig.InsecureSkipVerify:
+		t.Errorf("ServerName, InsecureSkipVerify = %q, %t, want kafka, true", tlsConfig.ServerName, tlsConfig.InsecureSkipVerify)
+	}
+
+	if tlsConfig, err = (TLSOptions{}).Config(); err != nil || tlsConfig.RootCAs != nil || len(tlsConfig.Certificates) != 0 {
+		t.Errorf("Config() of empty options = %+v, %v, want system roots without client certificate", tlsConfig, err)
+	}
+
+	for name, o := range map[string]TLSOptions{
+		"missing CA file":    {CAFile: filepath.Join(dir, "missing.pem")},
+		"CA file not PEM":    {CAFile: notPEM},
+		"certificate only":   {CertFile: certFile},
+		"key only":           {KeyFile: keyFile},
+		"key of certificate": {CertFile: keyFile, KeyFile: certFile},
+	} {
+		if _, err := o.Config(); err == nil {
+			t.Errorf("%s: Config() error = nil", name)
+		}
+	}
+}
+
+func TestApplyTLS(t *testing.T) {
+	certFile, _ := writeCertificate(t, t.TempDir())
+
+	cfg := sarama.NewConfig()
+	if err := (Options{TLS: TLSOptions{Enabled: true, CAFile: certFile}}).Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cfg.Net.TLS.Enable || cfg.Net.TLS.Config == nil || cfg.Net.SASL.Enable {
+		t.Errorf("TLS, SASL = %t, %t, want TLS only", cfg.Net.TLS.Enable, cfg.Net.SASL.Enable)
+	}
+
+	cfg = sarama.NewConfig()
+	if err := (Options{TLS: TLSOptions{CAFile: certFile}}).Apply(cfg); err != nil || cfg.Net.TLS.Enable {
+		t.Errorf("Apply() of disabled TLS = %v, TLS enabled %t", err, cfg.Net.TLS.Enable)
+	}
+}
+
+func TestApplyPlain(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := writeFile(t, dir, "password", testPassword+"\n")
+
+	for name, o := range map[string]SASLOptions{
+		"password":      {Mechanism: MechanismPlain, User: testUser, Password: testPassword},
+		"password file": {Mechanism: MechanismPlain, User: testUser, PasswordFile: passwordFile},
+	} {
+		cfg := sarama.NewConfig()
+		cfg.Version = sarama.V1_0_0_0
+
+		if err := (Options{SASL: o}).Apply(cfg); err != nil {
+			t.Fatalf("%s: Apply() = %v", name, err)
+		}
+
+		sasl := cfg.Net.SASL
+
+		if !sasl.Enable || !sasl.Handshake || sasl.Mechanism != sarama.SASLTypePlaintext || sasl.Version != sarama.SASLHandshakeV1 {
+			t.Errorf("%s: SASL = %t, %t, %s, v%d, want PLAIN with handshake v1", name, sasl.Enable, sasl.Handshake, sasl.Mechanism, sasl.Version)
+		}
+
+		if sasl.User != testUser || sasl.Password != testPassword {
+			t.Errorf("%s: user, password = %q, %q, want %q, %q", name, sasl.User, sasl.Password, testUser, testPassword)
+		}
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V0_10_2_0
+
+	if err := (Options{SASL: SASLOptions{Mechanism: MechanismPlain, User: testUser, Password: testPassword}}).Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Net.SASL.Version != sarama.SASLHandshakeV0 {
+		t.Errorf("SASL handshake version of old broker = %d, want %d", cfg.Net.SASL.Version, sarama.SASLHandshakeV0)
+	}
+
+	for name, o := range map[string]SASLOptions{
+		"no user":                    {Mechanism: MechanismPlain, Password: testPassword},
+		"no password":                {Mechanism: MechanismPlain, User: testUser},
+		"empty password file":        {Mechanism: MechanismPlain, User: testUser, PasswordFile: writeFile(t, dir, "empty", "\n")},
+		"missing password file":      {Mechanism: MechanismPlain, User: testUser, PasswordFile: filepath.Join(dir, "missing")},
+		"password and password file": {Mechanism: MechanismPlain, User: testUser, Password: testPassword, PasswordFile: passwordFile},
+	} {
+		if err := (Options{SASL: o}).Apply(sarama.NewConfig()); err == nil {
+			t.Errorf("%s: Apply() error = nil", name)
+		}
+	}
+}
+
+func TestApplySCRAM(t *testing.T) {
+	for _, mechanism := range []string{MechanismSCRAMSHA256, MechanismSCRAMSHA512} {
+		cfg := sarama.NewConfig()
+
+		if err := (Options{SASL: SASLOptions{Mechanism: mechanism, User: testUser, Password: testPassword}}).Apply(cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Net.SASL.SCRAMClientGeneratorFunc == nil || cfg.Net.SASL.Mechanism != sarama.SASLMechanism(mechanism) {
+			t.Errorf("%s: SCRAM client is not configured", mechanism)
+		}
+	}
+}
+
+func TestApplyOAuthBearer(t *testing.T) {
+	dir := t.TempDir()
+	tokenPath := writeFile(t, dir, "token", "first\n")
+
+	cfg := sarama.NewConfig()
+	if err := (Options{SASL: SASLOptions{Mechanism: MechanismOAuthBearer, TokenFile: tokenPath}}).Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := cfg.Net.SASL.TokenProvider
+	if provider == nil || cfg.Net.SASL.Mechanism != sarama.SASLTypeOAuth {
+		t.Fatalf("SASL mechanism = %s, want OAUTHBEARER with token provider", cfg.Net.SASL.Mechanism)
+	}
+
+	// token is read on each authentication, so refreshed token is used.
+	for _, want := range []string{"first", "second"} {
+		writeFile(t, dir, "token", want+"\r\n")
+
+		if token, err := provider.Token(); err != nil || token.Token != want {
+			t.Errorf("Token() = %+v, %v, want %q", token, err, want)
+		}
+	}
+
+	writeFile(t, dir, "token", "")
+
+	if _, err := provider.Token(); err == nil {
+		t.Error("Token() of empty token file error = nil")
+	}
+
+	if _, err := tokenFile(filepath.Join(dir, "missing")).Token(); err == nil {
+		t.Error("Token() of missing token file error = nil")
+	}
+
+	if err := (Options{SASL: SASLOptions{Mechanism: MechanismOAuthBearer}}).Apply(sarama.NewConfig()); err == nil {
+		t.Error("Apply() of OAUTHBEARER without token file error = nil")
+	}
+}